@@ -0,0 +1,403 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Ringkasan turnaround time (menit) untuk satu periode harian/mingguan
+type TATSummary struct {
+	Periode            string  `json:"periode"`
+	Jumlah             int     `json:"jumlah"`
+	OrderToAcqP50      float64 `json:"order_to_acq_p50"`
+	OrderToAcqP90      float64 `json:"order_to_acq_p90"`
+	OrderToAcqP95      float64 `json:"order_to_acq_p95"`
+	AcqToReportP50     float64 `json:"acq_to_report_p50"`
+	AcqToReportP90     float64 `json:"acq_to_report_p90"`
+	AcqToReportP95     float64 `json:"acq_to_report_p95"`
+	orderToAcqMinutes  []float64
+	acqToReportMinutes []float64
+}
+
+type VolumeCount struct {
+	Kode   string `json:"kode"`
+	Nama   string `json:"nama"`
+	Jumlah int    `json:"jumlah"`
+}
+
+type BacklogItem struct {
+	NomorOrder  string    `json:"nomor_order"`
+	PatientName string    `json:"patient_name"`
+	Modality    string    `json:"modality"`
+	Pemeriksaan string    `json:"pemeriksaan"`
+	TglGambar   time.Time `json:"tgl_gambar_diterima"`
+	UmurMenit   float64   `json:"umur_menit"`
+	StudyUID    string    `json:"study_instance_uid"`
+	OHIFLink    string    `json:"ohif_link"`
+}
+
+type AnalyticsReport struct {
+	Dari        string        `json:"dari"`
+	Sampai      string        `json:"sampai"`
+	Periode     string        `json:"periode"`
+	TAT         []TATSummary  `json:"tat"`
+	PerModality []VolumeCount `json:"per_modality"`
+	PerExam     []VolumeCount `json:"per_exam"`
+	Backlog     []BacklogItem `json:"backlog"`
+}
+
+// Rentang analitik dibatasi agar satu request tidak memindai seluruh tabel
+const maxAnalyticsDays = 366
+
+type orderTimes struct {
+	NomorOrder string
+	Status     string
+	Worklist   WorklistRequest
+	StudyUID   string
+	// Waktu order dibuat di Khanza. Baris sebelum migrasi 14 memakai waktu
+	// dari JSON worklist, lalu waktu masuk worklist.
+	TglPermintaan *time.Time
+	TglGambar     *time.Time
+	TglHasil      *time.Time
+}
+
+// Ambil order beserta timestamp lifecycle pada rentang waktu permintaan Khanza.
+// Order kiriman backfill atau kirim ulang tetap masuk periode order dibuat.
+func (s *sqlStore) GetOrderTimes(dari, sampai time.Time) ([]orderTimes, error) {
+	rows, err := s.db.Query(`SELECT nomor_order, IFNULL(status, ''), IFNULL(worklist, ''), IFNULL(study_instance_uid, ''),
+		tgl_permintaan, tgl_masuk_worklist, tgl_gambar_diterima, tgl_terima_hasil
+		FROM sent_worklist WHERE COALESCE(tgl_permintaan, tgl_masuk_worklist) >= ? AND COALESCE(tgl_permintaan, tgl_masuk_worklist) < ?`, dari, sampai)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanOrderTimes(rows)
+}
+
+// Order yang gambarnya sudah diterima tapi belum ada hasil bacaan
+func (s *sqlStore) GetUnreportedOrders() ([]orderTimes, error) {
+	rows, err := s.db.Query(`SELECT nomor_order, IFNULL(status, ''), IFNULL(worklist, ''), IFNULL(study_instance_uid, ''),
+		tgl_permintaan, tgl_masuk_worklist, tgl_gambar_diterima, tgl_terima_hasil
+		FROM sent_worklist WHERE status=? ORDER BY tgl_gambar_diterima`, OrderAcquired)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanOrderTimes(rows)
+}
+
+func scanOrderTimes(rows *sql.Rows) ([]orderTimes, error) {
+	var result []orderTimes
+	for rows.Next() {
+		var ot orderTimes
+		var wlJSON string
+		var tglMasuk *time.Time
+		if err := rows.Scan(&ot.NomorOrder, &ot.Status, &wlJSON, &ot.StudyUID, &ot.TglPermintaan, &tglMasuk, &ot.TglGambar, &ot.TglHasil); err != nil {
+			log.Printf("Error scan analytics: %v", err)
+			continue
+		}
+		if wlJSON != "" {
+			json.Unmarshal([]byte(wlJSON), &ot.Worklist)
+		}
+		if ot.TglPermintaan == nil {
+			ot.TglPermintaan = ot.Worklist.WaktuPermintaan()
+		}
+		if ot.TglPermintaan == nil {
+			ot.TglPermintaan = tglMasuk
+		}
+		result = append(result, ot)
+	}
+	return result, rows.Err()
+}
+
+// Percentile nearest-rank dari data yang sudah terurut
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return math.Round(sorted[rank]*10) / 10
+}
+
+func periodKey(t time.Time, periode string) string {
+	if periode == "weekly" {
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	}
+	return t.Format("2006-01-02")
+}
+
+func BuildAnalyticsReport(cfg Config, db Store, dari, sampai time.Time, periode string) (AnalyticsReport, error) {
+	report := AnalyticsReport{
+		Dari:    dari.Format("2006-01-02"),
+		Sampai:  sampai.AddDate(0, 0, -1).Format("2006-01-02"),
+		Periode: periode,
+	}
+	orders, err := db.GetOrderTimes(dari, sampai)
+	if err != nil {
+		return report, err
+	}
+
+	tat := map[string]*TATSummary{}
+	modality := map[string]*VolumeCount{}
+	exam := map[string]*VolumeCount{}
+	for _, ot := range orders {
+		// Baris lama dipilih query lewat waktu masuk worklist, saring ulang
+		// dengan waktu permintaan dari JSON worklist
+		if ot.Status == string(OrderCancelled) || ot.TglPermintaan == nil ||
+			ot.TglPermintaan.Before(dari) || !ot.TglPermintaan.Before(sampai) {
+			continue
+		}
+		key := periodKey(*ot.TglPermintaan, periode)
+		s, ok := tat[key]
+		if !ok {
+			s = &TATSummary{Periode: key}
+			tat[key] = s
+		}
+		s.Jumlah++
+		if ot.TglGambar != nil {
+			s.orderToAcqMinutes = append(s.orderToAcqMinutes, ot.TglGambar.Sub(*ot.TglPermintaan).Minutes())
+			if ot.TglHasil != nil {
+				s.acqToReportMinutes = append(s.acqToReportMinutes, ot.TglHasil.Sub(*ot.TglGambar).Minutes())
+			}
+		}
+
+		mod := ot.Worklist.Modality
+		if modality[mod] == nil {
+			modality[mod] = &VolumeCount{Kode: mod, Nama: mod}
+		}
+		modality[mod].Jumlah++
+		kd := ot.Worklist.KdJenisPrw
+		if exam[kd] == nil {
+			exam[kd] = &VolumeCount{Kode: kd, Nama: ot.Worklist.RequestedProcedureDescription}
+		}
+		exam[kd].Jumlah++
+	}
+
+	for _, s := range tat {
+		sort.Float64s(s.orderToAcqMinutes)
+		sort.Float64s(s.acqToReportMinutes)
+		s.OrderToAcqP50 = percentile(s.orderToAcqMinutes, 50)
+		s.OrderToAcqP90 = percentile(s.orderToAcqMinutes, 90)
+		s.OrderToAcqP95 = percentile(s.orderToAcqMinutes, 95)
+		s.AcqToReportP50 = percentile(s.acqToReportMinutes, 50)
+		s.AcqToReportP90 = percentile(s.acqToReportMinutes, 90)
+		s.AcqToReportP95 = percentile(s.acqToReportMinutes, 95)
+		report.TAT = append(report.TAT, *s)
+	}
+	sort.Slice(report.TAT, func(i, j int) bool { return report.TAT[i].Periode < report.TAT[j].Periode })
+	report.PerModality = sortedVolumes(modality)
+	report.PerExam = sortedVolumes(exam)
+
+	unreported, err := db.GetUnreportedOrders()
+	if err != nil {
+		return report, err
+	}
+	now := time.Now()
+	for _, ot := range unreported {
+		item := BacklogItem{
+			NomorOrder:  ot.NomorOrder,
+			PatientName: ot.Worklist.PatientName,
+			Modality:    ot.Worklist.Modality,
+			Pemeriksaan: ot.Worklist.RequestedProcedureDescription,
+			StudyUID:    ot.StudyUID,
+		}
+		if ot.TglGambar != nil {
+			item.TglGambar = *ot.TglGambar
+			item.UmurMenit = math.Round(now.Sub(*ot.TglGambar).Minutes())
+		}
+		if ot.StudyUID != "" {
+			item.OHIFLink = GenerateOHIFLink(cfg, ot.StudyUID)
+		}
+		report.Backlog = append(report.Backlog, item)
+	}
+	return report, nil
+}
+
+func sortedVolumes(m map[string]*VolumeCount) []VolumeCount {
+	result := make([]VolumeCount, 0, len(m))
+	for _, v := range m {
+		result = append(result, *v)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Jumlah != result[j].Jumlah {
+			return result[i].Jumlah > result[j].Jumlah
+		}
+		return result[i].Kode < result[j].Kode
+	})
+	return result
+}
+
+// Baca parameter dari, sampai (inklusif) dan periode; default 30 hari terakhir harian
+func parseAnalyticsParams(r *http.Request) (time.Time, time.Time, string, error) {
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	dari := today.AddDate(0, 0, -29)
+	sampai := today
+	if v, err := time.ParseInLocation("2006-01-02", r.URL.Query().Get("dari"), time.Local); err == nil {
+		dari = v
+	}
+	if v, err := time.ParseInLocation("2006-01-02", r.URL.Query().Get("sampai"), time.Local); err == nil {
+		sampai = v
+	}
+	periode := r.URL.Query().Get("periode")
+	if periode != "weekly" {
+		periode = "daily"
+	}
+	if sampai.Before(dari) {
+		return dari, sampai, periode, fmt.Errorf("tanggal akhir %s sebelum tanggal awal %s", sampai.Format("2006-01-02"), dari.Format("2006-01-02"))
+	}
+	if sampai.After(dari.AddDate(0, 0, maxAnalyticsDays-1)) {
+		return dari, sampai, periode, fmt.Errorf("rentang analitik maksimal %d hari", maxAnalyticsDays)
+	}
+	return dari, sampai.AddDate(0, 0, 1), periode, nil
+}
+
+func writeAnalyticsCSV(w http.ResponseWriter, report AnalyticsReport, jenis string) {
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=analytics_%s_%s_%s.csv", jenis, report.Dari, report.Sampai))
+	cw := csv.NewWriter(w)
+	f := func(v float64) string { return strconv.FormatFloat(v, 'f', 1, 64) }
+	switch jenis {
+	case "modality", "exam":
+		volumes := report.PerModality
+		if jenis == "exam" {
+			volumes = report.PerExam
+		}
+		cw.Write([]string{"kode", "nama", "jumlah"})
+		for _, v := range volumes {
+			cw.Write([]string{v.Kode, v.Nama, strconv.Itoa(v.Jumlah)})
+		}
+	case "backlog":
+		cw.Write([]string{"nomor_order", "patient_name", "modality", "pemeriksaan", "tgl_gambar_diterima", "umur_menit"})
+		for _, b := range report.Backlog {
+			cw.Write([]string{b.NomorOrder, b.PatientName, b.Modality, b.Pemeriksaan, b.TglGambar.Format("2006-01-02 15:04:05"), f(b.UmurMenit)})
+		}
+	default:
+		cw.Write([]string{"periode", "jumlah", "order_to_acq_p50", "order_to_acq_p90", "order_to_acq_p95", "acq_to_report_p50", "acq_to_report_p90", "acq_to_report_p95"})
+		for _, s := range report.TAT {
+			cw.Write([]string{s.Periode, strconv.Itoa(s.Jumlah), f(s.OrderToAcqP50), f(s.OrderToAcqP90), f(s.OrderToAcqP95), f(s.AcqToReportP50), f(s.AcqToReportP90), f(s.AcqToReportP95)})
+		}
+	}
+	cw.Flush()
+}
+
+var analyticsTmpl = `
+<!DOCTYPE html>
+<html>
+<head>
+    <title>Analitik Turnaround Time Radiologi</title>
+    <style>
+        body { font-family: Arial; margin: 40px; }
+        table { border-collapse: collapse; margin-bottom: 30px; }
+        th, td { border: 1px solid #ccc; padding: 6px 10px; text-align: left; }
+        th { background: #f0f0f0; }
+        td.num { text-align: right; }
+        form { margin-bottom: 20px; }
+    </style>
+</head>
+<body>
+    <h2>Analitik Turnaround Time (menit)</h2>
+    <p><a href="/">Dashboard</a> | <a href="/worklist">Daftar Worklist</a></p>
+    <form method="get" action="/analytics">
+        Dari <input type="date" name="dari" value="{{.Dari}}">
+        Sampai <input type="date" name="sampai" value="{{.Sampai}}">
+        <select name="periode">
+            <option value="daily" {{if eq .Periode "daily"}}selected{{end}}>Harian</option>
+            <option value="weekly" {{if eq .Periode "weekly"}}selected{{end}}>Mingguan</option>
+        </select>
+        <button type="submit">Tampilkan</button>
+    </form>
+
+    <h3>TAT per Periode <a href="/api/analytics.csv?jenis=tat&dari={{.Dari}}&sampai={{.Sampai}}&periode={{.Periode}}">CSV</a></h3>
+    <table>
+        <tr><th>Periode</th><th>Jumlah</th><th>Order&rarr;Gambar P50</th><th>P90</th><th>P95</th><th>Gambar&rarr;Hasil P50</th><th>P90</th><th>P95</th></tr>
+        {{range .TAT}}
+        <tr><td>{{.Periode}}</td><td class="num">{{.Jumlah}}</td>
+            <td class="num">{{.OrderToAcqP50}}</td><td class="num">{{.OrderToAcqP90}}</td><td class="num">{{.OrderToAcqP95}}</td>
+            <td class="num">{{.AcqToReportP50}}</td><td class="num">{{.AcqToReportP90}}</td><td class="num">{{.AcqToReportP95}}</td></tr>
+        {{end}}
+    </table>
+
+    <h3>Volume per Modality <a href="/api/analytics.csv?jenis=modality&dari={{.Dari}}&sampai={{.Sampai}}">CSV</a></h3>
+    <table>
+        <tr><th>Modality</th><th>Jumlah</th></tr>
+        {{range .PerModality}}<tr><td>{{.Kode}}</td><td class="num">{{.Jumlah}}</td></tr>{{end}}
+    </table>
+
+    <h3>Volume per Jenis Pemeriksaan <a href="/api/analytics.csv?jenis=exam&dari={{.Dari}}&sampai={{.Sampai}}">CSV</a></h3>
+    <table>
+        <tr><th>Kode</th><th>Pemeriksaan</th><th>Jumlah</th></tr>
+        {{range .PerExam}}<tr><td>{{.Kode}}</td><td>{{.Nama}}</td><td class="num">{{.Jumlah}}</td></tr>{{end}}
+    </table>
+
+    <h3>Backlog Belum Dibaca ({{len .Backlog}}) <a href="/api/analytics.csv?jenis=backlog">CSV</a></h3>
+    <table>
+        <tr><th>Accession</th><th>Pasien</th><th>Modality</th><th>Pemeriksaan</th><th>Gambar Diterima</th><th>Umur (menit)</th><th>OHIF</th></tr>
+        {{range .Backlog}}
+        <tr><td><a href="/order?accession={{.NomorOrder}}">{{.NomorOrder}}</a></td><td>{{.PatientName}}</td><td>{{.Modality}}</td><td>{{.Pemeriksaan}}</td>
+            <td>{{.TglGambar.Format "2006-01-02 15:04"}}</td><td class="num">{{.UmurMenit}}</td>
+            <td>{{if .OHIFLink}}<a href="{{.OHIFLink}}" target="_blank">Buka</a>{{end}}</td></tr>
+        {{end}}
+    </table>
+</body>
+</html>
+`
+
+func registerAnalyticsHandlers(mux *http.ServeMux, mwdb Store) {
+	mux.HandleFunc("/analytics", RequirePermission(mwdb, PermView, func(w http.ResponseWriter, r *http.Request) {
+		dari, sampai, periode, err := parseAnalyticsParams(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		report, err := BuildAnalyticsReport(RuntimeConfig(), mwdb, dari, sampai, periode)
+		if err != nil {
+			http.Error(w, "Gagal menyusun analitik: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		t, _ := template.New("analytics").Parse(analyticsTmpl)
+		t.Execute(w, report)
+	}))
+
+	mux.HandleFunc("/api/analytics", RequirePermission(mwdb, PermView, func(w http.ResponseWriter, r *http.Request) {
+		dari, sampai, periode, err := parseAnalyticsParams(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		report, err := BuildAnalyticsReport(RuntimeConfig(), mwdb, dari, sampai, periode)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(report)
+	}))
+
+	mux.HandleFunc("/api/analytics.csv", RequirePermission(mwdb, PermView, func(w http.ResponseWriter, r *http.Request) {
+		dari, sampai, periode, err := parseAnalyticsParams(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		report, err := BuildAnalyticsReport(RuntimeConfig(), mwdb, dari, sampai, periode)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeAnalyticsCSV(w, report, r.URL.Query().Get("jenis"))
+	}))
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TAT dihitung dari waktu permintaan Khanza, bukan waktu worklist dikirim
+// (backfill, kirim ulang, jendela look-back)
+func TestAnalyticsFromKhanzaRequestTime(t *testing.T) {
+	mwdb := openMemoryStore(t)
+	if err := mwdb.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	diminta := today.AddDate(0, 0, -3).Add(8 * time.Hour)
+	gambar := today.AddDate(0, 0, -1).Add(8 * time.Hour)
+
+	// Order lama dikirim lewat backfill kemarin pagi
+	backfill := WorklistRequest{AccessionNumber: "CR0001", Modality: "CR",
+		ScheduledProcedureStepStartDate: diminta.Format("20060102"), ScheduledProcedureStepStartTime: diminta.Format("150405")}
+	// Baris sebelum migrasi 14: waktu permintaan hanya ada di JSON worklist
+	lama := backfill
+	lama.AccessionNumber = "CR0002"
+	for _, wl := range []WorklistRequest{backfill, lama} {
+		data, _ := json.Marshal(wl)
+		var tgl *time.Time
+		if wl.AccessionNumber == backfill.AccessionNumber {
+			tgl = wl.WaktuPermintaan()
+		}
+		mwdb.InsertSentWorklist(wl.AccessionNumber, string(data), tgl)
+		if _, err := mwdb.(*sqlStore).db.Exec("UPDATE sent_worklist SET tgl_masuk_worklist=?, tgl_gambar_diterima=? WHERE nomor_order=?",
+			gambar.Add(-time.Hour), gambar, wl.AccessionNumber); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := BuildAnalyticsReport(Config{}, mwdb, today.AddDate(0, 0, -7), today.AddDate(0, 0, 1), "daily")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.TAT) != 1 {
+		t.Fatalf("periode TAT %+v, seharusnya satu hari permintaan", report.TAT)
+	}
+	s := report.TAT[0]
+	if s.Periode != diminta.Format("2006-01-02") || s.Jumlah != 2 {
+		t.Fatalf("periode %s jumlah %d, seharusnya %s jumlah 2", s.Periode, s.Jumlah, diminta.Format("2006-01-02"))
+	}
+	if want := gambar.Sub(diminta).Minutes(); s.OrderToAcqP50 != want {
+		t.Fatalf("order ke akuisisi %.1f menit, seharusnya %.1f", s.OrderToAcqP50, want)
+	}
+
+	// Rentang yang tidak memuat hari permintaan tidak ikut menghitung order itu
+	report, err = BuildAnalyticsReport(Config{}, mwdb, today.AddDate(0, 0, -1), today.AddDate(0, 0, 1), "daily")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.TAT) != 0 {
+		t.Fatalf("order masuk periode pengiriman worklist: %+v", report.TAT)
+	}
+}
+
+func TestParseAnalyticsParams(t *testing.T) {
+	tests := []struct {
+		query string
+		ok    bool
+	}{
+		{"", true},
+		{"dari=2026-01-01&sampai=2026-12-31", true},
+		{"dari=2025-01-01&sampai=2026-12-31", false},
+		{"dari=2026-02-01&sampai=2026-01-01", false},
+	}
+	for _, tt := range tests {
+		_, _, _, err := parseAnalyticsParams(httptest.NewRequest("GET", "/analytics?"+tt.query, nil))
+		if (err == nil) != tt.ok {
+			t.Errorf("%q: error %v, seharusnya ok=%v", tt.query, err, tt.ok)
+		}
+	}
+}
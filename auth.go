@@ -0,0 +1,622 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Role pengguna portal
+const (
+	RoleAdmin        = "admin"
+	RoleRadiographer = "radiographer"
+	RoleRadiologist  = "radiologist"
+	RoleViewer       = "viewer"
+)
+
+var PortalRoles = []string{RoleAdmin, RoleRadiographer, RoleRadiologist, RoleViewer}
+
+// Hak akses yang dicek oleh handler portal
+const (
+	PermView        = "view"
+	PermOrderManage = "order.manage"
+	PermUserManage  = "user.manage"
+	PermConfig      = "config"
+	PermReading     = "reading"
+)
+
+var rolePermissions = map[string][]string{
+	RoleAdmin:        {PermView, PermOrderManage, PermUserManage, PermConfig, PermReading},
+	RoleRadiographer: {PermView, PermOrderManage},
+	RoleRadiologist:  {PermView, PermReading},
+	RoleViewer:       {PermView},
+}
+
+const sessionCookieName = "mw_session"
+
+type PortalUser struct {
+	ID       int
+	Username string
+	Nama     string
+	Role     string
+	Aktif    bool
+}
+
+type PortalSession struct {
+	Token     string
+	CSRFToken string
+	User      PortalUser
+}
+
+type sessionContextKey struct{}
+
+func HasPermission(role, perm string) bool {
+	for _, p := range rolePermissions[role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+func randomToken() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Buat akun admin awal dari konfigurasi jika belum ada pengguna sama sekali
+func EnsureAdminUser(mwdb PortalStore, cfg Config) {
+	count, err := mwdb.CountPortalUsers()
+	if err != nil {
+		log.Printf("Gagal cek portal_user: %v", err)
+		return
+	}
+	if count > 0 {
+		return
+	}
+	if cfg.PortalAdminUser == "" || cfg.PortalAdminPass == "" {
+		log.Println("Belum ada pengguna portal, isi PORTAL_ADMIN_USER dan PORTAL_ADMIN_PASSWORD untuk membuat admin awal")
+		return
+	}
+	if err := CreatePortalUser(mwdb, cfg.PortalAdminUser, "Administrator", RoleAdmin, cfg.PortalAdminPass); err != nil {
+		log.Printf("Gagal membuat admin awal: %v", err)
+		return
+	}
+	log.Printf("Admin portal awal %s dibuat", cfg.PortalAdminUser)
+}
+
+func CreatePortalUser(mwdb PortalStore, username, nama, role, password string) error {
+	if !validRole(role) {
+		return errors.New("role tidak dikenal: " + role)
+	}
+	var hash []byte
+	if password != "" {
+		var err error
+		hash, err = bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+	}
+	return mwdb.InsertPortalUser(PortalUser{Username: username, Nama: nama, Role: role, Aktif: true}, string(hash))
+}
+
+func UpdatePortalUser(mwdb PortalStore, id int, role string, aktif bool, password string) error {
+	if !validRole(role) {
+		return errors.New("role tidak dikenal: " + role)
+	}
+	if password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+		if err := mwdb.SetPortalUserPassword(id, string(hash)); err != nil {
+			return err
+		}
+	}
+	return mwdb.UpdatePortalUser(id, role, aktif)
+}
+
+func (s *sqlStore) CountPortalUsers() (int, error) {
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM portal_user").Scan(&count)
+	return count, err
+}
+
+func (s *sqlStore) InsertPortalUser(u PortalUser, passwordHash string) error {
+	_, err := s.db.Exec("INSERT INTO portal_user (username, password_hash, nama, role, aktif, dibuat) VALUES (?, ?, ?, ?, ?, ?)",
+		u.Username, passwordHash, u.Nama, u.Role, u.Aktif, time.Now())
+	return err
+}
+
+// Ubah role dan status aktif. Sesi pengguna yang dinonaktifkan langsung dihapus.
+func (s *sqlStore) UpdatePortalUser(id int, role string, aktif bool) error {
+	_, err := s.db.Exec("UPDATE portal_user SET role=?, aktif=? WHERE id=?", role, aktif, id)
+	if err == nil && !aktif {
+		_, err = s.db.Exec("DELETE FROM portal_session WHERE user_id=?", id)
+	}
+	return err
+}
+
+func (s *sqlStore) SetPortalUserPassword(id int, passwordHash string) error {
+	_, err := s.db.Exec("UPDATE portal_user SET password_hash=? WHERE id=?", passwordHash, id)
+	return err
+}
+
+func (s *sqlStore) GetPortalUsers() ([]PortalUser, error) {
+	rows, err := s.db.Query("SELECT id, username, IFNULL(nama, ''), role, aktif FROM portal_user ORDER BY username")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var users []PortalUser
+	for rows.Next() {
+		var u PortalUser
+		if err := rows.Scan(&u.ID, &u.Username, &u.Nama, &u.Role, &u.Aktif); err == nil {
+			users = append(users, u)
+		}
+	}
+	return users, rows.Err()
+}
+
+// Data pengguna beserta hash password, sql.ErrNoRows bila username tidak ada
+func (s *sqlStore) GetPortalUserCredential(username string) (PortalUser, string, error) {
+	var u PortalUser
+	var hash string
+	err := s.db.QueryRow("SELECT id, username, IFNULL(nama, ''), role, aktif, IFNULL(password_hash, '') FROM portal_user WHERE username=?", username).
+		Scan(&u.ID, &u.Username, &u.Nama, &u.Role, &u.Aktif, &hash)
+	return u, hash, err
+}
+
+func validRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// Autentikasi pengguna: password bcrypt di DB middleware, atau tabel user Khanza bila diaktifkan
+func AuthenticatePortalUser(cfg Config, mwdb PortalStore, khanzaDB *sql.DB, username, password string) (PortalUser, error) {
+	u, hash, err := mwdb.GetPortalUserCredential(username)
+	if err != nil && err != sql.ErrNoRows {
+		return u, err
+	}
+	found := err == nil
+	if found && !u.Aktif {
+		return u, errors.New("akun tidak aktif")
+	}
+	if found && hash != "" {
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+			return u, errors.New("username atau password salah")
+		}
+		return u, nil
+	}
+	if !cfg.PortalKhanzaAuth {
+		return u, errors.New("username atau password salah")
+	}
+
+	// Tabel user Khanza menyimpan id_user dan password dengan AES_ENCRYPT
+	var ok bool
+	err = khanzaDB.QueryRow("SELECT EXISTS(SELECT 1 FROM user WHERE id_user=AES_ENCRYPT(?, 'nur') AND password=AES_ENCRYPT(?, 'windi'))",
+		username, password).Scan(&ok)
+	if err != nil {
+		return u, err
+	}
+	if !ok {
+		return u, errors.New("username atau password salah")
+	}
+	if !found {
+		// Pengguna Khanza yang belum terdaftar di portal mendapat role default
+		if err := CreatePortalUser(mwdb, username, username, cfg.PortalKhanzaRole, ""); err != nil {
+			return u, err
+		}
+		return AuthenticatePortalUser(cfg, mwdb, khanzaDB, username, password)
+	}
+	return u, nil
+}
+
+func CreateSession(mwdb PortalStore, userID int) (PortalSession, error) {
+	s := PortalSession{Token: randomToken(), CSRFToken: randomToken(), User: PortalUser{ID: userID}}
+	now := time.Now()
+	err := mwdb.InsertPortalSession(s, now, now.Add(RuntimeConfig().SessionDuration))
+	return s, err
+}
+
+// Simpan sesi baru sekaligus bersihkan sesi yang sudah kadaluarsa
+func (s *sqlStore) InsertPortalSession(session PortalSession, dibuat, kadaluarsa time.Time) error {
+	s.db.Exec("DELETE FROM portal_session WHERE kadaluarsa < ?", dibuat)
+	_, err := s.db.Exec("INSERT INTO portal_session (token, user_id, csrf_token, dibuat, kadaluarsa) VALUES (?, ?, ?, ?, ?)",
+		session.Token, session.User.ID, session.CSRFToken, dibuat, kadaluarsa)
+	return err
+}
+
+func (s *sqlStore) GetPortalSession(token string, now time.Time) (PortalSession, error) {
+	var session PortalSession
+	err := s.db.QueryRow(`SELECT s.token, s.csrf_token, u.id, u.username, IFNULL(u.nama, ''), u.role, u.aktif
+		FROM portal_session s JOIN portal_user u ON u.id = s.user_id
+		WHERE s.token=? AND s.kadaluarsa > ? AND u.aktif=1`, token, now).
+		Scan(&session.Token, &session.CSRFToken, &session.User.ID, &session.User.Username, &session.User.Nama, &session.User.Role, &session.User.Aktif)
+	return session, err
+}
+
+func (s *sqlStore) DeletePortalSession(token string) error {
+	_, err := s.db.Exec("DELETE FROM portal_session WHERE token=?", token)
+	return err
+}
+
+// Ambil sesi aktif dari context request (diisi oleh RequirePermission)
+func CurrentSession(r *http.Request) PortalSession {
+	s, _ := r.Context().Value(sessionContextKey{}).(PortalSession)
+	return s
+}
+
+// Bungkus handler portal: wajib login, punya hak akses, dan token CSRF valid untuk request non-GET
+func RequirePermission(mwdb PortalStore, perm string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie(sessionCookieName)
+		if err != nil {
+			redirectToLogin(w, r)
+			return
+		}
+		session, err := mwdb.GetPortalSession(cookie.Value, time.Now())
+		if err != nil {
+			redirectToLogin(w, r)
+			return
+		}
+		if !HasPermission(session.User.Role, perm) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead && !validCSRF(r, session) {
+			http.Error(w, "CSRF token tidak valid", http.StatusForbidden)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), sessionContextKey{}, session)))
+	}
+}
+
+func validCSRF(r *http.Request, s PortalSession) bool {
+	token := r.Header.Get("X-CSRF-Token")
+	if token == "" {
+		token = r.FormValue("csrf_token")
+	}
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.CSRFToken)) == 1
+}
+
+// Tujuan setelah login hanya boleh path lokal. "//host" dan "/\\host" dibaca
+// browser sebagai alamat situs lain; tab dan baris baru dibuang browser
+// sehingga "/\t/host" menjadi "//host".
+func safeRedirect(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") ||
+		strings.IndexFunc(next, unicode.IsControl) >= 0 {
+		return "/"
+	}
+	return next
+}
+
+// Batas login gagal. Hitungan username dikunci per IP agar orang lain tidak
+// bisa mengunci akun admin dari tempat lain; batas per IP lebih longgar
+// karena beberapa pengguna bisa berbagi IP (NAT).
+const (
+	loginMaxFailuresUser = 5
+	loginMaxFailuresIP   = 30
+	loginFailureWindow   = 15 * time.Minute
+	// Batas jumlah key di memori, key dengan kegagalan terakhir paling lama
+	// dibuang lebih dulu
+	loginThrottleMaxKeys = 10000
+)
+
+// Hitungan login gagal di memori, hilang saat restart
+type loginThrottle struct {
+	mu        sync.Mutex
+	failures  map[string][]time.Time
+	lastSweep time.Time
+}
+
+var portalLoginThrottle = &loginThrottle{failures: map[string][]time.Time{}}
+
+func loginUserKey(username, ip string) string { return "user:" + username + "|" + ip }
+func loginIPKey(ip string) string             { return "ip:" + ip }
+
+// Jumlah kegagalan key yang masih di dalam jendela waktu
+func (t *loginThrottle) recent(key string, now time.Time) int {
+	var kept []time.Time
+	for _, f := range t.failures[key] {
+		if now.Sub(f) < loginFailureWindow {
+			kept = append(kept, f)
+		}
+	}
+	if len(kept) == 0 {
+		delete(t.failures, key)
+	} else {
+		t.failures[key] = kept
+	}
+	return len(kept)
+}
+
+// Buang key yang kegagalannya sudah di luar jendela waktu. Tanpa ini
+// username atau IP acak yang tidak pernah muncul lagi tertinggal selamanya.
+func (t *loginThrottle) sweep(now time.Time) {
+	for key := range t.failures {
+		t.recent(key, now)
+	}
+	t.lastSweep = now
+	if len(t.failures) <= loginThrottleMaxKeys {
+		return
+	}
+	// Sisakan 90% agar pembuangan tidak berjalan di setiap kegagalan berikutnya
+	keys := make([]string, 0, len(t.failures))
+	for key := range t.failures {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := t.failures[keys[i]], t.failures[keys[j]]
+		return a[len(a)-1].Before(b[len(b)-1])
+	})
+	for _, key := range keys[:len(keys)-loginThrottleMaxKeys*9/10] {
+		delete(t.failures, key)
+	}
+}
+
+func (t *loginThrottle) Blocked(username, ip string, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.recent(loginUserKey(username, ip), now) >= loginMaxFailuresUser || t.recent(loginIPKey(ip), now) >= loginMaxFailuresIP
+}
+
+func (t *loginThrottle) Fail(username, ip string, now time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, key := range []string{loginUserKey(username, ip), loginIPKey(ip)} {
+		t.recent(key, now)
+		t.failures[key] = append(t.failures[key], now)
+	}
+	if now.Sub(t.lastSweep) >= time.Minute || len(t.failures) > loginThrottleMaxKeys {
+		t.sweep(now)
+	}
+}
+
+// Login berhasil menghapus hitungan username dari IP itu, hitungan IP tetap berjalan
+func (t *loginThrottle) Reset(username, ip string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.failures, loginUserKey(username, ip))
+}
+
+// Request datang dari reverse proxy yang dipercaya (TRUSTED_PROXIES)
+func fromTrustedProxy(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, p := range splitList(RuntimeConfig().TrustedProxies) {
+		if _, network, err := net.ParseCIDR(p); err == nil && network.Contains(ip) {
+			return true
+		}
+		if proxy := net.ParseIP(p); proxy != nil && proxy.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// IP klien. Di belakang proxy tepercaya dipakai alamat terakhir di
+// X-Forwarded-For, yaitu yang ditambahkan proxy itu sendiri.
+func clientIP(r *http.Request) string {
+	if fromTrustedProxy(r) {
+		hops := splitList(r.Header.Get("X-Forwarded-For"))
+		if len(hops) > 0 && net.ParseIP(hops[len(hops)-1]) != nil {
+			return hops[len(hops)-1]
+		}
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// Cookie sesi hanya dikirim lewat HTTPS bila PORTAL_COOKIE_SECURE aktif, portal
+// dibuka langsung lewat TLS, atau proxy tepercaya melaporkan X-Forwarded-Proto https
+func secureRequest(r *http.Request) bool {
+	if RuntimeConfig().PortalCookieSecure || r.TLS != nil {
+		return true
+	}
+	return fromTrustedProxy(r) && strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
+
+func redirectToLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet && r.Header.Get("Accept") != "application/json" {
+		http.Redirect(w, r, "/login?next="+template.URLQueryEscaper(r.URL.RequestURI()), http.StatusSeeOther)
+		return
+	}
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+}
+
+var loginTmpl = `
+<!DOCTYPE html>
+<html>
+<head>
+    <title>Login Portal Radiologi</title>
+    <style>
+        body { font-family: Arial; margin: 40px; }
+        .fail { color: red; }
+        input { display: block; margin: 6px 0 12px 0; }
+    </style>
+</head>
+<body>
+    <h2>Login Portal Radiologi</h2>
+    {{if .Error}}<p class="fail">{{.Error}}</p>{{end}}
+    <form method="post" action="/login">
+        <input type="hidden" name="next" value="{{.Next}}">
+        Username <input type="text" name="username" autofocus>
+        Password <input type="password" name="password">
+        <button type="submit">Login</button>
+    </form>
+</body>
+</html>
+`
+
+var usersTmpl = `
+<!DOCTYPE html>
+<html>
+<head>
+    <title>Pengguna Portal</title>
+    <style>
+        body { font-family: Arial; margin: 40px; }
+        table { border-collapse: collapse; margin-bottom: 30px; }
+        th, td { border: 1px solid #ccc; padding: 6px 10px; text-align: left; }
+        th { background: #f0f0f0; }
+    </style>
+</head>
+<body>
+    <h2>Pengguna Portal</h2>
+    <p><a href="/">Dashboard</a></p>
+    <table>
+        <tr><th>Username</th><th>Nama</th><th>Role</th><th>Aktif</th><th>Password Baru</th><th></th></tr>
+        {{range .Users}}
+        <tr><form method="post" action="/users/update">
+            <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+            <input type="hidden" name="id" value="{{.ID}}">
+            <td>{{.Username}}</td><td>{{.Nama}}</td>
+            <td><select name="role">{{$role := .Role}}{{range $.Roles}}<option value="{{.}}" {{if eq . $role}}selected{{end}}>{{.}}</option>{{end}}</select></td>
+            <td><input type="checkbox" name="aktif" value="1" {{if .Aktif}}checked{{end}}></td>
+            <td><input type="password" name="password"></td>
+            <td><button type="submit">Simpan</button></td>
+        </form></tr>
+        {{end}}
+    </table>
+    <h3>Tambah Pengguna</h3>
+    <form method="post" action="/users/create">
+        <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+        Username <input type="text" name="username">
+        Nama <input type="text" name="nama">
+        Role <select name="role">{{range .Roles}}<option value="{{.}}">{{.}}</option>{{end}}</select>
+        Password <input type="password" name="password">
+        <button type="submit">Tambah</button>
+    </form>
+</body>
+</html>
+`
+
+func registerAuthHandlers(mux *http.ServeMux, cfg Config, db *sql.DB, mwdb Store) {
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		next := safeRedirect(r.FormValue("next"))
+		render := func(status int, pesan string) {
+			w.WriteHeader(status)
+			t, _ := template.New("login").Parse(loginTmpl)
+			t.Execute(w, struct{ Error, Next string }{pesan, next})
+		}
+		if r.Method != http.MethodPost {
+			render(http.StatusOK, "")
+			return
+		}
+		username := r.FormValue("username")
+		ip := clientIP(r)
+		logger := NewLogger(mwdb, CompAuth)
+		if portalLoginThrottle.Blocked(username, ip, time.Now()) {
+			logger.Warn("Login portal "+username+" dari "+ip+" ditahan: terlalu banyak percobaan gagal", LogFields{})
+			render(http.StatusTooManyRequests, "Terlalu banyak percobaan login gagal, coba lagi beberapa menit lagi")
+			return
+		}
+		user, err := AuthenticatePortalUser(cfg, mwdb, db, username, r.FormValue("password"))
+		if err != nil {
+			portalLoginThrottle.Fail(username, ip, time.Now())
+			logger.Warn("Login portal gagal untuk "+username+" dari "+ip, LogFields{Err: err})
+			render(http.StatusUnauthorized, "Username atau password salah")
+			return
+		}
+		portalLoginThrottle.Reset(username, ip)
+		session, err := CreateSession(mwdb, user.ID)
+		if err != nil {
+			logger.Error("Gagal membuat sesi login "+username, LogFields{Err: err})
+			render(http.StatusInternalServerError, "Login gagal, coba lagi")
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     sessionCookieName,
+			Value:    session.Token,
+			Path:     "/",
+			HttpOnly: true,
+			Secure:   secureRequest(r),
+			SameSite: http.SameSiteLaxMode,
+			Expires:  time.Now().Add(RuntimeConfig().SessionDuration),
+		})
+		logger.Info("Login portal "+username, LogFields{})
+		http.Redirect(w, r, next, http.StatusSeeOther)
+	})
+
+	mux.HandleFunc("/logout", RequirePermission(mwdb, PermView, func(w http.ResponseWriter, r *http.Request) {
+		mwdb.DeletePortalSession(CurrentSession(r).Token)
+		http.SetCookie(w, &http.Cookie{Name: sessionCookieName, Value: "", Path: "/", MaxAge: -1})
+		http.Redirect(w, r, "/login", http.StatusSeeOther)
+	}))
+
+	mux.HandleFunc("/users", RequirePermission(mwdb, PermUserManage, func(w http.ResponseWriter, r *http.Request) {
+		users, err := mwdb.GetPortalUsers()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		t, _ := template.New("users").Parse(usersTmpl)
+		t.Execute(w, struct {
+			Users     []PortalUser
+			Roles     []string
+			CSRFToken string
+		}{users, PortalRoles, CurrentSession(r).CSRFToken})
+	}))
+
+	mux.HandleFunc("/users/create", RequirePermission(mwdb, PermUserManage, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		username := r.FormValue("username")
+		if username == "" || r.FormValue("password") == "" {
+			http.Error(w, "username dan password wajib diisi", http.StatusBadRequest)
+			return
+		}
+		if err := CreatePortalUser(mwdb, username, r.FormValue("nama"), r.FormValue("role"), r.FormValue("password")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		NewLogger(mwdb, CompAuth).Info("Pengguna "+username+" dibuat oleh "+CurrentSession(r).User.Username, LogFields{})
+		http.Redirect(w, r, "/users", http.StatusSeeOther)
+	}))
+
+	mux.HandleFunc("/users/update", RequirePermission(mwdb, PermUserManage, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id, err := strconv.Atoi(r.FormValue("id"))
+		if err != nil {
+			http.Error(w, "id tidak valid", http.StatusBadRequest)
+			return
+		}
+		if err := UpdatePortalUser(mwdb, id, r.FormValue("role"), r.FormValue("aktif") == "1", r.FormValue("password")); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		NewLogger(mwdb, CompAuth).Info("Pengguna id "+r.FormValue("id")+" diubah oleh "+CurrentSession(r).User.Username, LogFields{})
+		http.Redirect(w, r, "/users", http.StatusSeeOther)
+	}))
+}
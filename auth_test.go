@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSafeRedirect(t *testing.T) {
+	tests := map[string]string{
+		"":                      "/",
+		"/":                     "/",
+		"/reading?status=baru":  "/reading?status=baru",
+		"/report?accession=CR1": "/report?accession=CR1",
+		"//evil.com":            "/",
+		"/\\evil.com":           "/",
+		"/\t/evil.com":          "/",
+		"https://evil.com":      "/",
+		"evil.com":              "/",
+	}
+	for next, want := range tests {
+		if got := safeRedirect(next); got != want {
+			t.Errorf("safeRedirect(%q) = %q, seharusnya %q", next, got, want)
+		}
+	}
+}
+
+func loginFrom(ip string, mux *http.ServeMux, username, password, next string) *httptest.ResponseRecorder {
+	form := url.Values{"username": {username}, "password": {password}, "next": {next}}
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.RemoteAddr = ip + ":51234"
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestLoginThrottle(t *testing.T) {
+	var cfg Config
+	if err := applyDefaults(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	SetRuntimeConfig(cfg)
+	defer SetRuntimeConfig(Config{})
+	lama := portalLoginThrottle
+	portalLoginThrottle = &loginThrottle{failures: map[string][]time.Time{}}
+	defer func() { portalLoginThrottle = lama }()
+
+	mwdb := openMemoryStore(t)
+	if err := mwdb.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range []string{"radiolog1", "radiolog2"} {
+		if err := CreatePortalUser(mwdb, u, u, RoleRadiologist, "rahasia-"+u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mux := http.NewServeMux()
+	registerAuthHandlers(mux, cfg, nil, mwdb)
+	login := func(username, password, next string) *httptest.ResponseRecorder {
+		return loginFrom("10.0.0.7", mux, username, password, next)
+	}
+	loginFrom := func(ip, username, password string) *httptest.ResponseRecorder {
+		return loginFrom(ip, mux, username, password, "/")
+	}
+
+	for i := 0; i < loginMaxFailuresUser; i++ {
+		if rec := login("radiolog1", "salah", "/"); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("percobaan %d: status %d, seharusnya 401", i+1, rec.Code)
+		}
+	}
+	if rec := login("radiolog1", "rahasia-radiolog1", "/"); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("password benar setelah %d gagal: status %d, seharusnya 429", loginMaxFailuresUser, rec.Code)
+	}
+	// Pengguna lain dari IP yang sama tetap bisa login, dan next eksternal diabaikan
+	rec := login("radiolog2", "rahasia-radiolog2", "//evil.com")
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/" {
+		t.Fatalf("login radiolog2: status %d location %q", rec.Code, rec.Header().Get("Location"))
+	}
+	if len(rec.Result().Cookies()) == 0 {
+		t.Fatal("login berhasil tanpa cookie sesi")
+	}
+	// Percobaan gagal dari satu IP tidak mengunci username di IP lain
+	if rec := loginFrom("10.0.0.8", "radiolog1", "rahasia-radiolog1"); rec.Code != http.StatusSeeOther {
+		t.Fatalf("login radiolog1 dari IP lain: status %d, seharusnya 303", rec.Code)
+	}
+	// Setelah jendela waktu lewat, username boleh mencoba lagi
+	if portalLoginThrottle.Blocked("radiolog1", "10.0.0.7", time.Now().Add(loginFailureWindow)) {
+		t.Fatal("username masih ditahan setelah jendela waktu lewat")
+	}
+}
+
+// Username dan IP acak tidak boleh menumpuk di memori
+func TestLoginThrottleBounded(t *testing.T) {
+	throttle := &loginThrottle{failures: map[string][]time.Time{}}
+	now := time.Now()
+	for i := 0; i < loginThrottleMaxKeys; i++ {
+		throttle.Fail(fmt.Sprintf("acak%d", i), fmt.Sprintf("10.1.%d.%d", i/250, i%250), now.Add(time.Duration(i)*time.Millisecond))
+	}
+	if n := len(throttle.failures); n > loginThrottleMaxKeys {
+		t.Fatalf("%d key di memori, batas %d", n, loginThrottleMaxKeys)
+	}
+	// Key terbaru tetap dihitung
+	last := loginThrottleMaxKeys - 1
+	if throttle.failures[loginIPKey(fmt.Sprintf("10.1.%d.%d", last/250, last%250))] == nil {
+		t.Fatal("key terbaru ikut dibuang")
+	}
+	// Setelah jendela waktu lewat, sweep mengosongkan semua key lama
+	throttle.Fail("baru", "10.2.0.1", now.Add(loginFailureWindow+time.Hour))
+	if n := len(throttle.failures); n != 2 {
+		t.Fatalf("%d key tersisa setelah sweep, seharusnya 2", n)
+	}
+}
+
+func TestClientIPAndSecureCookie(t *testing.T) {
+	SetRuntimeConfig(Config{TrustedProxies: "10.9.0.0/16, 192.168.1.1"})
+	defer SetRuntimeConfig(Config{})
+	tests := []struct {
+		remote, forwardedFor, proto string
+		ip                          string
+		secure                      bool
+	}{
+		{"203.0.113.5:4000", "", "", "203.0.113.5", false},
+		// Header dari klien langsung tidak dipercaya
+		{"203.0.113.5:4000", "198.51.100.1", "https", "203.0.113.5", false},
+		{"10.9.3.4:4000", "198.51.100.9, 203.0.113.7", "https", "203.0.113.7", true},
+		{"192.168.1.1:4000", "203.0.113.8", "http", "203.0.113.8", false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/login", nil)
+		req.RemoteAddr = tt.remote
+		if tt.forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", tt.forwardedFor)
+		}
+		if tt.proto != "" {
+			req.Header.Set("X-Forwarded-Proto", tt.proto)
+		}
+		if ip := clientIP(req); ip != tt.ip {
+			t.Errorf("%s %q: clientIP %s, seharusnya %s", tt.remote, tt.forwardedFor, ip, tt.ip)
+		}
+		if secure := secureRequest(req); secure != tt.secure {
+			t.Errorf("%s %q: secure %v, seharusnya %v", tt.remote, tt.proto, secure, tt.secure)
+		}
+	}
+	SetRuntimeConfig(Config{PortalCookieSecure: true})
+	if !secureRequest(httptest.NewRequest(http.MethodGet, "/login", nil)) {
+		t.Error("PORTAL_COOKIE_SECURE tidak dipakai")
+	}
+}
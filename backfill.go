@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Rentang backfill manual dibatasi agar query Khanza tidak terlalu berat
+const maxBackfillDays = 31
+
+// Rentang tgl_permintaan yang dipantau loop worklist: beberapa hari ke belakang
+// untuk order susulan setelah tengah malam, dan ke depan untuk jadwal besok
+func worklistWindow(now time.Time, cfg Config) (string, string) {
+	dari := now.AddDate(0, 0, -cfg.WorklistLookbackDays).Format("2006-01-02")
+	sampai := now.AddDate(0, 0, cfg.WorklistLookaheadDays).Format("2006-01-02")
+	return dari, sampai
+}
+
+func parseBackfillRange(dari, sampai string) (time.Time, time.Time, error) {
+	d, err := time.Parse("2006-01-02", dari)
+	if err != nil {
+		return d, d, fmt.Errorf("tanggal awal tidak valid: %s", dari)
+	}
+	s, err := time.Parse("2006-01-02", sampai)
+	if err != nil {
+		return d, s, fmt.Errorf("tanggal akhir tidak valid: %s", sampai)
+	}
+	if s.Before(d) {
+		return d, s, fmt.Errorf("tanggal akhir %s sebelum tanggal awal %s", sampai, dari)
+	}
+	if s.Sub(d) > (maxBackfillDays-1)*24*time.Hour {
+		return d, s, fmt.Errorf("rentang backfill maksimal %d hari", maxBackfillDays)
+	}
+	return d, s, nil
+}
+
+// Kirim semua order Khanza pada rentang tanggal yang belum pernah terkirim,
+// misalnya setelah DB Khanza atau Orthanc sempat mati
+func BackfillWorklists(ctx context.Context, cfg Config, db *sql.DB, mwdb Store, dari, sampai string) (SendResult, error) {
+	if _, _, err := parseBackfillRange(dari, sampai); err != nil {
+		return SendResult{}, err
+	}
+	worklists, err := GetPendingWorklistRange(db, dari, sampai)
+	if err != nil {
+		return SendResult{}, err
+	}
+	res := sendWorklists(ctx, cfg, mwdb, worklists)
+	NewLogger(mwdb, CompWorklist).Info(fmt.Sprintf("Backfill %s s/d %s: %d order, %d terkirim, %d gagal, %d sudah terkirim",
+		dari, sampai, len(worklists), res.Terkirim, res.Gagal, res.Dilewati), LogFields{})
+	return res, nil
+}
+
+// State yang boleh dikirim ulang: gambar belum diterima
+func canResend(status string) bool {
+	switch OrderState(status) {
+	case OrderOrdered, OrderWorklisted, OrderError:
+		return true
+	}
+	return false
+}
+
+// Buat ulang file .wl dari data worklist yang tersimpan, misalnya bila file
+// terhapus dari folder worklist atau modality tidak menerimanya
+func ResendWorklist(cfg Config, mwdb Store, accession, alasan string) error {
+	sent, err := mwdb.GetSentWorklists([]string{accession})
+	if err != nil {
+		return err
+	}
+	sw, ok := sent[accession]
+	if !ok {
+		return fmt.Errorf("order %s tidak ditemukan di middleware", accession)
+	}
+	if !canResend(sw.Status) {
+		return fmt.Errorf("order %s berstatus %s, worklist tidak bisa dikirim ulang", accession, sw.Status)
+	}
+	if sw.Worklist == "" {
+		return fmt.Errorf("data worklist order %s tidak tersimpan, jalankan backfill untuk tanggal order tersebut", accession)
+	}
+	var wl WorklistRequest
+	if err := json.Unmarshal([]byte(sw.Worklist), &wl); err != nil {
+		return fmt.Errorf("data worklist order %s rusak: %v", accession, err)
+	}
+
+	logger := NewLogger(mwdb, CompWorklist)
+	fields := LogFields{Accession: accession, Pasien: wl.PatientID}
+	if err := SendWorklistToOrthanc(cfg, wl); err != nil {
+		fields.Err = err
+		logger.Error("Gagal kirim ulang worklist", fields)
+		transitionOrLog(mwdb, accession, OrderError, "kirim ulang gagal: "+err.Error())
+		return err
+	}
+	keterangan := "file .wl dibuat ulang"
+	if alasan != "" {
+		keterangan += ": " + alasan
+	}
+	logger.Info("Worklist dikirim ulang", fields)
+	return mwdb.TransitionOrder(accession, OrderWorklisted, keterangan)
+}
+
+func registerBackfillHandlers(mux *http.ServeMux, cfg Config, db *sql.DB, mwdb Store) {
+	mux.HandleFunc("/worklist/backfill", RequirePermission(mwdb, PermOrderManage, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		dari, sampai := r.FormValue("dari"), r.FormValue("sampai")
+		res, err := BackfillWorklists(r.Context(), cfg, db, mwdb, dari, sampai)
+		pesan := fmt.Sprintf("Backfill %s s/d %s oleh %s: %d terkirim, %d gagal, %d sudah terkirim",
+			dari, sampai, CurrentSession(r).User.Username, res.Terkirim, res.Gagal, res.Dilewati)
+		if err != nil {
+			pesan = "Backfill gagal: " + err.Error()
+		}
+		http.Redirect(w, r, "/worklist?date="+url.QueryEscape(dari)+"&pesan="+url.QueryEscape(pesan), http.StatusSeeOther)
+	}))
+
+	mux.HandleFunc("/order/resend", RequirePermission(mwdb, PermOrderManage, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		accession := r.FormValue("accession")
+		alasan := "oleh " + CurrentSession(r).User.Username
+		if a := r.FormValue("alasan"); a != "" {
+			alasan = a + " (" + alasan + ")"
+		}
+		if err := ResendWorklist(cfg, mwdb, accession, alasan); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		http.Redirect(w, r, "/order?accession="+url.QueryEscape(accession), http.StatusSeeOther)
+	}))
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Tagihan pemeriksaan radiologi di Khanza. Setiap item permintaan_pemeriksaan_radiologi
+// menjadi satu baris periksa_radiologi dengan rincian tarif dari
+// jns_perawatan_radiologi, dokter radiolog di kd_dokter dan radiografer di nip.
+// Waktu periksa diambil dari waktu sampel order (atau waktu permintaan) agar
+// proses ulang webhook tidak membuat tagihan ganda dan hasil_radiologi
+// tersambung ke baris periksa yang sama.
+
+type PeriksaRadiologi struct {
+	NoRawat       string
+	KdJenisPrw    string
+	TglPeriksa    string
+	Jam           string
+	DokterPerujuk string
+	KdDokter      string
+	Nip           string
+	BagianRS      float64
+	BHP           float64
+	TarifPerujuk  float64
+	TarifDokter   float64
+	TarifPetugas  float64
+	KSO           float64
+	Menejemen     float64
+	Biaya         float64
+	Status        string
+}
+
+// Hasil sudah tersimpan di hasil_radiologi tetapi tagihan periksa_radiologi gagal
+var ErrBillingFailed = errors.New("tagihan periksa_radiologi gagal")
+
+// Pelaksana pemeriksaan dari tag DICOM, berupa kode atau nama di Khanza
+type Pelaksana struct {
+	Dokter  string
+	Petugas string
+}
+
+// Nama DICOM (Family^Given) menjadi nama biasa
+func dicomName(pn string) string {
+	return strings.Join(strings.Fields(strings.ReplaceAll(pn, "^", " ")), " ")
+}
+
+// Cari kode di tabel master Khanza berdasarkan kode atau nama, kosong bila tidak ada
+func lookupKhanzaCode(db *sql.DB, query, nilai string) string {
+	if nilai == "" {
+		return ""
+	}
+	var kode string
+	if err := db.QueryRow(query, nilai, dicomName(nilai)).Scan(&kode); err != nil {
+		return ""
+	}
+	return kode
+}
+
+// Dokter radiolog: Performing Physician di DICOM, lalu KHANZA_KD_DOKTER_RADIOLOG
+func resolveRadiolog(db *sql.DB, cfg Config, p Pelaksana) (string, error) {
+	if kd := lookupKhanzaCode(db, "SELECT kd_dokter FROM dokter WHERE kd_dokter = ? OR nm_dokter = ? LIMIT 1", p.Dokter); kd != "" {
+		return kd, nil
+	}
+	if cfg.KhanzaKdDokterRadiolog != "" {
+		return cfg.KhanzaKdDokterRadiolog, nil
+	}
+	return "", fmt.Errorf("dokter radiolog %q tidak ditemukan di tabel dokter dan KHANZA_KD_DOKTER_RADIOLOG kosong", p.Dokter)
+}
+
+// Radiografer: Operators' Name di DICOM, lalu KHANZA_NIP_RADIOGRAFER
+func resolveRadiografer(db *sql.DB, cfg Config, p Pelaksana) (string, error) {
+	if nip := lookupKhanzaCode(db, "SELECT nip FROM petugas WHERE nip = ? OR nama = ? LIMIT 1", p.Petugas); nip != "" {
+		return nip, nil
+	}
+	if cfg.KhanzaNipRadiografer != "" {
+		return cfg.KhanzaNipRadiografer, nil
+	}
+	return "", fmt.Errorf("radiografer %q tidak ditemukan di tabel petugas dan KHANZA_NIP_RADIOGRAFER kosong", p.Petugas)
+}
+
+// Tanggal kosong Khanza ditulis sebagai NULL atau 0000-00-00
+func khanzaDateSet(tgl sql.NullString) bool {
+	return tgl.Valid && tgl.String != "" && !strings.HasPrefix(tgl.String, "0000-00-00")
+}
+
+// Data permintaan_radiologi yang menjadi kunci periksa_radiologi dan hasil_radiologi
+type permintaanRadiologi struct {
+	NoRawat       string
+	TglPeriksa    string
+	Jam           string
+	DokterPerujuk string
+	Status        string
+}
+
+func loadPermintaanRadiologi(db *sql.DB, noorder string) (permintaanRadiologi, error) {
+	var (
+		p                            permintaanRadiologi
+		tglPermintaan, jamPermintaan string
+		tglSampel, jamSampel         sql.NullString
+	)
+	kolomSampel := "NULL, NULL"
+	if k := CurrentKhanzaSchema().kolom; k.TglSampel != "" {
+		kolomSampel = k.TglSampel + ", " + k.JamSampel
+	}
+	err := db.QueryRow("SELECT no_rawat, tgl_permintaan, jam_permintaan, dokter_perujuk, IFNULL(status, ''), "+kolomSampel+
+		" FROM permintaan_radiologi WHERE noorder = ?", noorder).
+		Scan(&p.NoRawat, &tglPermintaan, &jamPermintaan, &p.DokterPerujuk, &p.Status, &tglSampel, &jamSampel)
+	if err != nil {
+		return p, fmt.Errorf("permintaan_radiologi %s: %v", noorder, err)
+	}
+	p.TglPeriksa, p.Jam = tglPermintaan, jamPermintaan
+	if khanzaDateSet(tglSampel) && jamSampel.Valid {
+		p.TglPeriksa, p.Jam = tglSampel.String, jamSampel.String
+	}
+	// periksa_radiologi.status berupa enum Ralan/Ranap
+	if p.Status != "" {
+		p.Status = strings.ToUpper(p.Status[:1]) + strings.ToLower(p.Status[1:])
+	}
+	return p, nil
+}
+
+// Susun baris periksa_radiologi untuk semua pemeriksaan dalam satu order
+func BuildBillingRows(db *sql.DB, cfg Config, noorder string, p Pelaksana) ([]PeriksaRadiologi, error) {
+	permintaan, err := loadPermintaanRadiologi(db, noorder)
+	if err != nil {
+		return nil, err
+	}
+	kdDokter, err := resolveRadiolog(db, cfg, p)
+	if err != nil {
+		return nil, err
+	}
+	nip, err := resolveRadiografer(db, cfg, p)
+	if err != nil {
+		return nil, err
+	}
+
+	tarif := "0, 0, 0, 0, 0, 0, 0"
+	if CurrentKhanzaSchema().TarifLengkap {
+		tarif = "IFNULL(jpr.bagian_rs, 0), IFNULL(jpr.bhp, 0), IFNULL(jpr.tarif_perujuk, 0), IFNULL(jpr.tarif_tindakan_dokter, 0), " +
+			"IFNULL(jpr.tarif_tindakan_petugas, 0), IFNULL(jpr.kso, 0), IFNULL(jpr.menejemen, 0)"
+	}
+	rows, err := db.Query(`SELECT pj.kd_jenis_prw, `+tarif+`, IFNULL(jpr.total_byr, 0)
+		FROM permintaan_pemeriksaan_radiologi pj
+		LEFT JOIN jns_perawatan_radiologi jpr ON pj.kd_jenis_prw = jpr.kd_jenis_prw
+		WHERE pj.noorder = ?
+		ORDER BY pj.kd_jenis_prw`, noorder)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []PeriksaRadiologi
+	for rows.Next() {
+		r := PeriksaRadiologi{
+			NoRawat: permintaan.NoRawat, TglPeriksa: permintaan.TglPeriksa, Jam: permintaan.Jam,
+			DokterPerujuk: permintaan.DokterPerujuk, KdDokter: kdDokter, Nip: nip, Status: permintaan.Status,
+		}
+		if err := rows.Scan(&r.KdJenisPrw, &r.BagianRS, &r.BHP, &r.TarifPerujuk, &r.TarifDokter,
+			&r.TarifPetugas, &r.KSO, &r.Menejemen, &r.Biaya); err != nil {
+			return nil, err
+		}
+		result = append(result, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("order %s tidak punya item permintaan_pemeriksaan_radiologi", noorder)
+	}
+	return result, nil
+}
+
+// Tulis tagihan dan link gambar dalam satu transaksi. Baris yang sudah ada
+// dilewati. Mengembalikan tgl_periksa dan jam untuk kunci hasil_radiologi.
+func InsertPeriksaRadiologiFromPermintaan(db *sql.DB, cfg Config, noorder, linkGambar string, p Pelaksana) (string, string, error) {
+	rows, err := BuildBillingRows(db, cfg, noorder, p)
+	if err != nil {
+		return "", "", err
+	}
+	tarifLengkap := CurrentKhanzaSchema().TarifLengkap
+
+	tx, err := db.Begin()
+	if err != nil {
+		return "", "", err
+	}
+	defer tx.Rollback()
+	for _, r := range rows {
+		var count int
+		err := tx.QueryRow("SELECT COUNT(*) FROM periksa_radiologi WHERE no_rawat = ? AND kd_jenis_prw = ? AND tgl_periksa = ? AND jam = ?",
+			r.NoRawat, r.KdJenisPrw, r.TglPeriksa, r.Jam).Scan(&count)
+		if err != nil {
+			return "", "", err
+		}
+		if count > 0 {
+			continue
+		}
+		if tarifLengkap {
+			_, err = tx.Exec(`INSERT INTO periksa_radiologi (no_rawat, nip, kd_jenis_prw, tgl_periksa, jam, dokter_perujuk,
+				bagian_rs, bhp, tarif_perujuk, tarif_tindakan_dokter, tarif_tindakan_petugas, kso, menejemen, biaya, kd_dokter, status)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+				r.NoRawat, r.Nip, r.KdJenisPrw, r.TglPeriksa, r.Jam, r.DokterPerujuk,
+				r.BagianRS, r.BHP, r.TarifPerujuk, r.TarifDokter, r.TarifPetugas, r.KSO, r.Menejemen, r.Biaya, r.KdDokter, r.Status)
+		} else {
+			_, err = tx.Exec(`INSERT INTO periksa_radiologi (no_rawat, nip, kd_jenis_prw, tgl_periksa, jam, dokter_perujuk, biaya, kd_dokter, status)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+				r.NoRawat, r.Nip, r.KdJenisPrw, r.TglPeriksa, r.Jam, r.DokterPerujuk, r.Biaya, r.KdDokter, r.Status)
+		}
+		if err != nil {
+			return "", "", fmt.Errorf("periksa_radiologi %s: %v", r.KdJenisPrw, err)
+		}
+	}
+
+	first := rows[0]
+	if linkGambar != "" {
+		if err := insertGambarRadiologi(tx, first.NoRawat, first.TglPeriksa, first.Jam, linkGambar); err != nil {
+			return "", "", err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return "", "", err
+	}
+	return first.TglPeriksa, first.Jam, nil
+}
+
+// *sql.DB atau *sql.Tx
+type khanzaExecer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// Tambah link gambar bila belum ada
+func insertGambarRadiologi(q khanzaExecer, noRawat, tglPeriksa, jam, link string) error {
+	var count int
+	err := q.QueryRow("SELECT COUNT(*) FROM gambar_radiologi WHERE no_rawat = ? AND tgl_periksa = ? AND jam = ? AND lokasi_gambar = ?",
+		noRawat, tglPeriksa, jam, link).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	if _, err := q.Exec("INSERT INTO gambar_radiologi (no_rawat, tgl_periksa, jam, lokasi_gambar) VALUES (?, ?, ?, ?)",
+		noRawat, tglPeriksa, jam, link); err != nil {
+		return fmt.Errorf("gambar_radiologi: %v", err)
+	}
+	return nil
+}
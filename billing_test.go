@@ -0,0 +1,209 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestKhanzaDateSet(t *testing.T) {
+	tests := []struct {
+		tgl  sql.NullString
+		want bool
+	}{
+		{sql.NullString{}, false},
+		{sql.NullString{Valid: true}, false},
+		{sql.NullString{String: "0000-00-00", Valid: true}, false},
+		{sql.NullString{String: "0000-00-00 00:00:00", Valid: true}, false},
+		{sql.NullString{String: "2026-01-05", Valid: true}, true},
+		{sql.NullString{String: "2026-01-05T00:00:00Z", Valid: true}, true},
+	}
+	for _, tt := range tests {
+		if got := khanzaDateSet(tt.tgl); got != tt.want {
+			t.Errorf("khanzaDateSet(%+v) = %v, seharusnya %v", tt.tgl, got, tt.want)
+		}
+	}
+}
+
+// Khanza palsu dengan konfigurasi bawaan dan skema standar, dipulihkan di akhir test
+func openBillingKhanza(t *testing.T) (*sql.DB, Config) {
+	var cfg Config
+	if err := applyDefaults(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	SetRuntimeConfig(cfg)
+	SetKhanzaSchema(nil)
+	t.Cleanup(func() {
+		SetKhanzaSchema(nil)
+		SetRuntimeConfig(Config{})
+	})
+	return openFakeKhanza(t), cfg
+}
+
+func billingOrder(t *testing.T, db *sql.DB, i int, waktu time.Time) fakeOrder {
+	o := fakeOrder{
+		NoOrder: fmt.Sprintf("PR202601050%03d", i), NoRawat: fmt.Sprintf("2026/01/05/%06d", i),
+		NoRM: fmt.Sprintf("%06d", i), NamaPasien: "PASIEN UJI", KdJenisPrw: "RAD001", Pemeriksaan: "THORAX PA", Waktu: waktu,
+	}
+	if err := seedFakeOrder(db, o); err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+// Waktu periksa memakai waktu sampel bila sudah diisi, selain itu waktu permintaan
+func TestLoadPermintaanRadiologiWaktu(t *testing.T) {
+	db, _ := openBillingKhanza(t)
+	waktu := time.Date(2026, 1, 5, 9, 30, 0, 0, time.Local)
+	tests := []struct {
+		nama     string
+		tgl, jam interface{}
+		want     string
+	}{
+		{"sampel kosong", nil, nil, "2026-01-05 09:30:00"},
+		{"sampel tanggal nol", "0000-00-00", "00:00:00", "2026-01-05 09:30:00"},
+		{"jam sampel kosong", "2026-01-05", nil, "2026-01-05 09:30:00"},
+		{"sampel diisi", "2026-01-05", "10:45:00", "2026-01-05 10:45:00"},
+	}
+	for i, tt := range tests {
+		t.Run(tt.nama, func(t *testing.T) {
+			o := billingOrder(t, db, i, waktu)
+			if _, err := db.Exec("UPDATE permintaan_radiologi SET tgl_sampel=?, jam_sampel=? WHERE noorder=?", tt.tgl, tt.jam, o.NoOrder); err != nil {
+				t.Fatal(err)
+			}
+			p, err := loadPermintaanRadiologi(db, o.NoOrder)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := p.TglPeriksa + " " + p.Jam; got != tt.want || p.Status != "Ralan" {
+				t.Fatalf("waktu periksa %q status %q, seharusnya %q Ralan", got, p.Status, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildBillingRows(t *testing.T) {
+	db, base := openBillingKhanza(t)
+	o := billingOrder(t, db, 1, time.Date(2026, 1, 5, 9, 30, 0, 0, time.Local))
+	tests := []struct {
+		nama         string
+		tarifLengkap bool
+		fallback     bool
+		p            Pelaksana
+		kdDokter     string
+		nip          string
+		bagianRS     float64
+		gagal        bool
+	}{
+		{"kode pelaksana, tarif rinci", true, false, Pelaksana{Dokter: "D0099", Petugas: "R001"}, "D0099", "R001", 60000, false},
+		{"nama DICOM, tarif rinci", true, false, Pelaksana{Dokter: "Radiolog^Uji", Petugas: "Radiografer^Uji"}, "D0099", "R001", 60000, false},
+		{"tanpa tarif rinci", false, false, Pelaksana{Dokter: "D0099", Petugas: "R001"}, "D0099", "R001", 0, false},
+		{"fallback konfigurasi", true, true, Pelaksana{Dokter: "Dokter^Luar"}, "D0001", "R001", 60000, false},
+		{"radiolog tidak dikenal", true, false, Pelaksana{Dokter: "Dokter^Luar", Petugas: "R001"}, "", "", 0, true},
+		{"radiografer tidak dikenal", true, false, Pelaksana{Dokter: "D0099", Petugas: "Petugas^Luar"}, "", "", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.nama, func(t *testing.T) {
+			schema := standardKhanzaSchema()
+			schema.TarifLengkap = tt.tarifLengkap
+			SetKhanzaSchema(schema)
+			cfg := base
+			if tt.fallback {
+				cfg.KhanzaKdDokterRadiolog, cfg.KhanzaNipRadiografer = "D0001", "R001"
+			}
+			rows, err := BuildBillingRows(db, cfg, o.NoOrder, tt.p)
+			if tt.gagal {
+				if err == nil {
+					t.Fatalf("seharusnya gagal, dapat %+v", rows)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rows) != 1 {
+				t.Fatalf("%d baris tagihan, seharusnya 1", len(rows))
+			}
+			r := rows[0]
+			if r.KdDokter != tt.kdDokter || r.Nip != tt.nip || r.DokterPerujuk != "D0001" || r.Status != "Ralan" {
+				t.Fatalf("pelaksana tagihan %+v", r)
+			}
+			if r.Biaya != 150000 || r.BagianRS != tt.bagianRS {
+				t.Fatalf("biaya %v bagian_rs %v, seharusnya 150000 dan %v", r.Biaya, r.BagianRS, tt.bagianRS)
+			}
+			if !tt.tarifLengkap && r.BHP+r.TarifPerujuk+r.TarifDokter+r.TarifPetugas+r.KSO+r.Menejemen != 0 {
+				t.Fatalf("tarif rinci terisi padahal kolomnya tidak ada: %+v", r)
+			}
+		})
+	}
+}
+
+// Khanza tanpa kolom tarif rinci: INSERT hanya mengisi biaya total
+func TestInsertPeriksaRadiologiTanpaTarif(t *testing.T) {
+	db, cfg := openBillingKhanza(t)
+	o := billingOrder(t, db, 1, time.Date(2026, 1, 5, 9, 30, 0, 0, time.Local))
+	schema := standardKhanzaSchema()
+	schema.TarifLengkap = false
+	SetKhanzaSchema(schema)
+	p := Pelaksana{Dokter: "D0099", Petugas: "R001"}
+	for i := 0; i < 2; i++ {
+		if _, _, err := InsertPeriksaRadiologiFromPermintaan(db, cfg, o.NoOrder, "", p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var n int
+	var bagianRS sql.NullFloat64
+	var biaya float64
+	if err := db.QueryRow("SELECT COUNT(*), MAX(bagian_rs), MAX(biaya) FROM periksa_radiologi WHERE no_rawat=?", o.NoRawat).Scan(&n, &bagianRS, &biaya); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || bagianRS.Valid || biaya != 150000 {
+		t.Fatalf("%d baris, bagian_rs %+v, biaya %v", n, bagianRS, biaya)
+	}
+}
+
+// Tagihan yang gagal tidak menahan hasil_radiologi; error lain tetap fatal
+func TestFileResultBillingFailed(t *testing.T) {
+	db, _ := openBillingKhanza(t)
+	mwdb := openMemoryStore(t)
+	if err := mwdb.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+	waktu := time.Date(2026, 1, 5, 9, 30, 0, 0, time.Local)
+	tests := []struct {
+		nama         string
+		p            Pelaksana
+		adaOrder     bool
+		tabel        string
+		billingGagal bool
+		periksa      int
+		hasil        int
+	}{
+		{"tagihan berhasil", Pelaksana{Dokter: "D0099", Petugas: "R001"}, true, "", false, 1, 1},
+		{"pelaksana tidak dikenal", Pelaksana{Dokter: "Dokter^Luar"}, true, "periksa_radiologi", true, 0, 1},
+		{"order tidak ada", Pelaksana{Dokter: "D0099", Petugas: "R001"}, false, "periksa_radiologi", false, 0, 0},
+	}
+	for i, tt := range tests {
+		t.Run(tt.nama, func(t *testing.T) {
+			o := fakeOrder{NoOrder: fmt.Sprintf("PR202601059%03d", i), NoRawat: fmt.Sprintf("2026/01/05/9%05d", i)}
+			if tt.adaOrder {
+				o = billingOrder(t, db, 100+i, waktu)
+			}
+			tabel, err := fileResultToKhanza(db, mwdb, "CR-"+o.NoOrder, o.NoOrder, "http://ohif.local/"+o.NoOrder, tt.p, "Thorax normal.")
+			if tabel != tt.tabel || errors.Is(err, ErrBillingFailed) != tt.billingGagal || (tt.tabel == "") != (err == nil) {
+				t.Fatalf("tabel %q error %v", tabel, err)
+			}
+			for table, want := range map[string]int{"periksa_radiologi": tt.periksa, "hasil_radiologi": tt.hasil, "gambar_radiologi": tt.hasil} {
+				var n int
+				if err := db.QueryRow("SELECT COUNT(*) FROM "+table+" WHERE no_rawat=?", o.NoRawat).Scan(&n); err != nil {
+					t.Fatal(err)
+				}
+				if n != want {
+					t.Fatalf("%d baris %s, seharusnya %d", n, table, want)
+				}
+			}
+		})
+	}
+}
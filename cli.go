@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+const cliUsage = `Pemakaian: middleware <perintah> [opsi]
+
+Perintah:
+  serve                          jalankan middleware (default)
+  worklist send --date TANGGAL   kirim worklist Khanza tanggal tertentu yang belum terkirim
+  worklist backfill --from TANGGAL --to TANGGAL
+                                 kirim order yang tertinggal pada rentang tanggal
+  worklist resend <accession>    buat ulang file .wl untuk satu order
+  worklist show <accession>      tampilkan data dan riwayat satu order
+  webhook replay <id>            proses ulang payload webhook SR yang tersimpan
+  sr parse <orthanc-instance>    tampilkan isi SR dari Orthanc tanpa menyimpan
+  reconcile --date TANGGAL       cocokkan order Khanza dengan state middleware dan Orthanc
+  khanza schema                  cek kompatibilitas skema DB Khanza
+  template export [FILE]         export template dan makro laporan ke JSON (default stdout)
+  template import FILE           import template dan makro laporan dari JSON
+  migrate [up|down N|status|force N]
+                                 kelola skema DB middleware
+  check-config                   validasi dan tampilkan konfigurasi efektif
+
+TANGGAL berformat 2006-01-02, default hari ini.
+`
+
+func runCommand(cmd string, args []string) error {
+	if cmd == "help" || cmd == "-h" || cmd == "--help" {
+		fmt.Print(cliUsage)
+		return nil
+	}
+	if cmd == "check-config" {
+		return runCheckConfig()
+	}
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+	SetRuntimeConfig(cfg)
+
+	switch cmd {
+	case "serve":
+		return runServe(cfg)
+	case "sr":
+		return runSRCommand(cfg, args)
+	case "migrate":
+		return withMiddlewareDB(cfg, func(mwdb Store) error {
+			return runMigrateCommand(mwdb, args)
+		})
+	case "worklist":
+		return withDatabases(cfg, func(db *sql.DB, mwdb Store) error {
+			return runWorklistCommand(cfg, db, mwdb, args)
+		})
+	case "webhook":
+		return withDatabases(cfg, func(db *sql.DB, mwdb Store) error {
+			return runWebhookCommand(cfg, db, mwdb, args)
+		})
+	case "khanza":
+		if len(args) == 0 || args[0] != "schema" {
+			return fmt.Errorf("pemakaian: khanza schema")
+		}
+		return withDatabases(cfg, func(db *sql.DB, mwdb Store) error {
+			s, err := DetectKhanzaSchema(db, mwdb)
+			if err != nil {
+				return err
+			}
+			printKhanzaSchema(s)
+			if !s.Compatible() {
+				return ErrKhanzaSchemaIncompatible
+			}
+			return nil
+		})
+	case "template":
+		return withMiddlewareDB(cfg, func(mwdb Store) error {
+			if err := mwdb.MigrateUp(); err != nil {
+				return fmt.Errorf("Gagal migrasi DB Middleware: %v", err)
+			}
+			return runTemplateCommand(mwdb, args)
+		})
+	case "reconcile":
+		return withDatabases(cfg, func(db *sql.DB, mwdb Store) error {
+			return runReconcileCommand(cfg, db, mwdb, args)
+		})
+	}
+	fmt.Fprint(os.Stderr, cliUsage)
+	return fmt.Errorf("perintah tidak dikenal: %s", cmd)
+}
+
+func withMiddlewareDB(cfg Config, fn func(Store) error) error {
+	mwdb, err := ConnectMiddlewareDB(cfg)
+	if err != nil {
+		return fmt.Errorf("Gagal koneksi DB Middleware: %v", err)
+	}
+	defer mwdb.Close()
+	return fn(mwdb)
+}
+
+func withDatabases(cfg Config, fn func(*sql.DB, Store) error) error {
+	return withMiddlewareDB(cfg, func(mwdb Store) error {
+		if err := mwdb.MigrateUp(); err != nil {
+			return fmt.Errorf("Gagal migrasi DB Middleware: %v", err)
+		}
+		db, err := ConnectKhanzaDB(cfg)
+		if err != nil {
+			return fmt.Errorf("Gagal koneksi DB Khanza: %v", err)
+		}
+		defer db.Close()
+		DetectKhanzaSchema(db, mwdb)
+		return fn(db, mwdb)
+	})
+}
+
+// Flag --date untuk worklist send dan reconcile
+func parseDateFlag(name string, args []string) (string, []string, error) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	date := fs.String("date", time.Now().Format("2006-01-02"), "tanggal permintaan (2006-01-02)")
+	if err := fs.Parse(args); err != nil {
+		return "", nil, err
+	}
+	if _, err := time.Parse("2006-01-02", *date); err != nil {
+		return "", nil, fmt.Errorf("tanggal tidak valid: %s", *date)
+	}
+	return *date, fs.Args(), nil
+}
+
+func runCheckConfig() error {
+	cfg, err := LoadConfig()
+	fmt.Printf("File konfigurasi: %s\n", ConfigFilePath())
+	for _, e := range cfg.Entries() {
+		reload := ""
+		if e.Reload {
+			reload = " (reload)"
+		}
+		fmt.Printf("  %-26s %s%s\n", e.Key, e.Value, reload)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println("Konfigurasi valid")
+	return nil
+}
+
+func runWorklistCommand(cfg Config, db *sql.DB, mwdb Store, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("pemakaian: worklist send|backfill|resend|show")
+	}
+	switch args[0] {
+	case "send":
+		date, _, err := parseDateFlag("worklist send", args[1:])
+		if err != nil {
+			return err
+		}
+		worklists, err := GetPendingWorklist(db, date)
+		if err != nil {
+			return err
+		}
+		res := sendWorklists(context.Background(), cfg, mwdb, worklists)
+		fmt.Printf("%d order tanggal %s: %d terkirim, %d gagal, %d sudah terkirim sebelumnya\n",
+			len(worklists), date, res.Terkirim, res.Gagal, res.Dilewati)
+		if res.Gagal > 0 {
+			return fmt.Errorf("%d worklist gagal dikirim, lihat log", res.Gagal)
+		}
+		return nil
+	case "backfill":
+		fs := flag.NewFlagSet("worklist backfill", flag.ContinueOnError)
+		today := time.Now().Format("2006-01-02")
+		dari := fs.String("from", today, "tanggal awal (2006-01-02)")
+		sampai := fs.String("to", today, "tanggal akhir (2006-01-02)")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		res, err := BackfillWorklists(context.Background(), cfg, db, mwdb, *dari, *sampai)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Backfill %s s/d %s: %d terkirim, %d gagal, %d sudah terkirim sebelumnya\n",
+			*dari, *sampai, res.Terkirim, res.Gagal, res.Dilewati)
+		if res.Gagal > 0 {
+			return fmt.Errorf("%d worklist gagal dikirim, lihat log", res.Gagal)
+		}
+		return nil
+	case "resend":
+		if len(args) < 2 {
+			return fmt.Errorf("pemakaian: worklist resend <accession>")
+		}
+		if err := ResendWorklist(cfg, mwdb, args[1], "dari CLI"); err != nil {
+			return err
+		}
+		fmt.Printf("Worklist %s dikirim ulang\n", args[1])
+		return nil
+	case "show":
+		if len(args) < 2 {
+			return fmt.Errorf("pemakaian: worklist show <accession>")
+		}
+		return showOrder(mwdb, args[1])
+	}
+	return fmt.Errorf("perintah worklist tidak dikenal: %s", args[0])
+}
+
+func showOrder(mwdb Store, accession string) error {
+	sent, err := mwdb.GetSentWorklists([]string{accession})
+	if err != nil {
+		return err
+	}
+	sw, ok := sent[accession]
+	if !ok {
+		return fmt.Errorf("order %s tidak ditemukan di middleware", accession)
+	}
+	fmt.Printf("Accession      : %s\n", sw.NomorOrder)
+	fmt.Printf("Status         : %s\n", sw.Status)
+	fmt.Printf("Masuk worklist : %s\n", formatTime(sw.TglMasukWorklist))
+	fmt.Printf("Kirim worklist : %s\n", formatTime(sw.TglKirimWorklist))
+	fmt.Printf("Gambar diterima: %s\n", formatTime(sw.TglGambarDiterima))
+	fmt.Printf("Terima hasil   : %s\n", formatTime(sw.TglTerimaHasil))
+	fmt.Printf("Simpan hasil   : %s\n", formatTime(sw.TglSimpanHasil))
+	if sw.StudyInstanceUID != "" {
+		fmt.Printf("Study UID      : %s\n", sw.StudyInstanceUID)
+		fmt.Printf("OHIF           : %s\n", GenerateOHIFLink(RuntimeConfig(), sw.StudyInstanceUID))
+	}
+	timeline, err := mwdb.GetOrderTimeline(accession)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Riwayat:")
+	for _, t := range timeline {
+		fmt.Printf("  %s [%s] %s\n", t.Waktu.Format("2006-01-02 15:04:05"), t.Sumber, t.Pesan)
+	}
+	return nil
+}
+
+func runWebhookCommand(cfg Config, db *sql.DB, mwdb Store, args []string) error {
+	if len(args) < 2 || args[0] != "replay" {
+		return fmt.Errorf("pemakaian: webhook replay <id>")
+	}
+	id, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return fmt.Errorf("id payload tidak valid: %s", args[1])
+	}
+	body, err := mwdb.GetWebhookPayload(id)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("payload webhook %d tidak ditemukan", id)
+	}
+	if err != nil {
+		return err
+	}
+	NewLogger(mwdb, CompSR).Info(fmt.Sprintf("Replay payload webhook %d dari CLI", id), LogFields{})
+	processSRWebhook(cfg, db, mwdb, body)
+	return nil
+}
+
+func runSRCommand(cfg Config, args []string) error {
+	if len(args) < 2 || args[0] != "parse" {
+		return fmt.Errorf("pemakaian: sr parse <orthanc-instance>")
+	}
+	content, err := ParseSRContentFromOrthanc(cfg, args[1])
+	if err != nil {
+		return err
+	}
+	fmt.Println(content)
+	return nil
+}
+
+// Cocokkan order Khanza pada satu tanggal dengan state di middleware. Order
+// yang gambarnya sudah ada di Orthanc tapi belum tercatat ikut dipindah ke acquired.
+func runReconcileCommand(cfg Config, db *sql.DB, mwdb Store, args []string) error {
+	date, _, err := parseDateFlag("reconcile", args)
+	if err != nil {
+		return err
+	}
+	worklists, err := GetPendingWorklist(db, date)
+	if err != nil {
+		return err
+	}
+	nomorOrders := make([]string, 0, len(worklists))
+	for _, wl := range worklists {
+		nomorOrders = append(nomorOrders, wl.AccessionNumber)
+	}
+	sent, err := mwdb.GetSentWorklists(nomorOrders)
+	if err != nil {
+		return err
+	}
+
+	var belumTerkirim, diperbaiki int
+	for _, wl := range worklists {
+		sw, ok := sent[wl.AccessionNumber]
+		status := sw.Status
+		keterangan := ""
+		switch {
+		case !ok || sw.Status == string(OrderOrdered) || sw.Status == string(OrderError):
+			if !ok {
+				status = "-"
+			}
+			keterangan = "belum terkirim ke modality"
+			belumTerkirim++
+		case sw.Status == string(OrderWorklisted):
+			studyUID, err := FindStudyByAccession(cfg, wl.AccessionNumber)
+			if err != nil {
+				keterangan = "gagal cek Orthanc: " + err.Error()
+				break
+			}
+			if studyUID == "" {
+				keterangan = "menunggu gambar"
+				break
+			}
+			mwdb.UpdateStudyInstanceUID(wl.AccessionNumber, studyUID)
+			if err := mwdb.TransitionOrder(wl.AccessionNumber, OrderAcquired, "reconcile: "+studyUID); err != nil {
+				keterangan = "gagal update state: " + err.Error()
+				break
+			}
+			syncKhanzaProgress(db, mwdb, wl.AccessionNumber, wl.PatientID, OrderAcquired, "")
+			enqueueReading(db, mwdb, wl.AccessionNumber, studyUID)
+			status = string(OrderAcquired)
+			keterangan = "gambar ditemukan di Orthanc, state diperbarui"
+			diperbaiki++
+		}
+		fmt.Printf("%-24s %-20s %-11s %s\n", wl.AccessionNumber, wl.PatientName, status, keterangan)
+	}
+	fmt.Printf("%d order tanggal %s: %d belum terkirim, %d diperbarui\n", len(worklists), date, belumTerkirim, diperbaiki)
+	if belumTerkirim > 0 {
+		fmt.Printf("Jalankan `middleware worklist send --date %s` untuk mengirim yang tertinggal\n", date)
+	}
+	return nil
+}
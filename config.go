@@ -1,41 +1,395 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
 )
 
+// Konfigurasi middleware. Urutan pembacaan: nilai default, file YAML
+// (CONFIG_FILE, default config.yaml bila ada), lalu environment variable.
+// Field bertag reload:"true" ikut diperbarui saat SIGHUP, sisanya perlu restart.
 type Config struct {
-	DBHost           string
-	DBPort           string
-	DBUser           string
-	DBPassword       string
-	DBName           string
-	DBKhanzaHost     string
-	DBKhanzaPort     string
-	DBKhanzaUser     string
-	DBKhanzaPassword string
-	DBKhanzaName     string
-	OrthancURL       string
-	OHIFURL          string
-	OrthancUser      string
-	OrthancPass      string
-}
-
-func LoadConfig() Config {
-	return Config{
-		DBHost:           os.Getenv("MIDDLEWARE_DB_HOST"),
-		DBPort:           os.Getenv("MIDDLEWARE_DB_PORT"),
-		DBUser:           os.Getenv("MIDDLEWARE_DB_USER"),
-		DBPassword:       os.Getenv("MIDDLEWARE_DB_PASSWORD"),
-		DBName:           os.Getenv("MIDDLEWARE_DB_NAME"),
-		DBKhanzaHost:     os.Getenv("KHANZA_DB_HOST"),
-		DBKhanzaPort:     os.Getenv("KHANZA_DB_PORT"),
-		DBKhanzaUser:     os.Getenv("KHANZA_DB_USER"),
-		DBKhanzaPassword: os.Getenv("KHANZA_DB_PASSWORD"),
-		DBKhanzaName:     os.Getenv("KHANZA_DB_NAME"),
-		OrthancURL:       os.Getenv("ORTHANC_URL"),
-		OHIFURL:          os.Getenv("OHIF_URL"),
-		OrthancUser:      os.Getenv("ORTHANC_USER"),
-		OrthancPass:      os.Getenv("ORTHANC_PASS"),
+	DBDriver           string `yaml:"middleware_db_driver" env:"MIDDLEWARE_DB_DRIVER" default:"mysql"`
+	DBPath             string `yaml:"middleware_db_path" env:"MIDDLEWARE_DB_PATH" default:"./middleware.db"`
+	DBHost             string `yaml:"middleware_db_host" env:"MIDDLEWARE_DB_HOST"`
+	DBPort             string `yaml:"middleware_db_port" env:"MIDDLEWARE_DB_PORT" default:"3306"`
+	DBUser             string `yaml:"middleware_db_user" env:"MIDDLEWARE_DB_USER"`
+	DBPassword         string `yaml:"middleware_db_password" env:"MIDDLEWARE_DB_PASSWORD" secret:"true"`
+	DBName             string `yaml:"middleware_db_name" env:"MIDDLEWARE_DB_NAME"`
+	DBKhanzaHost       string `yaml:"khanza_db_host" env:"KHANZA_DB_HOST" required:"true"`
+	DBKhanzaPort       string `yaml:"khanza_db_port" env:"KHANZA_DB_PORT" default:"3306"`
+	DBKhanzaUser       string `yaml:"khanza_db_user" env:"KHANZA_DB_USER" required:"true"`
+	DBKhanzaPassword   string `yaml:"khanza_db_password" env:"KHANZA_DB_PASSWORD" secret:"true"`
+	DBKhanzaName       string `yaml:"khanza_db_name" env:"KHANZA_DB_NAME" required:"true"`
+	OrthancURL         string `yaml:"orthanc_url" env:"ORTHANC_URL" required:"true"`
+	OHIFURL            string `yaml:"ohif_url" env:"OHIF_URL" required:"true" reload:"true"`
+	OrthancUser        string `yaml:"orthanc_user" env:"ORTHANC_USER"`
+	OrthancPass        string `yaml:"orthanc_pass" env:"ORTHANC_PASS" secret:"true"`
+	WorklistFolder     string `yaml:"folder_worklist" env:"FOLDER_WORKLIST" default:"./worklists"`
+	PortalAddr         string `yaml:"portal_addr" env:"PORTAL_ADDR" default:":8080"`
+	WebhookAddr        string `yaml:"webhook_addr" env:"WEBHOOK_ADDR"`
+	WebhookToken       string `yaml:"webhook_token" env:"WEBHOOK_TOKEN" secret:"true" reload:"true"`
+	PortalAdminUser    string `yaml:"portal_admin_user" env:"PORTAL_ADMIN_USER"`
+	PortalAdminPass    string `yaml:"portal_admin_password" env:"PORTAL_ADMIN_PASSWORD" secret:"true"`
+	PortalKhanzaAuth   bool   `yaml:"portal_auth_khanza" env:"PORTAL_AUTH_KHANZA"`
+	PortalKhanzaRole   string `yaml:"portal_khanza_role" env:"PORTAL_KHANZA_ROLE" default:"viewer"`
+	PortalCookieSecure bool   `yaml:"portal_cookie_secure" env:"PORTAL_COOKIE_SECURE" reload:"true"`
+	TrustedProxies     string `yaml:"trusted_proxies" env:"TRUSTED_PROXIES" reload:"true"`
+
+	// Kolom permintaan_radiologi yang diisi saat gambar diterima dan hasil
+	// tersimpan. Kosongkan untuk menonaktifkan; status dan link hanya ada di fork.
+	KhanzaKolomTglSampel string `yaml:"khanza_kolom_tgl_sampel" env:"KHANZA_KOLOM_TGL_SAMPEL" default:"tgl_sampel"`
+	KhanzaKolomJamSampel string `yaml:"khanza_kolom_jam_sampel" env:"KHANZA_KOLOM_JAM_SAMPEL" default:"jam_sampel"`
+	KhanzaKolomTglHasil  string `yaml:"khanza_kolom_tgl_hasil" env:"KHANZA_KOLOM_TGL_HASIL" default:"tgl_hasil"`
+	KhanzaKolomJamHasil  string `yaml:"khanza_kolom_jam_hasil" env:"KHANZA_KOLOM_JAM_HASIL" default:"jam_hasil"`
+	KhanzaKolomStatus    string `yaml:"khanza_kolom_status" env:"KHANZA_KOLOM_STATUS"`
+	KhanzaKolomLink      string `yaml:"khanza_kolom_link" env:"KHANZA_KOLOM_LINK" default:"link_hasil"`
+
+	// Pelaksana default di periksa_radiologi bila tag DICOM Performing
+	// Physician / Operators' Name kosong atau tidak cocok dengan data Khanza
+	KhanzaKdDokterRadiolog string `yaml:"khanza_kd_dokter_radiolog" env:"KHANZA_KD_DOKTER_RADIOLOG" reload:"true"`
+	KhanzaNipRadiografer   string `yaml:"khanza_nip_radiografer" env:"KHANZA_NIP_RADIOGRAFER" reload:"true"`
+
+	// Laporan PDF: kop (baris alamat dipisah "|"), kaki halaman dan jumlah
+	// gambar kunci. Bila folder diisi, PDF disimpan saat hasil dikirim ke
+	// Khanza; bila URL juga diisi, link PDF dicatat di gambar_radiologi.
+	ReportHeaderTitle string `yaml:"report_header_title" env:"REPORT_HEADER_TITLE" default:"INSTALASI RADIOLOGI" reload:"true"`
+	ReportHeaderLines string `yaml:"report_header_lines" env:"REPORT_HEADER_LINES" reload:"true"`
+	ReportFooter      string `yaml:"report_footer" env:"REPORT_FOOTER" reload:"true"`
+	ReportKeyImages   int    `yaml:"report_key_images" env:"REPORT_KEY_IMAGES" default:"2" reload:"true" allowzero:"true"`
+	ReportPDFFolder   string `yaml:"report_pdf_folder" env:"REPORT_PDF_FOLDER" reload:"true"`
+	ReportPDFURL      string `yaml:"report_pdf_url" env:"REPORT_PDF_URL" reload:"true"`
+
+	// Temuan kritis: kode ConceptCodeSequence SR dan kata kunci (dipisah koma)
+	// yang menandai laporan kritis. Notifikasi level 1 ke penerima utama;
+	// bila belum diakui dalam batas waktu, dieskalasi ke penerima eskalasi
+	// sampai CRITICAL_MAX_ESCALATION kali. Penerima dipisah koma.
+	CriticalSRCodes                  string        `yaml:"critical_sr_codes" env:"CRITICAL_SR_CODES" default:"KRITIS" reload:"true"`
+	CriticalKeywords                 string        `yaml:"critical_keywords" env:"CRITICAL_KEYWORDS" default:"pneumothorax,perdarahan intrakranial,diseksi aorta,udara bebas,free air,emboli paru" reload:"true"`
+	CriticalAckTimeout               time.Duration `yaml:"critical_ack_timeout" env:"CRITICAL_ACK_TIMEOUT" default:"30m" reload:"true"`
+	CriticalMaxEscalation            int           `yaml:"critical_max_escalation" env:"CRITICAL_MAX_ESCALATION" default:"3" reload:"true" allowzero:"true"`
+	CriticalEmailTo                  string        `yaml:"critical_email_to" env:"CRITICAL_EMAIL_TO" reload:"true"`
+	CriticalEscalationEmailTo        string        `yaml:"critical_escalation_email_to" env:"CRITICAL_ESCALATION_EMAIL_TO" reload:"true"`
+	CriticalWebhookURL               string        `yaml:"critical_webhook_url" env:"CRITICAL_WEBHOOK_URL" reload:"true"`
+	CriticalWebhookToken             string        `yaml:"critical_webhook_token" env:"CRITICAL_WEBHOOK_TOKEN" secret:"true" reload:"true"`
+	CriticalWebhookTargets           string        `yaml:"critical_webhook_targets" env:"CRITICAL_WEBHOOK_TARGETS" reload:"true"`
+	CriticalEscalationWebhookTargets string        `yaml:"critical_escalation_webhook_targets" env:"CRITICAL_ESCALATION_WEBHOOK_TARGETS" reload:"true"`
+	SMTPHost                         string        `yaml:"smtp_host" env:"SMTP_HOST" reload:"true"`
+	SMTPPort                         string        `yaml:"smtp_port" env:"SMTP_PORT" default:"587" reload:"true"`
+	SMTPUser                         string        `yaml:"smtp_user" env:"SMTP_USER" reload:"true"`
+	SMTPPass                         string        `yaml:"smtp_pass" env:"SMTP_PASS" secret:"true" reload:"true"`
+	SMTPFrom                         string        `yaml:"smtp_from" env:"SMTP_FROM" reload:"true"`
+
+	WorklistPollInterval     time.Duration `yaml:"worklist_poll_interval" env:"WORKLIST_POLL_INTERVAL" default:"3s" reload:"true"`
+	WorklistFullScanInterval time.Duration `yaml:"worklist_full_scan_interval" env:"WORKLIST_FULL_SCAN_INTERVAL" default:"30s" reload:"true"`
+	WorklistRetryInterval    time.Duration `yaml:"worklist_retry_interval" env:"WORKLIST_RETRY_INTERVAL" default:"10s" reload:"true"`
+	WorklistLookbackDays     int           `yaml:"worklist_lookback_days" env:"WORKLIST_LOOKBACK_DAYS" default:"1" reload:"true" allowzero:"true"`
+	WorklistLookaheadDays    int           `yaml:"worklist_lookahead_days" env:"WORKLIST_LOOKAHEAD_DAYS" default:"1" reload:"true" allowzero:"true"`
+	ImageWaitWindow          time.Duration `yaml:"image_wait_window" env:"IMAGE_WAIT_WINDOW" default:"48h" reload:"true"`
+	HealthCheckInterval      time.Duration `yaml:"health_check_interval" env:"HEALTH_CHECK_INTERVAL" default:"10s" reload:"true"`
+	SessionDuration          time.Duration `yaml:"session_duration" env:"SESSION_DURATION" default:"8h" reload:"true"`
+	ShutdownTimeout          time.Duration `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" default:"30s" reload:"true"`
+	WebhookWorkers           int           `yaml:"webhook_workers" env:"WEBHOOK_WORKERS" default:"2"`
+	WebhookQueueSize         int           `yaml:"webhook_queue_size" env:"WEBHOOK_QUEUE_SIZE" default:"100"`
+	DashboardLogLines        int           `yaml:"dashboard_log_lines" env:"DASHBOARD_LOG_LINES" default:"200" reload:"true"`
+	LogLevel                 string        `yaml:"log_level" env:"LOG_LEVEL" default:"INFO" reload:"true"`
+	LogRetentionDays         int           `yaml:"log_retention_days" env:"LOG_RETENTION_DAYS" default:"90" reload:"true" allowzero:"true"`
+}
+
+// Baca konfigurasi dari file dan environment. Kesalahan validasi dikembalikan sekaligus.
+func LoadConfig() (Config, error) {
+	var cfg Config
+	if err := applyDefaults(&cfg); err != nil {
+		return cfg, err
+	}
+	path := ConfigFilePath()
+	if data, err := os.ReadFile(path); err == nil {
+		if err := yaml.Unmarshal(data, &cfg); err != nil {
+			return cfg, fmt.Errorf("file konfigurasi %s tidak valid: %v", path, err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) || os.Getenv("CONFIG_FILE") != "" {
+		return cfg, fmt.Errorf("gagal membaca file konfigurasi %s: %v", path, err)
+	}
+	if err := applyEnv(&cfg); err != nil {
+		return cfg, err
+	}
+	return cfg, cfg.Validate()
+}
+
+func ConfigFilePath() string {
+	return getEnvDefault("CONFIG_FILE", "config.yaml")
+}
+
+func getEnvDefault(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func applyDefaults(cfg *Config) error {
+	return eachConfigField(cfg, func(f reflect.StructField, v reflect.Value) error {
+		if def, ok := f.Tag.Lookup("default"); ok {
+			return setConfigValue(v, def)
+		}
+		return nil
+	})
+}
+
+// Env var yang ada tetap dipakai walau kosong, misalnya KHANZA_KOLOM_LINK=""
+// untuk mematikan kolom yang diisi di file konfigurasi
+func applyEnv(cfg *Config) error {
+	return eachConfigField(cfg, func(f reflect.StructField, v reflect.Value) error {
+		key := f.Tag.Get("env")
+		if raw, ok := os.LookupEnv(key); ok {
+			if err := setConfigValue(v, raw); err != nil {
+				return fmt.Errorf("%s: %v", key, err)
+			}
+		}
+		return nil
+	})
+}
+
+func eachConfigField(cfg *Config, fn func(reflect.StructField, reflect.Value) error) error {
+	v := reflect.ValueOf(cfg).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if err := fn(t.Field(i), v.Field(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func setConfigValue(v reflect.Value, raw string) error {
+	switch v.Interface().(type) {
+	case time.Duration:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+	case int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(n))
+	case bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	default:
+		v.SetString(raw)
+	}
+	return nil
+}
+
+// Validasi field wajib dan nilai yang masuk akal, pesan menyebut nama env var
+func (cfg Config) Validate() error {
+	var problems []string
+	eachConfigField(&cfg, func(f reflect.StructField, v reflect.Value) error {
+		key := f.Tag.Get("env")
+		if f.Tag.Get("required") == "true" && v.Kind() == reflect.String && strings.TrimSpace(v.String()) == "" {
+			problems = append(problems, key+" wajib diisi")
+		}
+		switch val := v.Interface().(type) {
+		case time.Duration:
+			if val <= 0 {
+				problems = append(problems, key+" harus lebih dari 0")
+			}
+		case int:
+			if val < 0 || (val == 0 && f.Tag.Get("allowzero") != "true") {
+				problems = append(problems, key+" harus lebih dari 0")
+			}
+		}
+		return nil
+	})
+	switch cfg.DBDriver {
+	case StoreMySQL:
+		// Koneksi MySQL wajib lengkap, SQLite cukup path file
+		for _, f := range []struct{ key, val string }{
+			{"MIDDLEWARE_DB_HOST", cfg.DBHost}, {"MIDDLEWARE_DB_USER", cfg.DBUser}, {"MIDDLEWARE_DB_NAME", cfg.DBName},
+		} {
+			if strings.TrimSpace(f.val) == "" {
+				problems = append(problems, f.key+" wajib diisi untuk driver mysql")
+			}
+		}
+	case StoreSQLite:
+		if strings.TrimSpace(cfg.DBPath) == "" {
+			problems = append(problems, "MIDDLEWARE_DB_PATH wajib diisi untuk driver sqlite")
+		}
+	default:
+		problems = append(problems, "MIDDLEWARE_DB_DRIVER harus mysql atau sqlite: "+cfg.DBDriver)
+	}
+	for key, raw := range map[string]string{"ORTHANC_URL": cfg.OrthancURL, "OHIF_URL": cfg.OHIFURL} {
+		if raw == "" {
+			continue
+		}
+		if u, err := url.Parse(raw); err != nil || u.Scheme == "" || u.Host == "" {
+			problems = append(problems, key+" bukan URL yang valid: "+raw)
+		}
+	}
+	for _, f := range cfg.KhanzaColumns().fields() {
+		if f.kolom != "" && !sqlIdentifier.MatchString(f.kolom) {
+			problems = append(problems, f.env+" bukan nama kolom yang valid: "+f.kolom)
+		}
+	}
+	for _, p := range splitList(cfg.TrustedProxies) {
+		if _, _, err := net.ParseCIDR(p); err != nil && net.ParseIP(p) == nil {
+			problems = append(problems, "TRUSTED_PROXIES berisi alamat yang tidak valid: "+p)
+		}
+	}
+	if !validRole(cfg.PortalKhanzaRole) {
+		problems = append(problems, "PORTAL_KHANZA_ROLE tidak dikenal: "+cfg.PortalKhanzaRole)
+	}
+	if levelsFrom(cfg.LogLevel) == nil {
+		problems = append(problems, "LOG_LEVEL harus salah satu dari "+strings.Join(LogLevels, ", "))
+	}
+	if len(problems) > 0 {
+		return errors.New("konfigurasi tidak valid:\n  - " + strings.Join(problems, "\n  - "))
 	}
+	if cfg.WebhookToken == "" {
+		// Bukan error agar instalasi lama tetap jalan sampai Lua Orthanc diperbarui
+		log.Println("Peringatan: WEBHOOK_TOKEN kosong; /webhook menerima request tanpa autentikasi dan bisa memicu tagihan serta hasil di Khanza")
+	}
+	if cfg.KhanzaKdDokterRadiolog == "" && cfg.KhanzaNipRadiografer == "" {
+		// Bukan error: hasil_radiologi tetap ditulis, hanya tagihannya yang gagal
+		log.Println("Peringatan: KHANZA_KD_DOKTER_RADIOLOG dan KHANZA_NIP_RADIOGRAFER kosong; tagihan periksa_radiologi gagal bila nama dokter/radiografer di SR tidak cocok dengan tabel dokter/petugas Khanza")
+	}
+	return nil
+}
+
+type ConfigEntry struct {
+	Key    string
+	Value  string
+	Reload bool
+}
+
+// Konfigurasi efektif untuk ditampilkan, nilai rahasia disamarkan
+func (cfg Config) Entries() []ConfigEntry {
+	var entries []ConfigEntry
+	eachConfigField(&cfg, func(f reflect.StructField, v reflect.Value) error {
+		value := fmt.Sprint(v.Interface())
+		if f.Tag.Get("secret") == "true" && value != "" {
+			value = "********"
+		}
+		entries = append(entries, ConfigEntry{Key: f.Tag.Get("env"), Value: value, Reload: f.Tag.Get("reload") == "true"})
+		return nil
+	})
+	return entries
+}
+
+var (
+	runtimeConfig      Config
+	runtimeConfigMutex sync.RWMutex
+)
+
+// Konfigurasi yang sedang berlaku, termasuk hasil reload SIGHUP
+func RuntimeConfig() Config {
+	runtimeConfigMutex.RLock()
+	defer runtimeConfigMutex.RUnlock()
+	return runtimeConfig
+}
+
+func SetRuntimeConfig(cfg Config) {
+	runtimeConfigMutex.Lock()
+	defer runtimeConfigMutex.Unlock()
+	runtimeConfig = cfg
+}
+
+// Baca ulang konfigurasi dan terapkan hanya field yang aman diubah tanpa restart
+func ReloadConfig() error {
+	next, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+	runtimeConfigMutex.Lock()
+	defer runtimeConfigMutex.Unlock()
+	cur := reflect.ValueOf(&runtimeConfig).Elem()
+	nv := reflect.ValueOf(next)
+	t := cur.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if reflect.DeepEqual(cur.Field(i).Interface(), nv.Field(i).Interface()) {
+			continue
+		}
+		if f.Tag.Get("reload") == "true" {
+			cur.Field(i).Set(nv.Field(i))
+			log.Printf("Konfigurasi %s diperbarui", f.Tag.Get("env"))
+		} else {
+			log.Printf("Konfigurasi %s berubah tapi baru berlaku setelah restart", f.Tag.Get("env"))
+		}
+	}
+	return nil
+}
+
+var configTmpl = `
+<!DOCTYPE html>
+<html>
+<head>
+    <title>Konfigurasi Middleware</title>
+    <style>
+        body { font-family: Arial; margin: 40px; }
+        table { border-collapse: collapse; margin-bottom: 20px; }
+        th, td { border: 1px solid #ccc; padding: 6px 10px; text-align: left; }
+        th { background: #f0f0f0; }
+        .fail { color: red; }
+    </style>
+</head>
+<body>
+    <h2>Konfigurasi Efektif</h2>
+    <p><a href="/">Dashboard</a></p>
+    <p>File: {{.File}}</p>
+    {{if .Error}}<p class="fail">{{.Error}}</p>{{end}}
+    <table>
+        <tr><th>Nama</th><th>Nilai</th><th>Reload tanpa restart</th></tr>
+        {{range .Entries}}<tr><td>{{.Key}}</td><td>{{.Value}}</td><td>{{if .Reload}}ya{{else}}-{{end}}</td></tr>{{end}}
+    </table>
+    <form method="post" action="/config/reload">
+        <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+        <button type="submit">Muat Ulang Konfigurasi</button>
+    </form>
+</body>
+</html>
+`
+
+func registerConfigHandlers(mux *http.ServeMux, mwdb Store) {
+	mux.HandleFunc("/config", RequirePermission(mwdb, PermConfig, func(w http.ResponseWriter, r *http.Request) {
+		t, _ := template.New("config").Parse(configTmpl)
+		t.Execute(w, struct {
+			File      string
+			Error     string
+			Entries   []ConfigEntry
+			CSRFToken string
+		}{ConfigFilePath(), r.URL.Query().Get("error"), RuntimeConfig().Entries(), CurrentSession(r).CSRFToken})
+	}))
+
+	mux.HandleFunc("/config/reload", RequirePermission(mwdb, PermConfig, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := ReloadConfig(); err != nil {
+			http.Redirect(w, r, "/config?error="+url.QueryEscape(err.Error()), http.StatusSeeOther)
+			return
+		}
+		NewLogger(mwdb, CompPortal).Info("Konfigurasi dimuat ulang oleh "+CurrentSession(r).User.Username, LogFields{})
+		http.Redirect(w, r, "/config", http.StatusSeeOther)
+	}))
 }
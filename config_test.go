@@ -0,0 +1,26 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestApplyEnvEmpty(t *testing.T) {
+	var cfg Config
+	if err := applyDefaults(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("KHANZA_KOLOM_LINK", "")
+	if err := applyEnv(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.KhanzaKolomLink != "" {
+		t.Fatalf("KHANZA_KOLOM_LINK kosong diabaikan, nilai %q", cfg.KhanzaKolomLink)
+	}
+
+	// Env kosong untuk angka bukan nilai yang valid, jangan diam-diam pakai default
+	t.Setenv("WEBHOOK_WORKERS", "")
+	if err := applyEnv(&cfg); err == nil || !strings.Contains(err.Error(), "WEBHOOK_WORKERS") {
+		t.Fatalf("WEBHOOK_WORKERS kosong seharusnya error, dapat %v", err)
+	}
+}
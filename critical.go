@@ -0,0 +1,740 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Temuan kritis. Laporan ditandai kritis dari item CODE di SR, kata kunci di
+// teks laporan, atau centang radiolog di editor portal. Setiap temuan dikirim
+// ke semua kanal notifikasi (banner portal, email, webhook HTTP) dan setiap
+// pengiriman dicatat. Temuan yang belum diakui dalam CRITICAL_ACK_TIMEOUT
+// dieskalasi ke penerima eskalasi. Pengiriman berjalan di loop latar
+// belakang agar SMTP atau gateway yang lambat tidak menahan penyimpanan
+// hasil dan tanda tangan laporan.
+
+const (
+	CriticalSourceCode    = "kode_sr"
+	CriticalSourceKeyword = "kata_kunci"
+	CriticalSourcePortal  = "portal"
+
+	// Kode lokal (skema 99) yang ditulis editor portal ke SR
+	criticalCodeValue  = "KRITIS"
+	criticalCodeScheme = "99RADMW"
+)
+
+var ErrCriticalAcknowledged = errors.New("temuan kritis sudah diakui")
+
+type CriticalFinding struct {
+	ID              int
+	NomorOrder      string
+	Sumber          string
+	Keterangan      string
+	Dibuat          time.Time
+	Level           int
+	EskalasiBerikut *time.Time
+	DiakuiOleh      string
+	DiakuiPada      *time.Time
+	Catatan         string
+}
+
+// Jejak audit satu pengiriman notifikasi
+type CriticalNotification struct {
+	ID         int
+	FindingID  int
+	Level      int
+	Kanal      string
+	Tujuan     string
+	Berhasil   bool
+	PesanError string
+	Dikirim    time.Time
+}
+
+const criticalFindingColumns = `id, nomor_order, sumber, IFNULL(keterangan, ''), dibuat, level, eskalasi_berikut,
+	IFNULL(diakui_oleh, ''), diakui_pada, IFNULL(catatan, '')`
+
+func scanCriticalFinding(row interface{ Scan(...interface{}) error }) (CriticalFinding, error) {
+	var f CriticalFinding
+	err := row.Scan(&f.ID, &f.NomorOrder, &f.Sumber, &f.Keterangan, &f.Dibuat, &f.Level, &f.EskalasiBerikut,
+		&f.DiakuiOleh, &f.DiakuiPada, &f.Catatan)
+	return f, err
+}
+
+// Catat temuan kritis satu order. Bila order masih punya temuan yang belum
+// diakui (replay webhook), temuan itu dikembalikan dengan created false.
+// Temuan yang sudah diakui tidak menahan temuan baru dari SR koreksi atau
+// addendum.
+func (s *sqlStore) CreateCriticalFinding(f CriticalFinding) (CriticalFinding, bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return f, false, err
+	}
+	defer tx.Rollback()
+	lama, err := scanCriticalFinding(tx.QueryRow("SELECT "+criticalFindingColumns+" FROM critical_finding WHERE nomor_order=? AND diakui_pada IS NULL ORDER BY id DESC LIMIT 1"+s.dialect.lockSuffix, f.NomorOrder))
+	if err == nil {
+		return lama, false, nil
+	}
+	if err != sql.ErrNoRows {
+		return f, false, err
+	}
+	res, err := tx.Exec(`INSERT INTO critical_finding (nomor_order, sumber, keterangan, dibuat, level, eskalasi_berikut)
+		VALUES (?, ?, ?, ?, ?, ?)`, f.NomorOrder, f.Sumber, f.Keterangan, f.Dibuat, f.Level, f.EskalasiBerikut)
+	if err != nil {
+		return f, false, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return f, false, err
+	}
+	f.ID = int(id)
+	return f, true, tx.Commit()
+}
+
+// Temuan terbaru dulu; terbuka true hanya yang belum diakui
+func (s *sqlStore) GetCriticalFindings(terbuka bool, limit int) ([]CriticalFinding, error) {
+	query := "SELECT " + criticalFindingColumns + " FROM critical_finding"
+	if terbuka {
+		query += " WHERE diakui_pada IS NULL"
+	}
+	query += " ORDER BY dibuat DESC"
+	if limit > 0 {
+		query += " LIMIT " + strconv.Itoa(limit)
+	}
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []CriticalFinding
+	for rows.Next() {
+		f, err := scanCriticalFinding(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, f)
+	}
+	return result, rows.Err()
+}
+
+func (s *sqlStore) GetCriticalFinding(id int) (CriticalFinding, error) {
+	return scanCriticalFinding(s.db.QueryRow("SELECT "+criticalFindingColumns+" FROM critical_finding WHERE id=?", id))
+}
+
+// Temuan terbaru satu order
+func (s *sqlStore) GetCriticalFindingByOrder(nomorOrder string) (CriticalFinding, error) {
+	return scanCriticalFinding(s.db.QueryRow("SELECT "+criticalFindingColumns+" FROM critical_finding WHERE nomor_order=? ORDER BY id DESC LIMIT 1", nomorOrder))
+}
+
+// Akui temuan dan hentikan eskalasi
+func (s *sqlStore) AcknowledgeCriticalFinding(id int, oleh, catatan string) error {
+	res, err := s.db.Exec(`UPDATE critical_finding SET diakui_oleh=?, diakui_pada=?, catatan=?, eskalasi_berikut=NULL
+		WHERE id=? AND diakui_pada IS NULL`, oleh, time.Now(), catatan, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		if _, err := s.GetCriticalFinding(id); err != nil {
+			return err
+		}
+		return ErrCriticalAcknowledged
+	}
+	return nil
+}
+
+// Temuan belum diakui yang notifikasi level sekarangnya belum dikirim
+func (s *sqlStore) PendingCriticalNotices() ([]CriticalFinding, error) {
+	rows, err := s.db.Query("SELECT " + criticalFindingColumns + ` FROM critical_finding
+		WHERE diakui_pada IS NULL AND notifikasi_level < level ORDER BY dibuat`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []CriticalFinding
+	for rows.Next() {
+		f, err := scanCriticalFinding(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, f)
+	}
+	return result, rows.Err()
+}
+
+// Tandai notifikasi level sudah diambil untuk dikirim. False bila sudah
+// diambil instance lain, sehingga notifikasi tidak terkirim ganda.
+func (s *sqlStore) ClaimCriticalNotice(id, level int) (bool, error) {
+	res, err := s.db.Exec("UPDATE critical_finding SET notifikasi_level=? WHERE id=? AND level=? AND notifikasi_level < ?",
+		level, id, level, level)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// Temuan belum diakui yang waktu eskalasinya sudah lewat
+func (s *sqlStore) DueCriticalEscalations(now time.Time) ([]CriticalFinding, error) {
+	rows, err := s.db.Query("SELECT "+criticalFindingColumns+` FROM critical_finding
+		WHERE diakui_pada IS NULL AND eskalasi_berikut IS NOT NULL AND eskalasi_berikut <= ? ORDER BY eskalasi_berikut`, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []CriticalFinding
+	for rows.Next() {
+		f, err := scanCriticalFinding(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, f)
+	}
+	return result, rows.Err()
+}
+
+// Naikkan level dari level dari ke level ke. False bila temuan sudah diakui
+// atau sudah dieskalasi proses lain.
+func (s *sqlStore) EscalateCriticalFinding(id, dari, ke int, berikut *time.Time) (bool, error) {
+	res, err := s.db.Exec("UPDATE critical_finding SET level=?, eskalasi_berikut=? WHERE id=? AND level=? AND diakui_pada IS NULL",
+		ke, berikut, id, dari)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s *sqlStore) AddCriticalNotification(n CriticalNotification) error {
+	_, err := s.db.Exec(`INSERT INTO critical_notification (finding_id, level, kanal, tujuan, berhasil, pesan_error, dikirim)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`, n.FindingID, n.Level, n.Kanal, n.Tujuan, n.Berhasil, n.PesanError, n.Dikirim)
+	return err
+}
+
+func (s *sqlStore) GetCriticalNotifications(findingID int) ([]CriticalNotification, error) {
+	rows, err := s.db.Query(`SELECT id, finding_id, level, kanal, tujuan, berhasil, IFNULL(pesan_error, ''), dikirim
+		FROM critical_notification WHERE finding_id=? ORDER BY dikirim, id`, findingID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []CriticalNotification
+	for rows.Next() {
+		var n CriticalNotification
+		if err := rows.Scan(&n.ID, &n.FindingID, &n.Level, &n.Kanal, &n.Tujuan, &n.Berhasil, &n.PesanError, &n.Dikirim); err != nil {
+			return nil, err
+		}
+		result = append(result, n)
+	}
+	return result, rows.Err()
+}
+
+// Daftar dipisah koma tanpa elemen kosong
+func splitList(s string) []string {
+	var result []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// Kata yang membatalkan kata kunci bila muncul tepat sebelumnya
+var criticalNegations = map[string]bool{
+	"tidak": true, "tak": true, "tanpa": true, "bukan": true, "negatif": true,
+	"no": true, "not": true, "without": true,
+}
+
+// Kata yang membuka klausa temuan baru. "tidak tampak X" tetap negasi, tetapi
+// "tampak" tanpa negasi di depannya mengakhiri negasi klausa sebelumnya.
+var criticalAffirmations = map[string]bool{
+	"tampak": true, "didapatkan": true, "terdapat": true, "terlihat": true,
+}
+
+// Jumlah kata sebelum kata kunci yang diperiksa untuk negasi
+const criticalNegationWindow = 4
+
+// Teks sebelum kata kunci menegasikannya bila ada kata negasi dalam beberapa
+// kata terakhir klausa yang sama. Koma memulai klausa baru.
+func criticalNegated(sebelum string) bool {
+	if i := strings.LastIndex(sebelum, ","); i >= 0 {
+		sebelum = sebelum[i+1:]
+	}
+	words := strings.FieldsFunc(sebelum, func(r rune) bool { return unicode.IsSpace(r) || r == ':' || r == '(' || r == ')' })
+	for n := 0; n < criticalNegationWindow && n < len(words); n++ {
+		i := len(words) - 1 - n
+		if criticalNegations[words[i]] {
+			return true
+		}
+		if criticalAffirmations[words[i]] {
+			return i > 0 && criticalNegations[words[i-1]]
+		}
+	}
+	return false
+}
+
+// Cari kalimat yang memuat kata kunci yang tidak dinegasikan. Mengembalikan
+// kalimat tersebut sebagai keterangan.
+func criticalKeywordMatch(teks string, keywords []string) (string, bool) {
+	kalimat := strings.FieldsFunc(teks, func(r rune) bool { return r == '.' || r == ';' || r == '\n' })
+	for _, k := range kalimat {
+		lower := strings.ToLower(k)
+		for _, kw := range keywords {
+			kw = strings.ToLower(kw)
+			// Periksa setiap kemunculan: "tidak tampak X kiri, tampak X kanan"
+			for start := 0; ; {
+				idx := strings.Index(lower[start:], kw)
+				if idx < 0 {
+					break
+				}
+				idx += start
+				if !criticalNegated(lower[:idx]) {
+					return strings.TrimSpace(k), true
+				}
+				start = idx + len(kw)
+			}
+		}
+	}
+	return "", false
+}
+
+// Periksa SR: kode kritis lebih dulu, lalu kata kunci di teks
+func DetectCriticalFinding(cfg Config, report SRReport) (sumber, keterangan string, ok bool) {
+	codes := map[string]bool{}
+	for _, c := range splitList(cfg.CriticalSRCodes) {
+		codes[c] = true
+	}
+	for _, k := range report.Kode {
+		if codes[k] {
+			return CriticalSourceCode, "kode SR " + k, true
+		}
+	}
+	if kalimat, ok := criticalKeywordMatch(report.Teks, splitList(cfg.CriticalKeywords)); ok {
+		return CriticalSourceKeyword, kalimat, true
+	}
+	return "", "", false
+}
+
+// Periksa isi laporan yang baru masuk dan kirim notifikasi bila kritis
+func CheckCriticalFinding(mwdb Store, accession string, report SRReport) {
+	if sumber, keterangan, ok := DetectCriticalFinding(RuntimeConfig(), report); ok {
+		FlagCriticalFinding(mwdb, accession, sumber, keterangan)
+	}
+}
+
+// Catat temuan kritis; notifikasi level 1 dikirim oleh criticalNoticeLoop.
+// Selama temuan order belum diakui tidak ada notifikasi ulang; eskalasi
+// berjalan dari temuan itu. Setelah diakui, temuan berikutnya dinotifikasi
+// sebagai temuan baru.
+func FlagCriticalFinding(mwdb Store, accession, sumber, keterangan string) {
+	cfg := RuntimeConfig()
+	logger := NewLogger(mwdb, CompKritis)
+	now := time.Now()
+	f := CriticalFinding{NomorOrder: accession, Sumber: sumber, Keterangan: keterangan, Dibuat: now, Level: 1}
+	if cfg.CriticalMaxEscalation > 0 {
+		berikut := now.Add(cfg.CriticalAckTimeout)
+		f.EskalasiBerikut = &berikut
+	}
+	f, created, err := mwdb.CreateCriticalFinding(f)
+	if err != nil {
+		logger.Error("Gagal mencatat temuan kritis", LogFields{Accession: accession, Err: err})
+		return
+	}
+	if !created {
+		return
+	}
+	logger.Warn("Temuan kritis ("+sumber+"): "+keterangan, LogFields{Accession: accession})
+	wakeCriticalNotices()
+}
+
+// Isi notifikasi untuk semua kanal
+type CriticalNotice struct {
+	FindingID   int    `json:"id"`
+	Accession   string `json:"accession"`
+	NamaPasien  string `json:"nama_pasien"`
+	Pemeriksaan string `json:"pemeriksaan"`
+	Sumber      string `json:"sumber"`
+	Keterangan  string `json:"keterangan"`
+	Level       int    `json:"level"`
+}
+
+func (n CriticalNotice) Judul() string {
+	judul := "TEMUAN KRITIS radiologi " + n.Accession
+	if n.Level > 1 {
+		judul = fmt.Sprintf("[ESKALASI %d] %s", n.Level-1, judul)
+	}
+	return judul
+}
+
+func (n CriticalNotice) Pesan() string {
+	return fmt.Sprintf("%s\nPasien: %s\nPemeriksaan: %s\nTemuan: %s\nMohon segera ditindaklanjuti dan diakui di portal radiologi (menu Temuan Kritis).",
+		n.Judul(), n.NamaPasien, n.Pemeriksaan, n.Keterangan)
+}
+
+// Kanal notifikasi. Penerima kosong berarti kanal tidak dipakai untuk level itu.
+type CriticalChannel interface {
+	Kanal() string
+	Penerima(cfg Config, level int) []string
+	Kirim(cfg Config, tujuan string, n CriticalNotice) error
+}
+
+var criticalChannels = []CriticalChannel{portalBannerChannel{}, emailChannel{}, webhookChannel{}}
+
+// Penerima level 1 atau penerima eskalasi; eskalasi kosong memakai penerima utama
+func criticalRecipients(utama, eskalasi string, level int) []string {
+	if level > 1 && strings.TrimSpace(eskalasi) != "" {
+		return splitList(eskalasi)
+	}
+	return splitList(utama)
+}
+
+// Banner di portal lewat event bus; temuan terbuka juga dibaca langsung dari DB
+type portalBannerChannel struct{}
+
+func (portalBannerChannel) Kanal() string { return "portal" }
+
+func (portalBannerChannel) Penerima(cfg Config, level int) []string { return []string{"banner"} }
+
+func (portalBannerChannel) Kirim(cfg Config, tujuan string, n CriticalNotice) error {
+	Bus.Publish(EventKritis, n)
+	return nil
+}
+
+type emailChannel struct{}
+
+func (emailChannel) Kanal() string { return "email" }
+
+func (emailChannel) Penerima(cfg Config, level int) []string {
+	return criticalRecipients(cfg.CriticalEmailTo, cfg.CriticalEscalationEmailTo, level)
+}
+
+// Kirim lewat SMTP dengan STARTTLS bila didukung server
+func (emailChannel) Kirim(cfg Config, tujuan string, n CriticalNotice) error {
+	if cfg.SMTPHost == "" || cfg.SMTPFrom == "" {
+		return fmt.Errorf("SMTP_HOST dan SMTP_FROM wajib diisi")
+	}
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(cfg.SMTPHost, cfg.SMTPPort), 10*time.Second)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+	c, err := smtp.NewClient(conn, cfg.SMTPHost)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: cfg.SMTPHost}); err != nil {
+			return err
+		}
+	}
+	if cfg.SMTPUser != "" {
+		if err := c.Auth(smtp.PlainAuth("", cfg.SMTPUser, cfg.SMTPPass, cfg.SMTPHost)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(cfg.SMTPFrom); err != nil {
+		return err
+	}
+	if err := c.Rcpt(tujuan); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	msg := "From: " + headerValue(cfg.SMTPFrom) + "\r\nTo: " + headerValue(tujuan) +
+		"\r\nSubject: " + mime.QEncoding.Encode("UTF-8", headerValue(n.Judul())) +
+		"\r\nMIME-Version: 1.0\r\nContent-Type: text/plain; charset=UTF-8\r\nContent-Transfer-Encoding: 8bit\r\n\r\n" +
+		strings.ReplaceAll(n.Pesan(), "\n", "\r\n") + "\r\n"
+	if _, err := w.Write([]byte(msg)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// Isi header email satu baris: CR/LF dari data pasien atau konfigurasi
+// dibuang agar tidak bisa menyisipkan header lain
+func headerValue(s string) string {
+	return strings.Join(strings.FieldsFunc(s, func(r rune) bool { return r == '\r' || r == '\n' }), " ")
+}
+
+// Webhook HTTP generik, misalnya gateway WhatsApp: satu POST JSON per target
+type webhookChannel struct{}
+
+func (webhookChannel) Kanal() string { return "webhook" }
+
+func (webhookChannel) Penerima(cfg Config, level int) []string {
+	if cfg.CriticalWebhookURL == "" {
+		return nil
+	}
+	return criticalRecipients(cfg.CriticalWebhookTargets, cfg.CriticalEscalationWebhookTargets, level)
+}
+
+func (webhookChannel) Kirim(cfg Config, tujuan string, n CriticalNotice) error {
+	body, _ := json.Marshal(map[string]interface{}{
+		"target":  tujuan,
+		"message": n.Pesan(),
+		"temuan":  n,
+	})
+	req, err := http.NewRequest("POST", cfg.CriticalWebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if cfg.CriticalWebhookToken != "" {
+		req.Header.Set("Authorization", cfg.CriticalWebhookToken)
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook membalas %s", resp.Status)
+	}
+	return nil
+}
+
+// Kirim notifikasi level temuan ke semua kanal dan catat setiap pengiriman
+func dispatchCriticalNotice(cfg Config, mwdb Store, f CriticalFinding) {
+	logger := NewLogger(mwdb, CompKritis)
+	n := CriticalNotice{FindingID: f.ID, Accession: f.NomorOrder, Sumber: f.Sumber, Keterangan: f.Keterangan, Level: f.Level}
+	if o, err := loadReportOrder(cfg, mwdb, f.NomorOrder); err == nil {
+		n.NamaPasien, n.Pemeriksaan = dicomName(o.NamaPasien), o.Pemeriksaan
+	}
+	for _, ch := range criticalChannels {
+		for _, tujuan := range ch.Penerima(cfg, f.Level) {
+			err := ch.Kirim(cfg, tujuan, n)
+			rec := CriticalNotification{FindingID: f.ID, Level: f.Level, Kanal: ch.Kanal(), Tujuan: tujuan, Berhasil: err == nil, Dikirim: time.Now()}
+			fields := LogFields{Accession: f.NomorOrder, Err: err}
+			if err != nil {
+				rec.PesanError = err.Error()
+				logger.Error(fmt.Sprintf("Notifikasi temuan kritis level %d ke %s %s gagal", f.Level, ch.Kanal(), tujuan), fields)
+			} else {
+				logger.Info(fmt.Sprintf("Notifikasi temuan kritis level %d dikirim ke %s %s", f.Level, ch.Kanal(), tujuan), fields)
+			}
+			if err := mwdb.AddCriticalNotification(rec); err != nil {
+				logger.Error("Gagal mencatat audit notifikasi temuan kritis", LogFields{Accession: f.NomorOrder, Err: err})
+			}
+		}
+	}
+}
+
+// Kirim notifikasi yang masih antri: temuan baru dan temuan yang baru
+// dieskalasi. Mengembalikan jumlah notifikasi yang dikirim.
+func SendPendingCriticalNotices(cfg Config, mwdb Store) (int, error) {
+	pending, err := mwdb.PendingCriticalNotices()
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, f := range pending {
+		ok, err := mwdb.ClaimCriticalNotice(f.ID, f.Level)
+		if err != nil {
+			return n, err
+		}
+		if !ok {
+			continue
+		}
+		dispatchCriticalNotice(cfg, mwdb, f)
+		n++
+	}
+	return n, nil
+}
+
+// Eskalasi temuan yang belum diakui; notifikasinya dikirim lewat
+// SendPendingCriticalNotices. Mengembalikan jumlah temuan yang dieskalasi.
+func EscalateCriticalFindings(cfg Config, mwdb Store, now time.Time) (int, error) {
+	due, err := mwdb.DueCriticalEscalations(now)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, f := range due {
+		ke := f.Level + 1
+		var berikut *time.Time
+		if ke <= cfg.CriticalMaxEscalation {
+			t := now.Add(cfg.CriticalAckTimeout)
+			berikut = &t
+		}
+		ok, err := mwdb.EscalateCriticalFinding(f.ID, f.Level, ke, berikut)
+		if err != nil {
+			return n, err
+		}
+		if !ok {
+			continue
+		}
+		NewLogger(mwdb, CompKritis).Warn(fmt.Sprintf("Temuan kritis belum diakui, eskalasi ke level %d", ke), LogFields{Accession: f.NomorOrder})
+		n++
+	}
+	return n, nil
+}
+
+// Bangunkan criticalNoticeLoop tanpa menunggu interval berikutnya
+var criticalNoticeWake = make(chan struct{}, 1)
+
+func wakeCriticalNotices() {
+	select {
+	case criticalNoticeWake <- struct{}{}:
+	default:
+	}
+}
+
+// Eskalasi dan kirim notifikasi temuan kritis setiap menit, atau segera
+// setelah temuan baru dicatat. Temuan yang dicatat saat loop tidak berjalan
+// (perintah CLI, restart) dikirim pada putaran pertama.
+func criticalNoticeLoop(ctx context.Context, mwdb Store) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		cfg := RuntimeConfig()
+		if _, err := EscalateCriticalFindings(cfg, mwdb, time.Now()); err != nil {
+			log.Printf("Gagal eskalasi temuan kritis: %v", err)
+		}
+		if _, err := SendPendingCriticalNotices(cfg, mwdb); err != nil {
+			log.Printf("Gagal mengirim notifikasi temuan kritis: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-criticalNoticeWake:
+		}
+	}
+}
+
+var criticalTmpl = `
+<!DOCTYPE html>
+<html>
+<head>
+    <title>Temuan Kritis</title>
+    <style>
+        body { font-family: Arial; margin: 40px; }
+        table { border-collapse: collapse; width: 100%; }
+        th, td { border: 1px solid #ccc; padding: 6px; text-align: left; vertical-align: top; }
+        th { background: #f0f0f0; }
+        .terbuka { background: #fdecea; }
+        .gagal { color: red; }
+        form.inline { display: inline; margin: 0; }
+    </style>
+</head>
+<body>
+    <h2>Temuan Kritis</h2>
+    <p><a href="/">Dashboard</a> | <a href="/reading">Worklist Baca</a></p>
+    {{if .Pesan}}<p><b>{{.Pesan}}</b></p>{{end}}
+    <table>
+        <tr><th>Waktu</th><th>Accession</th><th>Sumber</th><th>Keterangan</th><th>Level</th><th>Eskalasi Berikut</th><th>Diakui</th><th></th></tr>
+        {{range .Findings}}
+        <tr {{if not .DiakuiPada}}class="terbuka"{{end}}>
+            <td>{{.Dibuat.Format "2006-01-02 15:04"}}</td>
+            <td><a href="/report?accession={{.NomorOrder}}">{{.NomorOrder}}</a></td>
+            <td>{{.Sumber}}</td>
+            <td>{{.Keterangan}}</td>
+            <td>{{.Level}}</td>
+            <td>{{if .EskalasiBerikut}}{{.EskalasiBerikut.Format "2006-01-02 15:04"}}{{else}}-{{end}}</td>
+            <td>{{if .DiakuiPada}}{{.DiakuiOleh}}, {{.DiakuiPada.Format "2006-01-02 15:04"}}{{if .Catatan}}: {{.Catatan}}{{end}}{{else}}
+                <form class="inline" method="post" action="/critical/ack">
+                    <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+                    <input type="hidden" name="id" value="{{.ID}}">
+                    <input type="text" name="catatan" placeholder="Tindak lanjut">
+                    <button type="submit">Akui</button>
+                </form>{{end}}</td>
+            <td><a href="/critical?id={{.ID}}">Audit</a></td>
+        </tr>
+        {{else}}
+        <tr><td colspan="8">Belum ada temuan kritis</td></tr>
+        {{end}}
+    </table>
+    {{if .Audit}}
+    <h3>Audit Notifikasi {{.Terpilih.NomorOrder}}</h3>
+    <table>
+        <tr><th>Waktu</th><th>Level</th><th>Kanal</th><th>Tujuan</th><th>Hasil</th></tr>
+        {{range .Audit}}
+        <tr><td>{{.Dikirim.Format "2006-01-02 15:04:05"}}</td><td>{{.Level}}</td><td>{{.Kanal}}</td><td>{{.Tujuan}}</td>
+            <td>{{if .Berhasil}}terkirim{{else}}<span class="gagal">gagal: {{.PesanError}}</span>{{end}}</td></tr>
+        {{end}}
+        {{if .Terpilih.DiakuiPada}}
+        <tr><td>{{.Terpilih.DiakuiPada.Format "2006-01-02 15:04:05"}}</td><td>{{.Terpilih.Level}}</td><td>-</td><td>diakui oleh {{.Terpilih.DiakuiOleh}}</td><td>{{.Terpilih.Catatan}}</td></tr>
+        {{end}}
+    </table>
+    {{end}}
+</body>
+</html>
+`
+
+// Potongan banner temuan kritis yang belum diakui, untuk halaman portal lain
+const criticalBannerTmpl = `{{define "kritis"}}{{if .}}<div style="background:#c62828;color:#fff;padding:10px;margin-bottom:15px;">
+    <b>{{len .}} temuan kritis belum diakui:</b>
+    {{range .}}<a style="color:#fff" href="/report?accession={{.NomorOrder}}">{{.NomorOrder}}</a> ({{.Keterangan}}) {{end}}
+    | <a style="color:#fff" href="/critical">Akui di halaman Temuan Kritis</a>
+</div>{{end}}{{end}}`
+
+func openCriticalFindings(mwdb CriticalStore) []CriticalFinding {
+	findings, err := mwdb.GetCriticalFindings(true, 20)
+	if err != nil {
+		log.Printf("Gagal ambil temuan kritis: %v", err)
+	}
+	return findings
+}
+
+func registerCriticalHandlers(mux *http.ServeMux, mwdb Store) {
+	mux.HandleFunc("/critical", RequirePermission(mwdb, PermView, func(w http.ResponseWriter, r *http.Request) {
+		findings, err := mwdb.GetCriticalFindings(false, 200)
+		if err != nil {
+			http.Error(w, "Gagal ambil temuan kritis: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var terpilih CriticalFinding
+		var audit []CriticalNotification
+		if id, err := strconv.Atoi(r.URL.Query().Get("id")); err == nil {
+			if terpilih, err = mwdb.GetCriticalFinding(id); err == nil {
+				audit, _ = mwdb.GetCriticalNotifications(id)
+			}
+		}
+		t, _ := template.New("critical").Parse(criticalTmpl)
+		t.Execute(w, struct {
+			Findings  []CriticalFinding
+			Terpilih  CriticalFinding
+			Audit     []CriticalNotification
+			Pesan     string
+			CSRFToken string
+		}{findings, terpilih, audit, r.URL.Query().Get("pesan"), CurrentSession(r).CSRFToken})
+	}))
+
+	mux.HandleFunc("/critical/ack", RequirePermission(mwdb, PermView, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		user := CurrentSession(r).User
+		id, _ := strconv.Atoi(r.FormValue("id"))
+		pesan := "Temuan kritis diakui"
+		if err := mwdb.AcknowledgeCriticalFinding(id, user.Username, strings.TrimSpace(r.FormValue("catatan"))); err != nil {
+			pesan = "Gagal: " + err.Error()
+		} else if f, err := mwdb.GetCriticalFinding(id); err == nil {
+			NewLogger(mwdb, CompKritis).Info("Temuan kritis diakui oleh "+user.Username, LogFields{Accession: f.NomorOrder})
+		}
+		http.Redirect(w, r, "/critical?pesan="+url.QueryEscape(pesan), http.StatusSeeOther)
+	}))
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestCriticalKeywordMatch(t *testing.T) {
+	keywords := []string{"pneumothorax", "perdarahan intrakranial", "free air"}
+	tests := []struct {
+		teks   string
+		kritis bool
+	}{
+		{"Tampak pneumothorax kanan luas.", true},
+		{"Tidak tampak pneumothorax.", false},
+		{"Tidak tampak pneumothorax", false},
+		{"Tanpa tanda pneumothorax.", false},
+		{"Tidak tampak efusi maupun pneumothorax.", false},
+		{"Tidak tampak efusi, tampak pneumothorax kanan.", true},
+		{"Tidak tampak efusi pleura dan tampak pneumothorax kanan.", true},
+		{"Tidak tampak fraktur, didapatkan perdarahan intrakranial.", true},
+		{"Tidak didapatkan perdarahan intrakranial.", false},
+		{"Tidak tampak pneumothorax kiri, tampak pneumothorax kanan.", true},
+		{"Kesan: pneumothorax kanan.", true},
+		{"Negatif: pneumothorax.", false},
+		{"Sinus tajam (tidak tampak pneumothorax).", false},
+		{"Tidak ada kelainan pada foto ini yang sebelumnya menunjukkan pneumothorax.", true},
+		{"Cor normal.\nTidak tampak pneumothorax; sinus tajam.", false},
+		{"Cor normal; Free air di bawah diafragma.", true},
+		{"No free air.", false},
+		{"Pulmo dalam batas normal.", false},
+	}
+	for _, tt := range tests {
+		if _, got := criticalKeywordMatch(tt.teks, keywords); got != tt.kritis {
+			t.Errorf("%q: kritis=%v, seharusnya %v", tt.teks, got, tt.kritis)
+		}
+	}
+}
+
+// Nama pasien dari Khanza tidak boleh menyisipkan header email
+func TestCriticalEmailHeader(t *testing.T) {
+	n := CriticalNotice{Accession: "CR0001\r\nBcc: penyusup@contoh.id", Level: 1}
+	subject := mime.QEncoding.Encode("UTF-8", headerValue(n.Judul()))
+	if strings.ContainsAny(subject, "\r\n") {
+		t.Fatalf("subject memuat CR/LF: %q", subject)
+	}
+	got, err := new(mime.WordDecoder).DecodeHeader(subject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "TEMUAN KRITIS radiologi CR0001 Bcc: penyusup@contoh.id" {
+		t.Fatalf("subject %q", got)
+	}
+	if enc := mime.QEncoding.Encode("UTF-8", headerValue("Pasien Ñoño")); !strings.HasPrefix(enc, "=?UTF-8?q?") {
+		t.Fatalf("nama non-ASCII tidak di-encode: %q", enc)
+	}
+}
+
+// Temuan baru setelah temuan lama diakui (SR koreksi, addendum, tanda kritis
+// saat tanda tangan ulang) harus dinotifikasi lagi
+func TestCriticalFindingAfterAcknowledge(t *testing.T) {
+	var mu sync.Mutex
+	var targets []string
+	gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct{ Target string }
+		json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		targets = append(targets, body.Target)
+		mu.Unlock()
+	}))
+	defer gateway.Close()
+	var cfg Config
+	if err := applyDefaults(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	cfg.CriticalWebhookURL = gateway.URL
+	cfg.CriticalWebhookTargets = "081100000001"
+	SetRuntimeConfig(cfg)
+	defer SetRuntimeConfig(Config{})
+	mwdb := openMemoryStore(t)
+	if err := mwdb.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+	const accession = "CR0001012026101909"
+
+	kirim := func(want int) {
+		t.Helper()
+		if n, err := SendPendingCriticalNotices(cfg, mwdb); err != nil || n != want {
+			t.Fatalf("%d notifikasi terkirim, seharusnya %d: %v", n, want, err)
+		}
+	}
+	FlagCriticalFinding(mwdb, accession, CriticalSourceKeyword, "pneumothorax")
+	kirim(1)
+	// Replay selama temuan belum diakui tidak membuat temuan baru
+	FlagCriticalFinding(mwdb, accession, CriticalSourceKeyword, "pneumothorax")
+	kirim(0)
+	pertama, err := mwdb.GetCriticalFindingByOrder(accession)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mwdb.AcknowledgeCriticalFinding(pertama.ID, "dokter.jaga", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	FlagCriticalFinding(mwdb, accession, CriticalSourcePortal, "ditandai kritis oleh radiolog")
+	kirim(1)
+	kedua, err := mwdb.GetCriticalFindingByOrder(accession)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kedua.ID == pertama.ID || kedua.DiakuiPada != nil || kedua.Sumber != CriticalSourcePortal {
+		t.Fatalf("temuan kedua tidak dibuat: %+v", kedua)
+	}
+	if open, _ := mwdb.GetCriticalFindings(true, 0); len(open) != 1 || open[0].ID != kedua.ID {
+		t.Fatalf("temuan terbuka %+v, seharusnya hanya temuan kedua", open)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(targets) != 2 {
+		t.Fatalf("%d notifikasi diterima gateway, seharusnya 2", len(targets))
+	}
+}
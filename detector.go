@@ -0,0 +1,81 @@
+package main
+
+import (
+	"database/sql"
+	"sort"
+	"time"
+)
+
+// Detektor order baru dari Khanza. Di antara scan penuh jendela tanggal,
+// hanya order dengan waktu permintaan >= high-water mark yang diambil sehingga
+// polling bisa dilakukan tiap beberapa detik tanpa mengulang join besar.
+// Scan penuh tetap berjalan berkala untuk menangkap order yang diubah atau
+// diinput dengan tanggal mundur.
+type OrderDetector struct {
+	mark     time.Time
+	lastFull time.Time
+	// Order dalam jendela tanggal, untuk tampilan portal
+	orders map[string]WorklistRequest
+}
+
+func NewOrderDetector() *OrderDetector {
+	return &OrderDetector{orders: map[string]WorklistRequest{}}
+}
+
+// Waktu permintaan order dari tanggal (yyyymmdd) dan jam (hhmmss) worklist
+func requestTime(wl WorklistRequest) (time.Time, bool) {
+	t, err := time.ParseInLocation("20060102150405", wl.ScheduledProcedureStepStartDate+wl.ScheduledProcedureStepStartTime, time.Local)
+	return t, err == nil
+}
+
+// Ambil order yang perlu diproses. full bernilai true bila ini scan penuh.
+func (d *OrderDetector) Poll(db *sql.DB, now time.Time, cfg Config) (worklists []WorklistRequest, full bool, err error) {
+	dari, sampai := worklistWindow(now, cfg)
+	full = d.mark.IsZero() || now.Sub(d.lastFull) >= cfg.WorklistFullScanInterval
+	if full {
+		start := time.Now()
+		worklists, err = GetPendingWorklistRange(db, dari, sampai)
+		observeStage(StageWorklistQuery, start)
+		if err != nil {
+			return nil, true, err
+		}
+		d.lastFull = now
+		d.orders = make(map[string]WorklistRequest, len(worklists))
+	} else {
+		start := time.Now()
+		worklists, err = GetPendingWorklistSince(db, d.mark, sampai)
+		observeStage(StageWorklistIncremental, start)
+		if err != nil {
+			return nil, false, err
+		}
+	}
+
+	if full {
+		// Order di luar jendela tidak menaikkan mark, misalnya jadwal besok
+		d.mark = time.Time{}
+	}
+	for _, wl := range worklists {
+		d.orders[wl.AccessionNumber] = wl
+		if t, ok := requestTime(wl); ok && t.After(d.mark) && !t.After(now) {
+			d.mark = t
+		}
+	}
+	if d.mark.IsZero() {
+		// Belum ada order hari ini, mulai dari awal jendela
+		d.mark, _ = time.ParseInLocation("2006-01-02", dari, time.Local)
+	}
+	return worklists, full, nil
+}
+
+// Semua order dalam jendela yang sudah terdeteksi
+func (d *OrderDetector) Orders() []WorklistRequest {
+	result := make([]WorklistRequest, 0, len(d.orders))
+	for _, wl := range d.orders {
+		result = append(result, wl)
+	}
+	key := func(wl WorklistRequest) string {
+		return wl.ScheduledProcedureStepStartDate + wl.ScheduledProcedureStepStartTime + wl.AccessionNumber
+	}
+	sort.Slice(result, func(i, j int) bool { return key(result[i]) < key(result[j]) })
+	return result
+}
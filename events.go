@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Jenis event yang dikirim ke dashboard
+const (
+	EventLog    = "log"
+	EventStatus = "status"
+	EventOrder  = "order"
+	EventKritis = "kritis"
+)
+
+type Event struct {
+	Type  string      `json:"type"`
+	Waktu time.Time   `json:"waktu"`
+	Data  interface{} `json:"data"`
+}
+
+type OrderStateChange struct {
+	NomorOrder string `json:"nomor_order"`
+	Dari       string `json:"dari"`
+	Ke         string `json:"ke"`
+	Keterangan string `json:"keterangan"`
+}
+
+// Event bus in-process: publisher tidak pernah menunggu subscriber yang lambat
+type EventBus struct {
+	mu          sync.RWMutex
+	closed      bool
+	subscribers map[chan Event]struct{}
+}
+
+var Bus = NewEventBus()
+
+func NewEventBus() *EventBus {
+	return &EventBus{subscribers: make(map[chan Event]struct{})}
+}
+
+func (b *EventBus) Publish(eventType string, data interface{}) {
+	ev := Event{Type: eventType, Waktu: time.Now(), Data: data}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- ev:
+		default:
+			// Buffer subscriber penuh, event dibuang agar publisher tidak tertahan
+		}
+	}
+}
+
+func (b *EventBus) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, 64)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	b.subscribers[ch] = struct{}{}
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subscribers[ch]; ok {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Tutup semua subscriber, dipanggil saat shutdown agar stream SSE selesai
+func (b *EventBus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for ch := range b.subscribers {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// Endpoint Server-Sent Events untuk dashboard
+func handleEventStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming tidak didukung", http.StatusInternalServerError)
+		return
+	}
+	// Stream terbuka selama dashboard dibuka, lepas dari WriteTimeout server
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		http.Error(w, "Streaming tidak didukung", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	events, unsubscribe := Bus.Subscribe()
+	defer unsubscribe()
+
+	// Kirim status terkini agar dashboard langsung sinkron
+	writeSSE(w, Event{Type: EventStatus, Waktu: time.Now(), Data: GetStatus()})
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(25 * time.Second)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			writeSSE(w, ev)
+			flusher.Flush()
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			flusher.Flush()
+		}
+	}
+}
+
+func writeSSE(w http.ResponseWriter, ev Event) {
+	data, err := json.Marshal(ev.Data)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data)
+}
@@ -0,0 +1,440 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	sqlite "modernc.org/sqlite"
+)
+
+// Komponen palsu untuk harness integrasi: Khanza di atas SQLite dengan
+// penerjemah sintaks MySQL, Orthanc dan OHIF lewat httptest.
+
+const fakeKhanzaDriver = "khanza-sqlite"
+
+var registerFakeKhanza sync.Once
+
+// Terjemahan minimal sintaks MySQL yang dipakai khanza.go ke SQLite
+var mysqlToSQLite = []struct {
+	pattern *regexp.Regexp
+	replace string
+}{
+	{regexp.MustCompile(`(?i)\bLEFT\(`), "mysql_left("},
+	{regexp.MustCompile(`(?i)ON DUPLICATE KEY UPDATE`), "ON CONFLICT DO UPDATE SET"},
+	// information_schema ditiru dengan tabel biasa, lihat refreshInformationSchema
+	{regexp.MustCompile(`(?i)\binformation_schema\.`), "information_schema_"},
+	{regexp.MustCompile(`(?i)\bDATABASE\(\)`), "'khanza'"},
+}
+
+type mysqlCompatDriver struct{ driver.Driver }
+type mysqlCompatConn struct{ driver.Conn }
+
+func (d mysqlCompatDriver) Open(name string) (driver.Conn, error) {
+	c, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return mysqlCompatConn{c}, nil
+}
+
+func (c mysqlCompatConn) Prepare(query string) (driver.Stmt, error) {
+	for _, t := range mysqlToSQLite {
+		query = t.pattern.ReplaceAllString(query, t.replace)
+	}
+	return c.Conn.Prepare(query)
+}
+
+func valueString(v driver.Value) string {
+	switch x := v.(type) {
+	case []byte:
+		return string(x)
+	case time.Time:
+		return x.Format("2006-01-02 15:04:05")
+	}
+	return fmt.Sprint(v)
+}
+
+// Fungsi MySQL yang dipakai query Khanza, didaftarkan ke SQLite
+func registerMySQLFunctions() {
+	sqlite.MustRegisterDeterministicScalarFunction("concat", -1, func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		var b strings.Builder
+		for _, a := range args {
+			if a == nil {
+				return nil, nil
+			}
+			b.WriteString(valueString(a))
+		}
+		return b.String(), nil
+	})
+	sqlite.MustRegisterDeterministicScalarFunction("mysql_left", 2, func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		if args[0] == nil {
+			return nil, nil
+		}
+		s, n := []rune(valueString(args[0])), 0
+		fmt.Sscan(valueString(args[1]), &n)
+		if n < len(s) {
+			s = s[:n]
+		}
+		return string(s), nil
+	})
+	formats := strings.NewReplacer("%Y", "2006", "%m", "01", "%d", "02", "%H", "15", "%i", "04", "%s", "05")
+	sqlite.MustRegisterDeterministicScalarFunction("date_format", 2, func(ctx *sqlite.FunctionContext, args []driver.Value) (driver.Value, error) {
+		if args[0] == nil {
+			return nil, nil
+		}
+		raw := valueString(args[0])
+		for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02"} {
+			if t, err := time.Parse(layout, raw); err == nil {
+				return t.Format(formats.Replace(valueString(args[1]))), nil
+			}
+		}
+		return nil, nil
+	})
+}
+
+// Subset skema Khanza yang disentuh middleware. Tanggal dan jam disimpan
+// sebagai teks, sama seperti hasil scan driver MySQL tanpa parseTime.
+var fakeKhanzaSchema = []string{
+	`CREATE TABLE pasien (no_rkm_medis TEXT PRIMARY KEY, nm_pasien TEXT, tgl_lahir TEXT, jk TEXT)`,
+	`CREATE TABLE reg_periksa (no_rawat TEXT PRIMARY KEY, no_rkm_medis TEXT, tgl_registrasi TEXT, kd_poli TEXT)`,
+	`CREATE TABLE jns_perawatan_radiologi (kd_jenis_prw TEXT PRIMARY KEY, nm_perawatan TEXT, bagian_rs REAL, bhp REAL, tarif_perujuk REAL,
+		tarif_tindakan_dokter REAL, tarif_tindakan_petugas REAL, kso REAL, menejemen REAL, total_byr REAL, kd_pj TEXT, status TEXT, kelas TEXT)`,
+	`CREATE TABLE permintaan_radiologi (noorder TEXT PRIMARY KEY, no_rawat TEXT, tgl_permintaan TEXT, jam_permintaan TEXT,
+		tgl_sampel TEXT, jam_sampel TEXT, tgl_hasil TEXT, jam_hasil TEXT, dokter_perujuk TEXT, status TEXT)`,
+	`CREATE TABLE permintaan_pemeriksaan_radiologi (noorder TEXT, kd_jenis_prw TEXT, stts_bayar TEXT, PRIMARY KEY (noorder, kd_jenis_prw))`,
+	`CREATE TABLE periksa_radiologi (no_rawat TEXT, nip TEXT, kd_jenis_prw TEXT, tgl_periksa TEXT, jam TEXT, dokter_perujuk TEXT,
+		bagian_rs REAL, bhp REAL, tarif_perujuk REAL, tarif_tindakan_dokter REAL, tarif_tindakan_petugas REAL, kso REAL, menejemen REAL,
+		biaya REAL, kd_dokter TEXT, status TEXT, proyeksi TEXT, PRIMARY KEY (no_rawat, kd_jenis_prw, tgl_periksa, jam))`,
+	`CREATE TABLE dokter (kd_dokter TEXT PRIMARY KEY, nm_dokter TEXT)`,
+	`CREATE TABLE petugas (nip TEXT PRIMARY KEY, nama TEXT)`,
+	`CREATE TABLE hasil_radiologi (no_rawat TEXT, tgl_periksa TEXT, jam TEXT, hasil TEXT, PRIMARY KEY (no_rawat, tgl_periksa, jam))`,
+	`CREATE TABLE gambar_radiologi (no_rawat TEXT, tgl_periksa TEXT, jam TEXT, lokasi_gambar TEXT, PRIMARY KEY (no_rawat, tgl_periksa, jam, lokasi_gambar))`,
+}
+
+// Order radiologi contoh untuk seed
+type fakeOrder struct {
+	NoOrder     string
+	NoRawat     string
+	NoRM        string
+	NamaPasien  string
+	KdJenisPrw  string
+	Pemeriksaan string
+	Waktu       time.Time
+}
+
+// Khanza palsu in memory, ditutup otomatis di akhir test
+func openFakeKhanza(t *testing.T) *sql.DB {
+	registerFakeKhanza.Do(func() {
+		registerMySQLFunctions()
+		// Fungsi terdaftar di instance driver global modernc, bukan di sqlite.Driver baru
+		base, _ := sql.Open("sqlite", "")
+		sql.Register(fakeKhanzaDriver, mysqlCompatDriver{base.Driver()})
+		base.Close()
+	})
+	db := openMemoryDB(t, fakeKhanzaDriver, ":memory:")
+	for _, stmt := range fakeKhanzaSchema {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("gagal membuat skema Khanza palsu: %v", err)
+		}
+	}
+	if err := refreshInformationSchema(db); err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range []string{
+		"INSERT INTO dokter VALUES ('D0001', 'dr. Perujuk Uji'), ('D0099', 'Radiolog Uji')",
+		"INSERT INTO petugas VALUES ('R001', 'Radiografer Uji')",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+// Database SQLite in memory. Satu koneksi saja: setiap koneksi baru ke
+// :memory: adalah database kosong yang lain.
+func openMemoryDB(t *testing.T, driverName, dsn string) *sql.DB {
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// DB middleware SQLite in memory tanpa migrasi, format waktu sama dengan openSQLite
+func openMemoryStore(t *testing.T) Store {
+	return NewSQLiteStore(openMemoryDB(t, "sqlite", "file::memory:?_time_format=sqlite"))
+}
+
+// Isi ulang tiruan information_schema.columns dan .statistics dari katalog
+// SQLite. Panggil lagi setelah mengubah skema Khanza palsu.
+func refreshInformationSchema(db *sql.DB) error {
+	stmts := []string{
+		`DROP TABLE IF EXISTS information_schema_columns`,
+		`DROP TABLE IF EXISTS information_schema_statistics`,
+		`CREATE TABLE information_schema_columns AS
+			SELECT 'khanza' AS table_schema, m.name AS table_name, c.name AS column_name
+			FROM sqlite_master m, pragma_table_info(m.name) c
+			WHERE m.type = 'table' AND m.name NOT LIKE 'information_schema_%'`,
+		`CREATE TABLE information_schema_statistics AS
+			SELECT 'khanza' AS table_schema, m.name AS table_name,
+				CASE l.origin WHEN 'pk' THEN 'PRIMARY' ELSE l.name END AS index_name,
+				1 - l."unique" AS non_unique, i.name AS column_name, i.seqno + 1 AS seq_in_index
+			FROM sqlite_master m, pragma_index_list(m.name) l, pragma_index_info(l.name) i
+			WHERE m.type = 'table' AND m.name NOT LIKE 'information_schema_%'`,
+	}
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			return fmt.Errorf("gagal membuat information_schema palsu: %v", err)
+		}
+	}
+	return nil
+}
+
+func seedFakeOrder(db *sql.DB, o fakeOrder) error {
+	stmts := []struct {
+		query string
+		args  []interface{}
+	}{
+		{"INSERT INTO pasien VALUES (?, ?, ?, ?)", []interface{}{o.NoRM, o.NamaPasien, "1980-05-17", "L"}},
+		{"INSERT INTO reg_periksa VALUES (?, ?, ?, 'RAD')", []interface{}{o.NoRawat, o.NoRM, o.Waktu.Format("2006-01-02")}},
+		{`INSERT OR IGNORE INTO jns_perawatan_radiologi (kd_jenis_prw, nm_perawatan, bagian_rs, bhp, tarif_perujuk, tarif_tindakan_dokter,
+			tarif_tindakan_petugas, kso, menejemen, total_byr, kd_pj, status, kelas) VALUES (?, ?, 60000, 15000, 5000, 50000, 20000, 0, 0, 150000, '-', '1', '-')`,
+			[]interface{}{o.KdJenisPrw, o.Pemeriksaan}},
+		{"INSERT INTO permintaan_radiologi (noorder, no_rawat, tgl_permintaan, jam_permintaan, dokter_perujuk, status) VALUES (?, ?, ?, ?, ?, ?)",
+			[]interface{}{o.NoOrder, o.NoRawat, o.Waktu.Format("2006-01-02"), o.Waktu.Format("15:04:05"), "D0001", "ralan"}},
+		{"INSERT INTO permintaan_pemeriksaan_radiologi VALUES (?, ?, ?)", []interface{}{o.NoOrder, o.KdJenisPrw, "Belum"}},
+	}
+	for _, s := range stmts {
+		if _, err := db.Exec(s.query, s.args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Orthanc palsu: /system, /tools/find, /studies/{id}/series, /instances/{id}/tags,
+// /instances/{id}/preview, /tools/create-dicom dan /changes
+type fakeOrthanc struct {
+	*httptest.Server
+	user, pass string
+	mu         sync.Mutex
+	studies    map[string]string                 // accession -> StudyInstanceUID
+	instances  map[string]map[string]interface{} // instance id -> tag format /tags
+	changes    []map[string]interface{}
+}
+
+func newFakeOrthanc(user, pass string) *fakeOrthanc {
+	f := &fakeOrthanc{user: user, pass: pass, studies: map[string]string{}, instances: map[string]map[string]interface{}{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/system", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{"Name": "FakeOrthanc", "Version": "harness"})
+	})
+	mux.HandleFunc("/tools/find", func(w http.ResponseWriter, r *http.Request) {
+		var q struct {
+			Query map[string]string
+		}
+		json.NewDecoder(r.Body).Decode(&q)
+		f.mu.Lock()
+		uid, ok := f.studies[q.Query["AccessionNumber"]]
+		f.mu.Unlock()
+		result := []interface{}{}
+		if ok {
+			result = append(result, map[string]interface{}{
+				"ID":            fakeStudyID(q.Query["AccessionNumber"]),
+				"MainDicomTags": map[string]string{"StudyInstanceUID": uid},
+			})
+		}
+		json.NewEncoder(w).Encode(result)
+	})
+	mux.HandleFunc("/studies/", func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/studies/"), "/series")
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		for accession := range f.studies {
+			if fakeStudyID(accession) == id {
+				// Satu seri CR dengan satu gambar, SR buatan editor tidak ikut didaftar
+				json.NewEncoder(w).Encode([]interface{}{map[string]interface{}{
+					"ID":            "series-" + accession,
+					"MainDicomTags": map[string]string{"Modality": "CR"},
+					"Instances":     []string{fakeImageID(accession)},
+				}})
+				return
+			}
+		}
+		http.NotFound(w, r)
+	})
+	mux.HandleFunc("/instances/", func(w http.ResponseWriter, r *http.Request) {
+		if id := strings.TrimPrefix(r.URL.Path, "/instances/"); strings.HasSuffix(id, "/preview") {
+			if !strings.HasPrefix(id, "img-") {
+				http.NotFound(w, r)
+				return
+			}
+			// Gradasi abu-abu sebagai pengganti preview gambar
+			img := image.NewGray(image.Rect(0, 0, 64, 48))
+			for y := 0; y < 48; y++ {
+				for x := 0; x < 64; x++ {
+					img.SetGray(x, y, color.Gray{Y: uint8(x * 4)})
+				}
+			}
+			w.Header().Set("Content-Type", "image/png")
+			png.Encode(w, img)
+			return
+		}
+		id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/instances/"), "/tags")
+		f.mu.Lock()
+		tags, ok := f.instances[id]
+		f.mu.Unlock()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(tags)
+	})
+	mux.HandleFunc("/tools/create-dicom", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Parent string
+			Tags   map[string]interface{}
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		found := false
+		for accession := range f.studies {
+			found = found || fakeStudyID(accession) == req.Parent
+		}
+		if !found {
+			http.Error(w, "parent tidak ditemukan", http.StatusNotFound)
+			return
+		}
+		tags, err := fakeDicomTags(req.Tags)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		id := fmt.Sprintf("created-%d", len(f.instances)+1)
+		f.instances[id] = tags
+		f.changes = append(f.changes, map[string]interface{}{"ChangeType": "NewInstance", "ID": id, "Seq": len(f.changes) + 1})
+		json.NewEncoder(w).Encode(map[string]string{"ID": id, "Path": "/instances/" + id, "Type": "Instance"})
+	})
+	mux.HandleFunc("/changes", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]interface{}{"Changes": f.changes, "Done": true, "Last": len(f.changes)})
+	})
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, p, ok := r.BasicAuth(); !ok || u != f.user || p != f.pass {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	return f
+}
+
+// Simulasikan modality mengirim gambar untuk accession
+func (f *fakeOrthanc) AddStudy(accession, studyUID string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.studies[accession] = studyUID
+	f.changes = append(f.changes, map[string]interface{}{"ChangeType": "StableStudy", "ID": studyUID, "Seq": len(f.changes) + 1})
+}
+
+// Simulasikan radiolog menyimpan SR
+func (f *fakeOrthanc) AddReport(instanceID string, report SRReport) {
+	tags := map[string]interface{}{
+		"0040,a730": map[string]interface{}{
+			"Name": "ContentSequence",
+			"Value": []interface{}{map[string]interface{}{
+				"0040,a160": map[string]interface{}{"Name": "TextValue", "Value": report.Teks},
+			}},
+		},
+	}
+	if report.Dokter != "" {
+		tags["0008,1050"] = map[string]interface{}{"Name": "PerformingPhysicianName", "Value": report.Dokter}
+	}
+	if report.Operator != "" {
+		tags["0008,1070"] = map[string]interface{}{"Name": "OperatorsName", "Value": report.Operator}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.instances[instanceID] = tags
+	f.changes = append(f.changes, map[string]interface{}{"ChangeType": "NewInstance", "ID": instanceID, "Seq": len(f.changes) + 1})
+}
+
+func fakeStudyID(accession string) string {
+	return "study-" + accession
+}
+
+func fakeImageID(accession string) string {
+	return "img-" + accession
+}
+
+// Tag DICOM yang dipakai editor laporan, untuk mengubah JSON create-dicom
+// (nama tag) menjadi format /instances/{id}/tags (nomor tag)
+var fakeDicomDictionary = map[string]string{
+	"SOPClassUID": "0008,0016", "Modality": "0008,0060", "SeriesDescription": "0008,103e",
+	"SeriesNumber": "0020,0011", "InstanceNumber": "0020,0013", "ContentDate": "0008,0023",
+	"ContentTime": "0008,0033", "ValueType": "0040,a040", "ContinuityOfContent": "0040,a050",
+	"ConceptNameCodeSequence": "0040,a043", "CodeValue": "0008,0100", "CodingSchemeDesignator": "0008,0102",
+	"CodeMeaning": "0008,0104", "CompletionFlag": "0040,a491", "VerificationFlag": "0040,a493",
+	"VerifyingObserverSequence": "0040,a073", "VerifyingObserverName": "0040,a075",
+	"VerifyingOrganization": "0040,a027", "VerificationDateTime": "0040,a030",
+	"VerifyingObserverIdentificationCodeSequence": "0040,a088", "ContentSequence": "0040,a730",
+	"RelationshipType": "0040,a010", "TextValue": "0040,a160", "ConceptCodeSequence": "0040,a168",
+	"PerformingPhysicianName": "0008,1050", "OperatorsName": "0008,1070",
+}
+
+func fakeDicomTags(named map[string]interface{}) (map[string]interface{}, error) {
+	result := map[string]interface{}{}
+	for name, v := range named {
+		tag, ok := fakeDicomDictionary[name]
+		if !ok {
+			return nil, fmt.Errorf("tag %s tidak dikenal", name)
+		}
+		switch v := v.(type) {
+		case string:
+			result[tag] = map[string]interface{}{"Name": name, "Type": "String", "Value": v}
+		case []interface{}:
+			var items []interface{}
+			for _, item := range v {
+				m, ok := item.(map[string]interface{})
+				if !ok {
+					return nil, fmt.Errorf("item sequence %s tidak valid", name)
+				}
+				converted, err := fakeDicomTags(m)
+				if err != nil {
+					return nil, err
+				}
+				items = append(items, converted)
+			}
+			result[tag] = map[string]interface{}{"Name": name, "Type": "Sequence", "Value": items}
+		default:
+			return nil, fmt.Errorf("nilai tag %s tidak valid", name)
+		}
+	}
+	return result, nil
+}
+
+func newFakeOHIF() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html>OHIF Viewer</html>"))
+	}))
+}
@@ -0,0 +1,767 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Harness integrasi: jalankan alur order -> worklist -> gambar -> SR -> hasil
+// di Khanza tanpa server sungguhan. Khanza palsu berjalan di atas SQLite in
+// memory, Orthanc dan OHIF memakai httptest, DB middleware memakai backend
+// SQLite in memory. Tidak membaca konfigurasi maupun .env.
+type harness struct {
+	t       *testing.T
+	dir     string
+	cfg     Config
+	khanza  *sql.DB
+	mwdb    Store
+	orthanc *fakeOrthanc
+	ohif    *httptest.Server
+}
+
+func newHarness(t *testing.T) *harness {
+	h := &harness{t: t, dir: t.TempDir()}
+	if err := applyDefaults(&h.cfg); err != nil {
+		t.Fatal(err)
+	}
+	h.useDump2dcm()
+	h.orthanc = newFakeOrthanc("orthanc", "rahasia")
+	h.ohif = newFakeOHIF()
+	t.Cleanup(h.Close)
+
+	h.cfg.DBDriver = "sqlite"
+	h.cfg.OrthancURL = h.orthanc.URL
+	h.cfg.OrthancUser = h.orthanc.user
+	h.cfg.OrthancPass = h.orthanc.pass
+	h.cfg.OHIFURL = h.ohif.URL
+	h.cfg.WorklistFolder = filepath.Join(h.dir, "worklists")
+	// Scan penuh hanya sekali di awal agar poll berikutnya lewat jalur inkremental
+	h.cfg.WorklistFullScanInterval = time.Hour
+	h.cfg.LogLevel = "DEBUG"
+	h.cfg.WebhookToken = "rahasia-webhook"
+	// Radiografer tidak ada di tag SR uji, dokter radiolog dicari dari nama DICOM
+	h.cfg.KhanzaNipRadiografer = "R001"
+	SetRuntimeConfig(h.cfg)
+	t.Cleanup(func() { SetRuntimeConfig(Config{}) })
+
+	h.khanza = openFakeKhanza(t)
+	h.mwdb = openMemoryStore(t)
+	return h
+}
+
+// Pakai dump2dcm dari DCMTK bila terpasang, selain itu skrip pengganti yang
+// hanya menyalin file dump agar alur tetap bisa diuji
+func (h *harness) useDump2dcm() {
+	if path, err := exec.LookPath("dump2dcm"); err == nil {
+		h.t.Logf("Memakai dump2dcm: %s", path)
+		return
+	}
+	bin := filepath.Join(h.dir, "bin")
+	if err := os.MkdirAll(bin, 0755); err != nil {
+		h.t.Fatal(err)
+	}
+	script := "#!/bin/sh\ncp \"$1\" \"$2\"\n"
+	if err := os.WriteFile(filepath.Join(bin, "dump2dcm"), []byte(script), 0755); err != nil {
+		h.t.Fatal(err)
+	}
+	h.t.Log("dump2dcm tidak ditemukan, memakai pengganti yang menyalin file dump")
+	h.t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func (h *harness) Close() {
+	if h.orthanc != nil {
+		h.orthanc.Close()
+	}
+	if h.ohif != nil {
+		h.ohif.Close()
+	}
+}
+
+func (h *harness) expectState(accession string, want OrderState) error {
+	sent, err := h.mwdb.GetSentWorklists([]string{accession})
+	if err != nil {
+		return err
+	}
+	sw, ok := sent[accession]
+	if !ok {
+		return fmt.Errorf("order %s tidak ada di sent_worklist", accession)
+	}
+	if sw.Status != string(want) {
+		return fmt.Errorf("order %s berstatus %s, seharusnya %s", accession, sw.Status, want)
+	}
+	return nil
+}
+
+func (h *harness) expectKhanzaRows(table, noRawat string) error {
+	var n int
+	if err := h.khanza.QueryRow("SELECT COUNT(*) FROM "+table+" WHERE no_rawat = ?", noRawat).Scan(&n); err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("tidak ada baris %s untuk no_rawat %s", table, noRawat)
+	}
+	return nil
+}
+
+// Isi hasil_radiologi harus teks gabungan semua versi laporan apa adanya,
+// sama dengan hasil_orthanc di sent_worklist
+func (h *harness) khanzaHasil(noRawat, accession string) (string, error) {
+	var hasil string
+	if err := h.khanza.QueryRow("SELECT hasil FROM hasil_radiologi WHERE no_rawat = ?", noRawat).Scan(&hasil); err != nil {
+		return "", err
+	}
+	versions, err := h.mwdb.GetReportVersions(accession)
+	if err != nil {
+		return "", err
+	}
+	if want := CombinedReportText(versions); hasil != want {
+		return "", fmt.Errorf("isi hasil_radiologi %q, seharusnya %q", hasil, want)
+	}
+	sent, err := h.mwdb.GetSentWorklists([]string{accession})
+	if err != nil {
+		return "", err
+	}
+	if sent[accession].HasilOrthanc != hasil {
+		return "", fmt.Errorf("hasil_orthanc %q berbeda dengan hasil_radiologi", sent[accession].HasilOrthanc)
+	}
+	return hasil, nil
+}
+
+func (h *harness) expectReading(accession, status, radiolog string) error {
+	items, err := h.mwdb.GetReadingItems(ReadingFilter{})
+	if err != nil {
+		return err
+	}
+	for _, it := range items {
+		if it.NomorOrder != accession {
+			continue
+		}
+		if it.Status != status || it.Radiolog != radiolog || it.Unit != "RAD" {
+			return fmt.Errorf("item worklist baca %s: status=%s radiolog=%s unit=%s", accession, it.Status, it.Radiolog, it.Unit)
+		}
+		return nil
+	}
+	return fmt.Errorf("order %s tidak ada di worklist baca", accession)
+}
+
+// Kolom tanggal progres permintaan_radiologi harus terisi
+func (h *harness) expectKhanzaProgress(noorder, kolom string) error {
+	var tgl sql.NullString
+	if err := h.khanza.QueryRow("SELECT "+kolom+" FROM permintaan_radiologi WHERE noorder = ?", noorder).Scan(&tgl); err != nil {
+		return err
+	}
+	if !tgl.Valid || tgl.String == "" {
+		return fmt.Errorf("permintaan_radiologi.%s order %s belum diisi", kolom, noorder)
+	}
+	return nil
+}
+
+// Kirim payload seperti Lua script Orthanc ke handler webhook, lalu tunggu
+// antrian selesai diproses
+func (h *harness) postWebhook(payload map[string]interface{}) error {
+	queue := NewWebhookQueue(h.cfg, h.khanza, h.mwdb, 1, 10)
+	mux := http.NewServeMux()
+	RegisterWebhookHandler(mux, queue, h.mwdb)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	body, _ := json.Marshal(payload)
+	req, _ := http.NewRequest(http.MethodPost, srv.URL+"/webhook", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", h.cfg.WebhookToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		queue.Drain()
+		return err
+	}
+	resp.Body.Close()
+	queue.Drain()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("webhook ditolak: %s", resp.Status)
+	}
+	return nil
+}
+
+type harnessStep struct {
+	nama string
+	run  func() error
+}
+
+// Alur lengkap order sampai hasil. Langkah dijalankan berurutan sebagai
+// subtest dan berhenti di langkah pertama yang gagal.
+func TestOrderFlow(t *testing.T) {
+	h := newHarness(t)
+	now := time.Now().Truncate(time.Second)
+	first := fakeOrder{
+		NoOrder: "PR" + now.Format("20060102") + "0001", NoRawat: now.Format("2006/01/02") + "/000001",
+		NoRM: "000101", NamaPasien: "PASIEN UJI SATU", KdJenisPrw: "RAD001", Pemeriksaan: "THORAX PA",
+		Waktu: now.Add(-10 * time.Minute),
+	}
+	second := fakeOrder{
+		NoOrder: "PR" + now.Format("20060102") + "0002", NoRawat: now.Format("2006/01/02") + "/000002",
+		NoRM: "000102", NamaPasien: "PASIEN UJI DUA", KdJenisPrw: "RAD002", Pemeriksaan: "USG ABDOMEN",
+		Waktu: now.Add(-5 * time.Minute),
+	}
+	detector := NewOrderDetector()
+	var accession, accession2 string
+	const laporan = "Cor dan pulmo dalam batas normal."
+
+	steps := []harnessStep{
+		{"migrasi DB middleware", func() error {
+			return h.mwdb.MigrateUp()
+		}},
+		{"probe skema Khanza", func() error {
+			s, err := DetectKhanzaSchema(h.khanza, h.mwdb)
+			if err != nil {
+				return err
+			}
+			if s.Variant != KhanzaVariantStandar || !s.HasilUpsert {
+				return fmt.Errorf("varian %s (upsert hasil=%v), seharusnya %s dengan upsert", s.Variant, s.HasilUpsert, KhanzaVariantStandar)
+			}
+			return nil
+		}},
+		{"seed order Khanza", func() error {
+			return seedFakeOrder(h.khanza, first)
+		}},
+		{"deteksi order (scan penuh)", func() error {
+			worklists, full, err := detector.Poll(h.khanza, now, h.cfg)
+			if err != nil {
+				return err
+			}
+			if !full || len(worklists) != 1 {
+				return fmt.Errorf("scan penuh=%v dengan %d order, seharusnya scan penuh dengan 1 order", full, len(worklists))
+			}
+			wl := worklists[0]
+			if wl.PatientID != first.NoOrder || wl.RequestedProcedureDescription != first.Pemeriksaan || wl.Modality != "CR" {
+				return fmt.Errorf("data worklist tidak sesuai: %+v", wl)
+			}
+			accession = wl.AccessionNumber
+			return nil
+		}},
+		{"kirim worklist", func() error {
+			res := sendWorklists(context.Background(), h.cfg, h.mwdb, detector.Orders())
+			if res.Terkirim != 1 || res.Gagal != 0 {
+				return fmt.Errorf("%d terkirim, %d gagal", res.Terkirim, res.Gagal)
+			}
+			if _, err := os.Stat(filepath.Join(h.cfg.WorklistFolder, accession+".wl")); err != nil {
+				return err
+			}
+			return h.expectState(accession, OrderWorklisted)
+		}},
+		{"deteksi order (inkremental)", func() error {
+			if err := seedFakeOrder(h.khanza, second); err != nil {
+				return err
+			}
+			worklists, full, err := detector.Poll(h.khanza, now.Add(time.Second), h.cfg)
+			if err != nil {
+				return err
+			}
+			if full {
+				return fmt.Errorf("poll kedua seharusnya inkremental")
+			}
+			res := sendWorklists(context.Background(), h.cfg, h.mwdb, worklists)
+			if res.Terkirim != 1 || res.Dilewati != len(worklists)-1 {
+				return fmt.Errorf("%d order: %d terkirim, %d dilewati", len(worklists), res.Terkirim, res.Dilewati)
+			}
+			for _, wl := range worklists {
+				if wl.PatientID == second.NoOrder {
+					accession2 = wl.AccessionNumber
+				}
+			}
+			return nil
+		}},
+		{"aturan penugasan radiolog", func() error {
+			return h.mwdb.SaveReadingRule(ReadingRule{Modality: "CR", Unit: "RAD", Radiolog: "radiolog1,radiolog2"})
+		}},
+		{"gambar diterima Orthanc", func() error {
+			h.orthanc.AddStudy(accession, "1.2.826.0.1.3680043.8.498.1")
+			detectAcquiredStudies(h.cfg, h.khanza, h.mwdb)
+			if err := h.expectState(accession, OrderAcquired); err != nil {
+				return err
+			}
+			return h.expectKhanzaProgress(first.NoOrder, "tgl_sampel")
+		}},
+		{"worklist baca ditugaskan", func() error {
+			return h.expectReading(accession, ReadingUnread, "radiolog1")
+		}},
+		{"webhook SR", func() error {
+			h.orthanc.AddReport("sr-instance-1", SRReport{Teks: laporan, Dokter: "Radiolog^Uji"})
+			return h.postWebhook(map[string]interface{}{
+				"accession":    accession,
+				"patient_id":   first.NoOrder,
+				"orthanc_uuid": "sr-instance-1",
+				"study":        "1.2.826.0.1.3680043.8.498.1",
+				"link":         GenerateOHIFLink(h.cfg, "1.2.826.0.1.3680043.8.498.1"),
+			})
+		}},
+		{"hasil tersimpan di Khanza", func() error {
+			if err := h.expectState(accession, OrderFiled); err != nil {
+				return err
+			}
+			for _, table := range []string{"periksa_radiologi", "hasil_radiologi", "gambar_radiologi"} {
+				if err := h.expectKhanzaRows(table, first.NoRawat); err != nil {
+					return err
+				}
+			}
+			if err := h.expectKhanzaProgress(first.NoOrder, "tgl_hasil"); err != nil {
+				return err
+			}
+			hasil, err := h.khanzaHasil(first.NoRawat, accession)
+			if err != nil {
+				return err
+			}
+			if !strings.Contains(hasil, laporan) {
+				return fmt.Errorf("isi hasil_radiologi tidak sesuai: %s", hasil)
+			}
+			return nil
+		}},
+		{"tagihan periksa_radiologi", func() error {
+			var kdDokter, nip, tgl, jam, status string
+			var bagianRS, biaya float64
+			err := h.khanza.QueryRow("SELECT kd_dokter, nip, tgl_periksa, jam, status, bagian_rs, biaya FROM periksa_radiologi WHERE no_rawat = ?",
+				first.NoRawat).Scan(&kdDokter, &nip, &tgl, &jam, &status, &bagianRS, &biaya)
+			if err != nil {
+				return err
+			}
+			if kdDokter != "D0099" || nip != "R001" || status != "Ralan" || bagianRS != 60000 || biaya != 150000 {
+				return fmt.Errorf("baris tidak sesuai: kd_dokter=%s nip=%s status=%s bagian_rs=%.0f biaya=%.0f", kdDokter, nip, status, bagianRS, biaya)
+			}
+			// hasil_radiologi harus memakai kunci waktu yang sama dengan periksa_radiologi
+			var n int
+			if err := h.khanza.QueryRow("SELECT COUNT(*) FROM hasil_radiologi WHERE no_rawat = ? AND tgl_periksa = ? AND jam = ?",
+				first.NoRawat, tgl, jam).Scan(&n); err != nil {
+				return err
+			}
+			if n != 1 {
+				return fmt.Errorf("hasil_radiologi tidak tersambung ke periksa_radiologi %s %s", tgl, jam)
+			}
+			return nil
+		}},
+		{"replay webhook tanpa tagihan ganda", func() error {
+			if err := h.postWebhook(map[string]interface{}{
+				"accession": accession, "patient_id": first.NoOrder, "orthanc_uuid": "sr-instance-1",
+				"link": GenerateOHIFLink(h.cfg, "1.2.826.0.1.3680043.8.498.1"),
+			}); err != nil {
+				return err
+			}
+			for _, table := range []string{"periksa_radiologi", "hasil_radiologi", "gambar_radiologi"} {
+				var n int
+				if err := h.khanza.QueryRow("SELECT COUNT(*) FROM "+table+" WHERE no_rawat = ?", first.NoRawat).Scan(&n); err != nil {
+					return err
+				}
+				if n != 1 {
+					return fmt.Errorf("%d baris %s setelah replay, seharusnya 1", n, table)
+				}
+			}
+			if err := h.expectState(accession, OrderFiled); err != nil {
+				return err
+			}
+			return h.expectReading(accession, ReadingRead, "radiolog1")
+		}},
+		{"riwayat order lengkap", func() error {
+			events, err := h.mwdb.GetOrderEvents(accession)
+			if err != nil {
+				return err
+			}
+			var got []string
+			for _, e := range events {
+				got = append(got, e.KeStatus)
+			}
+			// Replay webhook mengulang reported -> filed
+			want := []string{"ordered", "worklisted", "acquired", "reported", "filed", "reported", "filed"}
+			if strings.Join(got, ",") != strings.Join(want, ",") {
+				return fmt.Errorf("urutan state %v, seharusnya %v", got, want)
+			}
+			return nil
+		}},
+		{"laporan dari editor portal", func() error {
+			h.orthanc.AddStudy(accession2, "1.2.826.0.1.3680043.8.498.2")
+			detectAcquiredStudies(h.cfg, h.khanza, h.mwdb)
+			if err := h.expectState(accession2, OrderAcquired); err != nil {
+				return err
+			}
+			// Template umum modality dan template khusus pemeriksaan: yang khusus dipakai
+			for _, t := range []ReportTemplate{
+				{Nama: "USG umum", Modality: "US", Temuan: "Tidak tampak kelainan."},
+				{Nama: second.Pemeriksaan, KdJenisPrw: second.KdJenisPrw,
+					Temuan: "Pasien {nama}, {umur}. Hepar dan lien normal.", Kesan: "{pemeriksaan} normal."},
+			} {
+				if err := h.mwdb.SaveReportTemplate(t); err != nil {
+					return err
+				}
+			}
+			templates, err := h.mwdb.GetReportTemplates()
+			if err != nil {
+				return err
+			}
+			sent, err := h.mwdb.GetSentWorklists([]string{accession2})
+			if err != nil {
+				return err
+			}
+			var wl WorklistRequest
+			json.Unmarshal([]byte(sent[accession2].Worklist), &wl)
+			t, ok := DefaultReportTemplate(templates, []string{wl.RequestedProcedureID}, wl.Modality)
+			if !ok || t.KdJenisPrw != second.KdJenisPrw {
+				return fmt.Errorf("template bawaan %q, seharusnya template %s", t.Nama, second.KdJenisPrw)
+			}
+			vars := ReportPlaceholders(wl)
+			temuan, kesan := FillPlaceholders(t.Temuan, vars), FillPlaceholders(t.Kesan, vars)
+			if !strings.Contains(temuan, second.NamaPasien) || strings.Contains(temuan+kesan, "{") || vars["umur"] == "" {
+				return fmt.Errorf("placeholder tidak terisi: %q %q", temuan, kesan)
+			}
+			if err := h.mwdb.SaveReport(Report{NomorOrder: accession2, Temuan: temuan, Kesan: kesan, Status: ReportPreliminary, Penulis: "radiolog1"}); err != nil {
+				return err
+			}
+			radiolog := PortalUser{Username: "radiolog1", Nama: "Radiolog Uji", Role: RoleRadiologist}
+			if err := SignReport(h.khanza, h.mwdb, accession2, radiolog); err != nil {
+				return err
+			}
+			var kdDokter string
+			hasil, err := h.khanzaHasil(second.NoRawat, accession2)
+			if err != nil {
+				return err
+			}
+			if !strings.Contains(hasil, "TEMUAN:") || !strings.Contains(hasil, "KESAN:") || !strings.Contains(hasil, kesan) {
+				return fmt.Errorf("isi hasil_radiologi tidak sesuai: %s", hasil)
+			}
+			if err := h.khanza.QueryRow("SELECT kd_dokter FROM periksa_radiologi WHERE no_rawat = ?", second.NoRawat).Scan(&kdDokter); err != nil {
+				return err
+			}
+			if kdDokter != "D0099" {
+				return fmt.Errorf("kd_dokter %s, seharusnya radiolog penandatangan D0099", kdDokter)
+			}
+			report, err := h.mwdb.GetReport(accession2)
+			if err != nil {
+				return err
+			}
+			if report.Status != ReportFinal || report.SRInstance == "" {
+				return fmt.Errorf("laporan status=%s sr=%s, seharusnya final dengan SR", report.Status, report.SRInstance)
+			}
+			if err := SignReport(h.khanza, h.mwdb, accession2, radiolog); err != ErrReportFinal {
+				return fmt.Errorf("tanda tangan ulang seharusnya ditolak, dapat %v", err)
+			}
+			return h.expectState(accession2, OrderFiled)
+		}},
+		{"addendum laporan", func() error {
+			radiolog := PortalUser{Username: "radiolog2", Nama: "Radiolog Kedua", Role: RoleRadiologist}
+			if err := AddReportAddendum(h.khanza, h.mwdb, accession2, radiolog, "Tambahan.", ""); err == nil {
+				return fmt.Errorf("addendum tanpa alasan seharusnya ditolak")
+			}
+			tambahan := "Tampak kista kecil di ginjal kiri."
+			if err := AddReportAddendum(h.khanza, h.mwdb, accession2, radiolog, tambahan, "koreksi temuan"); err != nil {
+				return err
+			}
+			report, err := h.mwdb.GetReport(accession2)
+			if err != nil {
+				return err
+			}
+			// Replay SR asli tidak boleh menimpa addendum
+			if err := h.postWebhook(map[string]interface{}{
+				"accession": accession2, "patient_id": second.NoOrder, "orthanc_uuid": report.SRInstance,
+				"link": GenerateOHIFLink(h.cfg, "1.2.826.0.1.3680043.8.498.2"),
+			}); err != nil {
+				return err
+			}
+			hasil, err := h.khanzaHasil(second.NoRawat, accession2)
+			if err != nil {
+				return err
+			}
+			if !strings.Contains(hasil, "ADDENDUM 1") || !strings.Contains(hasil, tambahan) || !strings.Contains(hasil, "KESAN:") {
+				return fmt.Errorf("hasil_radiologi tanpa laporan asli dan addendum: %s", hasil)
+			}
+			for acc, want := range map[string]int{accession: 1, accession2: 2} {
+				versions, err := h.mwdb.GetReportVersions(acc)
+				if err != nil {
+					return err
+				}
+				if len(versions) != want {
+					return fmt.Errorf("%d versi laporan %s, seharusnya %d", len(versions), acc, want)
+				}
+			}
+			return h.expectState(accession2, OrderFiled)
+		}},
+		{"laporan PDF", func() error {
+			if _, err := GenerateReportPDF(h.cfg, h.khanza, h.mwdb, "BELUM-ADA"); err == nil {
+				return fmt.Errorf("PDF untuk order tanpa laporan final seharusnya ditolak")
+			}
+			pdf, err := GenerateReportPDF(h.cfg, h.khanza, h.mwdb, accession)
+			if err != nil {
+				return err
+			}
+			if !bytes.HasPrefix(pdf, []byte("%PDF-")) || !bytes.HasSuffix(pdf, []byte("%%EOF\n")) ||
+				!bytes.Contains(pdf, []byte(first.NamaPasien)) || !bytes.Contains(pdf, []byte("/Subtype /Image")) {
+				return fmt.Errorf("PDF laporan tidak lengkap (%d byte)", len(pdf))
+			}
+			// Simpan PDF otomatis saat hasil dikirim ulang ke Khanza
+			cfg := h.cfg
+			cfg.ReportPDFFolder = filepath.Join(h.dir, "laporan")
+			cfg.ReportPDFURL = "http://pacs.rs.local/laporan/"
+			SetRuntimeConfig(cfg)
+			defer SetRuntimeConfig(h.cfg)
+			radiolog := PortalUser{Username: "radiolog2", Nama: "Radiolog Kedua", Role: RoleRadiologist}
+			if err := AddReportAddendum(h.khanza, h.mwdb, accession2, radiolog, "Kesan tambahan: kista simpleks.", "lengkapi kesan"); err != nil {
+				return err
+			}
+			files, err := filepath.Glob(filepath.Join(cfg.ReportPDFFolder, accession2+"-*.pdf"))
+			if err != nil {
+				return err
+			}
+			if len(files) != 1 {
+				return fmt.Errorf("%d file PDF untuk %s, seharusnya 1", len(files), accession2)
+			}
+			// Nama file tidak bisa ditebak dari accession dan tidak terbaca pengguna lain
+			nama := filepath.Base(files[0])
+			if len(nama) != len(accession2+"-.pdf")+64 {
+				return fmt.Errorf("nama file PDF tanpa token acak: %s", nama)
+			}
+			info, err := os.Stat(files[0])
+			if err != nil {
+				return err
+			}
+			if info.Mode().Perm() != 0640 {
+				return fmt.Errorf("mode file PDF %v, seharusnya 0640", info.Mode().Perm())
+			}
+			isi, err := os.ReadFile(files[0])
+			if err != nil {
+				return err
+			}
+			if !bytes.Contains(isi, []byte("ADDENDUM 2")) {
+				return fmt.Errorf("PDF tersimpan tanpa addendum terbaru")
+			}
+			var tgl, jam string
+			if err := h.khanza.QueryRow("SELECT tgl_periksa, jam FROM gambar_radiologi WHERE no_rawat = ? AND lokasi_gambar = ?",
+				second.NoRawat, "http://pacs.rs.local/laporan/"+nama).Scan(&tgl, &jam); err != nil {
+				return fmt.Errorf("link PDF tidak tercatat di gambar_radiologi: %v", err)
+			}
+			// Simpan ulang menimpa file yang sama, link tidak bertambah
+			path, err := PublishReportPDF(cfg, h.khanza, h.mwdb, accession2, tgl, jam)
+			if err != nil {
+				return err
+			}
+			if path != files[0] {
+				return fmt.Errorf("PDF disimpan ulang ke %s, seharusnya %s", path, files[0])
+			}
+			var n int
+			if err := h.khanza.QueryRow("SELECT COUNT(*) FROM gambar_radiologi WHERE no_rawat = ? AND lokasi_gambar LIKE ?",
+				second.NoRawat, "http://pacs.rs.local/laporan/%").Scan(&n); err != nil {
+				return err
+			}
+			if n != 1 {
+				return fmt.Errorf("%d link PDF di gambar_radiologi, seharusnya 1", n)
+			}
+			return nil
+		}},
+		{"temuan kritis", func() error {
+			var mu sync.Mutex
+			var targets []string
+			gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var body struct{ Target string }
+				json.NewDecoder(r.Body).Decode(&body)
+				mu.Lock()
+				targets = append(targets, body.Target)
+				mu.Unlock()
+			}))
+			defer gateway.Close()
+			cfg := h.cfg
+			cfg.CriticalWebhookURL = gateway.URL
+			cfg.CriticalWebhookTargets = "081100000001"
+			cfg.CriticalEscalationWebhookTargets = "081100000009"
+			SetRuntimeConfig(cfg)
+			defer SetRuntimeConfig(h.cfg)
+
+			radiolog := PortalUser{Username: "radiolog2", Nama: "Radiolog Kedua", Role: RoleRadiologist}
+			if err := AddReportAddendum(h.khanza, h.mwdb, accession2, radiolog, "Tidak tampak pneumothorax.", "konfirmasi klinis"); err != nil {
+				return err
+			}
+			if open, err := h.mwdb.GetCriticalFindings(true, 0); err != nil || len(open) != 0 {
+				return fmt.Errorf("kalimat negasi seharusnya tidak kritis: %v %v", open, err)
+			}
+			if err := AddReportAddendum(h.khanza, h.mwdb, accession2, radiolog, "Tampak pneumothorax kanan luas.", "temuan baru"); err != nil {
+				return err
+			}
+			open, err := h.mwdb.GetCriticalFindings(true, 0)
+			if err != nil {
+				return err
+			}
+			if len(open) != 1 || open[0].NomorOrder != accession2 || open[0].Sumber != CriticalSourceKeyword {
+				return fmt.Errorf("temuan kritis terbuka %+v, seharusnya satu dari kata kunci", open)
+			}
+			f := open[0]
+			// Notifikasi dikirim loop latar belakang, bukan saat laporan disimpan
+			if audit, _ := h.mwdb.GetCriticalNotifications(f.ID); len(audit) != 0 {
+				return fmt.Errorf("notifikasi terkirim sinkron saat addendum disimpan")
+			}
+			if n, err := SendPendingCriticalNotices(cfg, h.mwdb); err != nil || n != 1 {
+				return fmt.Errorf("%d notifikasi antri terkirim, seharusnya 1: %v", n, err)
+			}
+			if n, _ := SendPendingCriticalNotices(cfg, h.mwdb); n != 0 {
+				return fmt.Errorf("notifikasi level 1 terkirim ulang")
+			}
+			// Eskalasi hanya setelah batas waktu pengakuan lewat
+			if n, err := EscalateCriticalFindings(cfg, h.mwdb, time.Now()); err != nil || n != 0 {
+				return fmt.Errorf("eskalasi sebelum waktunya: %d %v", n, err)
+			}
+			if n, err := EscalateCriticalFindings(cfg, h.mwdb, time.Now().Add(cfg.CriticalAckTimeout+time.Minute)); err != nil || n != 1 {
+				return fmt.Errorf("eskalasi %d temuan, seharusnya 1: %v", n, err)
+			}
+			if n, err := SendPendingCriticalNotices(cfg, h.mwdb); err != nil || n != 1 {
+				return fmt.Errorf("%d notifikasi eskalasi terkirim, seharusnya 1: %v", n, err)
+			}
+			if err := h.mwdb.AcknowledgeCriticalFinding(f.ID, "dokter.jaga", "dipasang WSD"); err != nil {
+				return err
+			}
+			if err := h.mwdb.AcknowledgeCriticalFinding(f.ID, "dokter.jaga", ""); err != ErrCriticalAcknowledged {
+				return fmt.Errorf("pengakuan ganda seharusnya ditolak, dapat %v", err)
+			}
+			if n, _ := EscalateCriticalFindings(cfg, h.mwdb, time.Now().Add(24*time.Hour)); n != 0 {
+				return fmt.Errorf("temuan yang sudah diakui masih dieskalasi")
+			}
+			mu.Lock()
+			terkirim := strings.Join(targets, ",")
+			mu.Unlock()
+			if terkirim != "081100000001,081100000009" {
+				return fmt.Errorf("webhook terkirim ke %s, seharusnya penerima utama lalu eskalasi", terkirim)
+			}
+			// Banner dan webhook untuk level 1 dan level 2
+			audit, err := h.mwdb.GetCriticalNotifications(f.ID)
+			if err != nil {
+				return err
+			}
+			if len(audit) != 4 {
+				return fmt.Errorf("%d catatan audit notifikasi, seharusnya 4", len(audit))
+			}
+			// Centang kritis di editor portal menjadi item CODE di SR
+			named, _ := json.Marshal(BuildBasicTextSR(Report{Kesan: "Fraktur terbuka.", Kritis: true}, "Radiolog Uji", time.Now()))
+			var m map[string]interface{}
+			json.Unmarshal(named, &m)
+			tags, err := fakeDicomTags(m)
+			if err != nil {
+				return err
+			}
+			if sumber, _, ok := DetectCriticalFinding(cfg, SRReport{Kode: srCodeValues(tags)}); !ok || sumber != CriticalSourceCode {
+				return fmt.Errorf("kode kritis di SR tidak terdeteksi")
+			}
+			return nil
+		}},
+		{"hasil tetap tersimpan saat tagihan gagal", func() error {
+			third := fakeOrder{
+				NoOrder: "PR" + now.Format("20060102") + "0003", NoRawat: now.Format("2006/01/02") + "/000003",
+				NoRM: "000103", NamaPasien: "PASIEN UJI TIGA", KdJenisPrw: "RAD001", Pemeriksaan: "THORAX PA",
+				Waktu: now.Add(-2 * time.Minute),
+			}
+			if err := seedFakeOrder(h.khanza, third); err != nil {
+				return err
+			}
+			// Instalasi default: fallback pelaksana kosong dan nama di SR tidak ada di Khanza
+			cfg := h.cfg
+			cfg.KhanzaKdDokterRadiolog, cfg.KhanzaNipRadiografer = "", ""
+			SetRuntimeConfig(cfg)
+			defer SetRuntimeConfig(h.cfg)
+			tabel, err := fileResultToKhanza(h.khanza, h.mwdb, "CR-TIGA", third.NoOrder, "http://ohif.local/tiga", Pelaksana{Dokter: "Dokter^Luar"}, laporan)
+			if !errors.Is(err, ErrBillingFailed) || tabel != "periksa_radiologi" {
+				return fmt.Errorf("seharusnya hanya tagihan yang gagal, dapat %s: %v", tabel, err)
+			}
+			for _, table := range []string{"hasil_radiologi", "gambar_radiologi"} {
+				if err := h.expectKhanzaRows(table, third.NoRawat); err != nil {
+					return err
+				}
+			}
+			var n int
+			if err := h.khanza.QueryRow("SELECT COUNT(*) FROM periksa_radiologi WHERE no_rawat = ?", third.NoRawat).Scan(&n); err != nil {
+				return err
+			}
+			if n != 0 {
+				return fmt.Errorf("%d baris periksa_radiologi tanpa pelaksana", n)
+			}
+			return nil
+		}},
+		{"export import template laporan", func() error {
+			if err := h.mwdb.SaveReportMacro(ReportMacro{Kode: "normal-thorax", Nama: "Thorax normal", Modality: "CR",
+				Temuan: "Cor dan pulmo dalam batas normal.", Kesan: "Thorax normal."}); err != nil {
+				return err
+			}
+			lib, err := ExportReportLibrary(h.mwdb)
+			if err != nil {
+				return err
+			}
+			data, _ := json.Marshal(lib)
+			nTemplate, nMakro, err := ImportReportLibrary(h.mwdb, bytes.NewReader(data))
+			if err != nil {
+				return err
+			}
+			after, err := ExportReportLibrary(h.mwdb)
+			if err != nil {
+				return err
+			}
+			if nTemplate != 2 || nMakro != 1 || len(after.Template) != 2 || len(after.Makro) != 1 {
+				return fmt.Errorf("import %d template %d makro, sesudahnya %d template %d makro", nTemplate, nMakro, len(after.Template), len(after.Makro))
+			}
+			return nil
+		}},
+		{"skema tanpa unique key hasil_radiologi", func() error {
+			for _, stmt := range []string{
+				"ALTER TABLE hasil_radiologi RENAME TO hasil_radiologi_pk",
+				"CREATE TABLE hasil_radiologi (no_rawat TEXT, tgl_periksa TEXT, jam TEXT, hasil TEXT)",
+			} {
+				if _, err := h.khanza.Exec(stmt); err != nil {
+					return err
+				}
+			}
+			if err := refreshInformationSchema(h.khanza); err != nil {
+				return err
+			}
+			s, err := DetectKhanzaSchema(h.khanza, h.mwdb)
+			if err != nil {
+				return err
+			}
+			if s.HasilUpsert {
+				return fmt.Errorf("unique key hasil_radiologi seharusnya tidak terdeteksi")
+			}
+			// Simpan dua kali: baris kedua harus memperbarui, bukan menambah
+			for _, hasil := range []string{"draf", laporan} {
+				if err := SaveRadiologyResult(h.khanza, first.NoOrder, "2026-01-02", "10:00:00", hasil); err != nil {
+					return err
+				}
+			}
+			var n int
+			if err := h.khanza.QueryRow("SELECT COUNT(*) FROM hasil_radiologi WHERE hasil = ?", laporan).Scan(&n); err != nil {
+				return err
+			}
+			if n != 1 {
+				return fmt.Errorf("%d baris hasil_radiologi, seharusnya 1", n)
+			}
+			return nil
+		}},
+		{"health check", func() error {
+			for _, r := range RunHealthChecks(h.cfg, h.khanza, h.mwdb) {
+				if !r.Up {
+					return fmt.Errorf("%s down: %s", r.Komponen, r.Error)
+				}
+			}
+			return nil
+		}},
+	}
+
+	for _, s := range steps {
+		if !t.Run(s.nama, func(t *testing.T) {
+			if err := s.run(); err != nil {
+				t.Fatal(err)
+			}
+		}) {
+			t.FailNow()
+		}
+	}
+}
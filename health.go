@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Nama komponen yang dicek
+const (
+	HealthKhanzaDB       = "khanza_db"
+	HealthMiddlewareDB   = "middleware_db"
+	HealthOrthanc        = "orthanc"
+	HealthOHIF           = "ohif"
+	HealthWorklistFolder = "worklist_folder"
+)
+
+// Komponen yang wajib sehat agar middleware dianggap siap (/readyz)
+var readinessComponents = []string{HealthKhanzaDB, HealthMiddlewareDB, HealthOrthanc, HealthWorklistFolder}
+
+type HealthResult struct {
+	Komponen  string    `json:"komponen"`
+	Up        bool      `json:"up"`
+	LatencyMs int64     `json:"latency_ms"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+type HealthOutage struct {
+	ID       int
+	Komponen string
+	Mulai    time.Time
+	Selesai  *time.Time
+	Pesan    string
+}
+
+var (
+	healthResults = map[string]HealthResult{}
+	healthMutex   sync.RWMutex
+)
+
+func GetHealthResults() map[string]HealthResult {
+	healthMutex.RLock()
+	defer healthMutex.RUnlock()
+	results := make(map[string]HealthResult, len(healthResults))
+	for k, v := range healthResults {
+		results[k] = v
+	}
+	return results
+}
+
+func timedCheck(komponen string, check func() error) HealthResult {
+	start := time.Now()
+	err := check()
+	res := HealthResult{
+		Komponen:  komponen,
+		Up:        err == nil,
+		LatencyMs: time.Since(start).Milliseconds(),
+		CheckedAt: time.Now(),
+	}
+	if err != nil {
+		res.Error = err.Error()
+	}
+	return res
+}
+
+// Cek Orthanc lewat /system dengan kredensial yang dikonfigurasi
+func checkOrthanc(cfg Config) error {
+	req, err := http.NewRequest("GET", cfg.OrthancURL+"/system", nil)
+	if err != nil {
+		return err
+	}
+	if cfg.OrthancUser != "" {
+		req.SetBasicAuth(cfg.OrthancUser, cfg.OrthancPass)
+	}
+	client := http.Client{Timeout: 3 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return fmt.Errorf("kredensial Orthanc ditolak: %s", resp.Status)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Orthanc error: %s", resp.Status)
+	}
+	return nil
+}
+
+// OHIF cukup merespons tanpa error server
+func checkOHIF(cfg Config) error {
+	client := http.Client{Timeout: 3 * time.Second}
+	resp, err := client.Get(cfg.OHIFURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("OHIF error: %s", resp.Status)
+	}
+	return nil
+}
+
+// Folder worklist harus ada dan bisa ditulisi oleh proses middleware
+func checkWorklistFolder(cfg Config) error {
+	if err := os.MkdirAll(cfg.WorklistFolder, 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(cfg.WorklistFolder, ".healthcheck-*")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	f.Close()
+	return os.Remove(name)
+}
+
+func RunHealthChecks(cfg Config, db *sql.DB, mwdb Store) []HealthResult {
+	return []HealthResult{
+		timedCheck(HealthKhanzaDB, db.Ping),
+		timedCheck(HealthMiddlewareDB, mwdb.Ping),
+		timedCheck(HealthOrthanc, func() error { return checkOrthanc(cfg) }),
+		timedCheck(HealthOHIF, func() error { return checkOHIF(cfg) }),
+		timedCheck(HealthWorklistFolder, func() error { return checkWorklistFolder(cfg) }),
+	}
+}
+
+// Simpan hasil cek terbaru, catat awal/akhir gangguan, dan perbarui status dashboard
+func recordHealthResults(mwdb HealthStore, results []HealthResult) {
+	healthMutex.Lock()
+	previous := healthResults
+	healthResults = make(map[string]HealthResult, len(results))
+	for _, res := range results {
+		healthResults[res.Komponen] = res
+	}
+	healthMutex.Unlock()
+
+	for _, res := range results {
+		prev, known := previous[res.Komponen]
+		wasUp := !known || prev.Up
+		switch {
+		case wasUp && !res.Up:
+			log.Printf("Komponen %s gagal: %s", res.Komponen, res.Error)
+			if err := mwdb.OpenHealthOutage(res.Komponen, res.CheckedAt, res.Error); err != nil {
+				log.Printf("Error insert health_outage: %v", err)
+			}
+		case (!known || !prev.Up) && res.Up:
+			// Saat start juga menutup gangguan yang tercatat sebelum restart
+			if known {
+				log.Printf("Komponen %s kembali normal", res.Komponen)
+			}
+			if err := mwdb.CloseHealthOutage(res.Komponen, res.CheckedAt); err != nil {
+				log.Printf("Error update health_outage: %v", err)
+			}
+		}
+	}
+
+	current := GetHealthResults()
+	UpdateStatus(Status{
+		KhanzaDB:       current[HealthKhanzaDB].Up,
+		MiddlewareDB:   current[HealthMiddlewareDB].Up,
+		Orthanc:        current[HealthOrthanc].Up,
+		OHIF:           current[HealthOHIF].Up,
+		WorklistFolder: current[HealthWorklistFolder].Up,
+	})
+}
+
+// Loop health check berkala. Konfigurasi dibaca ulang setiap putaran agar
+// OHIF_URL dan nilai lain hasil reload SIGHUP ikut dicek.
+func healthCheckLoop(ctx context.Context, db *sql.DB, mwdb Store) {
+	for ctx.Err() == nil {
+		runtime := RuntimeConfig()
+		recordHealthResults(mwdb, RunHealthChecks(runtime, db, mwdb))
+		sleepCtx(ctx, runtime.HealthCheckInterval)
+	}
+}
+
+func (s *sqlStore) OpenHealthOutage(komponen string, mulai time.Time, pesan string) error {
+	_, err := s.db.Exec("INSERT INTO health_outage (komponen, mulai, pesan) VALUES (?, ?, ?)", komponen, mulai, pesan)
+	return err
+}
+
+// Tutup gangguan komponen yang masih terbuka
+func (s *sqlStore) CloseHealthOutage(komponen string, selesai time.Time) error {
+	_, err := s.db.Exec("UPDATE health_outage SET selesai=? WHERE komponen=? AND selesai IS NULL", selesai, komponen)
+	return err
+}
+
+func (s *sqlStore) GetHealthOutages(limit int) ([]HealthOutage, error) {
+	rows, err := s.db.Query("SELECT id, komponen, mulai, selesai, IFNULL(pesan, '') FROM health_outage ORDER BY mulai DESC LIMIT ?", limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var outages []HealthOutage
+	for rows.Next() {
+		var o HealthOutage
+		if err := rows.Scan(&o.ID, &o.Komponen, &o.Mulai, &o.Selesai, &o.Pesan); err == nil {
+			outages = append(outages, o)
+		}
+	}
+	return outages, rows.Err()
+}
+
+func isReady() (bool, map[string]HealthResult) {
+	results := GetHealthResults()
+	for _, k := range readinessComponents {
+		if !results[k].Up {
+			return false, results
+		}
+	}
+	return true, results
+}
+
+var healthTmpl = `
+<!DOCTYPE html>
+<html>
+<head>
+    <title>Kesehatan Komponen</title>
+    <style>
+        body { font-family: Arial; margin: 40px; }
+        table { border-collapse: collapse; margin-bottom: 30px; }
+        th, td { border: 1px solid #ccc; padding: 6px 10px; text-align: left; }
+        th { background: #f0f0f0; }
+        .ok { color: green; font-weight: bold; }
+        .fail { color: red; font-weight: bold; }
+    </style>
+</head>
+<body>
+    <h2>Kesehatan Komponen</h2>
+    <p><a href="/">Dashboard</a></p>
+    <table>
+        <tr><th>Komponen</th><th>Status</th><th>Latensi (ms)</th><th>Dicek</th><th>Error</th></tr>
+        {{range .Results}}
+        <tr><td>{{.Komponen}}</td>
+            <td>{{if .Up}}<span class='ok'>Tersambung</span>{{else}}<span class='fail'>Gagal</span>{{end}}</td>
+            <td>{{.LatencyMs}}</td><td>{{.CheckedAt.Format "15:04:05"}}</td><td>{{.Error}}</td></tr>
+        {{end}}
+    </table>
+    <h3>Riwayat Gangguan</h3>
+    <table>
+        <tr><th>Komponen</th><th>Mulai</th><th>Selesai</th><th>Pesan</th></tr>
+        {{range .Outages}}
+        <tr><td>{{.Komponen}}</td><td>{{.Mulai.Format "2006-01-02 15:04:05"}}</td>
+            <td>{{if .Selesai}}{{.Selesai.Format "2006-01-02 15:04:05"}}{{else}}<span class='fail'>berlangsung</span>{{end}}</td>
+            <td>{{.Pesan}}</td></tr>
+        {{end}}
+    </table>
+</body>
+</html>
+`
+
+func registerHealthHandlers(mux *http.ServeMux, mwdb Store) {
+	// Liveness: proses hidup dan bisa melayani HTTP
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+
+	// Readiness: dependensi utama tersambung
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		ready, results := isReady()
+		w.Header().Set("Content-Type", "application/json")
+		if !ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(struct {
+			Ready    bool                    `json:"ready"`
+			Komponen map[string]HealthResult `json:"komponen"`
+		}{ready, results})
+	})
+
+	mux.HandleFunc("/health", RequirePermission(mwdb, PermView, func(w http.ResponseWriter, r *http.Request) {
+		current := GetHealthResults()
+		var results []HealthResult
+		for _, k := range []string{HealthKhanzaDB, HealthMiddlewareDB, HealthOrthanc, HealthOHIF, HealthWorklistFolder} {
+			if res, ok := current[k]; ok {
+				results = append(results, res)
+			}
+		}
+		outages, err := mwdb.GetHealthOutages(100)
+		if err != nil {
+			log.Printf("Gagal ambil riwayat gangguan: %v", err)
+		}
+		t, _ := template.New("health").Parse(healthTmpl)
+		t.Execute(w, struct {
+			Results []HealthResult
+			Outages []HealthOutage
+		}{results, outages})
+	}))
+}
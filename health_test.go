@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Setelah reload SIGHUP, loop health check mengecek OHIF_URL yang baru
+func TestHealthCheckLoopReload(t *testing.T) {
+	var lama, baru int32
+	ohifLama := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { atomic.AddInt32(&lama, 1) }))
+	defer ohifLama.Close()
+	ohifBaru := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { atomic.AddInt32(&baru, 1) }))
+	defer ohifBaru.Close()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	tulis := func(ohif string) {
+		t.Helper()
+		isi := "khanza_db_host: 127.0.0.1\nkhanza_db_user: uji\nkhanza_db_name: sik\n" +
+			"orthanc_url: http://127.0.0.1:1\nohif_url: " + ohif + "\n" +
+			"middleware_db_driver: sqlite\nfolder_worklist: " + filepath.Join(dir, "worklists") + "\n" +
+			"health_check_interval: 20ms\n"
+		if err := os.WriteFile(path, []byte(isi), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("CONFIG_FILE", path)
+	tulis(ohifLama.URL)
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetRuntimeConfig(cfg)
+	defer SetRuntimeConfig(Config{})
+
+	mwdb := openMemoryStore(t)
+	if err := mwdb.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+	khanza := openFakeKhanza(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	selesai := make(chan struct{})
+	go func() {
+		healthCheckLoop(ctx, khanza, mwdb)
+		close(selesai)
+	}()
+	defer func() {
+		cancel()
+		<-selesai
+	}()
+
+	tunggu := func(hit *int32, nama string) {
+		t.Helper()
+		batas := time.Now().Add(5 * time.Second)
+		for atomic.LoadInt32(hit) == 0 {
+			if time.Now().After(batas) {
+				t.Fatalf("OHIF %s tidak pernah dicek", nama)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	tunggu(&lama, "lama")
+	tulis(ohifBaru.URL)
+	if err := ReloadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	tunggu(&baru, "baru")
+}
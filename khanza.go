@@ -2,6 +2,8 @@ package main
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
 	"log"
 	"time"
 
@@ -27,6 +29,20 @@ type WorklistRequest struct {
 	KdJenisPrw                        string
 }
 
+// Waktu order dibuat di Khanza (tgl_permintaan + jam_permintaan), nil bila
+// tidak terbaca
+func (wl WorklistRequest) WaktuPermintaan() *time.Time {
+	jam := wl.ScheduledProcedureStepStartTime
+	if len(jam) > 6 {
+		jam = jam[:6]
+	}
+	t, err := time.ParseInLocation("20060102150405", wl.ScheduledProcedureStepStartDate+jam, time.Local)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
+
 func ConnectKhanzaDB(cfg Config) (*sql.DB, error) {
 	dsn := cfg.DBKhanzaUser + ":" + cfg.DBKhanzaPassword + "@tcp(" + cfg.DBKhanzaHost + ":" + cfg.DBKhanzaPort + ")/" + cfg.DBKhanzaName
 	db, err := sql.Open("mysql", dsn)
@@ -47,7 +63,24 @@ func ConnectKhanzaDB(cfg Config) (*sql.DB, error) {
 }
 
 func GetPendingWorklist(db *sql.DB, tglPermintaan string) ([]WorklistRequest, error) {
-	query := `SELECT
+	return GetPendingWorklistRange(db, tglPermintaan, tglPermintaan)
+}
+
+// Order radiologi dengan tgl_permintaan di antara dari dan sampai (inklusif)
+func GetPendingWorklistRange(db *sql.DB, dari, sampai string) ([]WorklistRequest, error) {
+	return queryPendingWorklist(db, "pr.tgl_permintaan BETWEEN ? AND ?", dari, sampai)
+}
+
+// Order yang waktu permintaannya sama atau setelah sejak, sampai tanggal akhir
+// jendela. Dipakai detektor inkremental; order di detik yang sama ikut terambil
+// lagi dan disaring oleh cek sent_worklist.
+func GetPendingWorklistSince(db *sql.DB, sejak time.Time, sampai string) ([]WorklistRequest, error) {
+	tgl, jam := sejak.Format("2006-01-02"), sejak.Format("15:04:05")
+	return queryPendingWorklist(db, "(pr.tgl_permintaan > ? OR (pr.tgl_permintaan = ? AND pr.jam_permintaan >= ?)) AND pr.tgl_permintaan <= ?",
+		tgl, tgl, jam, sampai)
+}
+
+const pendingWorklistQuery = `SELECT
 	IFNULL(pr.noorder, '') AS PatientID,
 	IFNULL(p.nm_pasien, '') AS PatientName,
 	IFNULL(DATE_FORMAT(p.tgl_lahir, '%Y%m%d'), '') AS PatientBirthDate,
@@ -141,14 +174,16 @@ JOIN
 	reg_periksa r ON pr.no_rawat = r.no_rawat
 JOIN
 	pasien p ON r.no_rkm_medis = p.no_rkm_medis
+-- Pemeriksaan order ini saja. noorder berawalan PR, bukan no_rawat.
 LEFT JOIN
-	permintaan_pemeriksaan_radiologi pj ON pj.noorder LIKE CONCAT(pr.no_rawat, '%')
+	permintaan_pemeriksaan_radiologi pj ON pj.noorder = pr.noorder
 LEFT JOIN
 	jns_perawatan_radiologi jpr ON pj.kd_jenis_prw = jpr.kd_jenis_prw
 WHERE
-	pr.tgl_permintaan = ?`
+	`
 
-	rows, err := db.Query(query, tglPermintaan)
+func queryPendingWorklist(db *sql.DB, where string, args ...interface{}) ([]WorklistRequest, error) {
+	rows, err := db.Query(pendingWorklistQuery+where, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -178,19 +213,46 @@ WHERE
 		requests = append(requests, req)
 	}
 	for _, v := range requests {
-		v.AccessionNumber = v.Modality + v.AccessionNumber + time.Now().Format("2006010215")
+		// Jam diambil dari waktu permintaan, bukan waktu proses, agar accession
+		// tetap sama saat order diproses ulang di jam atau hari lain
+		jam := time.Now().Format("2006010215")
+		if len(v.ScheduledProcedureStepStartDate) == 8 && len(v.ScheduledProcedureStepStartTime) >= 2 {
+			jam = v.ScheduledProcedureStepStartDate + v.ScheduledProcedureStepStartTime[:2]
+		}
+		v.AccessionNumber = v.Modality + v.AccessionNumber + jam
 		newReq = append(newReq, v)
 	}
 	return newReq, nil
 }
 
-func UpdateWorklistStatus(db *sql.DB, id int, status string) error {
-	_, err := db.Exec("UPDATE permintaan_radiologi SET status=? WHERE id=?", status, id)
-	return err
+var ErrKhanzaColumnMissing = errors.New("kolom tidak tersedia di skema Khanza")
+
+// Isi kolom status permintaan_radiologi (fork) dengan state order middleware
+func UpdateWorklistStatus(db *sql.DB, noorder, status string) error {
+	return execKhanzaProgress(db, CurrentKhanzaSchema().Queries().UpdateStatus, "status", status, noorder)
 }
 
-func SaveStudyLinkToKhanza(db *sql.DB, noRawat string, link string) error {
-	_, err := db.Exec("UPDATE permintaan_radiologi SET link_hasil=? WHERE no_rawat=?", link, noRawat)
+func SaveStudyLinkToKhanza(db *sql.DB, noorder string, link string) error {
+	return execKhanzaProgress(db, CurrentKhanzaSchema().Queries().SimpanLink, "link", link, noorder)
+}
+
+// Gambar sudah diterima di Orthanc: isi tgl/jam sampel bila masih kosong
+func UpdateOrderSampel(db *sql.DB, noorder string, waktu time.Time) error {
+	return execKhanzaProgress(db, CurrentKhanzaSchema().Queries().UpdateSampel, "sampel",
+		waktu.Format("2006-01-02"), waktu.Format("15:04:05"), noorder)
+}
+
+// Hasil sudah tersimpan: isi tgl/jam hasil
+func UpdateOrderHasil(db *sql.DB, noorder string, waktu time.Time) error {
+	return execKhanzaProgress(db, CurrentKhanzaSchema().Queries().UpdateSelesai, "hasil",
+		waktu.Format("2006-01-02"), waktu.Format("15:04:05"), noorder)
+}
+
+func execKhanzaProgress(db *sql.DB, query, kolom string, args ...interface{}) error {
+	if query == "" {
+		return fmt.Errorf("kolom %s permintaan_radiologi: %w", kolom, ErrKhanzaColumnMissing)
+	}
+	_, err := db.Exec(query, args...)
 	return err
 }
 
@@ -201,65 +263,21 @@ func SaveRadiologyResult(db *sql.DB, noorder, tglPeriksa, jam, hasil string) err
 		return err
 	}
 	noRawat := noRawatDB
-	_, err = db.Exec(
-		"INSERT INTO hasil_radiologi (no_rawat, tgl_periksa, jam, hasil) VALUES (?, ?, ?, ?) ON DUPLICATE KEY UPDATE hasil=?, no_rawat=?",
-		noRawat, tglPeriksa, jam, hasil, hasil, noRawat,
-	)
-	return err
-}
-
-func InsertPeriksaRadiologiFromPermintaan(db *sql.DB, noorder, jam, linkGambar string) error {
-	// Ambil data dari relasi tabel yang diperlukan
-	var (
-		noRawat, tglPeriksa, kdDokter, kdJenisPrw, status string
-		biaya                                             float64
-	)
-	query := `
-        SELECT
-            pr.no_rawat,
-            pr.tgl_permintaan,
-            pr.jam_permintaan,
-            pr.dokter_perujuk,
-            pj.kd_jenis_prw,
-            IFNULL(jpr.total_byr, 0) AS biaya,
-            pr.status
-        FROM permintaan_radiologi pr
-        LEFT JOIN permintaan_pemeriksaan_radiologi pj ON pj.noorder = pr.noorder
-        LEFT JOIN jns_perawatan_radiologi jpr ON pj.kd_jenis_prw = jpr.kd_jenis_prw
-        WHERE pr.noorder = ?
-        LIMIT 1
-        `
-	err := db.QueryRow(query, noorder).Scan(
-		&noRawat, &tglPeriksa, &jam, &kdDokter, &kdJenisPrw, &biaya, &status,
-	)
-	if err != nil {
+	q := CurrentKhanzaSchema().Queries()
+	if q.UpdateHasil == "" {
+		_, err = db.Exec(q.SimpanHasil, noRawat, tglPeriksa, jam, hasil, hasil)
 		return err
 	}
-
-	// Cek apakah data sudah ada di periksa_radiologi
+	// Tanpa unique key: cek dulu agar hasil yang sama tidak tersimpan dua kali
 	var count int
-	err = db.QueryRow(
-		`SELECT COUNT(*) FROM periksa_radiologi WHERE no_rawat = ? AND kd_jenis_prw = ? AND tgl_periksa = ? AND jam = ?`,
-		noRawat, kdJenisPrw, tglPeriksa, jam,
-	).Scan(&count)
+	err = db.QueryRow("SELECT COUNT(*) FROM hasil_radiologi WHERE no_rawat=? AND tgl_periksa=? AND jam=?", noRawat, tglPeriksa, jam).Scan(&count)
 	if err != nil {
 		return err
 	}
 	if count > 0 {
-		// Sudah ada, tidak perlu insert lagi
-		return nil
+		_, err = db.Exec(q.UpdateHasil, hasil, noRawat, tglPeriksa, jam)
+		return err
 	}
-
-	_, err = db.Exec(`
-        INSERT INTO periksa_radiologi (
-            no_rawat, tgl_periksa, jam, kd_dokter, kd_jenis_prw, biaya, status
-        ) VALUES (?, ?, ?, ?, ?, ?, ?)
-        `, noRawat, tglPeriksa, jam, kdDokter, kdJenisPrw, biaya, status)
-
-	_, err = db.Exec(`
-        INSERT INTO gambar_radiologi (
-            no_rawat, tgl_periksa, jam, lokasi_gambar
-        ) VALUES (?, ?, ?, ?)
-        `, noRawat, tglPeriksa, jam, linkGambar)
+	_, err = db.Exec(q.SimpanHasil, noRawat, tglPeriksa, jam, hasil)
 	return err
 }
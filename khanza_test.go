@@ -0,0 +1,114 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// Pemeriksaan diambil dari permintaan_pemeriksaan_radiologi dengan noorder
+// yang sama. Dua order dalam satu kunjungan tidak boleh tertukar
+// pemeriksaan dan modality-nya.
+func TestPendingWorklistExamPerOrder(t *testing.T) {
+	db := openFakeKhanza(t)
+	waktu := time.Now().Truncate(time.Hour)
+	thorax := fakeOrder{
+		NoOrder: "PR" + waktu.Format("20060102") + "0001", NoRawat: waktu.Format("2006/01/02") + "/000001",
+		NoRM: "000101", NamaPasien: "PASIEN UJI", KdJenisPrw: "RAD001", Pemeriksaan: "THORAX PA", Waktu: waktu,
+	}
+	if err := seedFakeOrder(db, thorax); err != nil {
+		t.Fatal(err)
+	}
+	// Order kedua di kunjungan yang sama
+	usg := thorax
+	usg.NoOrder, usg.KdJenisPrw, usg.Pemeriksaan = "PR"+waktu.Format("20060102")+"0002", "RAD002", "USG ABDOMEN"
+	for _, stmt := range []struct {
+		query string
+		args  []interface{}
+	}{
+		{`INSERT INTO jns_perawatan_radiologi (kd_jenis_prw, nm_perawatan, bagian_rs, bhp, tarif_perujuk, tarif_tindakan_dokter,
+			tarif_tindakan_petugas, kso, menejemen, total_byr, kd_pj, status, kelas) VALUES (?, ?, 60000, 15000, 5000, 50000, 20000, 0, 0, 150000, '-', '1', '-')`,
+			[]interface{}{usg.KdJenisPrw, usg.Pemeriksaan}},
+		{"INSERT INTO permintaan_radiologi (noorder, no_rawat, tgl_permintaan, jam_permintaan, dokter_perujuk, status) VALUES (?, ?, ?, ?, ?, ?)",
+			[]interface{}{usg.NoOrder, usg.NoRawat, waktu.Format("2006-01-02"), waktu.Format("15:04:05"), "D0001", "ralan"}},
+		{"INSERT INTO permintaan_pemeriksaan_radiologi VALUES (?, ?, ?)", []interface{}{usg.NoOrder, usg.KdJenisPrw, "Belum"}},
+	} {
+		if _, err := db.Exec(stmt.query, stmt.args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reqs, err := GetPendingWorklist(db, waktu.Format("2006-01-02"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]struct{ kd, pemeriksaan, modality string }{
+		thorax.NoOrder: {"RAD001", "THORAX PA", "CR"},
+		usg.NoOrder:    {"RAD002", "USG ABDOMEN", "US"},
+	}
+	if len(reqs) != len(want) {
+		t.Fatalf("%d baris worklist, seharusnya %d: %+v", len(reqs), len(want), reqs)
+	}
+	for _, r := range reqs {
+		w, ok := want[r.PatientID]
+		if !ok {
+			t.Fatalf("order tidak dikenal %s", r.PatientID)
+		}
+		if r.RequestedProcedureID != w.kd || r.RequestedProcedureDescription != w.pemeriksaan || r.Modality != w.modality {
+			t.Errorf("order %s: pemeriksaan %s %q modality %s, seharusnya %s %q %s", r.PatientID,
+				r.RequestedProcedureID, r.RequestedProcedureDescription, r.Modality, w.kd, w.pemeriksaan, w.modality)
+		}
+	}
+}
+
+// Waktu sampel yang sudah diisi petugas tidak ditimpa; NULL dan 0000-00-00
+// dianggap kosong
+func TestUpdateOrderSampel(t *testing.T) {
+	var cfg Config
+	if err := applyDefaults(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	SetRuntimeConfig(cfg)
+	defer SetRuntimeConfig(Config{})
+	db := openFakeKhanza(t)
+	waktu := time.Date(2026, 1, 5, 9, 30, 0, 0, time.Local)
+	tests := []struct {
+		nama     string
+		tgl, jam interface{}
+		want     string
+	}{
+		{"kosong", nil, nil, "2026-01-05 09:30:00"},
+		{"tanggal nol", "0000-00-00", "00:00:00", "2026-01-05 09:30:00"},
+		{"sudah diisi petugas", "2026-01-05", "08:15:00", "2026-01-05 08:15:00"},
+	}
+	for i, tt := range tests {
+		t.Run(tt.nama, func(t *testing.T) {
+			o := fakeOrder{
+				NoOrder: fmt.Sprintf("PR202601050%03d", i), NoRawat: fmt.Sprintf("2026/01/05/%06d", i),
+				NoRM: fmt.Sprintf("%06d", i), NamaPasien: "PASIEN UJI", KdJenisPrw: "RAD001", Pemeriksaan: "THORAX PA", Waktu: waktu,
+			}
+			if err := seedFakeOrder(db, o); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := db.Exec("UPDATE permintaan_radiologi SET tgl_sampel=?, jam_sampel=? WHERE noorder=?", tt.tgl, tt.jam, o.NoOrder); err != nil {
+				t.Fatal(err)
+			}
+			if err := UpdateOrderSampel(db, o.NoOrder, waktu); err != nil {
+				t.Fatal(err)
+			}
+			var tgl, jam sql.NullString
+			if err := db.QueryRow("SELECT tgl_sampel, jam_sampel FROM permintaan_radiologi WHERE noorder=?", o.NoOrder).Scan(&tgl, &jam); err != nil {
+				t.Fatal(err)
+			}
+			if got := tgl.String + " " + jam.String; got != tt.want {
+				t.Fatalf("waktu sampel %q, seharusnya %q", got, tt.want)
+			}
+		})
+	}
+	// KHANZA_KOLOM_STATUS kosong secara bawaan
+	if err := UpdateWorklistStatus(db, "PR202601050000", string(OrderAcquired)); !errors.Is(err, ErrKhanzaColumnMissing) {
+		t.Fatalf("status tanpa kolom seharusnya ErrKhanzaColumnMissing, dapat %v", err)
+	}
+}
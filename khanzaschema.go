@@ -0,0 +1,446 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Skema SIMRS Khanza berbeda antar versi dan fork: sebagian menambah kolom
+// id/link_hasil di permintaan_radiologi, versi lama belum punya kolom tarif
+// rinci di periksa_radiologi, dan tidak semua instalasi punya primary key di
+// hasil_radiologi. Saat start, middleware membaca information_schema lalu
+// memilih query yang cocok dan menolak menulis ke Khanza bila kolom wajib
+// tidak ada.
+
+var ErrKhanzaSchemaIncompatible = errors.New("skema Khanza tidak kompatibel, lihat halaman /khanza")
+
+type khanzaTable struct {
+	Nama  string
+	Wajib []string
+}
+
+// Kolom yang dipakai query worklist dan penyimpanan hasil
+var khanzaRequiredColumns = []khanzaTable{
+	{"permintaan_radiologi", []string{"noorder", "no_rawat", "tgl_permintaan", "jam_permintaan", "dokter_perujuk", "status"}},
+	{"reg_periksa", []string{"no_rawat", "no_rkm_medis"}},
+	{"pasien", []string{"no_rkm_medis", "nm_pasien", "tgl_lahir", "jk"}},
+	{"permintaan_pemeriksaan_radiologi", []string{"noorder", "kd_jenis_prw"}},
+	{"jns_perawatan_radiologi", []string{"kd_jenis_prw", "nm_perawatan", "total_byr"}},
+	{"periksa_radiologi", []string{"no_rawat", "tgl_periksa", "jam", "kd_dokter", "kd_jenis_prw", "biaya", "status"}},
+	{"hasil_radiologi", []string{"no_rawat", "tgl_periksa", "jam", "hasil"}},
+	{"gambar_radiologi", []string{"no_rawat", "tgl_periksa", "jam", "lokasi_gambar"}},
+}
+
+// Kolom tarif rinci periksa_radiologi di Khanza versi baru
+var khanzaTarifColumns = []string{"bagian_rs", "bhp", "tarif_perujuk", "tarif_tindakan_dokter", "tarif_tindakan_petugas", "kso", "menejemen"}
+
+// Nama kolom dari konfigurasi disisipkan langsung ke query, jadi dibatasi
+// ke identifier SQL biasa
+var sqlIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Pemetaan kolom progres di permintaan_radiologi. Kolom kosong berarti tidak diisi.
+type KhanzaColumnMap struct {
+	TglSampel, JamSampel string
+	TglHasil, JamHasil   string
+	Status               string
+	Link                 string
+}
+
+func (cfg Config) KhanzaColumns() KhanzaColumnMap {
+	return KhanzaColumnMap{
+		TglSampel: cfg.KhanzaKolomTglSampel, JamSampel: cfg.KhanzaKolomJamSampel,
+		TglHasil: cfg.KhanzaKolomTglHasil, JamHasil: cfg.KhanzaKolomJamHasil,
+		Status: cfg.KhanzaKolomStatus, Link: cfg.KhanzaKolomLink,
+	}
+}
+
+type khanzaColumnField struct{ env, kolom string }
+
+func (m KhanzaColumnMap) fields() []khanzaColumnField {
+	return []khanzaColumnField{
+		{"KHANZA_KOLOM_TGL_SAMPEL", m.TglSampel}, {"KHANZA_KOLOM_JAM_SAMPEL", m.JamSampel},
+		{"KHANZA_KOLOM_TGL_HASIL", m.TglHasil}, {"KHANZA_KOLOM_JAM_HASIL", m.JamHasil},
+		{"KHANZA_KOLOM_STATUS", m.Status}, {"KHANZA_KOLOM_LINK", m.Link},
+	}
+}
+
+const (
+	KhanzaVariantStandar    = "standar"
+	KhanzaVariantModifikasi = "modifikasi"
+	KhanzaVariantLama       = "lama"
+	KhanzaVariantTidakCocok = "tidak kompatibel"
+)
+
+type KhanzaSchema struct {
+	Variant   string
+	DicekPada time.Time
+	Kolom     map[string]map[string]bool
+	// Kolom wajib yang tidak ditemukan, format tabel.kolom
+	Hilang []string
+	// Fitur opsional yang tidak tersedia di instalasi ini
+	Peringatan []string
+
+	// Kolom progres hasil pemetaan konfigurasi yang benar-benar ada
+	kolom KhanzaColumnMap
+	// permintaan_radiologi punya tgl/jam sampel dan hasil
+	Progress bool
+	// Kolom status dan link di permintaan_radiologi (fork)
+	StatusKolom bool
+	LinkHasil   bool
+	// permintaan_radiologi.id (fork)
+	OrderID bool
+	// periksa_radiologi punya kolom tarif rinci
+	TarifLengkap bool
+	// hasil_radiologi punya unique key di (no_rawat, tgl_periksa, jam) sehingga
+	// ON DUPLICATE KEY bisa dipakai
+	HasilUpsert bool
+}
+
+func (s *KhanzaSchema) Compatible() bool {
+	return len(s.Hilang) == 0
+}
+
+func (s *KhanzaSchema) Has(table, column string) bool {
+	return s.Kolom[table][column]
+}
+
+func (s *KhanzaSchema) hasAll(table string, columns []string) bool {
+	for _, c := range columns {
+		if !s.Has(table, c) {
+			return false
+		}
+	}
+	return true
+}
+
+func khanzaTableNames() []interface{} {
+	names := make([]interface{}, len(khanzaRequiredColumns))
+	for i, t := range khanzaRequiredColumns {
+		names[i] = t.Nama
+	}
+	return names
+}
+
+// Baca kolom dan unique key tabel Khanza dari information_schema
+func ProbeKhanzaSchema(db *sql.DB) (*KhanzaSchema, error) {
+	tables := khanzaTableNames()
+	in := strings.TrimSuffix(strings.Repeat("?,", len(tables)), ",")
+	s := &KhanzaSchema{DicekPada: time.Now(), Kolom: map[string]map[string]bool{}}
+
+	rows, err := db.Query("SELECT table_name, column_name FROM information_schema.columns WHERE table_schema = DATABASE() AND table_name IN ("+in+")", tables...)
+	if err != nil {
+		return nil, fmt.Errorf("gagal membaca information_schema.columns: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var table, column string
+		if err := rows.Scan(&table, &column); err != nil {
+			return nil, err
+		}
+		table, column = strings.ToLower(table), strings.ToLower(column)
+		if s.Kolom[table] == nil {
+			s.Kolom[table] = map[string]bool{}
+		}
+		s.Kolom[table][column] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Kolom tiap unique index hasil_radiologi
+	rows, err = db.Query(`SELECT index_name, column_name FROM information_schema.statistics
+		WHERE table_schema = DATABASE() AND table_name = 'hasil_radiologi' AND non_unique = 0
+		ORDER BY index_name, seq_in_index`)
+	if err != nil {
+		return nil, fmt.Errorf("gagal membaca information_schema.statistics: %v", err)
+	}
+	defer rows.Close()
+	uniqueKeys := map[string][]string{}
+	for rows.Next() {
+		var index, column string
+		if err := rows.Scan(&index, &column); err != nil {
+			return nil, err
+		}
+		uniqueKeys[index] = append(uniqueKeys[index], strings.ToLower(column))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	s.analyze(uniqueKeys, RuntimeConfig().KhanzaColumns())
+	return s, nil
+}
+
+func (s *KhanzaSchema) analyze(uniqueKeys map[string][]string, kolom KhanzaColumnMap) {
+	for _, t := range khanzaRequiredColumns {
+		for _, c := range t.Wajib {
+			if !s.Has(t.Nama, c) {
+				s.Hilang = append(s.Hilang, t.Nama+"."+c)
+			}
+		}
+	}
+
+	// Kolom yang dipetakan tapi tidak ada dianggap tidak dipetakan
+	pasangan := func(tgl, jam string) (string, string) {
+		if tgl == "" || jam == "" || !s.hasAll("permintaan_radiologi", []string{tgl, jam}) {
+			return "", ""
+		}
+		return tgl, jam
+	}
+	tunggal := func(c string) string {
+		if c == "" || !s.Has("permintaan_radiologi", c) {
+			return ""
+		}
+		return c
+	}
+	s.kolom.TglSampel, s.kolom.JamSampel = pasangan(kolom.TglSampel, kolom.JamSampel)
+	s.kolom.TglHasil, s.kolom.JamHasil = pasangan(kolom.TglHasil, kolom.JamHasil)
+	s.kolom.Status = tunggal(kolom.Status)
+	s.kolom.Link = tunggal(kolom.Link)
+	s.Progress = s.kolom.TglSampel != "" && s.kolom.TglHasil != ""
+	s.StatusKolom = s.kolom.Status != ""
+	s.LinkHasil = s.kolom.Link != ""
+	s.OrderID = s.Has("permintaan_radiologi", "id")
+	s.TarifLengkap = s.hasAll("periksa_radiologi", khanzaTarifColumns)
+	// Unique key hanya berguna untuk upsert bila seluruh kolomnya ikut diisi
+	// saat menyimpan hasil
+	for _, cols := range uniqueKeys {
+		covered := true
+		for _, c := range cols {
+			if c != "no_rawat" && c != "tgl_periksa" && c != "jam" {
+				covered = false
+			}
+		}
+		if covered {
+			s.HasilUpsert = true
+		}
+	}
+
+	if s.kolom.TglSampel == "" {
+		s.Peringatan = append(s.Peringatan, fmt.Sprintf("kolom sampel permintaan_radiologi (%s/%s) tidak ada: waktu gambar diterima tidak tampil di Khanza", kolom.TglSampel, kolom.JamSampel))
+	}
+	if s.kolom.TglHasil == "" {
+		s.Peringatan = append(s.Peringatan, fmt.Sprintf("kolom hasil permintaan_radiologi (%s/%s) tidak ada: waktu hasil tersimpan tidak tampil di Khanza", kolom.TglHasil, kolom.JamHasil))
+	}
+	if kolom.Status != "" && !s.StatusKolom {
+		s.Peringatan = append(s.Peringatan, "permintaan_radiologi tanpa kolom "+kolom.Status+": status order tidak diperbarui")
+	}
+	if !s.TarifLengkap {
+		s.Peringatan = append(s.Peringatan, "periksa_radiologi tanpa kolom tarif rinci: hanya biaya total yang diisi")
+	}
+	if !s.HasilUpsert {
+		s.Peringatan = append(s.Peringatan, "hasil_radiologi tanpa unique key (no_rawat, tgl_periksa, jam): hasil disimpan dengan cek manual sebelum insert")
+	}
+	if kolom.Link != "" && !s.LinkHasil {
+		s.Peringatan = append(s.Peringatan, "permintaan_radiologi tanpa kolom "+kolom.Link+": link OHIF hanya disimpan di gambar_radiologi")
+	}
+	sort.Strings(s.Peringatan)
+
+	switch {
+	case !s.Compatible():
+		s.Variant = KhanzaVariantTidakCocok
+	case s.OrderID || s.Has("permintaan_radiologi", "link_hasil"):
+		s.Variant = KhanzaVariantModifikasi
+	case !s.Progress || !s.TarifLengkap:
+		s.Variant = KhanzaVariantLama
+	default:
+		s.Variant = KhanzaVariantStandar
+	}
+}
+
+// Query penyimpanan yang dipilih sesuai skema
+type KhanzaQueries struct {
+	// Upsert bila ada unique key, selain itu INSERT setelah UpdateHasil tidak
+	// menemukan baris
+	SimpanHasil string
+	UpdateHasil string
+	// Kosong bila kolomnya tidak ada di instalasi ini atau tidak dipetakan
+	SimpanLink    string
+	UpdateStatus  string
+	UpdateSampel  string
+	UpdateSelesai string
+}
+
+func (s *KhanzaSchema) Queries() KhanzaQueries {
+	q := KhanzaQueries{
+		SimpanHasil: "INSERT INTO hasil_radiologi (no_rawat, tgl_periksa, jam, hasil) VALUES (?, ?, ?, ?)",
+		UpdateHasil: "UPDATE hasil_radiologi SET hasil=? WHERE no_rawat=? AND tgl_periksa=? AND jam=?",
+	}
+	if s.HasilUpsert {
+		q.SimpanHasil += " ON DUPLICATE KEY UPDATE hasil=?"
+		q.UpdateHasil = ""
+	}
+	k := s.kolom
+	if k.Link != "" {
+		q.SimpanLink = "UPDATE permintaan_radiologi SET " + k.Link + "=? WHERE noorder=?"
+	}
+	if k.Status != "" {
+		q.UpdateStatus = "UPDATE permintaan_radiologi SET " + k.Status + "=? WHERE noorder=?"
+	}
+	// Waktu sampel yang sudah diisi petugas di Khanza tidak ditimpa
+	if k.TglSampel != "" {
+		q.UpdateSampel = "UPDATE permintaan_radiologi SET " + k.TglSampel + "=?, " + k.JamSampel + "=? WHERE noorder=? AND (" +
+			k.TglSampel + " IS NULL OR " + k.TglSampel + "='0000-00-00')"
+	}
+	if k.TglHasil != "" {
+		q.UpdateSelesai = "UPDATE permintaan_radiologi SET " + k.TglHasil + "=?, " + k.JamHasil + "=? WHERE noorder=?"
+	}
+	return q
+}
+
+var (
+	khanzaSchemaMutex sync.RWMutex
+	khanzaSchema      *KhanzaSchema
+)
+
+// Skema hasil probe terakhir. Sebelum probe dijalankan dianggap Khanza standar.
+func CurrentKhanzaSchema() *KhanzaSchema {
+	khanzaSchemaMutex.RLock()
+	defer khanzaSchemaMutex.RUnlock()
+	if khanzaSchema == nil {
+		return standardKhanzaSchema()
+	}
+	return khanzaSchema
+}
+
+func SetKhanzaSchema(s *KhanzaSchema) {
+	khanzaSchemaMutex.Lock()
+	khanzaSchema = s
+	khanzaSchemaMutex.Unlock()
+}
+
+func standardKhanzaSchema() *KhanzaSchema {
+	s := &KhanzaSchema{Kolom: map[string]map[string]bool{}}
+	for _, t := range khanzaRequiredColumns {
+		s.Kolom[t.Nama] = map[string]bool{}
+		for _, c := range t.Wajib {
+			s.Kolom[t.Nama][c] = true
+		}
+	}
+	for _, c := range []string{"tgl_sampel", "jam_sampel", "tgl_hasil", "jam_hasil"} {
+		s.Kolom["permintaan_radiologi"][c] = true
+	}
+	for _, c := range khanzaTarifColumns {
+		s.Kolom["periksa_radiologi"][c] = true
+	}
+	s.analyze(map[string][]string{"PRIMARY": {"no_rawat", "tgl_periksa", "jam"}}, RuntimeConfig().KhanzaColumns())
+	return s
+}
+
+// Probe skema saat start dan catat masalahnya ke log portal
+func DetectKhanzaSchema(db *sql.DB, mwdb Store) (*KhanzaSchema, error) {
+	logger := NewLogger(mwdb, CompWorklist)
+	s, err := ProbeKhanzaSchema(db)
+	if err != nil {
+		logger.Error("Gagal membaca skema Khanza", LogFields{Err: err})
+		return nil, err
+	}
+	SetKhanzaSchema(s)
+	if !s.Compatible() {
+		logger.Error("Skema Khanza tidak kompatibel, penyimpanan hasil ke Khanza dinonaktifkan. Kolom hilang: "+strings.Join(s.Hilang, ", "), LogFields{})
+		return s, nil
+	}
+	logger.Info("Skema Khanza terdeteksi: varian "+s.Variant, LogFields{})
+	for _, p := range s.Peringatan {
+		logger.Warn("Skema Khanza: "+p, LogFields{})
+	}
+	return s, nil
+}
+
+var khanzaSchemaTmpl = `
+<!DOCTYPE html>
+<html>
+<head>
+    <title>Skema Khanza</title>
+    <style>
+        body { font-family: Arial; margin: 40px; }
+        table { border-collapse: collapse; margin-bottom: 20px; }
+        th, td { border: 1px solid #ccc; padding: 6px 10px; text-align: left; }
+        th { background: #f0f0f0; }
+        .ok { color: green; font-weight: bold; }
+        .fail { color: red; font-weight: bold; }
+    </style>
+</head>
+<body>
+    <h2>Kompatibilitas Skema Khanza</h2>
+    <p><a href="/">Dashboard</a></p>
+    {{if .Error}}<p class="fail">{{.Error}}</p>{{end}}
+    {{with .Schema}}
+    <p>Varian: {{if .Compatible}}<span class="ok">{{.Variant}}</span>{{else}}<span class="fail">{{.Variant}}</span>{{end}},
+       dicek {{.DicekPada.Format "2006-01-02 15:04:05"}}</p>
+    {{if .Hilang}}
+    <h3>Kolom Wajib Tidak Ditemukan</h3>
+    <p class="fail">Penyimpanan hasil ke Khanza dinonaktifkan sampai kolom berikut tersedia.</p>
+    <ul>{{range .Hilang}}<li>{{.}}</li>{{end}}</ul>
+    {{end}}
+    <h3>Fitur</h3>
+    <table>
+        <tr><th>Fitur</th><th>Tersedia</th></tr>
+        <tr><td>Progres sampel/hasil di permintaan_radiologi</td><td>{{if .Progress}}ya{{else}}-{{end}}</td></tr>
+        <tr><td>Status order di permintaan_radiologi</td><td>{{if .StatusKolom}}ya{{else}}-{{end}}</td></tr>
+        <tr><td>Tarif rinci periksa_radiologi</td><td>{{if .TarifLengkap}}ya{{else}}-{{end}}</td></tr>
+        <tr><td>Upsert hasil_radiologi</td><td>{{if .HasilUpsert}}ya{{else}}-{{end}}</td></tr>
+        <tr><td>Link OHIF di permintaan_radiologi</td><td>{{if .LinkHasil}}ya{{else}}-{{end}}</td></tr>
+        <tr><td>permintaan_radiologi.id</td><td>{{if .OrderID}}ya{{else}}-{{end}}</td></tr>
+    </table>
+    {{if .Peringatan}}
+    <h3>Peringatan</h3>
+    <ul>{{range .Peringatan}}<li>{{.}}</li>{{end}}</ul>
+    {{end}}
+    {{end}}
+    <form method="post" action="/khanza/probe">
+        <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+        <button type="submit">Cek Ulang Skema</button>
+    </form>
+</body>
+</html>
+`
+
+func registerKhanzaSchemaHandlers(mux *http.ServeMux, db *sql.DB, mwdb Store) {
+	mux.HandleFunc("/khanza", RequirePermission(mwdb, PermView, func(w http.ResponseWriter, r *http.Request) {
+		khanzaSchemaMutex.RLock()
+		s := khanzaSchema
+		khanzaSchemaMutex.RUnlock()
+		pesan := r.URL.Query().Get("error")
+		if s == nil && pesan == "" {
+			pesan = "Skema Khanza belum pernah dicek"
+		}
+		t, _ := template.New("khanza").Parse(khanzaSchemaTmpl)
+		t.Execute(w, struct {
+			Schema    *KhanzaSchema
+			Error     string
+			CSRFToken string
+		}{s, pesan, CurrentSession(r).CSRFToken})
+	}))
+
+	mux.HandleFunc("/khanza/probe", RequirePermission(mwdb, PermConfig, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if _, err := DetectKhanzaSchema(db, mwdb); err != nil {
+			http.Redirect(w, r, "/khanza?error="+url.QueryEscape(err.Error()), http.StatusSeeOther)
+			return
+		}
+		http.Redirect(w, r, "/khanza", http.StatusSeeOther)
+	}))
+}
+
+func printKhanzaSchema(s *KhanzaSchema) {
+	fmt.Printf("Varian skema Khanza: %s\n", s.Variant)
+	for _, h := range s.Hilang {
+		fmt.Printf("  kolom wajib hilang: %s\n", h)
+	}
+	for _, p := range s.Peringatan {
+		fmt.Printf("  peringatan: %s\n", p)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Pemetaan kolom progres bawaan konfigurasi
+var defaultKhanzaColumns = KhanzaColumnMap{
+	TglSampel: "tgl_sampel", JamSampel: "jam_sampel",
+	TglHasil: "tgl_hasil", JamHasil: "jam_hasil",
+	Link: "link_hasil",
+}
+
+// Kolom Khanza standar terbaru, diubah per kasus lewat ubah
+func khanzaColumnsWith(ubah func(k map[string]map[string]bool)) map[string]map[string]bool {
+	k := map[string]map[string]bool{}
+	for table, cols := range standardKhanzaSchema().Kolom {
+		k[table] = map[string]bool{}
+		for c := range cols {
+			k[table][c] = true
+		}
+	}
+	if ubah != nil {
+		ubah(k)
+	}
+	return k
+}
+
+func TestKhanzaSchemaVariant(t *testing.T) {
+	pkHasil := map[string][]string{"PRIMARY": {"no_rawat", "tgl_periksa", "jam"}}
+	tests := []struct {
+		nama       string
+		ubah       func(k map[string]map[string]bool)
+		uniqueKeys map[string][]string
+		variant    string
+		cek        func(s *KhanzaSchema) bool
+	}{
+		{"standar", nil, pkHasil, KhanzaVariantStandar, func(s *KhanzaSchema) bool {
+			return s.Progress && s.TarifLengkap && s.HasilUpsert && !s.OrderID && !s.LinkHasil
+		}},
+		{"fork dengan permintaan_radiologi.id", func(k map[string]map[string]bool) {
+			k["permintaan_radiologi"]["id"] = true
+		}, pkHasil, KhanzaVariantModifikasi, func(s *KhanzaSchema) bool { return s.OrderID }},
+		{"fork dengan link_hasil", func(k map[string]map[string]bool) {
+			k["permintaan_radiologi"]["link_hasil"] = true
+		}, pkHasil, KhanzaVariantModifikasi, func(s *KhanzaSchema) bool { return s.LinkHasil && !s.OrderID }},
+		{"lama tanpa tarif rinci", func(k map[string]map[string]bool) {
+			delete(k["periksa_radiologi"], "kso")
+		}, pkHasil, KhanzaVariantLama, func(s *KhanzaSchema) bool { return !s.TarifLengkap && s.Progress }},
+		{"lama tanpa kolom sampel", func(k map[string]map[string]bool) {
+			delete(k["permintaan_radiologi"], "jam_sampel")
+		}, pkHasil, KhanzaVariantLama, func(s *KhanzaSchema) bool { return !s.Progress && s.TarifLengkap }},
+		{"hasil_radiologi tanpa unique key", nil, nil, KhanzaVariantStandar, func(s *KhanzaSchema) bool { return !s.HasilUpsert }},
+		{"unique key hasil_radiologi memuat kolom lain", nil, map[string][]string{"uk": {"no_rawat", "tgl_periksa", "jam", "id"}},
+			KhanzaVariantStandar, func(s *KhanzaSchema) bool { return !s.HasilUpsert }},
+		{"kolom wajib hilang", func(k map[string]map[string]bool) {
+			delete(k["hasil_radiologi"], "hasil")
+			k["permintaan_radiologi"]["id"] = true
+		}, pkHasil, KhanzaVariantTidakCocok, func(s *KhanzaSchema) bool {
+			return !s.Compatible() && reflect.DeepEqual(s.Hilang, []string{"hasil_radiologi.hasil"})
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.nama, func(t *testing.T) {
+			s := &KhanzaSchema{Kolom: khanzaColumnsWith(tt.ubah)}
+			s.analyze(tt.uniqueKeys, defaultKhanzaColumns)
+			if s.Variant != tt.variant {
+				t.Fatalf("varian %q, seharusnya %q", s.Variant, tt.variant)
+			}
+			if !tt.cek(s) {
+				t.Fatalf("fitur skema tidak sesuai: %+v", s)
+			}
+		})
+	}
+}
+
+func TestKhanzaSchemaQueries(t *testing.T) {
+	noKey := &KhanzaSchema{Kolom: khanzaColumnsWith(nil)}
+	noKey.analyze(nil, defaultKhanzaColumns)
+	if q := noKey.Queries(); strings.Contains(q.SimpanHasil, "ON DUPLICATE") || q.UpdateHasil == "" {
+		t.Fatalf("tanpa unique key seharusnya UPDATE lalu INSERT biasa: %+v", q)
+	}
+	withKey := &KhanzaSchema{Kolom: khanzaColumnsWith(nil)}
+	withKey.analyze(map[string][]string{"PRIMARY": {"no_rawat", "tgl_periksa", "jam"}}, defaultKhanzaColumns)
+	if q := withKey.Queries(); !strings.HasSuffix(q.SimpanHasil, "ON DUPLICATE KEY UPDATE hasil=?") || q.UpdateHasil != "" {
+		t.Fatalf("dengan unique key seharusnya upsert: %+v", q)
+	}
+}
+
+// Probe information_schema Khanza palsu, sebelum dan sesudah kolom fork ditambah
+func TestProbeKhanzaSchema(t *testing.T) {
+	var cfg Config
+	if err := applyDefaults(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	SetRuntimeConfig(cfg)
+	defer SetRuntimeConfig(Config{})
+	db := openFakeKhanza(t)
+
+	s, err := ProbeKhanzaSchema(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Variant != KhanzaVariantStandar || !s.HasilUpsert || s.LinkHasil {
+		t.Fatalf("Khanza palsu seharusnya standar: %+v", s)
+	}
+	for _, stmt := range []string{
+		"ALTER TABLE permintaan_radiologi ADD COLUMN id INTEGER",
+		"ALTER TABLE permintaan_radiologi ADD COLUMN link_hasil TEXT",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := refreshInformationSchema(db); err != nil {
+		t.Fatal(err)
+	}
+	if s, err = ProbeKhanzaSchema(db); err != nil {
+		t.Fatal(err)
+	}
+	if s.Variant != KhanzaVariantModifikasi || !s.OrderID || !s.LinkHasil {
+		t.Fatalf("Khanza dengan kolom fork seharusnya modifikasi: %+v", s)
+	}
+	if q := s.Queries(); q.SimpanLink != "UPDATE permintaan_radiologi SET link_hasil=? WHERE noorder=?" {
+		t.Fatalf("query link %q", q.SimpanLink)
+	}
+}
+
+// Kolom progres yang dipetakan tapi tidak ada di Khanza dilewati tanpa error
+func TestKhanzaProgressQueries(t *testing.T) {
+	tests := []struct {
+		nama                          string
+		kolom                         KhanzaColumnMap
+		sampel, selesai, status, link bool
+	}{
+		{"pemetaan bawaan", defaultKhanzaColumns, true, true, false, false},
+		{"kolom status fork", KhanzaColumnMap{Status: "status"}, false, false, true, false},
+		{"kolom tidak ada", KhanzaColumnMap{TglSampel: "tgl_ambil", JamSampel: "jam_ambil", Status: "stts", Link: "url"}, false, false, false, false},
+		{"pasangan tidak lengkap", KhanzaColumnMap{TglHasil: "tgl_hasil"}, false, false, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.nama, func(t *testing.T) {
+			s := &KhanzaSchema{Kolom: khanzaColumnsWith(nil)}
+			s.analyze(nil, tt.kolom)
+			q := s.Queries()
+			got := [4]bool{q.UpdateSampel != "", q.UpdateSelesai != "", q.UpdateStatus != "", q.SimpanLink != ""}
+			if want := [4]bool{tt.sampel, tt.selesai, tt.status, tt.link}; got != want {
+				t.Fatalf("query progres %v, seharusnya %v: %+v", got, want, q)
+			}
+		})
+	}
+}
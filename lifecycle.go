@@ -0,0 +1,295 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// State order radiologi di middleware. Setiap perpindahan state dicatat di
+// kolom status sent_worklist dan di tabel order_event beserta waktunya.
+type OrderState string
+
+const (
+	OrderOrdered    OrderState = "ordered"
+	OrderWorklisted OrderState = "worklisted"
+	OrderAcquired   OrderState = "acquired"
+	OrderReported   OrderState = "reported"
+	OrderFiled      OrderState = "filed"
+	OrderCancelled  OrderState = "cancelled"
+	OrderError      OrderState = "error"
+)
+
+// Urutan state untuk tampilan portal
+var OrderStates = []OrderState{OrderOrdered, OrderWorklisted, OrderAcquired, OrderReported, OrderFiled, OrderCancelled, OrderError}
+
+// Transisi yang diizinkan dari setiap state
+var orderTransitions = map[OrderState][]OrderState{
+	OrderOrdered:    {OrderWorklisted, OrderCancelled, OrderError},
+	OrderWorklisted: {OrderWorklisted, OrderAcquired, OrderReported, OrderCancelled, OrderError},
+	OrderAcquired:   {OrderReported, OrderCancelled, OrderError},
+	OrderReported:   {OrderFiled, OrderError},
+	OrderFiled:      {OrderReported},
+	OrderError:      {OrderWorklisted, OrderAcquired, OrderReported, OrderFiled, OrderCancelled},
+	OrderCancelled:  {},
+}
+
+// Kolom timestamp sent_worklist yang diisi saat masuk ke state tertentu
+var orderStateColumns = map[OrderState]string{
+	OrderOrdered:    "tgl_masuk_worklist",
+	OrderWorklisted: "tgl_kirim_worklist",
+	OrderAcquired:   "tgl_gambar_diterima",
+	OrderReported:   "tgl_terima_hasil",
+	OrderFiled:      "tgl_simpan_hasil",
+}
+
+type OrderEvent struct {
+	ID         int
+	NomorOrder string
+	DariStatus string
+	KeStatus   string
+	Waktu      time.Time
+	Keterangan string
+}
+
+type TimelineEntry struct {
+	Waktu  time.Time
+	Sumber string
+	Status string
+	Pesan  string
+}
+
+func orderStateNames() []string {
+	names := make([]string, len(OrderStates))
+	for i, s := range OrderStates {
+		names[i] = string(s)
+	}
+	return names
+}
+
+func CanTransition(from, to OrderState) bool {
+	for _, s := range orderTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Pindahkan order ke state baru. Baris sent_worklist dibuat otomatis bila belum ada.
+func (s *sqlStore) TransitionOrder(nomorOrder string, to OrderState, keterangan string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var current sql.NullString
+	err = tx.QueryRow("SELECT status FROM sent_worklist WHERE nomor_order=?"+s.dialect.lockSuffix, nomorOrder).Scan(&current)
+	switch {
+	case err == sql.ErrNoRows:
+		if to != OrderOrdered && to != OrderWorklisted && to != OrderError {
+			return fmt.Errorf("order %s belum tercatat, tidak bisa langsung ke state %s", nomorOrder, to)
+		}
+		if _, err := tx.Exec("INSERT INTO sent_worklist (nomor_order, status, tgl_masuk_worklist) VALUES (?, ?, ?)", nomorOrder, OrderOrdered, time.Now()); err != nil {
+			return err
+		}
+		if err := insertOrderEvent(tx, nomorOrder, "", OrderOrdered, "order baru dari Khanza"); err != nil {
+			return err
+		}
+		if to == OrderOrdered {
+			if err := tx.Commit(); err != nil {
+				return err
+			}
+			Bus.Publish(EventOrder, OrderStateChange{NomorOrder: nomorOrder, Ke: string(OrderOrdered)})
+			return nil
+		}
+		current = sql.NullString{String: string(OrderOrdered), Valid: true}
+	case err != nil:
+		return err
+	case to == OrderOrdered:
+		// ordered hanya state awal, order yang sudah tercatat tidak diulang
+		return nil
+	}
+
+	from := OrderState(current.String)
+	if !current.Valid || from == "" {
+		// Baris lama sebelum ada kolom status dianggap sudah terkirim
+		from = OrderWorklisted
+	}
+	if !CanTransition(from, to) {
+		if from == to {
+			// Sudah di state tersebut, tidak perlu dicatat ulang
+			return nil
+		}
+		return fmt.Errorf("transisi order %s dari %s ke %s tidak diizinkan", nomorOrder, from, to)
+	}
+
+	if col, ok := orderStateColumns[to]; ok {
+		_, err = tx.Exec("UPDATE sent_worklist SET status=?, "+col+"=? WHERE nomor_order=?", to, time.Now(), nomorOrder)
+	} else {
+		_, err = tx.Exec("UPDATE sent_worklist SET status=? WHERE nomor_order=?", to, nomorOrder)
+	}
+	if err != nil {
+		return err
+	}
+	if err := insertOrderEvent(tx, nomorOrder, from, to, keterangan); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	Bus.Publish(EventOrder, OrderStateChange{NomorOrder: nomorOrder, Dari: string(from), Ke: string(to), Keterangan: keterangan})
+	return nil
+}
+
+func insertOrderEvent(tx *sql.Tx, nomorOrder string, from, to OrderState, keterangan string) error {
+	_, err := tx.Exec("INSERT INTO order_event (nomor_order, dari_status, ke_status, waktu, keterangan) VALUES (?, ?, ?, ?, ?)",
+		nomorOrder, from, to, time.Now(), keterangan)
+	return err
+}
+
+// Helper transisi yang cukup mencatat kegagalan ke log
+func transitionOrLog(mwdb Store, nomorOrder string, to OrderState, keterangan string) {
+	if err := mwdb.TransitionOrder(nomorOrder, to, keterangan); err != nil {
+		NewLogger(mwdb, CompOrder).Error("Gagal update state order ke "+string(to), LogFields{Accession: nomorOrder, Err: err})
+	}
+}
+
+// Noorder Khanza dari data worklist yang tersimpan (PatientID worklist = noorder)
+func noorderOf(mwdb WorklistStore, accession string) (string, error) {
+	sent, err := mwdb.GetSentWorklists([]string{accession})
+	if err != nil {
+		return "", err
+	}
+	var wl WorklistRequest
+	if err := json.Unmarshal([]byte(sent[accession].Worklist), &wl); err != nil || wl.PatientID == "" {
+		return "", fmt.Errorf("data worklist order %s tidak tersimpan", accession)
+	}
+	return wl.PatientID, nil
+}
+
+// Tampilkan progres order di permintaan_radiologi Khanza: waktu sampel saat
+// gambar diterima, waktu hasil dan link saat hasil tersimpan. Kolom yang tidak
+// dipetakan dilewati; kegagalan lain cukup dicatat karena state middleware
+// tetap menjadi acuan. noorder boleh kosong, dicari dari sent_worklist.
+func syncKhanzaProgress(db *sql.DB, mwdb Store, accession, noorder string, state OrderState, link string) {
+	logger := NewLogger(mwdb, CompOrder)
+	fields := LogFields{Accession: accession, Pasien: noorder}
+	if noorder == "" {
+		var err error
+		if noorder, err = noorderOf(mwdb, accession); err != nil {
+			fields.Err = err
+			logger.Warn("Progres order tidak diperbarui di Khanza", fields)
+			return
+		}
+		fields.Pasien = noorder
+	}
+
+	now := time.Now()
+	var updates []func() error
+	switch state {
+	case OrderAcquired:
+		updates = append(updates, func() error { return UpdateOrderSampel(db, noorder, now) })
+	case OrderFiled:
+		updates = append(updates, func() error { return UpdateOrderHasil(db, noorder, now) })
+		if link != "" {
+			updates = append(updates, func() error { return SaveStudyLinkToKhanza(db, noorder, link) })
+		}
+	default:
+		return
+	}
+	updates = append(updates, func() error { return UpdateWorklistStatus(db, noorder, string(state)) })
+
+	for _, update := range updates {
+		err := update()
+		if errors.Is(err, ErrKhanzaColumnMissing) {
+			continue
+		}
+		if err != nil {
+			fields.Err = err
+			metricKhanzaWriteFailures.WithLabelValues("permintaan_radiologi").Inc()
+			logger.Error("Gagal update progres order di Khanza", fields)
+			return
+		}
+	}
+	logger.Debug("Progres order di Khanza diperbarui ke "+string(state), fields)
+}
+
+func (s *sqlStore) GetOrderEvents(nomorOrder string) ([]OrderEvent, error) {
+	rows, err := s.db.Query("SELECT id, nomor_order, dari_status, ke_status, waktu, IFNULL(keterangan, '') FROM order_event WHERE nomor_order=? ORDER BY waktu, id", nomorOrder)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var events []OrderEvent
+	for rows.Next() {
+		var ev OrderEvent
+		if err := rows.Scan(&ev.ID, &ev.NomorOrder, &ev.DariStatus, &ev.KeStatus, &ev.Waktu, &ev.Keterangan); err != nil {
+			log.Printf("Error scan order_event: %v", err)
+			continue
+		}
+		events = append(events, ev)
+	}
+	return events, rows.Err()
+}
+
+// Gabungkan event state order dengan baris log_portal yang menyebut accession/noorder
+func (s *sqlStore) GetOrderTimeline(accession string) ([]TimelineEntry, error) {
+	events, err := s.GetOrderEvents(accession)
+	if err != nil {
+		return nil, err
+	}
+	var timeline []TimelineEntry
+	for _, ev := range events {
+		timeline = append(timeline, TimelineEntry{
+			Waktu:  ev.Waktu,
+			Sumber: "state",
+			Status: ev.KeStatus,
+			Pesan:  strings.TrimSpace(ev.DariStatus + " → " + ev.KeStatus + " " + ev.Keterangan),
+		})
+	}
+
+	// Log webhook SR memakai noorder (PatientID), ambil dari JSON worklist yang tersimpan
+	keys := []interface{}{"%" + accession + "%"}
+	var worklistJSON sql.NullString
+	s.db.QueryRow("SELECT worklist FROM sent_worklist WHERE nomor_order=?", accession).Scan(&worklistJSON)
+	if worklistJSON.Valid && worklistJSON.String != "" {
+		var wl WorklistRequest
+		if json.Unmarshal([]byte(worklistJSON.String), &wl) == nil && wl.PatientID != "" {
+			keys = append(keys, "%"+wl.PatientID+"%")
+		}
+	}
+	// Log terstruktur punya kolom accession, log lama hanya bisa dicari lewat isi pesan
+	query := "SELECT waktu, IFNULL(level, ''), IFNULL(komponen, ''), pesan, IFNULL(error, '') FROM log_portal WHERE accession=? OR pesan LIKE ?"
+	if len(keys) > 1 {
+		query += " OR pesan LIKE ?"
+	}
+	rows, err := s.db.Query(query+" ORDER BY waktu", append([]interface{}{accession}, keys...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e LogEntry
+		if err := rows.Scan(&e.Waktu, &e.Level, &e.Komponen, &e.Pesan, &e.Error); err != nil {
+			continue
+		}
+		timeline = append(timeline, TimelineEntry{
+			Waktu:  e.Waktu,
+			Sumber: "log",
+			Status: e.Level,
+			Pesan:  strings.TrimPrefix(e.String(), e.Waktu.Format("2006-01-02 15:04:05")+" : "),
+		})
+	}
+
+	sort.SliceStable(timeline, func(i, j int) bool {
+		return timeline[i].Waktu.Before(timeline[j].Waktu)
+	})
+	return timeline, nil
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Level log, urut dari yang paling ringan
+const (
+	LevelDebug = "DEBUG"
+	LevelInfo  = "INFO"
+	LevelWarn  = "WARN"
+	LevelError = "ERROR"
+)
+
+var LogLevels = []string{LevelDebug, LevelInfo, LevelWarn, LevelError}
+
+// Komponen yang menulis log
+const (
+	CompWorklist = "worklist"
+	CompSR       = "sr"
+	CompOrder    = "order"
+	CompAuth     = "auth"
+	CompPortal   = "portal"
+	CompKritis   = "kritis"
+)
+
+var LogComponents = []string{CompWorklist, CompSR, CompOrder, CompAuth, CompPortal, CompKritis}
+
+type LogFields struct {
+	Accession string
+	Pasien    string
+	Err       error
+}
+
+type LogEntry struct {
+	ID        int       `json:"id"`
+	Waktu     time.Time `json:"waktu"`
+	Level     string    `json:"level"`
+	Komponen  string    `json:"komponen"`
+	Accession string    `json:"accession,omitempty"`
+	Pasien    string    `json:"pasien,omitempty"`
+	Pesan     string    `json:"pesan"`
+	Error     string    `json:"error,omitempty"`
+}
+
+func (e LogEntry) String() string {
+	s := e.Waktu.Format("2006-01-02 15:04:05") + " : "
+	if e.Komponen != "" {
+		s += "[" + e.Level + "][" + e.Komponen + "] "
+	}
+	s += e.Pesan
+	if e.Error != "" {
+		s += ": " + e.Error
+	}
+	return s
+}
+
+// Logger terstruktur per komponen. Setiap entri ditulis ke stdout, tabel
+// log_portal, dan event bus untuk dashboard.
+type Logger struct {
+	db       LogStore
+	komponen string
+}
+
+func NewLogger(db LogStore, komponen string) Logger {
+	return Logger{db: db, komponen: komponen}
+}
+
+func (l Logger) Debug(msg string, f LogFields) { l.write(LevelDebug, msg, f) }
+func (l Logger) Info(msg string, f LogFields)  { l.write(LevelInfo, msg, f) }
+func (l Logger) Warn(msg string, f LogFields)  { l.write(LevelWarn, msg, f) }
+func (l Logger) Error(msg string, f LogFields) { l.write(LevelError, msg, f) }
+
+func (l Logger) write(level, msg string, f LogFields) {
+	if !levelEnabled(level) {
+		return
+	}
+	entry := LogEntry{
+		Waktu:     time.Now(),
+		Level:     level,
+		Komponen:  l.komponen,
+		Accession: f.Accession,
+		Pasien:    f.Pasien,
+		Pesan:     msg,
+	}
+	if f.Err != nil {
+		entry.Error = f.Err.Error()
+	}
+
+	line := fmt.Sprintf("level=%s komponen=%s msg=%q", level, l.komponen, msg)
+	if entry.Accession != "" {
+		line += " accession=" + entry.Accession
+	}
+	if entry.Pasien != "" {
+		line += " pasien=" + entry.Pasien
+	}
+	if entry.Error != "" {
+		line += fmt.Sprintf(" error=%q", entry.Error)
+	}
+	log.Println(line)
+
+	if l.db == nil {
+		return
+	}
+	if err := l.db.InsertLog(entry); err != nil {
+		log.Printf("Error insert log_portal: %v", err)
+	}
+	Bus.Publish(EventLog, entry)
+}
+
+func (s *sqlStore) InsertLog(e LogEntry) error {
+	_, err := s.db.Exec("INSERT INTO log_portal (waktu, level, komponen, accession, pasien, pesan, error) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		e.Waktu, e.Level, e.Komponen, nullIfEmpty(e.Accession), nullIfEmpty(e.Pasien), e.Pesan, nullIfEmpty(e.Error))
+	return err
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+type LogFilter struct {
+	Level     string
+	Komponen  string
+	Accession string
+	Dari      time.Time
+	Sampai    time.Time
+	Limit     int
+	Offset    int
+}
+
+// Log di bawah LOG_LEVEL tidak dicatat
+func levelEnabled(level string) bool {
+	min := RuntimeConfig().LogLevel
+	if min == "" {
+		return true
+	}
+	for _, l := range levelsFrom(min) {
+		if l == level {
+			return true
+		}
+	}
+	return false
+}
+
+// Hapus log_portal yang lebih tua dari LOG_RETENTION_DAYS (0 berarti disimpan selamanya)
+func PurgeOldLogs(db LogStore, days int) (int64, error) {
+	if days <= 0 {
+		return 0, nil
+	}
+	return db.PurgeLogs(time.Now().AddDate(0, 0, -days))
+}
+
+func (s *sqlStore) PurgeLogs(sebelum time.Time) (int64, error) {
+	res, err := s.db.Exec("DELETE FROM log_portal WHERE waktu < ?", sebelum)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func logRetentionLoop(ctx context.Context, mwdb LogStore) {
+	for ctx.Err() == nil {
+		if n, err := PurgeOldLogs(mwdb, RuntimeConfig().LogRetentionDays); err != nil {
+			log.Printf("Gagal hapus log lama: %v", err)
+		} else if n > 0 {
+			log.Printf("%d baris log lama dihapus", n)
+		}
+		sleepCtx(ctx, time.Hour)
+	}
+}
+
+// Level minimum: filter WARN menampilkan WARN dan ERROR
+func levelsFrom(level string) []string {
+	for i, l := range LogLevels {
+		if l == level {
+			return LogLevels[i:]
+		}
+	}
+	return nil
+}
+
+// Cari log dengan filter dan paging, urut terbaru dulu. Mengembalikan total baris yang cocok.
+func (s *sqlStore) QueryLogs(f LogFilter) ([]LogEntry, int, error) {
+	var where []string
+	var args []interface{}
+	if levels := levelsFrom(f.Level); levels != nil {
+		where = append(where, "level IN (?"+strings.Repeat(",?", len(levels)-1)+")")
+		for _, l := range levels {
+			args = append(args, l)
+		}
+	}
+	if f.Komponen != "" {
+		where = append(where, "komponen=?")
+		args = append(args, f.Komponen)
+	}
+	if f.Accession != "" {
+		where = append(where, "accession=?")
+		args = append(args, f.Accession)
+	}
+	if !f.Dari.IsZero() {
+		where = append(where, "waktu >= ?")
+		args = append(args, f.Dari)
+	}
+	if !f.Sampai.IsZero() {
+		where = append(where, "waktu < ?")
+		args = append(args, f.Sampai)
+	}
+	cond := ""
+	if len(where) > 0 {
+		cond = " WHERE " + strings.Join(where, " AND ")
+	}
+
+	var total int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM log_portal"+cond, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	if f.Limit <= 0 {
+		f.Limit = 100
+	}
+	rows, err := s.db.Query(`SELECT id, waktu, IFNULL(level, ''), IFNULL(komponen, ''), IFNULL(accession, ''), IFNULL(pasien, ''), pesan, IFNULL(error, '')
+		FROM log_portal`+cond+" ORDER BY waktu DESC, id DESC LIMIT ? OFFSET ?", append(args, f.Limit, f.Offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	var entries []LogEntry
+	for rows.Next() {
+		var e LogEntry
+		if err := rows.Scan(&e.ID, &e.Waktu, &e.Level, &e.Komponen, &e.Accession, &e.Pasien, &e.Pesan, &e.Error); err == nil {
+			entries = append(entries, e)
+		}
+	}
+	return entries, total, rows.Err()
+}
+
+// Log terbaru dalam bentuk teks, urut lama ke baru, untuk dashboard
+func GetPortalLogs(db LogStore, limit int) ([]string, error) {
+	entries, _, err := db.QueryLogs(LogFilter{Limit: limit})
+	if err != nil {
+		return nil, err
+	}
+	logs := make([]string, 0, len(entries))
+	for i := len(entries) - 1; i >= 0; i-- {
+		logs = append(logs, entries[i].String())
+	}
+	return logs, nil
+}
+
+func parseLogFilter(r *http.Request) LogFilter {
+	q := r.URL.Query()
+	f := LogFilter{
+		Level:     q.Get("level"),
+		Komponen:  q.Get("komponen"),
+		Accession: q.Get("accession"),
+		Limit:     100,
+	}
+	if v, err := time.ParseInLocation("2006-01-02", q.Get("dari"), time.Local); err == nil {
+		f.Dari = v
+	}
+	if v, err := time.ParseInLocation("2006-01-02", q.Get("sampai"), time.Local); err == nil {
+		f.Sampai = v.AddDate(0, 0, 1)
+	}
+	if v, err := strconv.Atoi(q.Get("limit")); err == nil && v > 0 && v <= 1000 {
+		f.Limit = v
+	}
+	if v, err := strconv.Atoi(q.Get("page")); err == nil && v > 1 {
+		f.Offset = (v - 1) * f.Limit
+	}
+	return f
+}
+
+var logViewerTmpl = `
+<!DOCTYPE html>
+<html>
+<head>
+    <title>Log Middleware Radiologi</title>
+    <style>
+        body { font-family: Arial; margin: 40px; }
+        table { border-collapse: collapse; width: 100%; font-size: 13px; }
+        th, td { border: 1px solid #ccc; padding: 6px; text-align: left; vertical-align: top; }
+        th { background: #f0f0f0; }
+        .ERROR { color: #b00; font-weight: bold; }
+        .WARN { color: #b60; }
+        .DEBUG { color: #888; }
+        form { margin-bottom: 20px; }
+    </style>
+</head>
+<body>
+    <h2>Log Middleware</h2>
+    <p><a href="/">Dashboard</a></p>
+    <form method="get" action="/log">
+        Level <select name="level">
+            <option value="">Semua</option>
+            {{range .Levels}}<option value="{{.}}" {{if eq . $.Filter.Level}}selected{{end}}>{{.}}</option>{{end}}
+        </select>
+        Komponen <select name="komponen">
+            <option value="">Semua</option>
+            {{range .Components}}<option value="{{.}}" {{if eq . $.Filter.Komponen}}selected{{end}}>{{.}}</option>{{end}}
+        </select>
+        Accession <input type="text" name="accession" value="{{.Filter.Accession}}">
+        Dari <input type="date" name="dari" value="{{.Dari}}">
+        Sampai <input type="date" name="sampai" value="{{.Sampai}}">
+        <button type="submit">Filter</button>
+    </form>
+    <p>{{.Total}} entri, halaman {{.Page}} dari {{.Pages}}</p>
+    <table>
+        <tr><th>Waktu</th><th>Level</th><th>Komponen</th><th>Accession</th><th>Pasien</th><th>Pesan</th><th>Error</th></tr>
+        {{range .Entries}}
+        <tr>
+            <td>{{.Waktu.Format "2006-01-02 15:04:05"}}</td>
+            <td class="{{.Level}}">{{.Level}}</td>
+            <td>{{.Komponen}}</td>
+            <td>{{if .Accession}}<a href="/order?accession={{.Accession}}">{{.Accession}}</a>{{end}}</td>
+            <td>{{.Pasien}}</td>
+            <td>{{.Pesan}}</td>
+            <td>{{.Error}}</td>
+        </tr>
+        {{end}}
+    </table>
+    <p>
+        {{if gt .Page 1}}<a href="/log?{{.PrevQuery}}">&laquo; Sebelumnya</a>{{end}}
+        {{if lt .Page .Pages}}<a href="/log?{{.NextQuery}}">Berikutnya &raquo;</a>{{end}}
+    </p>
+</body>
+</html>
+`
+
+func registerLogHandlers(mux *http.ServeMux, mwdb Store) {
+	mux.HandleFunc("/log", RequirePermission(mwdb, PermView, func(w http.ResponseWriter, r *http.Request) {
+		f := parseLogFilter(r)
+		entries, total, err := mwdb.QueryLogs(f)
+		if err != nil {
+			http.Error(w, "Gagal ambil log: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		page := f.Offset/f.Limit + 1
+		pages := (total + f.Limit - 1) / f.Limit
+		if pages == 0 {
+			pages = 1
+		}
+		pageQuery := func(p int) string {
+			q := url.Values{}
+			for k, v := range r.URL.Query() {
+				q[k] = v
+			}
+			q.Set("page", strconv.Itoa(p))
+			return q.Encode()
+		}
+		t, _ := template.New("log").Parse(logViewerTmpl)
+		t.Execute(w, struct {
+			Filter     LogFilter
+			Dari       string
+			Sampai     string
+			Levels     []string
+			Components []string
+			Entries    []LogEntry
+			Total      int
+			Page       int
+			Pages      int
+			PrevQuery  template.URL
+			NextQuery  template.URL
+		}{
+			f, r.URL.Query().Get("dari"), r.URL.Query().Get("sampai"), LogLevels, LogComponents,
+			entries, total, page, pages, template.URL(pageQuery(page - 1)), template.URL(pageQuery(page + 1)),
+		})
+	}))
+
+	mux.HandleFunc("/api/logs", RequirePermission(mwdb, PermView, func(w http.ResponseWriter, r *http.Request) {
+		entries, total, err := mwdb.QueryLogs(parseLogFilter(r))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if entries == nil {
+			entries = []LogEntry{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(struct {
+			Total   int        `json:"total"`
+			Entries []LogEntry `json:"entries"`
+		}{total, entries})
+	}))
+}
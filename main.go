@@ -1,49 +1,162 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/joho/godotenv"
 )
 
-func processWorklist(cfg Config, db, mwdb *sql.DB) {
-	for {
-		worklists, err := GetPendingWorklist(db, time.Now().Format("2006-01-02"))
+func processWorklist(ctx context.Context, cfg Config, db *sql.DB, mwdb Store) {
+	logger := NewLogger(mwdb, CompWorklist)
+	detector := NewOrderDetector()
+	for ctx.Err() == nil {
+		runtime := RuntimeConfig()
+		worklists, full, err := detector.Poll(db, time.Now(), runtime)
 		if err != nil {
-			log.Printf("Gagal ambil worklist: %v", err)
-			SavePortalLog(mwdb, "[Worklist] Gagal ambil worklist: "+err.Error())
+			logger.Error("Gagal ambil worklist", LogFields{Err: err})
 			UpdateWorklists(nil)
-			time.Sleep(10 * time.Second)
+			sleepCtx(ctx, runtime.WorklistRetryInterval)
 			continue
 		}
-		// var wlPortal []Worklist
-		for _, wl := range worklists {
-			if IsWorklistSent(mwdb, wl.AccessionNumber) {
-				continue
-			}
-			SavePortalLog(mwdb, "[Worklist] Proses kirim worklist "+wl.AccessionNumber)
-			marsh, _ := json.Marshal(wl)
-			err = SendWorklistToOrthanc(cfg, wl)
-			if err != nil {
-				log.Printf("Gagal kirim worklist ke Orthanc untuk %s: %v", wl.AccessionNumber, err)
-				SavePortalLog(mwdb, "[Worklist] Gagal kirim worklist ke Orthanc untuk "+wl.AccessionNumber+": "+err.Error())
+		if sendWorklists(ctx, cfg, mwdb, worklists).Dibatalkan {
+			return
+		}
+		if full {
+			// Cek gambar di Orthanc cukup mengikuti jadwal scan penuh
+			detectAcquiredStudies(cfg, db, mwdb)
+		}
+		UpdateWorklists(BuildWorklistView(runtime, mwdb, detector.Orders()))
+		sleepCtx(ctx, runtime.WorklistPollInterval)
+	}
+}
+
+type SendResult struct {
+	Terkirim   int
+	Gagal      int
+	Dilewati   int
+	Dibatalkan bool
+}
+
+// Kirim worklist yang belum pernah terkirim ke Orthanc dan catat state-nya
+func sendWorklists(ctx context.Context, cfg Config, mwdb Store, worklists []WorklistRequest) SendResult {
+	logger := NewLogger(mwdb, CompWorklist)
+	var res SendResult
+	if len(worklists) == 0 {
+		return res
+	}
+	// Cek status kirim semua order sekaligus, bukan satu query per order
+	nomorOrders := make([]string, len(worklists))
+	for i, wl := range worklists {
+		nomorOrders[i] = wl.AccessionNumber
+	}
+	sent, err := mwdb.GetSentWorklists(nomorOrders)
+	if err != nil {
+		logger.Error("Gagal cek sent_worklist", LogFields{Err: err})
+		res.Gagal = len(worklists)
+		return res
+	}
+	for _, wl := range worklists {
+		if ctx.Err() != nil {
+			// Berhenti di antara order, worklist yang sedang dibuat tetap diselesaikan
+			res.Dibatalkan = true
+			return res
+		}
+		if sw, ok := sent[wl.AccessionNumber]; ok && sw.Terkirim() {
+			res.Dilewati++
+			continue
+		}
+		// Accession yang sama bisa muncul lebih dari sekali dalam satu batch
+		sent[wl.AccessionNumber] = SentWorklist{Status: string(OrderWorklisted)}
+		fields := LogFields{Accession: wl.AccessionNumber, Pasien: wl.PatientID}
+		transitionOrLog(mwdb, wl.AccessionNumber, OrderOrdered, "")
+		logger.Info("Proses kirim worklist", fields)
+		marsh, _ := json.Marshal(wl)
+		if err := SendWorklistToOrthanc(cfg, wl); err != nil {
+			fields.Err = err
+			logger.Error("Gagal kirim worklist ke Orthanc", fields)
+			transitionOrLog(mwdb, wl.AccessionNumber, OrderError, err.Error())
+			res.Gagal++
+			continue
+		}
+		logger.Info("Worklist dikirim ke Orthanc", fields)
+		mwdb.InsertSentWorklist(wl.AccessionNumber, string(marsh), wl.WaktuPermintaan())
+		transitionOrLog(mwdb, wl.AccessionNumber, OrderWorklisted, "file .wl dibuat")
+		res.Terkirim++
+	}
+	return res
+}
+
+// Muat ulang konfigurasi yang aman diubah setiap kali menerima SIGHUP
+func reloadOnSIGHUP(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			if err := ReloadConfig(); err != nil {
+				log.Printf("Reload konfigurasi gagal, konfigurasi lama tetap dipakai: %v", err)
 				continue
 			}
-			log.Printf("Worklist %s dikirim ke Orthanc", wl.AccessionNumber)
-			SavePortalLog(mwdb, "[Worklist] Worklist "+wl.AccessionNumber+" dikirim ke Orthanc")
-			InsertSentWorklist(mwdb, wl.AccessionNumber, string(marsh))
+			log.Println("Konfigurasi dimuat ulang")
+		}
+	}
+}
+
+// Tidur selama d atau sampai ctx dibatalkan
+func sleepCtx(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-t.C:
+	}
+}
+
+// Cek Orthanc untuk order yang sudah terkirim, tandai acquired bila study sudah masuk
+func detectAcquiredStudies(cfg Config, db *sql.DB, mwdb Store) {
+	logger := NewLogger(mwdb, CompWorklist)
+	orders, err := mwdb.GetOrdersAwaitingImages(RuntimeConfig().ImageWaitWindow)
+	if err != nil {
+		logger.Error("Gagal ambil order menunggu gambar", LogFields{Err: err})
+		return
+	}
+	for _, accession := range orders {
+		studyUID, err := FindStudyByAccession(cfg, accession)
+		if err != nil {
+			logger.Warn("Gagal cari study di Orthanc", LogFields{Accession: accession, Err: err})
+			return
+		}
+		if studyUID == "" {
+			continue
+		}
+		mwdb.UpdateStudyInstanceUID(accession, studyUID)
+		logger.Info("Gambar diterima di Orthanc", LogFields{Accession: accession})
+		if err := mwdb.TransitionOrder(accession, OrderAcquired, studyUID); err != nil {
+			logger.Error("Gagal update state order ke "+string(OrderAcquired), LogFields{Accession: accession, Err: err})
+			continue
 		}
-		time.Sleep(30 * time.Second)
+		syncKhanzaProgress(db, mwdb, accession, "", OrderAcquired, "")
+		enqueueReading(db, mwdb, accession, studyUID)
 	}
 }
 
-func processSRWebhook(cfg Config, db, mwdb *sql.DB, bodyBytes []byte) {
+func processSRWebhook(cfg Config, db *sql.DB, mwdb Store, bodyBytes []byte) {
+	defer observeStage(StageWebhookTotal, time.Now())
+	logger := NewLogger(mwdb, CompSR)
 	var payload struct {
 		Accession        string      `json:"accession"`
 		Link             string      `json:"link"`
@@ -56,100 +169,163 @@ func processSRWebhook(cfg Config, db, mwdb *sql.DB, bodyBytes []byte) {
 	}
 
 	if err := json.Unmarshal(bodyBytes, &payload); err != nil {
-		log.Printf("Invalid JSON payload : %v", err)
-		SavePortalLog(mwdb, "[SR] Webhook gagal: payload tidak valid")
+		logger.Error("Webhook gagal: payload tidak valid", LogFields{Err: err})
 		return
 	}
-	SavePortalLog(mwdb, "[SR] Webhook SR diterima dari Orthanc: "+payload.StudyInstanceUID)
 	switch v := payload.PatientIDINT.(type) {
 	case string:
 		payload.PatientID = v
 	case float64:
 		payload.PatientID = fmt.Sprintf("%.0f", v) // tanpa desimal
 	}
+	fields := LogFields{Accession: payload.Accession, Pasien: payload.PatientID}
+	logger.Info("Webhook SR diterima dari Orthanc: "+payload.StudyInstanceUID, fields)
 
 	// Gunakan orthanc_uuid jika tersedia untuk langsung ambil instance
 	instanceID := payload.OrthancUUID
-	SavePortalLog(mwdb, "[SR] Parsing isi SR instance: "+instanceID)
-	srContent, err := ParseSRContentFromOrthanc(cfg, instanceID)
+	logger.Debug("Parsing isi SR instance: "+instanceID, fields)
+	report, err := FetchSRReport(cfg, instanceID)
 	if err != nil {
-		SavePortalLog(mwdb, "[SR] Gagal parsing isi SR: "+err.Error())
-		log.Printf("Gagal parsing isi SR: %v", err)
+		fields.Err = err
+		metricSRParseFailures.Inc()
+		logger.Error("Gagal parsing isi SR", fields)
+		transitionOrLog(mwdb, payload.Accession, OrderError, "parsing SR gagal: "+err.Error())
 		return
 	}
+	if payload.StudyInstanceUID != "" {
+		mwdb.UpdateStudyInstanceUID(payload.Accession, payload.StudyInstanceUID)
+	}
+	transitionOrLog(mwdb, payload.Accession, OrderReported, instanceID)
+	pembaca := dicomName(report.Dokter)
+	if pembaca == "" {
+		pembaca = "SR " + instanceID
+	}
+	if err := mwdb.MarkReadingDone(payload.Accession, pembaca); err != nil {
+		logger.Warn("Gagal menandai worklist baca selesai", LogFields{Accession: payload.Accession, Err: err})
+	}
+	// Notifikasi temuan kritis tidak menunggu penyimpanan ke Khanza
+	CheckCriticalFinding(mwdb, payload.Accession, report)
 
-	hasilJSON, _ := json.MarshalIndent(srContent, "", "  ")
-	tglPeriksa := time.Now().Format("2006-01-02")
-	jam := time.Now().Format("15:04:05")
-	if err := InsertPeriksaRadiologiFromPermintaan(db, payload.PatientID, jam, payload.Link); err != nil {
-		log.Printf("Gagal1 simpan hasil SR ke Khanza untuk %s: %v", payload.PatientID, err)
-		SavePortalLog(mwdb, "[SR] Gagal simpan hasil SR ke Khanza untuk "+payload.PatientID+": "+err.Error())
+	if !CurrentKhanzaSchema().Compatible() {
+		fields.Err = ErrKhanzaSchemaIncompatible
+		logger.Error("Hasil SR tidak disimpan ke Khanza", fields)
+		transitionOrLog(mwdb, payload.Accession, OrderError, ErrKhanzaSchemaIncompatible.Error())
 		return
 	}
-	if err := SaveRadiologyResult(db, payload.PatientID, tglPeriksa, jam, string(hasilJSON)); err != nil {
-		log.Printf("Gagal2 simpan hasil SR ke Khanza untuk %s: %v", payload.PatientID, err)
-		SavePortalLog(mwdb, "[SR] Gagal simpan hasil SR ke Khanza untuk "+payload.PatientID+": "+err.Error())
+
+	// Laporan final terkunci, SR berikutnya dicatat sebagai addendum
+	hasil, err := recordSRVersion(mwdb, payload.Accession, instanceID, report)
+	if err != nil {
+		fields.Err = err
+		logger.Error("Gagal mencatat versi laporan", fields)
+		transitionOrLog(mwdb, payload.Accession, OrderError, "catat versi laporan gagal: "+err.Error())
 		return
 	}
-	InsertPeriksaRadiologiFromPermintaan(db, payload.PatientID, jam, payload.Link)
-	log.Printf("Hasil SR %s disimpan ke Khanza", payload.PatientID)
-	SavePortalLog(mwdb, "[SR] Hasil SR "+payload.PatientID+" disimpan ke Khanza")
-	UpdateHasilOrthanc(mwdb, payload.Accession, string(hasilJSON))
+	saveStart := time.Now()
+	pelaksana := Pelaksana{Dokter: report.Dokter, Petugas: report.Operator}
+	keterangan := "hasil tersimpan di Khanza"
+	if tabel, err := fileResultToKhanza(db, mwdb, payload.Accession, payload.PatientID, payload.Link, pelaksana, hasil); err != nil {
+		fields.Err = err
+		metricKhanzaWriteFailures.WithLabelValues(tabel).Inc()
+		if !errors.Is(err, ErrBillingFailed) {
+			logger.Error("Gagal simpan "+tabel+" ke Khanza", fields)
+			transitionOrLog(mwdb, payload.Accession, OrderError, "simpan "+tabel+" gagal: "+err.Error())
+			return
+		}
+		// Hasil tetap terkirim; tagihan diperbaiki manual atau lewat replay webhook
+		logger.Error("Hasil SR disimpan ke Khanza tetapi tagihan gagal", fields)
+		keterangan += ", " + err.Error()
+		fields.Err = nil
+	}
+	observeStage(StageKhanzaSave, saveStart)
+	logger.Info("Hasil SR disimpan ke Khanza", fields)
+	transitionOrLog(mwdb, payload.Accession, OrderFiled, keterangan)
+	syncKhanzaProgress(db, mwdb, payload.Accession, payload.PatientID, OrderFiled, payload.Link)
 }
 
 func main() {
 	godotenv.Load()
 
-	log.Println("Middleware Radiologi Khanza-Orthanc-OHIF berjalan...")
-	cfg := LoadConfig()
-
-	db, err := ConnectKhanzaDB(cfg)
-	if err != nil {
-		log.Fatalf("Gagal koneksi DB Khanza: %v", err)
+	cmd, args := "serve", os.Args[1:]
+	if len(args) > 0 {
+		cmd, args = args[0], args[1:]
 	}
-	defer db.Close()
+	if err := runCommand(cmd, args); err != nil {
+		log.Fatalf("%v", err)
+	}
+}
 
+// Jalankan middleware: loop worklist, health check, portal dan webhook
+func runServe(cfg Config) error {
+	log.Println("Middleware Radiologi Khanza-Orthanc-OHIF berjalan...")
 	mwdb, err := ConnectMiddlewareDB(cfg)
 	if err != nil {
-		log.Fatalf("Gagal koneksi DB Middleware: %v", err)
+		return fmt.Errorf("Gagal koneksi DB Middleware: %v", err)
 	}
 	defer mwdb.Close()
+	if err := mwdb.MigrateUp(); err != nil {
+		return fmt.Errorf("Gagal migrasi DB Middleware: %v", err)
+	}
 
-	go StartPortalServer(db, mwdb)
-	go processWorklist(cfg, db, mwdb)
+	db, err := ConnectKhanzaDB(cfg)
+	if err != nil {
+		return fmt.Errorf("Gagal koneksi DB Khanza: %v", err)
+	}
+	defer db.Close()
 
-	http.HandleFunc("/webhook", func(w http.ResponseWriter, r *http.Request) {
-		log.Println("webhook SR diterima....")
-		if r.Method != http.MethodPost {
-			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
-			return
-		}
-		bodyBytes, _ := io.ReadAll(r.Body)
-		log.Printf("Menerima webhook r.Body: %s", string(bodyBytes))
-		go processSRWebhook(cfg, db, mwdb, bodyBytes)
-		w.Write([]byte("OK"))
-	})
+	// Kegagalan probe tidak menghentikan start; query standar Khanza tetap dipakai
+	DetectKhanzaSchema(db, mwdb)
+	EnsureAdminUser(mwdb, cfg)
+	RegisterDBMetrics(db, mwdb)
 
-	for {
-		// Cek status koneksi
-		status := Status{
-			KhanzaDB:     db.Ping() == nil,
-			MiddlewareDB: mwdb.Ping() == nil,
-			Orthanc:      checkHTTPConnection("http://localhost:8042/"),
-			OHIF:         checkHTTPConnection("http://localhost:3000/"),
-		}
-		UpdateStatus(status)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-		time.Sleep(10 * time.Second)
+	go reloadOnSIGHUP(ctx)
+
+	queue := NewWebhookQueue(cfg, db, mwdb, cfg.WebhookWorkers, cfg.WebhookQueueSize)
+	servers := buildHTTPServers(cfg, db, mwdb, queue)
+	for _, srv := range servers {
+		srv.RegisterOnShutdown(Bus.Close)
+		go func(srv *http.Server) {
+			log.Printf("HTTP server berjalan di %s", srv.Addr)
+			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Printf("HTTP server %s berhenti: %v", srv.Addr, err)
+				stop()
+			}
+		}(srv)
 	}
-}
 
-func checkHTTPConnection(url string) bool {
-	client := http.Client{Timeout: 3 * time.Second}
-	resp, err := client.Get(url)
-	if err != nil {
-		return false
+	var wg sync.WaitGroup
+	wg.Add(4)
+	go func() {
+		defer wg.Done()
+		processWorklist(ctx, cfg, db, mwdb)
+	}()
+	go func() {
+		defer wg.Done()
+		healthCheckLoop(ctx, db, mwdb)
+	}()
+	go func() {
+		defer wg.Done()
+		logRetentionLoop(ctx, mwdb)
+	}()
+	go func() {
+		defer wg.Done()
+		criticalNoticeLoop(ctx, mwdb)
+	}()
+
+	<-ctx.Done()
+	log.Println("Sinyal berhenti diterima, menghentikan middleware...")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), RuntimeConfig().ShutdownTimeout)
+	defer cancel()
+	for _, srv := range servers {
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Gagal shutdown HTTP server %s: %v", srv.Addr, err)
+		}
 	}
-	defer resp.Body.Close()
-	return resp.StatusCode == 200
+	queue.Drain()
+	wg.Wait()
+	log.Println("Middleware berhenti")
+	return nil
 }
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// Metrik Prometheus, diekspos di /metrics
+var (
+	metricWorklistsGenerated = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "middleware_worklists_generated_total",
+		Help: "Jumlah file worklist .wl yang berhasil dibuat.",
+	})
+	metricWorklistFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "middleware_worklist_failures_total",
+		Help: "Kegagalan membuat worklist per tahap (write, dump2dcm).",
+	}, []string{"stage"})
+	metricWebhookRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "middleware_webhook_requests_total",
+		Help: "Request ke endpoint /webhook per hasil.",
+	}, []string{"result"})
+	metricSRParseFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "middleware_sr_parse_failures_total",
+		Help: "Jumlah SR yang gagal di-parse dari Orthanc.",
+	})
+	metricKhanzaWriteFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "middleware_khanza_write_failures_total",
+		Help: "Kegagalan menulis ke database Khanza per tabel.",
+	}, []string{"table"})
+	metricComponentUp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "middleware_component_up",
+		Help: "Status koneksi komponen (1 tersambung, 0 gagal).",
+	}, []string{"component"})
+	metricStageDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "middleware_stage_duration_seconds",
+		Help:    "Lama proses per tahap pipeline.",
+		Buckets: prometheus.ExponentialBuckets(0.005, 2, 14),
+	}, []string{"stage"})
+)
+
+// Nama tahap pipeline untuk metricStageDuration
+const (
+	StageWorklistQuery       = "worklist_query"
+	StageWorklistIncremental = "worklist_query_incremental"
+	StageWorklistSend        = "worklist_send"
+	StageOrthancFind         = "orthanc_find"
+	StageSRParse             = "sr_parse"
+	StageKhanzaSave          = "khanza_save"
+	StageWebhookTotal        = "webhook_total"
+)
+
+func init() {
+	prometheus.MustRegister(
+		metricWorklistsGenerated,
+		metricWorklistFailures,
+		metricWebhookRequests,
+		metricSRParseFailures,
+		metricKhanzaWriteFailures,
+		metricComponentUp,
+		metricStageDuration,
+	)
+}
+
+// Daftarkan statistik pool koneksi kedua database
+func RegisterDBMetrics(db *sql.DB, mwdb Store) {
+	prometheus.MustRegister(collectors.NewDBStatsCollector(db, "khanza"))
+	// Statistik pool hanya ada di implementasi database/sql
+	if s, ok := mwdb.(*sqlStore); ok {
+		prometheus.MustRegister(collectors.NewDBStatsCollector(s.db, "middleware"))
+	}
+}
+
+// Catat lama proses sejak start, dipakai dengan defer
+func observeStage(stage string, start time.Time) {
+	metricStageDuration.WithLabelValues(stage).Observe(time.Since(start).Seconds())
+}
+
+func boolGauge(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func recordStatusMetrics(s Status) {
+	metricComponentUp.WithLabelValues("khanza_db").Set(boolGauge(s.KhanzaDB))
+	metricComponentUp.WithLabelValues("middleware_db").Set(boolGauge(s.MiddlewareDB))
+	metricComponentUp.WithLabelValues("orthanc").Set(boolGauge(s.Orthanc))
+	metricComponentUp.WithLabelValues("ohif").Set(boolGauge(s.OHIF))
+	metricComponentUp.WithLabelValues("worklist_folder").Set(boolGauge(s.WorklistFolder))
+}
@@ -3,25 +3,29 @@ package main
 import (
 	"database/sql"
 	"log"
+	"strings"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 )
 
 type SentWorklist struct {
-	ID               int
-	NomorOrder       string
-	Worklist         string
-	TglMasukWorklist *time.Time
-	TglKirimWorklist *time.Time
-	TglTerimaHasil   *time.Time
-	TglSimpanHasil   *time.Time
-	HasilOrthanc     string
+	ID                int
+	NomorOrder        string
+	Worklist          string
+	Status            string
+	StudyInstanceUID  string
+	TglMasukWorklist  *time.Time
+	TglKirimWorklist  *time.Time
+	TglGambarDiterima *time.Time
+	TglTerimaHasil    *time.Time
+	TglSimpanHasil    *time.Time
+	HasilOrthanc      string
 }
 
-// Koneksi ke database middleware (bisa sama dengan Khanza, atau DB terpisah)
-func ConnectMiddlewareDB(cfg Config) (*sql.DB, error) {
-	dsn := cfg.DBUser + ":" + cfg.DBPassword + "@tcp(" + cfg.DBHost + ":" + cfg.DBPort + ")/" + cfg.DBName
+// Koneksi MySQL ke database middleware (bisa sama dengan Khanza, atau DB terpisah)
+func connectMiddlewareMySQL(cfg Config) (*sql.DB, error) {
+	dsn := cfg.DBUser + ":" + cfg.DBPassword + "@tcp(" + cfg.DBHost + ":" + cfg.DBPort + ")/" + cfg.DBName + "?parseTime=true&loc=Local"
 	db, err := sql.Open("mysql", dsn)
 	if err != nil {
 		return nil, err
@@ -39,29 +43,82 @@ func ConnectMiddlewareDB(cfg Config) (*sql.DB, error) {
 	return db, nil
 }
 
-// Mengecek apakah worklist sudah pernah dikirim berdasarkan nomor_order
-func IsWorklistSent(db *sql.DB, nomorOrder string) bool {
-	var exists bool
-	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM sent_worklist WHERE nomor_order=?)", nomorOrder).Scan(&exists)
-	if err != nil && err != sql.ErrNoRows {
-		log.Printf("Error cek sent_worklist: %v", err)
-		return false
+// Worklist sudah pernah dikirim. Order yang baru tercatat (ordered) atau gagal
+// sebelum terkirim belum dianggap terkirim.
+func (sw SentWorklist) Terkirim() bool {
+	if sw.TglKirimWorklist != nil {
+		return true
 	}
-	return exists
+	return sw.Status != string(OrderOrdered) && sw.Status != string(OrderError)
 }
 
-// Mencatat worklist yang sudah dikirim
-func InsertSentWorklist(db *sql.DB, nomorOrder, worklist string) {
-	_, err := db.Exec(`INSERT INTO sent_worklist (nomor_order, worklist, tgl_masuk_worklist) VALUES (?, ?, NOW()) ON DUPLICATE KEY UPDATE tgl_kirim_worklist=NOW()`, nomorOrder, worklist)
+// Mencatat isi worklist yang dikirim, waktu kirim diisi lewat TransitionOrder
+func (s *sqlStore) InsertSentWorklist(nomorOrder, worklist string, tglPermintaan *time.Time) {
+	_, err := s.db.Exec(s.dialect.upsertSentWorklist, nomorOrder, worklist, tglPermintaan, time.Now())
 	if err != nil {
 		log.Printf("Error insert sent_worklist: %v", err)
 	}
 }
 
-// Update hasil orthanc dan tanggal simpan hasil
-func UpdateHasilOrthanc(db *sql.DB, nomorOrder, hasilOrthanc string) {
-	_, err := db.Exec(`UPDATE sent_worklist SET hasil_orthanc=?, tgl_simpan_hasil=NOW() WHERE nomor_order=?`, hasilOrthanc, nomorOrder)
+// Update hasil orthanc, tanggal simpan hasil diisi lewat TransitionOrder
+func (s *sqlStore) UpdateHasilOrthanc(nomorOrder, hasilOrthanc string) {
+	_, err := s.db.Exec(`UPDATE sent_worklist SET hasil_orthanc=? WHERE nomor_order=?`, hasilOrthanc, nomorOrder)
 	if err != nil {
 		log.Printf("Error update hasil_orthanc: %v", err)
 	}
 }
+
+// Ambil data sent_worklist untuk banyak nomor_order sekaligus
+func (s *sqlStore) GetSentWorklists(nomorOrders []string) (map[string]SentWorklist, error) {
+	result := make(map[string]SentWorklist)
+	if len(nomorOrders) == 0 {
+		return result, nil
+	}
+	args := make([]interface{}, len(nomorOrders))
+	for i, no := range nomorOrders {
+		args[i] = no
+	}
+	query := `SELECT id, nomor_order, IFNULL(worklist, ''), IFNULL(status, ''), IFNULL(study_instance_uid, ''), tgl_masuk_worklist, tgl_kirim_worklist,
+		tgl_gambar_diterima, tgl_terima_hasil, tgl_simpan_hasil, IFNULL(hasil_orthanc, '')
+		FROM sent_worklist WHERE nomor_order IN (?` + strings.Repeat(",?", len(nomorOrders)-1) + `)`
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var sw SentWorklist
+		if err := rows.Scan(&sw.ID, &sw.NomorOrder, &sw.Worklist, &sw.Status, &sw.StudyInstanceUID, &sw.TglMasukWorklist, &sw.TglKirimWorklist,
+			&sw.TglGambarDiterima, &sw.TglTerimaHasil, &sw.TglSimpanHasil, &sw.HasilOrthanc); err != nil {
+			log.Printf("Error scan sent_worklist: %v", err)
+			continue
+		}
+		result[sw.NomorOrder] = sw
+	}
+	return result, rows.Err()
+}
+
+// Simpan StudyInstanceUID yang ditemukan di Orthanc untuk link OHIF
+func (s *sqlStore) UpdateStudyInstanceUID(nomorOrder, studyUID string) {
+	_, err := s.db.Exec(`UPDATE sent_worklist SET study_instance_uid=? WHERE nomor_order=? AND IFNULL(study_instance_uid, '')=''`, studyUID, nomorOrder)
+	if err != nil {
+		log.Printf("Error update study_instance_uid: %v", err)
+	}
+}
+
+// Daftar nomor_order yang sudah terkirim ke modality tapi gambarnya belum diterima
+func (s *sqlStore) GetOrdersAwaitingImages(window time.Duration) ([]string, error) {
+	rows, err := s.db.Query(`SELECT nomor_order FROM sent_worklist WHERE status=? AND tgl_kirim_worklist >= ?`, OrderWorklisted, time.Now().Add(-window))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var orders []string
+	for rows.Next() {
+		var no string
+		if err := rows.Scan(&no); err == nil {
+			orders = append(orders, no)
+		}
+	}
+	return orders, rows.Err()
+}
@@ -0,0 +1,1009 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+)
+
+// Satu langkah perubahan skema DB middleware. Up dijalankan berurutan saat
+// naik versi, Down membatalkannya saat turun versi.
+type migration struct {
+	Version int
+	Name    string
+	Up      []string
+	Down    []string
+}
+
+// Daftar migrasi MySQL, versi harus naik berurutan. Jangan ubah migrasi yang
+// sudah dirilis, tambahkan migrasi baru di akhir beserta padanannya di
+// sqliteMigrations dengan nomor versi yang sama.
+var mysqlMigrations = []migration{
+	{
+		Version: 1,
+		Name:    "sent_worklist dan log_portal awal",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS sent_worklist (
+				id INT AUTO_INCREMENT PRIMARY KEY,
+				nomor_order VARCHAR(64) NOT NULL,
+				worklist TEXT,
+				tgl_masuk_worklist DATETIME NULL,
+				tgl_kirim_worklist DATETIME NULL,
+				tgl_terima_hasil DATETIME NULL,
+				tgl_simpan_hasil DATETIME NULL,
+				hasil_orthanc LONGTEXT,
+				UNIQUE KEY uk_sent_worklist_nomor_order (nomor_order)
+			)`,
+			`CREATE TABLE IF NOT EXISTS log_portal (
+				id INT AUTO_INCREMENT PRIMARY KEY,
+				waktu DATETIME NOT NULL,
+				pesan TEXT,
+				KEY idx_log_portal_waktu (waktu)
+			)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS log_portal`,
+			`DROP TABLE IF EXISTS sent_worklist`,
+		},
+	},
+	{
+		Version: 2,
+		Name:    "status order dan order_event",
+		Up: []string{
+			`ALTER TABLE sent_worklist ADD COLUMN status VARCHAR(20) NULL`,
+			`ALTER TABLE sent_worklist ADD COLUMN study_instance_uid VARCHAR(128) NULL`,
+			`ALTER TABLE sent_worklist ADD COLUMN tgl_gambar_diterima DATETIME NULL`,
+			`ALTER TABLE sent_worklist ADD INDEX idx_sent_worklist_status (status)`,
+			`CREATE TABLE IF NOT EXISTS order_event (
+				id INT AUTO_INCREMENT PRIMARY KEY,
+				nomor_order VARCHAR(64) NOT NULL,
+				dari_status VARCHAR(20) NOT NULL,
+				ke_status VARCHAR(20) NOT NULL,
+				waktu DATETIME NOT NULL,
+				keterangan TEXT,
+				KEY idx_order_event_nomor_order (nomor_order, waktu)
+			)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS order_event`,
+			`ALTER TABLE sent_worklist DROP INDEX idx_sent_worklist_status`,
+			`ALTER TABLE sent_worklist DROP COLUMN tgl_gambar_diterima`,
+			`ALTER TABLE sent_worklist DROP COLUMN study_instance_uid`,
+			`ALTER TABLE sent_worklist DROP COLUMN status`,
+		},
+	},
+	{
+		Version: 3,
+		Name:    "log_portal terstruktur",
+		Up: []string{
+			`ALTER TABLE log_portal ADD COLUMN level VARCHAR(10) NULL`,
+			`ALTER TABLE log_portal ADD COLUMN komponen VARCHAR(20) NULL`,
+			`ALTER TABLE log_portal ADD COLUMN accession VARCHAR(64) NULL`,
+			`ALTER TABLE log_portal ADD COLUMN pasien VARCHAR(64) NULL`,
+			`ALTER TABLE log_portal ADD COLUMN error TEXT NULL`,
+			`ALTER TABLE log_portal ADD INDEX idx_log_portal_accession (accession)`,
+		},
+		Down: []string{
+			`ALTER TABLE log_portal DROP INDEX idx_log_portal_accession`,
+			`ALTER TABLE log_portal DROP COLUMN error`,
+			`ALTER TABLE log_portal DROP COLUMN pasien`,
+			`ALTER TABLE log_portal DROP COLUMN accession`,
+			`ALTER TABLE log_portal DROP COLUMN komponen`,
+			`ALTER TABLE log_portal DROP COLUMN level`,
+		},
+	},
+	{
+		Version: 4,
+		Name:    "pengguna dan sesi portal",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS portal_user (
+				id INT AUTO_INCREMENT PRIMARY KEY,
+				username VARCHAR(64) NOT NULL,
+				password_hash VARCHAR(100) NULL,
+				nama VARCHAR(100) NULL,
+				role VARCHAR(20) NOT NULL,
+				aktif TINYINT(1) NOT NULL DEFAULT 1,
+				dibuat DATETIME NOT NULL,
+				UNIQUE KEY uk_portal_user_username (username)
+			)`,
+			`CREATE TABLE IF NOT EXISTS portal_session (
+				token VARCHAR(64) PRIMARY KEY,
+				user_id INT NOT NULL,
+				csrf_token VARCHAR(64) NOT NULL,
+				dibuat DATETIME NOT NULL,
+				kadaluarsa DATETIME NOT NULL,
+				KEY idx_portal_session_user (user_id)
+			)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS portal_session`,
+			`DROP TABLE IF EXISTS portal_user`,
+		},
+	},
+	{
+		Version: 5,
+		Name:    "riwayat gangguan komponen",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS health_outage (
+				id INT AUTO_INCREMENT PRIMARY KEY,
+				komponen VARCHAR(32) NOT NULL,
+				mulai DATETIME NOT NULL,
+				selesai DATETIME NULL,
+				pesan TEXT,
+				KEY idx_health_outage_komponen (komponen, selesai)
+			)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS health_outage`,
+		},
+	},
+	{
+		Version: 6,
+		Name:    "payload webhook SR",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS webhook_payload (
+				id INT AUTO_INCREMENT PRIMARY KEY,
+				diterima DATETIME NOT NULL,
+				body LONGTEXT NOT NULL,
+				KEY idx_webhook_payload_diterima (diterima)
+			)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS webhook_payload`,
+		},
+	},
+	{
+		Version: 7,
+		Name:    "worklist baca radiolog",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS reading_item (
+				nomor_order VARCHAR(64) NOT NULL PRIMARY KEY,
+				study_instance_uid VARCHAR(128) NULL,
+				nama_pasien VARCHAR(128) NULL,
+				pemeriksaan VARCHAR(255) NULL,
+				modality VARCHAR(16) NULL,
+				unit VARCHAR(64) NULL,
+				prioritas VARCHAR(16) NOT NULL DEFAULT 'rutin',
+				status VARCHAR(16) NOT NULL DEFAULT 'unread',
+				radiolog VARCHAR(64) NULL,
+				dibuat DATETIME NOT NULL,
+				ditugaskan DATETIME NULL,
+				dibaca DATETIME NULL,
+				pembaca VARCHAR(128) NULL,
+				KEY idx_reading_item_status (status, radiolog)
+			)`,
+			`CREATE TABLE IF NOT EXISTS reading_rule (
+				id INT AUTO_INCREMENT PRIMARY KEY,
+				urutan INT NOT NULL DEFAULT 0,
+				modality VARCHAR(16) NOT NULL DEFAULT '',
+				unit VARCHAR(64) NOT NULL DEFAULT '',
+				radiolog VARCHAR(255) NOT NULL,
+				giliran INT NOT NULL DEFAULT 0
+			)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS reading_rule`,
+			`DROP TABLE IF EXISTS reading_item`,
+		},
+	},
+	{
+		Version: 8,
+		Name:    "laporan radiolog",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS report (
+				nomor_order VARCHAR(64) NOT NULL PRIMARY KEY,
+				temuan TEXT NULL,
+				kesan TEXT NULL,
+				status VARCHAR(16) NOT NULL DEFAULT 'draft',
+				penulis VARCHAR(64) NULL,
+				diubah DATETIME NOT NULL,
+				final_pada DATETIME NULL,
+				sr_instance VARCHAR(64) NULL
+			)`,
+			`CREATE TABLE IF NOT EXISTS report_template (
+				kd_jenis_prw VARCHAR(32) NOT NULL PRIMARY KEY,
+				nama VARCHAR(128) NOT NULL,
+				temuan TEXT NULL,
+				kesan TEXT NULL,
+				diubah DATETIME NOT NULL
+			)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS report_template`,
+			`DROP TABLE IF EXISTS report`,
+		},
+	},
+	{
+		Version: 9,
+		Name:    "template dan makro laporan",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS report_template_baru (
+				id INT AUTO_INCREMENT PRIMARY KEY,
+				nama VARCHAR(128) NOT NULL,
+				kd_jenis_prw VARCHAR(32) NOT NULL DEFAULT '',
+				modality VARCHAR(16) NOT NULL DEFAULT '',
+				temuan TEXT NULL,
+				kesan TEXT NULL,
+				diubah DATETIME NOT NULL,
+				KEY idx_report_template_kd (kd_jenis_prw),
+				KEY idx_report_template_modality (modality)
+			)`,
+			`INSERT INTO report_template_baru (nama, kd_jenis_prw, temuan, kesan, diubah)
+				SELECT nama, kd_jenis_prw, temuan, kesan, diubah FROM report_template`,
+			`DROP TABLE report_template`,
+			`ALTER TABLE report_template_baru RENAME TO report_template`,
+			`CREATE TABLE IF NOT EXISTS report_macro (
+				id INT AUTO_INCREMENT PRIMARY KEY,
+				kode VARCHAR(32) NOT NULL,
+				nama VARCHAR(128) NOT NULL,
+				modality VARCHAR(16) NOT NULL DEFAULT '',
+				temuan TEXT NULL,
+				kesan TEXT NULL,
+				diubah DATETIME NOT NULL,
+				UNIQUE KEY uk_report_macro_kode (kode)
+			)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS report_macro`,
+			`CREATE TABLE IF NOT EXISTS report_template_lama (
+				kd_jenis_prw VARCHAR(32) NOT NULL PRIMARY KEY,
+				nama VARCHAR(128) NOT NULL,
+				temuan TEXT NULL,
+				kesan TEXT NULL,
+				diubah DATETIME NOT NULL
+			)`,
+			`INSERT INTO report_template_lama (kd_jenis_prw, nama, temuan, kesan, diubah)
+				SELECT t.kd_jenis_prw, t.nama, t.temuan, t.kesan, t.diubah FROM report_template t
+				WHERE t.id IN (SELECT MAX(id) FROM report_template WHERE kd_jenis_prw <> '' GROUP BY kd_jenis_prw)`,
+			`DROP TABLE report_template`,
+			`ALTER TABLE report_template_lama RENAME TO report_template`,
+		},
+	},
+	{
+		Version: 10,
+		Name:    "riwayat versi laporan",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS report_version (
+				id INT AUTO_INCREMENT PRIMARY KEY,
+				nomor_order VARCHAR(64) NOT NULL,
+				versi INT NOT NULL,
+				jenis VARCHAR(16) NOT NULL,
+				teks LONGTEXT NOT NULL,
+				penulis VARCHAR(128) NULL,
+				alasan TEXT NULL,
+				sr_instance VARCHAR(64) NULL,
+				dibuat DATETIME NOT NULL,
+				UNIQUE KEY uk_report_version (nomor_order, versi)
+			)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS report_version`,
+		},
+	},
+	{
+		Version: 11,
+		Name:    "temuan kritis",
+		Up: []string{
+			`ALTER TABLE report ADD COLUMN kritis TINYINT(1) NOT NULL DEFAULT 0`,
+			`CREATE TABLE IF NOT EXISTS critical_finding (
+				id INT AUTO_INCREMENT PRIMARY KEY,
+				nomor_order VARCHAR(64) NOT NULL,
+				sumber VARCHAR(16) NOT NULL,
+				keterangan TEXT NULL,
+				dibuat DATETIME NOT NULL,
+				level INT NOT NULL DEFAULT 1,
+				eskalasi_berikut DATETIME NULL,
+				diakui_oleh VARCHAR(64) NULL,
+				diakui_pada DATETIME NULL,
+				catatan TEXT NULL,
+				UNIQUE KEY uk_critical_finding (nomor_order),
+				KEY idx_critical_finding_eskalasi (eskalasi_berikut)
+			)`,
+			`CREATE TABLE IF NOT EXISTS critical_notification (
+				id INT AUTO_INCREMENT PRIMARY KEY,
+				finding_id INT NOT NULL,
+				level INT NOT NULL,
+				kanal VARCHAR(16) NOT NULL,
+				tujuan VARCHAR(255) NOT NULL,
+				berhasil TINYINT(1) NOT NULL,
+				pesan_error TEXT NULL,
+				dikirim DATETIME NOT NULL,
+				KEY idx_critical_notification_finding (finding_id)
+			)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS critical_notification`,
+			`DROP TABLE IF EXISTS critical_finding`,
+			`ALTER TABLE report DROP COLUMN kritis`,
+		},
+	},
+	{
+		Version: 12,
+		Name:    "antrian notifikasi temuan kritis",
+		Up: []string{
+			`ALTER TABLE critical_finding ADD COLUMN notifikasi_level INT NOT NULL DEFAULT 0`,
+			// Temuan lama sudah dinotifikasi saat dibuat
+			`UPDATE critical_finding SET notifikasi_level = level`,
+		},
+		Down: []string{
+			`ALTER TABLE critical_finding DROP COLUMN notifikasi_level`,
+		},
+	},
+	{
+		Version: 13,
+		Name:    "temuan kritis berulang per order",
+		Up: []string{
+			`ALTER TABLE critical_finding ADD KEY idx_critical_finding_order (nomor_order)`,
+			`ALTER TABLE critical_finding DROP INDEX uk_critical_finding`,
+		},
+		// Gagal bila sudah ada order dengan lebih dari satu temuan
+		Down: []string{
+			`ALTER TABLE critical_finding ADD UNIQUE KEY uk_critical_finding (nomor_order)`,
+			`ALTER TABLE critical_finding DROP INDEX idx_critical_finding_order`,
+		},
+	},
+	{
+		Version: 14,
+		Name:    "waktu permintaan Khanza di sent_worklist",
+		Up: []string{
+			`ALTER TABLE sent_worklist ADD COLUMN tgl_permintaan DATETIME NULL`,
+			`ALTER TABLE sent_worklist ADD INDEX idx_sent_worklist_permintaan (tgl_permintaan)`,
+		},
+		Down: []string{
+			`ALTER TABLE sent_worklist DROP INDEX idx_sent_worklist_permintaan`,
+			`ALTER TABLE sent_worklist DROP COLUMN tgl_permintaan`,
+		},
+	},
+}
+
+// Padanan mysqlMigrations untuk SQLite dengan nomor versi yang sama. Database
+// SQLite selalu dibuat oleh middleware sendiri.
+var sqliteMigrations = []migration{
+	{
+		Version: 1,
+		Name:    "sent_worklist dan log_portal awal",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS sent_worklist (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				nomor_order TEXT NOT NULL UNIQUE,
+				worklist TEXT,
+				tgl_masuk_worklist DATETIME,
+				tgl_kirim_worklist DATETIME,
+				tgl_terima_hasil DATETIME,
+				tgl_simpan_hasil DATETIME,
+				hasil_orthanc TEXT
+			)`,
+			`CREATE TABLE IF NOT EXISTS log_portal (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				waktu DATETIME NOT NULL,
+				pesan TEXT
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_log_portal_waktu ON log_portal (waktu)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS log_portal`,
+			`DROP TABLE IF EXISTS sent_worklist`,
+		},
+	},
+	{
+		Version: 2,
+		Name:    "status order dan order_event",
+		Up: []string{
+			`ALTER TABLE sent_worklist ADD COLUMN status TEXT`,
+			`ALTER TABLE sent_worklist ADD COLUMN study_instance_uid TEXT`,
+			`ALTER TABLE sent_worklist ADD COLUMN tgl_gambar_diterima DATETIME`,
+			`CREATE INDEX IF NOT EXISTS idx_sent_worklist_status ON sent_worklist (status)`,
+			`CREATE TABLE IF NOT EXISTS order_event (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				nomor_order TEXT NOT NULL,
+				dari_status TEXT NOT NULL,
+				ke_status TEXT NOT NULL,
+				waktu DATETIME NOT NULL,
+				keterangan TEXT
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_order_event_nomor_order ON order_event (nomor_order, waktu)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS order_event`,
+			`DROP INDEX IF EXISTS idx_sent_worklist_status`,
+			`ALTER TABLE sent_worklist DROP COLUMN tgl_gambar_diterima`,
+			`ALTER TABLE sent_worklist DROP COLUMN study_instance_uid`,
+			`ALTER TABLE sent_worklist DROP COLUMN status`,
+		},
+	},
+	{
+		Version: 3,
+		Name:    "log_portal terstruktur",
+		Up: []string{
+			`ALTER TABLE log_portal ADD COLUMN level TEXT`,
+			`ALTER TABLE log_portal ADD COLUMN komponen TEXT`,
+			`ALTER TABLE log_portal ADD COLUMN accession TEXT`,
+			`ALTER TABLE log_portal ADD COLUMN pasien TEXT`,
+			`ALTER TABLE log_portal ADD COLUMN error TEXT`,
+			`CREATE INDEX IF NOT EXISTS idx_log_portal_accession ON log_portal (accession)`,
+		},
+		Down: []string{
+			`DROP INDEX IF EXISTS idx_log_portal_accession`,
+			`ALTER TABLE log_portal DROP COLUMN error`,
+			`ALTER TABLE log_portal DROP COLUMN pasien`,
+			`ALTER TABLE log_portal DROP COLUMN accession`,
+			`ALTER TABLE log_portal DROP COLUMN komponen`,
+			`ALTER TABLE log_portal DROP COLUMN level`,
+		},
+	},
+	{
+		Version: 4,
+		Name:    "pengguna dan sesi portal",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS portal_user (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				username TEXT NOT NULL UNIQUE,
+				password_hash TEXT,
+				nama TEXT,
+				role TEXT NOT NULL,
+				aktif INTEGER NOT NULL DEFAULT 1,
+				dibuat DATETIME NOT NULL
+			)`,
+			`CREATE TABLE IF NOT EXISTS portal_session (
+				token TEXT PRIMARY KEY,
+				user_id INTEGER NOT NULL,
+				csrf_token TEXT NOT NULL,
+				dibuat DATETIME NOT NULL,
+				kadaluarsa DATETIME NOT NULL
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_portal_session_user ON portal_session (user_id)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS portal_session`,
+			`DROP TABLE IF EXISTS portal_user`,
+		},
+	},
+	{
+		Version: 5,
+		Name:    "riwayat gangguan komponen",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS health_outage (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				komponen TEXT NOT NULL,
+				mulai DATETIME NOT NULL,
+				selesai DATETIME,
+				pesan TEXT
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_health_outage_komponen ON health_outage (komponen, selesai)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS health_outage`,
+		},
+	},
+	{
+		Version: 6,
+		Name:    "payload webhook SR",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS webhook_payload (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				diterima DATETIME NOT NULL,
+				body TEXT NOT NULL
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_webhook_payload_diterima ON webhook_payload (diterima)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS webhook_payload`,
+		},
+	},
+	{
+		Version: 7,
+		Name:    "worklist baca radiolog",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS reading_item (
+				nomor_order TEXT NOT NULL PRIMARY KEY,
+				study_instance_uid TEXT,
+				nama_pasien TEXT,
+				pemeriksaan TEXT,
+				modality TEXT,
+				unit TEXT,
+				prioritas TEXT NOT NULL DEFAULT 'rutin',
+				status TEXT NOT NULL DEFAULT 'unread',
+				radiolog TEXT,
+				dibuat DATETIME NOT NULL,
+				ditugaskan DATETIME,
+				dibaca DATETIME,
+				pembaca TEXT
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_reading_item_status ON reading_item (status, radiolog)`,
+			`CREATE TABLE IF NOT EXISTS reading_rule (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				urutan INTEGER NOT NULL DEFAULT 0,
+				modality TEXT NOT NULL DEFAULT '',
+				unit TEXT NOT NULL DEFAULT '',
+				radiolog TEXT NOT NULL,
+				giliran INTEGER NOT NULL DEFAULT 0
+			)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS reading_rule`,
+			`DROP TABLE IF EXISTS reading_item`,
+		},
+	},
+	{
+		Version: 8,
+		Name:    "laporan radiolog",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS report (
+				nomor_order TEXT NOT NULL PRIMARY KEY,
+				temuan TEXT,
+				kesan TEXT,
+				status TEXT NOT NULL DEFAULT 'draft',
+				penulis TEXT,
+				diubah DATETIME NOT NULL,
+				final_pada DATETIME,
+				sr_instance TEXT
+			)`,
+			`CREATE TABLE IF NOT EXISTS report_template (
+				kd_jenis_prw TEXT NOT NULL PRIMARY KEY,
+				nama TEXT NOT NULL,
+				temuan TEXT,
+				kesan TEXT,
+				diubah DATETIME NOT NULL
+			)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS report_template`,
+			`DROP TABLE IF EXISTS report`,
+		},
+	},
+	{
+		Version: 9,
+		Name:    "template dan makro laporan",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS report_template_baru (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				nama TEXT NOT NULL,
+				kd_jenis_prw TEXT NOT NULL DEFAULT '',
+				modality TEXT NOT NULL DEFAULT '',
+				temuan TEXT,
+				kesan TEXT,
+				diubah DATETIME NOT NULL
+			)`,
+			`INSERT INTO report_template_baru (nama, kd_jenis_prw, temuan, kesan, diubah)
+				SELECT nama, kd_jenis_prw, temuan, kesan, diubah FROM report_template`,
+			`DROP TABLE report_template`,
+			`ALTER TABLE report_template_baru RENAME TO report_template`,
+			`CREATE INDEX IF NOT EXISTS idx_report_template_kd ON report_template (kd_jenis_prw)`,
+			`CREATE INDEX IF NOT EXISTS idx_report_template_modality ON report_template (modality)`,
+			`CREATE TABLE IF NOT EXISTS report_macro (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				kode TEXT NOT NULL UNIQUE,
+				nama TEXT NOT NULL,
+				modality TEXT NOT NULL DEFAULT '',
+				temuan TEXT,
+				kesan TEXT,
+				diubah DATETIME NOT NULL
+			)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS report_macro`,
+			`CREATE TABLE IF NOT EXISTS report_template_lama (
+				kd_jenis_prw TEXT NOT NULL PRIMARY KEY,
+				nama TEXT NOT NULL,
+				temuan TEXT,
+				kesan TEXT,
+				diubah DATETIME NOT NULL
+			)`,
+			`INSERT INTO report_template_lama (kd_jenis_prw, nama, temuan, kesan, diubah)
+				SELECT t.kd_jenis_prw, t.nama, t.temuan, t.kesan, t.diubah FROM report_template t
+				WHERE t.id IN (SELECT MAX(id) FROM report_template WHERE kd_jenis_prw <> '' GROUP BY kd_jenis_prw)`,
+			`DROP TABLE report_template`,
+			`ALTER TABLE report_template_lama RENAME TO report_template`,
+		},
+	},
+	{
+		Version: 10,
+		Name:    "riwayat versi laporan",
+		Up: []string{
+			`CREATE TABLE IF NOT EXISTS report_version (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				nomor_order TEXT NOT NULL,
+				versi INTEGER NOT NULL,
+				jenis TEXT NOT NULL,
+				teks TEXT NOT NULL,
+				penulis TEXT,
+				alasan TEXT,
+				sr_instance TEXT,
+				dibuat DATETIME NOT NULL,
+				UNIQUE (nomor_order, versi)
+			)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS report_version`,
+		},
+	},
+	{
+		Version: 11,
+		Name:    "temuan kritis",
+		Up: []string{
+			`ALTER TABLE report ADD COLUMN kritis INTEGER NOT NULL DEFAULT 0`,
+			`CREATE TABLE IF NOT EXISTS critical_finding (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				nomor_order TEXT NOT NULL UNIQUE,
+				sumber TEXT NOT NULL,
+				keterangan TEXT,
+				dibuat DATETIME NOT NULL,
+				level INTEGER NOT NULL DEFAULT 1,
+				eskalasi_berikut DATETIME,
+				diakui_oleh TEXT,
+				diakui_pada DATETIME,
+				catatan TEXT
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_critical_finding_eskalasi ON critical_finding (eskalasi_berikut)`,
+			`CREATE TABLE IF NOT EXISTS critical_notification (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				finding_id INTEGER NOT NULL,
+				level INTEGER NOT NULL,
+				kanal TEXT NOT NULL,
+				tujuan TEXT NOT NULL,
+				berhasil INTEGER NOT NULL,
+				pesan_error TEXT,
+				dikirim DATETIME NOT NULL
+			)`,
+			`CREATE INDEX IF NOT EXISTS idx_critical_notification_finding ON critical_notification (finding_id)`,
+		},
+		Down: []string{
+			`DROP TABLE IF EXISTS critical_notification`,
+			`DROP TABLE IF EXISTS critical_finding`,
+			`ALTER TABLE report DROP COLUMN kritis`,
+		},
+	},
+	{
+		Version: 12,
+		Name:    "antrian notifikasi temuan kritis",
+		Up: []string{
+			`ALTER TABLE critical_finding ADD COLUMN notifikasi_level INTEGER NOT NULL DEFAULT 0`,
+			// Temuan lama sudah dinotifikasi saat dibuat
+			`UPDATE critical_finding SET notifikasi_level = level`,
+		},
+		Down: []string{
+			`ALTER TABLE critical_finding DROP COLUMN notifikasi_level`,
+		},
+	},
+	{
+		Version: 13,
+		Name:    "temuan kritis berulang per order",
+		// UNIQUE kolom SQLite tidak bisa di-drop, tabel dibangun ulang
+		Up: []string{
+			`CREATE TABLE critical_finding_baru (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				nomor_order TEXT NOT NULL,
+				sumber TEXT NOT NULL,
+				keterangan TEXT,
+				dibuat DATETIME NOT NULL,
+				level INTEGER NOT NULL DEFAULT 1,
+				eskalasi_berikut DATETIME,
+				diakui_oleh TEXT,
+				diakui_pada DATETIME,
+				catatan TEXT,
+				notifikasi_level INTEGER NOT NULL DEFAULT 0
+			)`,
+			`INSERT INTO critical_finding_baru SELECT id, nomor_order, sumber, keterangan, dibuat, level, eskalasi_berikut,
+				diakui_oleh, diakui_pada, catatan, notifikasi_level FROM critical_finding`,
+			`DROP TABLE critical_finding`,
+			`ALTER TABLE critical_finding_baru RENAME TO critical_finding`,
+			`CREATE INDEX IF NOT EXISTS idx_critical_finding_eskalasi ON critical_finding (eskalasi_berikut)`,
+			`CREATE INDEX IF NOT EXISTS idx_critical_finding_order ON critical_finding (nomor_order)`,
+		},
+		// Gagal bila sudah ada order dengan lebih dari satu temuan
+		Down: []string{
+			`CREATE TABLE critical_finding_lama (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				nomor_order TEXT NOT NULL UNIQUE,
+				sumber TEXT NOT NULL,
+				keterangan TEXT,
+				dibuat DATETIME NOT NULL,
+				level INTEGER NOT NULL DEFAULT 1,
+				eskalasi_berikut DATETIME,
+				diakui_oleh TEXT,
+				diakui_pada DATETIME,
+				catatan TEXT,
+				notifikasi_level INTEGER NOT NULL DEFAULT 0
+			)`,
+			`INSERT INTO critical_finding_lama SELECT id, nomor_order, sumber, keterangan, dibuat, level, eskalasi_berikut,
+				diakui_oleh, diakui_pada, catatan, notifikasi_level FROM critical_finding`,
+			`DROP TABLE critical_finding`,
+			`ALTER TABLE critical_finding_lama RENAME TO critical_finding`,
+			`CREATE INDEX IF NOT EXISTS idx_critical_finding_eskalasi ON critical_finding (eskalasi_berikut)`,
+		},
+	},
+	{
+		Version: 14,
+		Name:    "waktu permintaan Khanza di sent_worklist",
+		Up: []string{
+			`ALTER TABLE sent_worklist ADD COLUMN tgl_permintaan DATETIME`,
+			`CREATE INDEX IF NOT EXISTS idx_sent_worklist_permintaan ON sent_worklist (tgl_permintaan)`,
+		},
+		Down: []string{
+			`DROP INDEX IF EXISTS idx_sent_worklist_permintaan`,
+			`ALTER TABLE sent_worklist DROP COLUMN tgl_permintaan`,
+		},
+	},
+}
+
+// Nama kunci GET_LOCK MySQL yang dipegang selama migrasi berjalan
+const migrationLockName = "middleware_migrasi"
+
+// Tunggu maksimal sekian detik bila instance lain sedang migrasi
+const migrationLockTimeout = 60
+
+// schema_version mencatat migrasi yang selesai, schema_migration_step mencatat
+// langkah yang sudah berhasil dari migrasi yang belum selesai. Di MySQL DDL
+// tidak bisa di-rollback, jadi migrasi yang gagal di tengah dilanjutkan dari
+// langkah yang gagal saat dijalankan lagi.
+func ensureSchemaVersionTable(conn *sql.Conn) error {
+	ctx := context.Background()
+	if _, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_version (
+		version INT PRIMARY KEY,
+		nama VARCHAR(100) NOT NULL,
+		dijalankan DATETIME NOT NULL
+	)`); err != nil {
+		return err
+	}
+	_, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migration_step (
+		version INT NOT NULL,
+		arah VARCHAR(4) NOT NULL,
+		langkah INT NOT NULL,
+		dijalankan DATETIME NOT NULL,
+		PRIMARY KEY (version, arah, langkah)
+	)`)
+	return err
+}
+
+func schemaVersion(conn *sql.Conn) (int, error) {
+	if err := ensureSchemaVersionTable(conn); err != nil {
+		return 0, err
+	}
+	var version int
+	err := conn.QueryRowContext(context.Background(), "SELECT IFNULL(MAX(version), 0) FROM schema_version").Scan(&version)
+	return version, err
+}
+
+// Versi skema yang terpasang, 0 bila belum pernah migrasi
+func (s *sqlStore) SchemaVersion() (int, error) {
+	conn, err := s.db.Conn(context.Background())
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	return schemaVersion(conn)
+}
+
+// Daftar migrasi untuk driver DB middleware
+func migrationsFor(driver string) []migration {
+	if driver == StoreSQLite {
+		return sqliteMigrations
+	}
+	return mysqlMigrations
+}
+
+func latestSchemaVersion(driver string) int {
+	m := migrationsFor(driver)
+	return m[len(m)-1].Version
+}
+
+// Jalankan fn di satu koneksi yang memegang kunci migrasi agar dua instance
+// yang start bersamaan tidak menerapkan langkah yang sama. MySQL memakai
+// GET_LOCK; SQLite memakai transaksi BEGIN IMMEDIATE sehingga semua langkah
+// dibatalkan bila ada yang gagal.
+func (s *sqlStore) withMigrationLock(fn func(conn *sql.Conn) error) error {
+	ctx := context.Background()
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if s.dialect.driver == StoreSQLite {
+		if _, err := conn.ExecContext(ctx, "BEGIN IMMEDIATE"); err != nil {
+			return fmt.Errorf("gagal mengunci DB untuk migrasi: %v", err)
+		}
+		if err := fn(conn); err != nil {
+			conn.ExecContext(ctx, "ROLLBACK")
+			return err
+		}
+		_, err := conn.ExecContext(ctx, "COMMIT")
+		return err
+	}
+
+	var locked sql.NullInt64
+	if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", migrationLockName, migrationLockTimeout).Scan(&locked); err != nil {
+		return fmt.Errorf("gagal mengambil kunci migrasi: %v", err)
+	}
+	if locked.Int64 != 1 {
+		return fmt.Errorf("kunci migrasi %s masih dipegang instance lain setelah %d detik", migrationLockName, migrationLockTimeout)
+	}
+	// Bukan defer conn.QueryRowContext(...).Scan(...): argumen defer langsung
+	// dievaluasi sehingga kunci akan dilepas sebelum fn berjalan
+	defer func() {
+		conn.QueryRowContext(ctx, "SELECT RELEASE_LOCK(?)", migrationLockName).Scan(&locked)
+	}()
+	return fn(conn)
+}
+
+// Jalankan langkah migrasi berurutan. Langkah yang sudah tercatat berhasil
+// dilewati, langkah baru dicatat segera setelah berhasil.
+func runMigrationSteps(conn *sql.Conn, version int, arah string, steps []string) error {
+	ctx := context.Background()
+	done := map[int]bool{}
+	rows, err := conn.QueryContext(ctx, "SELECT langkah FROM schema_migration_step WHERE version=? AND arah=?", version, arah)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var langkah int
+		if err := rows.Scan(&langkah); err != nil {
+			rows.Close()
+			return err
+		}
+		done[langkah] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i, step := range steps {
+		if done[i+1] {
+			log.Printf("Langkah %d sudah diterapkan sebelumnya", i+1)
+			continue
+		}
+		if _, err := conn.ExecContext(ctx, step); err != nil {
+			return fmt.Errorf("langkah %d: %v", i+1, err)
+		}
+		if _, err := conn.ExecContext(ctx, "INSERT INTO schema_migration_step (version, arah, langkah, dijalankan) VALUES (?, ?, ?, ?)",
+			version, arah, i+1, time.Now()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Naikkan skema ke versi terbaru. Dipanggil saat start dan lewat `migrate up`.
+func (s *sqlStore) MigrateUp() error {
+	return s.withMigrationLock(func(conn *sql.Conn) error {
+		ctx := context.Background()
+		// Dibaca setelah kunci didapat: instance lain mungkin baru selesai migrasi
+		current, err := schemaVersion(conn)
+		if err != nil {
+			return err
+		}
+		for _, m := range s.dialect.schema {
+			if m.Version <= current {
+				continue
+			}
+			log.Printf("Migrasi %d: %s", m.Version, m.Name)
+			if err := runMigrationSteps(conn, m.Version, "up", m.Up); err != nil {
+				return fmt.Errorf("migrasi %d (%s) gagal: %v", m.Version, m.Name, err)
+			}
+			if _, err := conn.ExecContext(ctx, "INSERT INTO schema_version (version, nama, dijalankan) VALUES (?, ?, ?)", m.Version, m.Name, time.Now()); err != nil {
+				return err
+			}
+			if _, err := conn.ExecContext(ctx, "DELETE FROM schema_migration_step WHERE version=?", m.Version); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Turunkan skema sampai versi target dengan menjalankan langkah Down
+func (s *sqlStore) MigrateDown(target int) error {
+	return s.withMigrationLock(func(conn *sql.Conn) error {
+		ctx := context.Background()
+		migrations := s.dialect.schema
+		current, err := schemaVersion(conn)
+		if err != nil {
+			return err
+		}
+		if target < 0 || target > current {
+			return fmt.Errorf("versi target %d tidak valid, versi sekarang %d", target, current)
+		}
+		for i := len(migrations) - 1; i >= 0; i-- {
+			m := migrations[i]
+			if m.Version > current || m.Version <= target {
+				continue
+			}
+			log.Printf("Batalkan migrasi %d: %s", m.Version, m.Name)
+			if err := runMigrationSteps(conn, m.Version, "down", m.Down); err != nil {
+				return fmt.Errorf("pembatalan migrasi %d (%s) gagal: %v", m.Version, m.Name, err)
+			}
+			if _, err := conn.ExecContext(ctx, "DELETE FROM schema_version WHERE version=?", m.Version); err != nil {
+				return err
+			}
+			if _, err := conn.ExecContext(ctx, "DELETE FROM schema_migration_step WHERE version=?", m.Version); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Tandai skema sudah di versi tertentu tanpa menjalankan langkah apa pun,
+// untuk database lama yang tabelnya sudah dibuat manual
+func (s *sqlStore) ForceSchemaVersion(version int) error {
+	latest := s.dialect.schema[len(s.dialect.schema)-1].Version
+	if version < 0 || version > latest {
+		return fmt.Errorf("versi %d tidak valid, versi terbaru %d", version, latest)
+	}
+	return s.withMigrationLock(func(conn *sql.Conn) error {
+		ctx := context.Background()
+		if err := ensureSchemaVersionTable(conn); err != nil {
+			return err
+		}
+		for _, stmt := range []string{"DELETE FROM schema_version", "DELETE FROM schema_migration_step"} {
+			if _, err := conn.ExecContext(ctx, stmt); err != nil {
+				return err
+			}
+		}
+		for _, m := range s.dialect.schema {
+			if m.Version > version {
+				break
+			}
+			if _, err := conn.ExecContext(ctx, "INSERT INTO schema_version (version, nama, dijalankan) VALUES (?, ?, ?)", m.Version, m.Name, time.Now()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Subcommand: middleware migrate [up | down <versi> | status | force <versi>]
+func runMigrateCommand(mwdb MigrationStore, args []string) error {
+	cmd := "up"
+	if len(args) > 0 {
+		cmd = args[0]
+	}
+	versionArg := func() (int, error) {
+		if len(args) < 2 {
+			return 0, fmt.Errorf("migrate %s membutuhkan nomor versi", cmd)
+		}
+		return strconv.Atoi(args[1])
+	}
+	switch cmd {
+	case "up":
+		if err := mwdb.MigrateUp(); err != nil {
+			return err
+		}
+	case "down":
+		v, err := versionArg()
+		if err != nil {
+			return err
+		}
+		if err := mwdb.MigrateDown(v); err != nil {
+			return err
+		}
+	case "force":
+		v, err := versionArg()
+		if err != nil {
+			return err
+		}
+		if err := mwdb.ForceSchemaVersion(v); err != nil {
+			return err
+		}
+	case "status":
+	default:
+		return fmt.Errorf("perintah migrate tidak dikenal: %s", cmd)
+	}
+	current, err := mwdb.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Versi skema %s: %d (terbaru %d)\n", mwdb.Driver(), current, latestSchemaVersion(mwdb.Driver()))
+	for _, m := range migrationsFor(mwdb.Driver()) {
+		tanda := " "
+		if m.Version <= current {
+			tanda = "x"
+		}
+		fmt.Printf("  [%s] %d %s\n", tanda, m.Version, m.Name)
+	}
+	return nil
+}
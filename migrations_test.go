@@ -0,0 +1,465 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Test MySQL memakai database MySQL/MariaDB kosong sekali pakai, misalnya:
+//
+//	docker run -d --rm -e MARIADB_ROOT_PASSWORD=uji -e MARIADB_DATABASE=mw_uji -p 3307:3306 mariadb:10.11
+//	MIDDLEWARE_TEST_MYSQL_DSN='root:uji@tcp(127.0.0.1:3307)/mw_uji?parseTime=true&loc=Local' go test -run MySQL
+//
+// Semua tabel di database itu dihapus di akhir test.
+const testMySQLEnv = "MIDDLEWARE_TEST_MYSQL_DSN"
+
+func openTestMySQL(t *testing.T) *sql.DB {
+	dsn := os.Getenv(testMySQLEnv)
+	if dsn == "" {
+		t.Skipf("%s kosong, test MySQL dilewati", testMySQLEnv)
+	}
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		rows, err := db.Query("SELECT table_name FROM information_schema.tables WHERE table_schema = DATABASE()")
+		if err == nil {
+			var tables []string
+			for rows.Next() {
+				var name string
+				rows.Scan(&name)
+				tables = append(tables, name)
+			}
+			rows.Close()
+			for _, name := range tables {
+				db.Exec("DROP TABLE IF EXISTS `" + name + "`")
+			}
+		}
+		db.Close()
+	})
+	return db
+}
+
+// Daftar tabel selain tabel pencatat migrasi
+func storeTables(t *testing.T, store Store) []string {
+	db := store.(*sqlStore).db
+	query := "SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%'"
+	if store.Driver() == StoreMySQL {
+		query = "SELECT table_name FROM information_schema.tables WHERE table_schema = DATABASE()"
+	}
+	rows, err := db.Query(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var tables []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal(err)
+		}
+		if name != "schema_version" && name != "schema_migration_step" {
+			tables = append(tables, name)
+		}
+	}
+	return tables
+}
+
+func expectSchemaVersion(t *testing.T, store Store, want int) {
+	t.Helper()
+	got, err := store.SchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Fatalf("versi skema %d, seharusnya %d", got, want)
+	}
+}
+
+// Naik ke versi terbaru, turun satu per satu sampai 0, lalu naik lagi. Down
+// harus mengembalikan skema persis sehingga Up bisa diulang tanpa error.
+func testMigrateUpDown(t *testing.T, store Store) {
+	latest := latestSchemaVersion(store.Driver())
+	if err := store.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+	expectSchemaVersion(t, store, latest)
+	if err := store.MigrateUp(); err != nil {
+		t.Fatalf("migrate up kedua: %v", err)
+	}
+	if err := store.MigrateDown(latest + 1); err == nil {
+		t.Fatal("target di atas versi sekarang seharusnya ditolak")
+	}
+	for target := latest - 1; target >= 0; target-- {
+		if err := store.MigrateDown(target); err != nil {
+			t.Fatal(err)
+		}
+		expectSchemaVersion(t, store, target)
+	}
+	if tables := storeTables(t, store); len(tables) != 0 {
+		t.Fatalf("tabel tersisa setelah migrate down 0: %v", tables)
+	}
+	if err := store.MigrateUp(); err != nil {
+		t.Fatalf("migrate up setelah down: %v", err)
+	}
+	expectSchemaVersion(t, store, latest)
+}
+
+func TestMigrateUpDownSQLite(t *testing.T) {
+	testMigrateUpDown(t, openMemoryStore(t))
+}
+
+func TestMigrateUpDownMySQL(t *testing.T) {
+	store := NewMySQLStore(openTestMySQL(t))
+	testMigrateUpDown(t, store)
+	if err := store.MigrateDown(0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Dua instance start bersamaan: migrasi hanya diterapkan sekali
+func testMigrateConcurrent(t *testing.T, a, b Store) {
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i, store := range []Store{a, b} {
+		wg.Add(1)
+		go func(i int, store Store) {
+			defer wg.Done()
+			errs[i] = store.MigrateUp()
+		}(i, store)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectSchemaVersion(t, a, latestSchemaVersion(a.Driver()))
+	var n int
+	if err := a.(*sqlStore).db.QueryRow("SELECT COUNT(*) FROM schema_version").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if want := len(migrationsFor(a.Driver())); n != want {
+		t.Fatalf("%d baris schema_version, seharusnya %d", n, want)
+	}
+}
+
+func TestMigrateConcurrentSQLite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "middleware.db")
+	var stores []Store
+	for i := 0; i < 2; i++ {
+		db, err := openSQLite(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+		stores = append(stores, NewSQLiteStore(db))
+	}
+	testMigrateConcurrent(t, stores[0], stores[1])
+}
+
+func TestMigrateConcurrentMySQL(t *testing.T) {
+	db := openTestMySQL(t)
+	other, err := sql.Open("mysql", os.Getenv(testMySQLEnv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	testMigrateConcurrent(t, NewMySQLStore(db), NewMySQLStore(other))
+}
+
+// Migrasi uji dengan langkah ketiga yang gagal selama tabel induk belum ada
+func failingMigrations() []migration {
+	return []migration{{
+		Version: 1,
+		Name:    "uji langkah gagal",
+		Up: []string{
+			"CREATE TABLE uji_a (id INT)",
+			"CREATE TABLE uji_b (id INT)",
+			"ALTER TABLE uji_induk ADD COLUMN catatan VARCHAR(20)",
+		},
+		Down: []string{"DROP TABLE uji_b", "DROP TABLE uji_a"},
+	}}
+}
+
+// SQLite: langkah yang gagal membatalkan seluruh migrasi dalam transaksi
+func TestMigrateFailedStepSQLite(t *testing.T) {
+	dialect := sqliteDialect
+	dialect.schema = failingMigrations()
+	store := &sqlStore{db: openMemoryStore(t).(*sqlStore).db, dialect: dialect}
+
+	err := store.MigrateUp()
+	if err == nil || !strings.Contains(err.Error(), "langkah 3") {
+		t.Fatalf("migrasi seharusnya gagal di langkah 3, dapat %v", err)
+	}
+	expectSchemaVersion(t, store, 0)
+	if tables := storeTables(t, store); len(tables) != 0 {
+		t.Fatalf("langkah yang berhasil seharusnya di-rollback, tersisa %v", tables)
+	}
+	if _, err := store.db.Exec("CREATE TABLE uji_induk (id INT)"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+	expectSchemaVersion(t, store, 1)
+}
+
+// MySQL: DDL tidak bisa di-rollback, jadi migrasi dilanjutkan dari langkah
+// yang gagal tanpa menganggap error "sudah ada" sebagai sukses
+func TestMigrateResumeMySQL(t *testing.T) {
+	dialect := mysqlDialect
+	dialect.schema = failingMigrations()
+	store := &sqlStore{db: openTestMySQL(t), dialect: dialect}
+
+	err := store.MigrateUp()
+	if err == nil || !strings.Contains(err.Error(), "langkah 3") {
+		t.Fatalf("migrasi seharusnya gagal di langkah 3, dapat %v", err)
+	}
+	expectSchemaVersion(t, store, 0)
+	var n int
+	if err := store.db.QueryRow("SELECT COUNT(*) FROM schema_migration_step WHERE version = 1").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("%d langkah tercatat, seharusnya 2", n)
+	}
+	if _, err := store.db.Exec("CREATE TABLE uji_induk (id INT)"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.MigrateUp(); err != nil {
+		t.Fatalf("migrasi lanjutan: %v", err)
+	}
+	expectSchemaVersion(t, store, 1)
+	if err := store.MigrateDown(0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Versi force di luar 0..terbaru ditolak sebelum schema_version disentuh
+func TestForceSchemaVersionInvalid(t *testing.T) {
+	store := openMemoryStore(t)
+	if err := store.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+	latest := latestSchemaVersion(store.Driver())
+	for _, v := range []int{-1, latest + 1} {
+		if err := runMigrateCommand(store, []string{"force", strconv.Itoa(v)}); err == nil {
+			t.Fatalf("migrate force %d seharusnya ditolak", v)
+		}
+		expectSchemaVersion(t, store, latest)
+	}
+	if err := store.ForceSchemaVersion(0); err != nil {
+		t.Fatal(err)
+	}
+	expectSchemaVersion(t, store, 0)
+}
+
+// Driver uji yang meniru GET_LOCK/RELEASE_LOCK MySQL di atas SQLite: kunci
+// dipegang per koneksi, koneksi lain menunggu paling lama wait lalu dapat 0.
+// Dipakai untuk menjalankan jalur migrasi MySQL (kunci bernama, langkah tanpa
+// transaksi) tanpa server MySQL. DDL MySQL sendiri tetap hanya diuji lewat
+// MIDDLEWARE_TEST_MYSQL_DSN.
+type namedLocks struct {
+	mu      sync.Mutex
+	owner   map[string]*namedLockConn
+	wait    time.Duration
+	queries []string
+}
+
+type namedLockDriver struct {
+	driver.Driver
+	locks *namedLocks
+}
+
+type namedLockConn struct {
+	driver.Conn
+	locks *namedLocks
+}
+
+type namedLockStmt struct {
+	conn  *namedLockConn
+	query string
+}
+
+var namedLockSeq int
+
+func openNamedLockStore(t *testing.T, path string, locks *namedLocks) *sqlStore {
+	base, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	drv := base.Driver()
+	base.Close()
+	namedLockSeq++
+	name := "sqlite_namedlock_" + strconv.Itoa(namedLockSeq)
+	sql.Register(name, namedLockDriver{drv, locks})
+	db, err := sql.Open(name, "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_time_format=sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	dialect := mysqlDialect
+	dialect.lockSuffix = ""
+	return &sqlStore{db: db, dialect: dialect}
+}
+
+func (d namedLockDriver) Open(name string) (driver.Conn, error) {
+	c, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &namedLockConn{c, d.locks}, nil
+}
+
+func (c *namedLockConn) Prepare(query string) (driver.Stmt, error) {
+	if strings.HasPrefix(query, "SELECT GET_LOCK") || strings.HasPrefix(query, "SELECT RELEASE_LOCK") {
+		return &namedLockStmt{c, query}, nil
+	}
+	c.locks.mu.Lock()
+	c.locks.queries = append(c.locks.queries, query)
+	c.locks.mu.Unlock()
+	return c.Conn.Prepare(query)
+}
+
+func (s *namedLockStmt) Close() error  { return nil }
+func (s *namedLockStmt) NumInput() int { return strings.Count(s.query, "?") }
+func (s *namedLockStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, fmt.Errorf("%s hanya lewat Query", s.query)
+}
+
+func (s *namedLockStmt) Query(args []driver.Value) (driver.Rows, error) {
+	locks, name := s.conn.locks, valueString(args[0])
+	locks.mu.Lock()
+	defer locks.mu.Unlock()
+	locks.queries = append(locks.queries, s.query)
+	if strings.HasPrefix(s.query, "SELECT RELEASE_LOCK") {
+		if locks.owner[name] != s.conn {
+			return &namedLockRows{val: int64(0)}, nil
+		}
+		delete(locks.owner, name)
+		return &namedLockRows{val: int64(1)}, nil
+	}
+	deadline := time.Now().Add(locks.wait)
+	for locks.owner[name] != nil && locks.owner[name] != s.conn {
+		if time.Now().After(deadline) {
+			return &namedLockRows{val: int64(0)}, nil
+		}
+		locks.mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		locks.mu.Lock()
+	}
+	locks.owner[name] = s.conn
+	return &namedLockRows{val: int64(1)}, nil
+}
+
+type namedLockRows struct {
+	val  driver.Value
+	done bool
+}
+
+func (r *namedLockRows) Columns() []string { return []string{"hasil"} }
+func (r *namedLockRows) Close() error      { return nil }
+func (r *namedLockRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = r.val
+	return nil
+}
+
+// Jalur MySQL: kunci diambil dan dilepas, langkah yang sudah tercatat di
+// schema_migration_step dilewati saat migrasi dilanjutkan
+func TestMigrateMySQLLockAndResume(t *testing.T) {
+	locks := &namedLocks{owner: map[string]*namedLockConn{}, wait: time.Second}
+	store := openNamedLockStore(t, filepath.Join(t.TempDir(), "middleware.db"), locks)
+	store.dialect.schema = failingMigrations()
+
+	err := store.MigrateUp()
+	if err == nil || !strings.Contains(err.Error(), "langkah 3") {
+		t.Fatalf("migrasi seharusnya gagal di langkah 3, dapat %v", err)
+	}
+	if len(locks.owner) != 0 {
+		t.Fatal("kunci migrasi tidak dilepas setelah migrasi gagal")
+	}
+	expectSchemaVersion(t, store, 0)
+	var n int
+	if err := store.db.QueryRow("SELECT COUNT(*) FROM schema_migration_step WHERE version = 1 AND arah = 'up'").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("%d langkah tercatat, seharusnya 2", n)
+	}
+
+	if _, err := store.db.Exec("CREATE TABLE uji_induk (id INT)"); err != nil {
+		t.Fatal(err)
+	}
+	locks.queries = nil
+	if err := store.MigrateUp(); err != nil {
+		t.Fatalf("migrasi lanjutan: %v", err)
+	}
+	expectSchemaVersion(t, store, 1)
+	for _, q := range locks.queries {
+		if strings.HasPrefix(q, "CREATE TABLE uji_") {
+			t.Fatalf("langkah yang sudah tercatat dijalankan ulang: %s", q)
+		}
+	}
+	want := []string{"SELECT GET_LOCK(?, ?)", "ALTER TABLE uji_induk", "SELECT RELEASE_LOCK(?)"}
+	i := 0
+	for _, q := range locks.queries {
+		if i < len(want) && strings.HasPrefix(q, want[i]) {
+			i++
+		}
+	}
+	if i != len(want) {
+		t.Fatalf("urutan query %v, seharusnya memuat %v", locks.queries, want)
+	}
+	if err := store.db.QueryRow("SELECT COUNT(*) FROM schema_migration_step").Scan(&n); err != nil || n != 0 {
+		t.Fatalf("catatan langkah seharusnya dihapus setelah migrasi selesai: %d %v", n, err)
+	}
+}
+
+// Kunci dipegang instance lain: migrasi menyerah tanpa menjalankan langkah
+func TestMigrateMySQLLockBusy(t *testing.T) {
+	locks := &namedLocks{owner: map[string]*namedLockConn{}, wait: 50 * time.Millisecond}
+	path := filepath.Join(t.TempDir(), "middleware.db")
+	store := openNamedLockStore(t, path, locks)
+	store.dialect.schema = failingMigrations()
+	locks.owner[migrationLockName] = &namedLockConn{}
+
+	err := store.MigrateUp()
+	if err == nil || !strings.Contains(err.Error(), "masih dipegang") {
+		t.Fatalf("migrasi seharusnya gagal karena kunci dipegang, dapat %v", err)
+	}
+	for _, q := range locks.queries {
+		if strings.HasPrefix(q, "CREATE") || strings.HasPrefix(q, "ALTER") {
+			t.Fatalf("query dijalankan tanpa kunci: %s", q)
+		}
+	}
+	if err := store.ForceSchemaVersion(1); err == nil {
+		t.Fatal("force seharusnya juga menunggu kunci")
+	}
+
+	delete(locks.owner, migrationLockName)
+	if _, err := store.db.Exec("CREATE TABLE uji_induk (id INT)"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+	expectSchemaVersion(t, store, 1)
+}
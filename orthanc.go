@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -9,6 +10,8 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
+	"time"
 )
 
 type DicomWorklist struct {
@@ -24,10 +27,8 @@ type OrthancStudy struct {
 }
 
 func SendWorklistToOrthanc(cfg Config, wl WorklistRequest) error {
-	dir := os.Getenv("FOLDER_WORKLIST")
-	if dir == "" {
-		dir = "./worklists"
-	}
+	defer observeStage(StageWorklistSend, time.Now())
+	dir := cfg.WorklistFolder
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return fmt.Errorf("gagal membuat folder: %v", err)
 	}
@@ -69,6 +70,7 @@ func SendWorklistToOrthanc(cfg Config, wl WorklistRequest) error {
 
 	// Simpan file txt
 	if err := os.WriteFile(txtPath, []byte(txtContent), 0644); err != nil {
+		metricWorklistFailures.WithLabelValues("write").Inc()
 		return fmt.Errorf("gagal menyimpan file TXT DICOM: %v", err)
 	}
 
@@ -76,9 +78,11 @@ func SendWorklistToOrthanc(cfg Config, wl WorklistRequest) error {
 	cmd := exec.Command("dump2dcm", txtPath, wlPath)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
+		metricWorklistFailures.WithLabelValues("dump2dcm").Inc()
 		return fmt.Errorf("gagal menjalankan dump2dcm: %v\n%s", err, string(output))
 	}
 
+	metricWorklistsGenerated.Inc()
 	log.Printf("✅ Worklist berhasil dibuat: %s", wlPath)
 	return nil
 }
@@ -117,14 +121,31 @@ func GetNewStudiesFromOrthanc(cfg Config) ([]OrthancStudy, error) {
 	return studies, nil
 }
 
+// Isi SR beserta pelaksana pemeriksaan yang tercatat di tag DICOM
+type SRReport struct {
+	Teks string
+	// PerformingPhysicianName, atau VerifyingObserverName bila kosong
+	Dokter string
+	// OperatorsName (radiografer)
+	Operator string
+	// CodeValue dari ConceptCodeSequence item CODE, untuk penanda temuan kritis
+	Kode []string
+}
+
 // Parsing isi Structured Report (SR) dari Orthanc
 func ParseSRContentFromOrthanc(cfg Config, instanceID string) (string, error) {
+	report, err := FetchSRReport(cfg, instanceID)
+	return report.Teks, err
+}
+
+func FetchSRReport(cfg Config, instanceID string) (SRReport, error) {
+	defer observeStage(StageSRParse, time.Now())
 	url := cfg.OrthancURL + "/instances/" + instanceID + "/tags"
 	log.Println("Fetching SR content from:", url)
 
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		return "", err
+		return SRReport{}, err
 	}
 	// Tambahkan autentikasi basic auth
 	req.SetBasicAuth(cfg.OrthancUser, cfg.OrthancPass)
@@ -132,11 +153,11 @@ func ParseSRContentFromOrthanc(cfg Config, instanceID string) (string, error) {
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", err
+		return SRReport{}, err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != 200 {
-		return "", fmt.Errorf("Orthanc error: %s", resp.Status)
+		return SRReport{}, fmt.Errorf("Orthanc error: %s", resp.Status)
 	}
 	bodyBytes, _ := io.ReadAll(resp.Body)
 
@@ -144,10 +165,89 @@ func ParseSRContentFromOrthanc(cfg Config, instanceID string) (string, error) {
 	var tags map[string]interface{}
 	if err := json.Unmarshal(bodyBytes, &tags); err != nil {
 		log.Printf("Gagal decode SR content: %v", err)
-		return "", fmt.Errorf("invalid JSON payload: %v", err)
+		return SRReport{}, fmt.Errorf("invalid JSON payload: %v", err)
+	}
+
+	teks, err := parseSRText(tags)
+	if err != nil {
+		return SRReport{}, err
+	}
+	report := SRReport{
+		Teks:     teks,
+		Dokter:   tagString(tags, "0008,1050"),
+		Operator: tagString(tags, "0008,1070"),
+		Kode:     srCodeValues(tags),
+	}
+	if report.Dokter == "" {
+		// VerifyingObserverSequence (0040,a073) > VerifyingObserverName (0040,a075)
+		if seq, ok := tags["0040,a073"].(map[string]interface{}); ok {
+			if items, ok := seq["Value"].([]interface{}); ok && len(items) > 0 {
+				if item, ok := items[0].(map[string]interface{}); ok {
+					report.Dokter = tagString(item, "0040,a075")
+				}
+			}
+		}
+	}
+	return report, nil
+}
+
+// Nilai string tag dari hasil /tags Orthanc, kosong bila tidak ada
+func tagString(tags map[string]interface{}, key string) string {
+	tag, ok := tags[key].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	switch v := tag["Value"].(type) {
+	case string:
+		return strings.TrimSpace(v)
+	case []interface{}:
+		if len(v) > 0 {
+			if s, ok := v[0].(string); ok {
+				return strings.TrimSpace(s)
+			}
+		}
+	}
+	return ""
+}
+
+// ConceptCodeSequence (0040,a168) > CodeValue dari item CODE di ContentSequence
+func srCodeValues(tags map[string]interface{}) []string {
+	contentSeq, ok := tags["0040,a730"].(map[string]interface{})
+	if !ok {
+		return nil
 	}
+	items, _ := contentSeq["Value"].([]interface{})
+	var kode []string
+	for _, v := range items {
+		item, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		seq, ok := item["0040,a168"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		codes, _ := seq["Value"].([]interface{})
+		for _, c := range codes {
+			if code, ok := c.(map[string]interface{}); ok {
+				if value := tagString(code, "0008,0100"); value != "" {
+					kode = append(kode, value)
+				}
+			}
+		}
+	}
+	return kode
+}
 
-	// Ambil ContentSequence (0040,a730)
+// Judul bagian untuk konsep SR yang dikenal, selain itu memakai CodeMeaning
+var srSectionTitles = map[string]string{
+	"121071": "TEMUAN", // DCM Findings
+	"121073": "KESAN",  // DCM Impression
+}
+
+// Gabungkan semua item TEXT di ContentSequence (0040,a730). SR dengan satu
+// item dikembalikan apa adanya; beberapa item diberi judul bagian.
+func parseSRText(tags map[string]interface{}) (string, error) {
 	contentSeq, ok := tags["0040,a730"].(map[string]interface{})
 	if !ok {
 		return "", fmt.Errorf("ContentSequence (0040,a730) tidak ditemukan")
@@ -156,25 +256,144 @@ func ParseSRContentFromOrthanc(cfg Config, instanceID string) (string, error) {
 	if !ok || len(valArr) == 0 {
 		return "", fmt.Errorf("ContentSequence.Value kosong")
 	}
-	// Ambil item pertama
-	item, ok := valArr[0].(map[string]interface{})
-	if !ok {
-		return "", fmt.Errorf("Item pertama ContentSequence tidak valid")
+	var judul, teks []string
+	for _, v := range valArr {
+		item, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		val, ok := srTextValue(item)
+		if !ok {
+			continue
+		}
+		judul = append(judul, srSectionTitle(item))
+		teks = append(teks, val)
+	}
+	switch len(teks) {
+	case 0:
+		return "", fmt.Errorf("TextValue (0040,a160) tidak ditemukan di ContentSequence")
+	case 1:
+		return teks[0], nil
 	}
-	// Ambil TextValue (0040,a160)
+	var b strings.Builder
+	for i := range teks {
+		if i > 0 {
+			b.WriteString("\n\n")
+		}
+		if judul[i] != "" {
+			b.WriteString(judul[i] + ":\n")
+		}
+		b.WriteString(teks[i])
+	}
+	return b.String(), nil
+}
+
+// TextValue (0040,a160) satu item content, Value bisa string atau []interface{}
+func srTextValue(item map[string]interface{}) (string, bool) {
 	textVal, ok := item["0040,a160"].(map[string]interface{})
 	if !ok {
-		return "", fmt.Errorf("TextValue (0040,a160) tidak ditemukan")
+		return "", false
 	}
-	val, ok := textVal["Value"].(string)
-	if ok {
-		return val, nil
+	if val, ok := textVal["Value"].(string); ok {
+		return val, true
 	}
-	// Kadang Value bisa []interface{}
 	if arr, ok := textVal["Value"].([]interface{}); ok && len(arr) > 0 {
 		if s, ok := arr[0].(string); ok {
-			return s, nil
+			return s, true
 		}
 	}
-	return "", fmt.Errorf("TextValue (0040,a160) tidak ada Value string")
+	return "", false
+}
+
+// ConceptNameCodeSequence (0040,a043) > CodeValue/CodeMeaning
+func srSectionTitle(item map[string]interface{}) string {
+	seq, ok := item["0040,a043"].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	codes, ok := seq["Value"].([]interface{})
+	if !ok || len(codes) == 0 {
+		return ""
+	}
+	code, ok := codes[0].(map[string]interface{})
+	if !ok {
+		return ""
+	}
+	if judul, ok := srSectionTitles[tagString(code, "0008,0100")]; ok {
+		return judul
+	}
+	return strings.ToUpper(tagString(code, "0008,0104"))
+}
+
+// Cari study di Orthanc berdasarkan AccessionNumber, kembalikan StudyInstanceUID jika ada
+func FindStudyByAccession(cfg Config, accession string) (string, error) {
+	study, err := findOrthancStudy(cfg, accession)
+	return study.StudyInstanceUID, err
+}
+
+// Study Orthanc untuk accession: ID resource Orthanc dan StudyInstanceUID
+type orthancStudyRef struct {
+	ID               string
+	StudyInstanceUID string
+}
+
+func findOrthancStudy(cfg Config, accession string) (orthancStudyRef, error) {
+	defer observeStage(StageOrthancFind, time.Now())
+	query := map[string]interface{}{
+		"Level":  "Study",
+		"Query":  map[string]string{"AccessionNumber": accession},
+		"Expand": true,
+	}
+	body, _ := json.Marshal(query)
+	req, err := http.NewRequest("POST", cfg.OrthancURL+"/tools/find", bytes.NewReader(body))
+	if err != nil {
+		return orthancStudyRef{}, err
+	}
+	req.SetBasicAuth(cfg.OrthancUser, cfg.OrthancPass)
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return orthancStudyRef{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return orthancStudyRef{}, fmt.Errorf("Orthanc error: %s", resp.Status)
+	}
+	var studies []struct {
+		ID            string `json:"ID"`
+		MainDicomTags struct {
+			StudyInstanceUID string `json:"StudyInstanceUID"`
+		} `json:"MainDicomTags"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&studies); err != nil {
+		return orthancStudyRef{}, err
+	}
+	if len(studies) == 0 {
+		return orthancStudyRef{}, nil
+	}
+	return orthancStudyRef{ID: studies[0].ID, StudyInstanceUID: studies[0].MainDicomTags.StudyInstanceUID}, nil
+}
+
+// GET ke REST API Orthanc dengan basic auth, accept boleh kosong
+func orthancGet(cfg Config, path, accept string) ([]byte, error) {
+	req, err := http.NewRequest("GET", cfg.OrthancURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(cfg.OrthancUser, cfg.OrthancPass)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("Orthanc %s: %s", path, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
 }
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Penulis PDF minimal untuk laporan radiologi: font standar Helvetica (tidak
+// perlu embed), teks WinAnsi, garis dan gambar JPEG. Cukup untuk kop, isi
+// laporan dan gambar kunci tanpa menambah dependensi.
+
+// Ukuran A4 dalam point
+const (
+	pdfPageWidth  = 595.28
+	pdfPageHeight = 841.89
+)
+
+type pdfImage struct {
+	Data          []byte // JPEG baseline
+	Width, Height int
+	Gray          bool
+}
+
+type pdfDoc struct {
+	pages  []*bytes.Buffer
+	images []pdfImage
+}
+
+func newPDF() *pdfDoc {
+	return &pdfDoc{}
+}
+
+func (d *pdfDoc) AddPage() {
+	d.pages = append(d.pages, &bytes.Buffer{})
+}
+
+func (d *pdfDoc) page() *bytes.Buffer {
+	if len(d.pages) == 0 {
+		d.AddPage()
+	}
+	return d.pages[len(d.pages)-1]
+}
+
+// Teks satu baris; y adalah baseline dari bawah halaman
+func (d *pdfDoc) Text(x, y, size float64, bold bool, s string) {
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	fmt.Fprintf(d.page(), "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, y, pdfEscape(s))
+}
+
+func (d *pdfDoc) Line(x1, y1, x2, y2, width float64) {
+	fmt.Fprintf(d.page(), "%.2f w %.2f %.2f m %.2f %.2f l S\n", width, x1, y1, x2, y2)
+}
+
+// Daftarkan gambar JPEG, hasilnya indeks untuk Image
+func (d *pdfDoc) AddJPEG(img pdfImage) int {
+	d.images = append(d.images, img)
+	return len(d.images) - 1
+}
+
+// Gambar dengan pojok kiri bawah di (x, y)
+func (d *pdfDoc) Image(idx int, x, y, w, h float64) {
+	fmt.Fprintf(d.page(), "q %.2f 0 0 %.2f %.2f %.2f cm /Im%d Do Q\n", w, h, x, y, idx)
+}
+
+// Susun file PDF: katalog, halaman, font, gambar lalu tabel xref
+func (d *pdfDoc) Bytes() []byte {
+	if len(d.pages) == 0 {
+		d.AddPage()
+	}
+	var out bytes.Buffer
+	var offsets []int
+	obj := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+	stream := func(dict string, data []byte) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n<< %s /Length %d >>\nstream\n", len(offsets), dict, len(data))
+		out.Write(data)
+		out.WriteString("\nendstream\nendobj\n")
+	}
+
+	// Nomor objek: 1 katalog, 2 pages, 3-4 font, 5 resources, lalu gambar,
+	// lalu pasangan halaman dan isinya
+	firstImage := 6
+	firstPage := firstImage + len(d.images)
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	obj("<< /Type /Catalog /Pages 2 0 R >>")
+	var kids []string
+	for i := range d.pages {
+		kids = append(kids, fmt.Sprintf("%d 0 R", firstPage+2*i))
+	}
+	obj(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	obj("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	obj("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	var xobjects []string
+	for i := range d.images {
+		xobjects = append(xobjects, fmt.Sprintf("/Im%d %d 0 R", i, firstImage+i))
+	}
+	obj(fmt.Sprintf("<< /Font << /F1 3 0 R /F2 4 0 R >> /XObject << %s >> >>", strings.Join(xobjects, " ")))
+	for _, img := range d.images {
+		colorSpace := "/DeviceRGB"
+		if img.Gray {
+			colorSpace = "/DeviceGray"
+		}
+		stream(fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace %s /BitsPerComponent 8 /Filter /DCTDecode",
+			img.Width, img.Height, colorSpace), img.Data)
+	}
+	for i, p := range d.pages {
+		obj(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Resources 5 0 R /Contents %d 0 R >>",
+			pdfPageWidth, pdfPageHeight, firstPage+2*i+1))
+		stream("", p.Bytes())
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return out.Bytes()
+}
+
+// Ubah teks ke WinAnsi (Latin-1 untuk huruf Indonesia) dan escape string PDF.
+// Karakter di luar Latin-1 diganti '?'.
+func pdfEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '\t':
+			b.WriteString("    ")
+		case r < 0x20 || (r >= 0x7f && r < 0xa0) || r > 0xff:
+			b.WriteByte('?')
+		default:
+			b.WriteByte(byte(r))
+		}
+	}
+	return b.String()
+}
+
+// Lebar glyph Helvetica dan Helvetica-Bold (per 1000 unit) untuk ASCII 32-126
+var (
+	helveticaWidths = [95]int{
+		278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+		556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+		1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+		667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+		333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+		556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+	}
+	helveticaBoldWidths = [95]int{
+		278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+		556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+		975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+		667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+		333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+		611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584,
+	}
+)
+
+// Lebar teks dalam point. Huruf di luar ASCII dihitung selebar huruf n.
+func pdfTextWidth(s string, size float64, bold bool) float64 {
+	widths := &helveticaWidths
+	if bold {
+		widths = &helveticaBoldWidths
+	}
+	total := 0
+	for _, r := range s {
+		if r >= 32 && r <= 126 {
+			total += widths[r-32]
+		} else {
+			total += 556
+		}
+	}
+	return float64(total) * size / 1000
+}
+
+// Pecah paragraf menjadi baris yang muat di lebar tertentu. Baris kosong
+// dipertahankan; kata yang lebih panjang dari satu baris dipotong paksa.
+func pdfWrap(teks string, size, lebar float64, bold bool) []string {
+	var lines []string
+	for _, para := range strings.Split(strings.ReplaceAll(teks, "\r\n", "\n"), "\n") {
+		words := strings.Fields(para)
+		if len(words) == 0 {
+			lines = append(lines, "")
+			continue
+		}
+		line := ""
+		for _, w := range words {
+			for pdfTextWidth(w, size, bold) > lebar {
+				if line != "" {
+					lines = append(lines, line)
+					line = ""
+				}
+				n := len([]rune(w))
+				for n > 1 && pdfTextWidth(string([]rune(w)[:n]), size, bold) > lebar {
+					n--
+				}
+				lines = append(lines, string([]rune(w)[:n]))
+				w = string([]rune(w)[n:])
+			}
+			if w == "" {
+				continue
+			}
+			calon := w
+			if line != "" {
+				calon = line + " " + w
+			}
+			if pdfTextWidth(calon, size, bold) > lebar {
+				lines = append(lines, line)
+				calon = w
+			}
+			line = calon
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
@@ -6,21 +6,36 @@ import (
 	"html/template"
 	"log"
 	"net/http"
+	"net/url"
+	"sort"
 	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 type Worklist struct {
-	AccessionNumber string
-	PatientName     string
-	Modality        string
-	StudyDate       string
+	AccessionNumber   string
+	NoOrder           string
+	PatientName       string
+	Modality          string
+	StudyDate         string
+	Procedure         string
+	Status            string
+	TglKirim          string
+	TglGambarDiterima string
+	TglTerimaHasil    string
+	TglSimpanHasil    string
+	StudyInstanceUID  string
+	OHIFLink          string
 }
 
 type Status struct {
-	KhanzaDB     bool `json:"khanza_db"`
-	MiddlewareDB bool `json:"middleware_db"`
-	Orthanc      bool `json:"orthanc"`
-	OHIF         bool `json:"ohif"`
+	KhanzaDB       bool `json:"khanza_db"`
+	MiddlewareDB   bool `json:"middleware_db"`
+	Orthanc        bool `json:"orthanc"`
+	OHIF           bool `json:"ohif"`
+	WorklistFolder bool `json:"worklist_folder"`
 }
 
 var tmpl = `
@@ -33,23 +48,113 @@ var tmpl = `
         table { border-collapse: collapse; width: 100%; }
         th, td { border: 1px solid #ccc; padding: 8px; text-align: left; }
         th { background: #f0f0f0; }
+        .ok { color: green; font-weight: bold; }
+        .no { color: #aaa; }
+        form { margin-bottom: 20px; }
     </style>
 </head>
 <body>
     <h2>Daftar Worklist Radiologi</h2>
+    <p><a href="/">Dashboard</a></p>
+    {{if .Pesan}}<p><b>{{.Pesan}}</b></p>{{end}}
+    <form method="get" action="/worklist">
+        Tanggal <input type="date" name="date" value="{{.Date}}">
+        Modality <select name="modality">
+            <option value="">Semua</option>
+            {{range .Modalities}}<option value="{{.}}" {{if eq . $.Modality}}selected{{end}}>{{.}}</option>{{end}}
+        </select>
+        Status <select name="status">
+            <option value="">Semua</option>
+            {{range .Statuses}}<option value="{{.}}" {{if eq . $.StatusFilter}}selected{{end}}>{{.}}</option>{{end}}
+        </select>
+        <button type="submit">Filter</button>
+    </form>
+    {{if .CanManage}}
+    <form method="post" action="/worklist/backfill">
+        <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+        Kirim order yang tertinggal dari <input type="date" name="dari" value="{{.Date}}">
+        sampai <input type="date" name="sampai" value="{{.Date}}">
+        <button type="submit">Backfill</button>
+    </form>
+    {{end}}
     <table>
         <tr>
             <th>Accession Number</th>
+            <th>No Order</th>
             <th>Patient Name</th>
             <th>Modality</th>
+            <th>Pemeriksaan</th>
             <th>Study Date</th>
+            <th>Status</th>
+            <th>Pending</th>
+            <th>Terkirim ke Modality</th>
+            <th>Gambar Diterima</th>
+            <th>Hasil Diterima</th>
+            <th>Tersimpan di Khanza</th>
+            <th>OHIF</th>
         </tr>
-        {{range .}}
+        {{range .Worklists}}
         <tr>
-            <td>{{.AccessionNumber}}</td>
+            <td><a href="/order?accession={{.AccessionNumber}}">{{.AccessionNumber}}</a></td>
+            <td>{{.NoOrder}}</td>
             <td>{{.PatientName}}</td>
             <td>{{.Modality}}</td>
+            <td>{{.Procedure}}</td>
             <td>{{.StudyDate}}</td>
+            <td>{{.Status}}</td>
+            <td>{{if eq .Status "ordered"}}<span class='ok'>&#10003;</span>{{else}}<span class='no'>-</span>{{end}}</td>
+            <td>{{if .TglKirim}}<span class='ok'>{{.TglKirim}}</span>{{else}}<span class='no'>-</span>{{end}}</td>
+            <td>{{if .TglGambarDiterima}}<span class='ok'>{{.TglGambarDiterima}}</span>{{else}}<span class='no'>-</span>{{end}}</td>
+            <td>{{if .TglTerimaHasil}}<span class='ok'>{{.TglTerimaHasil}}</span>{{else}}<span class='no'>-</span>{{end}}</td>
+            <td>{{if .TglSimpanHasil}}<span class='ok'>{{.TglSimpanHasil}}</span>{{else}}<span class='no'>-</span>{{end}}</td>
+            <td>{{if .OHIFLink}}<a href="{{.OHIFLink}}" target="_blank">Buka</a>{{end}}</td>
+        </tr>
+        {{end}}
+    </table>
+</body>
+</html>
+`
+
+var timelineTmpl = `
+<!DOCTYPE html>
+<html>
+<head>
+    <title>Timeline Order {{.Accession}}</title>
+    <style>
+        body { font-family: Arial; margin: 40px; }
+        table { border-collapse: collapse; width: 100%; }
+        th, td { border: 1px solid #ccc; padding: 8px; text-align: left; }
+        th { background: #f0f0f0; }
+        .state { background: #eef7ee; }
+    </style>
+</head>
+<body>
+    <h2>Timeline Order {{.Accession}}</h2>
+    <p><a href="/worklist">Daftar Worklist</a> | <a href="/report?accession={{.Accession}}">Laporan</a></p>
+    <p>Status saat ini: <b>{{.Status}}</b></p>
+    {{if .CanResend}}
+    <form method="post" action="/order/resend">
+        <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+        <input type="hidden" name="accession" value="{{.Accession}}">
+        Alasan <input type="text" name="alasan">
+        <button type="submit">Kirim Ulang Worklist</button>
+    </form>
+    {{end}}
+    {{if .CanCancel}}
+    <form method="post" action="/order/cancel">
+        <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+        <input type="hidden" name="accession" value="{{.Accession}}">
+        Alasan <input type="text" name="alasan">
+        <button type="submit">Batalkan Order</button>
+    </form>
+    {{end}}
+    <table>
+        <tr><th>Waktu</th><th>Sumber</th><th>Keterangan</th></tr>
+        {{range .Timeline}}
+        <tr {{if eq .Sumber "state"}}class="state"{{end}}>
+            <td>{{.Waktu.Format "2006-01-02 15:04:05"}}</td>
+            <td>{{.Sumber}}</td>
+            <td>{{.Pesan}}</td>
         </tr>
         {{end}}
     </table>
@@ -67,8 +172,13 @@ var (
 // Fungsi untuk update status dari main.go
 func UpdateStatus(s Status) {
 	statusMutex.Lock()
-	defer statusMutex.Unlock()
+	changed := CurrentStatus != s
 	CurrentStatus = s
+	statusMutex.Unlock()
+	recordStatusMetrics(s)
+	if changed {
+		Bus.Publish(EventStatus, s)
+	}
 }
 
 // Fungsi untuk update worklist dari main.go
@@ -92,34 +202,79 @@ func GetWorklists() []Worklist {
 	return CurrentWorklists
 }
 
-func SavePortalLog(db *sql.DB, msg string) {
-	_, _ = db.Exec("INSERT INTO log_portal (waktu, pesan) VALUES (NOW(), ?)", msg)
-}
-
-func GetPortalLogs(db *sql.DB, limit int) ([]string, error) {
-	rows, err := db.Query("SELECT CONCAT(DATE_FORMAT(waktu, '%Y-%m-%d %H:%i:%s'), ' : ', pesan) FROM log_portal ORDER BY waktu DESC LIMIT ?", limit)
+// Susun tampilan worklist portal dari order Khanza dan data sent_worklist.
+// Pencocokan memakai accession, yang jamnya diambil dari waktu permintaan
+// (queryPendingWorklist), sehingga /worklist?date= untuk tanggal lampau tetap
+// menemukan baris sent_worklist yang dicatat saat order dikirim.
+func BuildWorklistView(cfg Config, mwdb WorklistStore, worklists []WorklistRequest) []Worklist {
+	nomorOrders := make([]string, 0, len(worklists))
+	for _, wl := range worklists {
+		nomorOrders = append(nomorOrders, wl.AccessionNumber)
+	}
+	sent, err := mwdb.GetSentWorklists(nomorOrders)
 	if err != nil {
-		return nil, err
+		log.Printf("Gagal ambil data sent_worklist: %v", err)
+		sent = map[string]SentWorklist{}
 	}
-	defer rows.Close()
-	var logs []string
-	for rows.Next() {
-		var msg string
-		if err := rows.Scan(&msg); err == nil {
-			logs = append(logs, msg)
+
+	views := make([]Worklist, 0, len(worklists))
+	for _, wl := range worklists {
+		v := Worklist{
+			AccessionNumber: wl.AccessionNumber,
+			NoOrder:         wl.PatientID,
+			PatientName:     wl.PatientName,
+			Modality:        wl.Modality,
+			StudyDate:       wl.ScheduledProcedureStepStartDate,
+			Procedure:       wl.RequestedProcedureDescription,
+			Status:          string(OrderOrdered),
+		}
+		if sw, ok := sent[wl.AccessionNumber]; ok {
+			if sw.Status != "" {
+				v.Status = sw.Status
+			} else {
+				v.Status = string(OrderWorklisted)
+			}
+			v.TglKirim = formatTime(sw.TglKirimWorklist)
+			v.TglGambarDiterima = formatTime(sw.TglGambarDiterima)
+			v.TglTerimaHasil = formatTime(sw.TglTerimaHasil)
+			v.TglSimpanHasil = formatTime(sw.TglSimpanHasil)
+			v.StudyInstanceUID = sw.StudyInstanceUID
+			if v.StudyInstanceUID != "" {
+				v.OHIFLink = GenerateOHIFLink(cfg, v.StudyInstanceUID)
+			}
 		}
+		views = append(views, v)
 	}
-	// reverse agar urutan lama ke baru
-	for i, j := 0, len(logs)-1; i < j; i, j = i+1, j-1 {
-		logs[i], logs[j] = logs[j], logs[i]
+	return views
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
+
+// Filter worklist berdasarkan modality dan status (kosong berarti semua)
+func FilterWorklists(worklists []Worklist, modality, status string) []Worklist {
+	filtered := []Worklist{}
+	for _, wl := range worklists {
+		if modality != "" && wl.Modality != modality {
+			continue
+		}
+		if status != "" && wl.Status != status {
+			continue
+		}
+		filtered = append(filtered, wl)
 	}
-	return logs, nil
+	return filtered
 }
 
-func StartPortalServer(db *sql.DB, mwdb *sql.DB) {
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+// Daftarkan seluruh handler portal ke mux
+func RegisterPortalHandlers(mux *http.ServeMux, cfg Config, db *sql.DB, mwdb Store) {
+	mux.HandleFunc("/", RequirePermission(mwdb, PermView, func(w http.ResponseWriter, r *http.Request) {
 		status := GetStatus()
-		logs, _ := GetPortalLogs(mwdb, 200)
+		logs, _ := GetPortalLogs(mwdb, RuntimeConfig().DashboardLogLines)
 		tmpl := `
 <!DOCTYPE html>
 <html>
@@ -137,70 +292,240 @@ func StartPortalServer(db *sql.DB, mwdb *sql.DB) {
 </head>
 <body>
     <h2>Dashboard Monitoring Koneksi</h2>
+    <p>
+        <a href="/worklist">Daftar Worklist</a> | <a href="/reading">Worklist Baca</a> | <a href="/critical">Temuan Kritis</a> | <a href="/analytics">Analitik TAT</a> | <a href="/log">Log</a> | <a href="/health">Kesehatan</a> | <a href="/khanza">Skema Khanza</a>
+        {{if .CanManageUsers}}| <a href="/users">Pengguna</a> | <a href="/config">Konfigurasi</a>{{end}}
+    </p>
+    <form method="post" action="/logout" style="position:absolute; top:20px; right:40px;">
+        {{.User.Nama}} ({{.User.Role}})
+        <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+        <button type="submit">Logout</button>
+    </form>
+    {{template "kritis" .Kritis}}
+    <div id="kritis-live"></div>
+    {{if not .Khanza.Compatible}}
+    <p class='fail'>Skema Khanza tidak kompatibel, hasil tidak disimpan ke Khanza. <a href="/khanza">Lihat detail</a></p>
+    {{end}}
     <table>
         <tr><th>Komponen</th><th>Status</th></tr>
         <tr><td>DB Khanza</td><td id="status-khanza">{{if .Status.KhanzaDB}}<span class='ok'>Tersambung</span>{{else}}<span class='fail'>Gagal</span>{{end}}</td></tr>
         <tr><td>DB Middleware</td><td id="status-mw">{{if .Status.MiddlewareDB}}<span class='ok'>Tersambung</span>{{else}}<span class='fail'>Gagal</span>{{end}}</td></tr>
         <tr><td>Orthanc</td><td id="status-orthanc">{{if .Status.Orthanc}}<span class='ok'>Tersambung</span>{{else}}<span class='fail'>Gagal</span>{{end}}</td></tr>
         <tr><td>OHIF</td><td id="status-ohif">{{if .Status.OHIF}}<span class='ok'>Tersambung</span>{{else}}<span class='fail'>Gagal</span>{{end}}</td></tr>
+        <tr><td>Folder Worklist</td><td id="status-folder">{{if .Status.WorklistFolder}}<span class='ok'>Bisa ditulis</span>{{else}}<span class='fail'>Gagal</span>{{end}}</td></tr>
     </table>
     <div id="logbox" class="logbox">
-    {{range .Logs}}{{.}}<br>{{end}}
+    {{range .Logs}}<div>{{.}}</div>{{end}}
     </div>
+    <h3>Perubahan Status Order</h3>
+    <ul id="orderfeed"></ul>
     <script>
-    function updateLogs() {
-        fetch('/logs').then(r => r.json()).then(arr => {
-            var logbox = document.getElementById('logbox');
-            if (!Array.isArray(arr) || !logbox) return;
-            logbox.innerHTML = arr.map(x => x + '<br>').join('');
-            setTimeout(function() {
-                logbox.scrollTop = logbox.scrollHeight;
-            }, 50);
-        });
+    function renderStatus(st) {
+        document.getElementById('status-khanza').innerHTML = st.khanza_db ? "<span class='ok'>Tersambung</span>" : "<span class='fail'>Gagal</span>";
+        document.getElementById('status-mw').innerHTML = st.middleware_db ? "<span class='ok'>Tersambung</span>" : "<span class='fail'>Gagal</span>";
+        document.getElementById('status-orthanc').innerHTML = st.orthanc ? "<span class='ok'>Tersambung</span>" : "<span class='fail'>Gagal</span>";
+        document.getElementById('status-ohif').innerHTML = st.ohif ? "<span class='ok'>Tersambung</span>" : "<span class='fail'>Gagal</span>";
+        document.getElementById('status-folder').innerHTML = st.worklist_folder ? "<span class='ok'>Bisa ditulis</span>" : "<span class='fail'>Gagal</span>";
     }
-    function updateStatus() {
-        fetch('/status').then(r => r.json()).then(st => {
-            document.getElementById('status-khanza').innerHTML = st.khanza_db ? "<span class='ok'>Tersambung</span>" : "<span class='fail'>Gagal</span>";
-            document.getElementById('status-mw').innerHTML = st.middleware_db ? "<span class='ok'>Tersambung</span>" : "<span class='fail'>Gagal</span>";
-            document.getElementById('status-orthanc').innerHTML = st.orthanc ? "<span class='ok'>Tersambung</span>" : "<span class='fail'>Gagal</span>";
-            document.getElementById('status-ohif').innerHTML = st.ohif ? "<span class='ok'>Tersambung</span>" : "<span class='fail'>Gagal</span>";
-        });
+    function appendLog(e) {
+        var line = e.waktu.substring(0, 19).replace('T', ' ') + ' : [' + e.level + '][' + e.komponen + '] ' + e.pesan + (e.error ? ': ' + e.error : '');
+        var logbox = document.getElementById('logbox');
+        var div = document.createElement('div');
+        div.textContent = line;
+        logbox.appendChild(div);
+        while (logbox.childNodes.length > 500) logbox.removeChild(logbox.firstChild);
+        logbox.scrollTop = logbox.scrollHeight;
+    }
+    function appendOrder(ev) {
+        var feed = document.getElementById('orderfeed');
+        var li = document.createElement('li');
+        var a = document.createElement('a');
+        a.href = '/order?accession=' + encodeURIComponent(ev.nomor_order);
+        a.textContent = ev.nomor_order;
+        li.appendChild(a);
+        li.appendChild(document.createTextNode(' ' + ev.dari + ' → ' + ev.ke + ' ' + (ev.keterangan || '')));
+        feed.insertBefore(li, feed.firstChild);
+        while (feed.childNodes.length > 20) feed.removeChild(feed.lastChild);
+    }
+    function showKritis(n) {
+        var div = document.createElement('div');
+        div.style.cssText = 'background:#c62828;color:#fff;padding:10px;margin-bottom:15px;';
+        div.textContent = (n.level > 1 ? '[ESKALASI ' + (n.level - 1) + '] ' : '') + 'TEMUAN KRITIS ' + n.accession +
+            ' ' + (n.nama_pasien || '') + ': ' + n.keterangan + ' ';
+        var a = document.createElement('a');
+        a.href = '/critical';
+        a.style.color = '#fff';
+        a.textContent = 'Akui';
+        div.appendChild(a);
+        document.getElementById('kritis-live').appendChild(div);
     }
     window.onload = function() {
-        updateLogs();
-        updateStatus();
+        var logbox = document.getElementById('logbox');
+        logbox.scrollTop = logbox.scrollHeight;
+        var es = new EventSource('/events');
+        es.addEventListener('status', function(e) { renderStatus(JSON.parse(e.data)); });
+        es.addEventListener('log', function(e) { appendLog(JSON.parse(e.data)); });
+        es.addEventListener('order', function(e) { appendOrder(JSON.parse(e.data)); });
+        es.addEventListener('kritis', function(e) { showKritis(JSON.parse(e.data)); });
     };
-    setInterval(updateLogs, 5000);
-    setInterval(updateStatus, 5000);
     </script>
 </body>
 </html>
 `
-		t, _ := template.New("dashboard").Parse(tmpl)
+		t, _ := template.New("dashboard").Parse(tmpl + criticalBannerTmpl)
+		session := CurrentSession(r)
 		t.Execute(w, struct {
-			Status Status
-			Logs   []string
-		}{status, logs})
-	})
+			Status         Status
+			Logs           []string
+			User           PortalUser
+			CSRFToken      string
+			CanManageUsers bool
+			Khanza         *KhanzaSchema
+			Kritis         []CriticalFinding
+		}{status, logs, session.User, session.CSRFToken, HasPermission(session.User.Role, PermUserManage), CurrentKhanzaSchema(),
+			openCriticalFindings(mwdb)})
+	}))
 
-	http.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+	mux.HandleFunc("/status", RequirePermission(mwdb, PermView, func(w http.ResponseWriter, r *http.Request) {
 		status := GetStatus()
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(status)
-	})
+	}))
+
+	mux.HandleFunc("/worklist", RequirePermission(mwdb, PermView, func(w http.ResponseWriter, r *http.Request) {
+		today := time.Now().Format("2006-01-02")
+		date := r.URL.Query().Get("date")
+		if date == "" {
+			date = today
+		}
+		modality := r.URL.Query().Get("modality")
+		statusFilter := r.URL.Query().Get("status")
 
-	http.HandleFunc("/api/worklists", func(w http.ResponseWriter, r *http.Request) {
-		worklists := GetWorklists()
+		var worklists []Worklist
+		if date == today {
+			// Cache loop worklist mencakup beberapa hari, tampilkan tanggal yang dipilih saja
+			studyDate := time.Now().Format("20060102")
+			worklists = []Worklist{}
+			for _, wl := range GetWorklists() {
+				if wl.StudyDate == studyDate {
+					worklists = append(worklists, wl)
+				}
+			}
+		} else {
+			reqs, err := GetPendingWorklist(db, date)
+			if err != nil {
+				http.Error(w, "Gagal ambil worklist: "+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			worklists = BuildWorklistView(RuntimeConfig(), mwdb, reqs)
+		}
+
+		modalitySet := map[string]bool{}
+		var modalities []string
+		for _, wl := range worklists {
+			if !modalitySet[wl.Modality] {
+				modalitySet[wl.Modality] = true
+				modalities = append(modalities, wl.Modality)
+			}
+		}
+		sort.Strings(modalities)
+
+		session := CurrentSession(r)
+		t, _ := template.New("worklist").Parse(tmpl)
+		t.Execute(w, struct {
+			Date         string
+			Modality     string
+			StatusFilter string
+			Modalities   []string
+			Statuses     []string
+			Worklists    []Worklist
+			Pesan        string
+			CanManage    bool
+			CSRFToken    string
+		}{
+			date, modality, statusFilter, modalities,
+			orderStateNames(),
+			FilterWorklists(worklists, modality, statusFilter),
+			r.URL.Query().Get("pesan"),
+			HasPermission(session.User.Role, PermOrderManage),
+			session.CSRFToken,
+		})
+	}))
+
+	mux.HandleFunc("/order", RequirePermission(mwdb, PermView, func(w http.ResponseWriter, r *http.Request) {
+		accession := r.URL.Query().Get("accession")
+		if accession == "" {
+			http.Error(w, "accession wajib diisi", http.StatusBadRequest)
+			return
+		}
+		timeline, err := mwdb.GetOrderTimeline(accession)
+		if err != nil {
+			http.Error(w, "Gagal ambil timeline: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		status := ""
+		if sent, err := mwdb.GetSentWorklists([]string{accession}); err == nil {
+			status = sent[accession].Status
+		}
+		canManage := HasPermission(CurrentSession(r).User.Role, PermOrderManage)
+		t, _ := template.New("timeline").Parse(timelineTmpl)
+		t.Execute(w, struct {
+			Accession string
+			Status    string
+			CanCancel bool
+			CanResend bool
+			CSRFToken string
+			Timeline  []TimelineEntry
+		}{
+			accession, status,
+			CanTransition(OrderState(status), OrderCancelled) && canManage,
+			canResend(status) && canManage,
+			CurrentSession(r).CSRFToken, timeline,
+		})
+	}))
+
+	mux.HandleFunc("/order/cancel", RequirePermission(mwdb, PermOrderManage, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		accession := r.FormValue("accession")
+		if err := mwdb.TransitionOrder(accession, OrderCancelled, r.FormValue("alasan")); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		NewLogger(mwdb, CompOrder).Info("Order dibatalkan dari portal oleh "+CurrentSession(r).User.Username, LogFields{Accession: accession})
+		http.Redirect(w, r, "/order?accession="+url.QueryEscape(accession), http.StatusSeeOther)
+	}))
+
+	mux.HandleFunc("/api/worklists", RequirePermission(mwdb, PermView, func(w http.ResponseWriter, r *http.Request) {
+		worklists := FilterWorklists(GetWorklists(), r.URL.Query().Get("modality"), r.URL.Query().Get("status"))
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(worklists)
-	})
+	}))
 
-	http.HandleFunc("/logs", func(w http.ResponseWriter, r *http.Request) {
-		logs, _ := GetPortalLogs(db, 200)
+	mux.HandleFunc("/logs", RequirePermission(mwdb, PermView, func(w http.ResponseWriter, r *http.Request) {
+		logs, _ := GetPortalLogs(mwdb, RuntimeConfig().DashboardLogLines)
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(logs)
-	})
+	}))
+
+	// Endpoint scrape Prometheus, tanpa login agar bisa diakses server monitoring
+	mux.Handle("/metrics", promhttp.Handler())
+
+	mux.HandleFunc("/events", RequirePermission(mwdb, PermView, handleEventStream))
 
-	log.Println("Portal web berjalan di http://localhost:8080")
-	http.ListenAndServe(":8080", nil)
+	registerAnalyticsHandlers(mux, mwdb)
+	registerLogHandlers(mux, mwdb)
+	registerHealthHandlers(mux, mwdb)
+	registerAuthHandlers(mux, cfg, db, mwdb)
+	registerConfigHandlers(mux, mwdb)
+	registerBackfillHandlers(mux, cfg, db, mwdb)
+	registerKhanzaSchemaHandlers(mux, db, mwdb)
+	registerReadingHandlers(mux, cfg, mwdb)
+	registerReportHandlers(mux, db, mwdb)
+	registerReportTemplateHandlers(mux, mwdb)
+	registerReportVersionHandlers(mux, db, mwdb)
+	registerReportPDFHandlers(mux, db, mwdb)
+	registerCriticalHandlers(mux, mwdb)
 }
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Worklist tanggal lampau: accession dihitung dari waktu permintaan, sehingga
+// sama dengan accession yang dicatat di sent_worklist saat order dikirim
+func TestBuildWorklistViewPastDate(t *testing.T) {
+	db := openFakeKhanza(t)
+	mwdb := openMemoryStore(t)
+	if err := mwdb.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+	waktu := time.Now().AddDate(0, 0, -3).Truncate(time.Hour).Add(-50 * time.Minute)
+	order := fakeOrder{
+		NoOrder: "PR" + waktu.Format("20060102") + "0001", NoRawat: waktu.Format("2006/01/02") + "/000001",
+		NoRM: "000101", NamaPasien: "PASIEN UJI LAMA", KdJenisPrw: "RAD001", Pemeriksaan: "THORAX PA", Waktu: waktu,
+	}
+	if err := seedFakeOrder(db, order); err != nil {
+		t.Fatal(err)
+	}
+	reqs, err := GetPendingWorklist(db, waktu.Format("2006-01-02"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reqs) != 1 {
+		t.Fatalf("%d order, seharusnya 1", len(reqs))
+	}
+	want := "CR" + order.NoRM + waktu.Format("2006010215")
+	if reqs[0].AccessionNumber != want {
+		t.Fatalf("accession %q, seharusnya %q", reqs[0].AccessionNumber, want)
+	}
+	mwdb.InsertSentWorklist(want, "{}", reqs[0].WaktuPermintaan())
+
+	views := BuildWorklistView(Config{}, mwdb, reqs)
+	if len(views) != 1 || views[0].Status != string(OrderWorklisted) {
+		t.Fatalf("worklist tanggal lampau tidak cocok dengan sent_worklist: %+v", views)
+	}
+}
@@ -0,0 +1,596 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Worklist baca radiolog. Study yang masuk ke Orthanc menjadi item belum
+// dibaca, ditugaskan ke radiolog (username portal) lewat aturan atau manual,
+// dan ditandai selesai saat webhook SR untuk order tersebut masuk.
+
+const (
+	ReadingUnread = "unread"
+	ReadingRead   = "read"
+
+	PrioritasCito  = "cito"
+	PrioritasRutin = "rutin"
+)
+
+var ErrReadingClaimed = errors.New("order sudah ditugaskan ke radiolog lain")
+
+type ReadingItem struct {
+	NomorOrder       string
+	StudyInstanceUID string
+	NamaPasien       string
+	Pemeriksaan      string
+	Modality         string
+	Unit             string
+	Prioritas        string
+	Status           string
+	Radiolog         string
+	Dibuat           time.Time
+	Ditugaskan       *time.Time
+	Dibaca           *time.Time
+	Pembaca          string
+}
+
+// Aturan penugasan otomatis. Modality/unit kosong berarti semua; radiolog
+// berisi satu atau beberapa username dipisah koma yang dipakai bergiliran.
+type ReadingRule struct {
+	ID       int
+	Urutan   int
+	Modality string
+	Unit     string
+	Radiolog string
+	Giliran  int
+}
+
+func (r ReadingRule) Matches(item ReadingItem) bool {
+	return (r.Modality == "" || strings.EqualFold(r.Modality, item.Modality)) &&
+		(r.Unit == "" || strings.EqualFold(r.Unit, item.Unit))
+}
+
+func (r ReadingRule) Radiologs() []string {
+	var result []string
+	for _, u := range strings.Split(r.Radiolog, ",") {
+		if u = strings.TrimSpace(u); u != "" {
+			result = append(result, u)
+		}
+	}
+	return result
+}
+
+type ReadingFilter struct {
+	Status   string
+	Radiolog string
+	Modality string
+	Limit    int
+}
+
+// Simpan item baru, false bila order sudah ada di worklist baca
+func (s *sqlStore) CreateReadingItem(item ReadingItem) (bool, error) {
+	var n int
+	if err := s.db.QueryRow("SELECT COUNT(*) FROM reading_item WHERE nomor_order=?", item.NomorOrder).Scan(&n); err != nil {
+		return false, err
+	}
+	if n > 0 {
+		return false, nil
+	}
+	_, err := s.db.Exec(`INSERT INTO reading_item (nomor_order, study_instance_uid, nama_pasien, pemeriksaan, modality, unit, prioritas, status, dibuat)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		item.NomorOrder, item.StudyInstanceUID, item.NamaPasien, item.Pemeriksaan, item.Modality, item.Unit, item.Prioritas, ReadingUnread, time.Now())
+	return err == nil, err
+}
+
+// Item CITO lebih dulu, lalu yang paling lama menunggu
+func (s *sqlStore) GetReadingItems(f ReadingFilter) ([]ReadingItem, error) {
+	query := `SELECT nomor_order, IFNULL(study_instance_uid, ''), IFNULL(nama_pasien, ''), IFNULL(pemeriksaan, ''), IFNULL(modality, ''),
+		IFNULL(unit, ''), prioritas, status, IFNULL(radiolog, ''), dibuat, ditugaskan, dibaca, IFNULL(pembaca, '')
+		FROM reading_item WHERE 1=1`
+	var args []interface{}
+	if f.Status != "" {
+		query += " AND status=?"
+		args = append(args, f.Status)
+	}
+	if f.Radiolog != "" {
+		query += " AND radiolog=?"
+		args = append(args, f.Radiolog)
+	}
+	if f.Modality != "" {
+		query += " AND modality=?"
+		args = append(args, f.Modality)
+	}
+	query += " ORDER BY CASE WHEN prioritas=? THEN 0 ELSE 1 END, dibuat"
+	args = append(args, PrioritasCito)
+	if f.Limit > 0 {
+		query += " LIMIT " + strconv.Itoa(f.Limit)
+	}
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ReadingItem
+	for rows.Next() {
+		var it ReadingItem
+		if err := rows.Scan(&it.NomorOrder, &it.StudyInstanceUID, &it.NamaPasien, &it.Pemeriksaan, &it.Modality, &it.Unit,
+			&it.Prioritas, &it.Status, &it.Radiolog, &it.Dibuat, &it.Ditugaskan, &it.Dibaca, &it.Pembaca); err != nil {
+			log.Printf("Error scan reading_item: %v", err)
+			continue
+		}
+		items = append(items, it)
+	}
+	return items, rows.Err()
+}
+
+// Tugaskan item ke radiolog, kosong berarti lepas penugasan
+func (s *sqlStore) AssignReading(nomorOrder, radiolog string) error {
+	var ditugaskan interface{}
+	if radiolog != "" {
+		ditugaskan = time.Now()
+	}
+	res, err := s.db.Exec("UPDATE reading_item SET radiolog=?, ditugaskan=? WHERE nomor_order=? AND status=?",
+		radiolog, ditugaskan, nomorOrder, ReadingUnread)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("order %s tidak ada di worklist baca atau sudah dibaca", nomorOrder)
+	}
+	return nil
+}
+
+// Radiolog mengambil item untuk dirinya sendiri. Hanya berhasil selama item
+// belum punya radiolog, sehingga dua radiolog yang menekan "Ambil" bersamaan
+// tidak saling menimpa.
+func (s *sqlStore) ClaimReading(nomorOrder, radiolog string) error {
+	res, err := s.db.Exec("UPDATE reading_item SET radiolog=?, ditugaskan=? WHERE nomor_order=? AND status=? AND (radiolog IS NULL OR radiolog='')",
+		radiolog, time.Now(), nomorOrder, ReadingUnread)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return nil
+	}
+	var status string
+	var pemilik sql.NullString
+	err = s.db.QueryRow("SELECT status, radiolog FROM reading_item WHERE nomor_order=?", nomorOrder).Scan(&status, &pemilik)
+	if err == sql.ErrNoRows || (err == nil && status != ReadingUnread) {
+		return fmt.Errorf("order %s tidak ada di worklist baca atau sudah dibaca", nomorOrder)
+	}
+	if err != nil {
+		return err
+	}
+	if pemilik.String == radiolog {
+		return nil
+	}
+	return fmt.Errorf("%w (%s)", ErrReadingClaimed, pemilik.String)
+}
+
+func (s *sqlStore) MarkReadingDone(nomorOrder, pembaca string) error {
+	_, err := s.db.Exec("UPDATE reading_item SET status=?, dibaca=?, pembaca=? WHERE nomor_order=?",
+		ReadingRead, time.Now(), pembaca, nomorOrder)
+	return err
+}
+
+func (s *sqlStore) GetReadingRules() ([]ReadingRule, error) {
+	rows, err := s.db.Query("SELECT id, urutan, modality, unit, radiolog, giliran FROM reading_rule ORDER BY urutan, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var rules []ReadingRule
+	for rows.Next() {
+		var r ReadingRule
+		if err := rows.Scan(&r.ID, &r.Urutan, &r.Modality, &r.Unit, &r.Radiolog, &r.Giliran); err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, rows.Err()
+}
+
+func (s *sqlStore) SaveReadingRule(r ReadingRule) error {
+	_, err := s.db.Exec("INSERT INTO reading_rule (urutan, modality, unit, radiolog, giliran) VALUES (?, ?, ?, ?, 0)",
+		r.Urutan, strings.ToUpper(r.Modality), r.Unit, r.Radiolog)
+	return err
+}
+
+func (s *sqlStore) DeleteReadingRule(id int) error {
+	_, err := s.db.Exec("DELETE FROM reading_rule WHERE id=?", id)
+	return err
+}
+
+// Ambil giliran aturan lalu naikkan, dalam satu transaksi agar dua study yang
+// masuk bersamaan tidak jatuh ke radiolog yang sama
+func (s *sqlStore) NextRuleTurn(id int) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	var giliran int
+	if err := tx.QueryRow("SELECT giliran FROM reading_rule WHERE id=?"+s.dialect.lockSuffix, id).Scan(&giliran); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec("UPDATE reading_rule SET giliran=? WHERE id=?", giliran+1, id); err != nil {
+		return 0, err
+	}
+	return giliran, tx.Commit()
+}
+
+// Unit asal order di Khanza (kode bangsal untuk ranap, kode poli untuk ralan)
+// dan prioritas CITO dari informasi tambahan/diagnosa klinis permintaan
+func khanzaOrderContext(db *sql.DB, noorder string) (unit, prioritas string) {
+	prioritas = PrioritasRutin
+	schema := CurrentKhanzaSchema()
+	var noRawat, status, kdPoli string
+	err := db.QueryRow(`SELECT pr.no_rawat, IFNULL(pr.status, ''), IFNULL(r.kd_poli, '') FROM permintaan_radiologi pr
+		JOIN reg_periksa r ON pr.no_rawat = r.no_rawat WHERE pr.noorder = ?`, noorder).Scan(&noRawat, &status, &kdPoli)
+	if err != nil {
+		return "", prioritas
+	}
+	unit = kdPoli
+	if strings.EqualFold(status, "ranap") {
+		unit = status
+		var bangsal string
+		err := db.QueryRow(`SELECT k.kd_bangsal FROM kamar_inap ki JOIN kamar k ON ki.kd_kamar = k.kd_kamar
+			WHERE ki.no_rawat = ? ORDER BY ki.tgl_masuk DESC, ki.jam_masuk DESC LIMIT 1`, noRawat).Scan(&bangsal)
+		if err == nil && bangsal != "" {
+			unit = bangsal
+		}
+	}
+
+	var keterangan []string
+	for _, kolom := range []string{"informasi_tambahan", "diagnosa_klinis"} {
+		if !schema.Has("permintaan_radiologi", kolom) {
+			continue
+		}
+		var v sql.NullString
+		if db.QueryRow("SELECT "+kolom+" FROM permintaan_radiologi WHERE noorder = ?", noorder).Scan(&v) == nil {
+			keterangan = append(keterangan, v.String)
+		}
+	}
+	if strings.Contains(strings.ToUpper(strings.Join(keterangan, " ")), "CITO") {
+		prioritas = PrioritasCito
+	}
+	return unit, prioritas
+}
+
+// Radiolog dari aturan pertama yang cocok, kosong bila tidak ada aturan
+func assignByRules(mwdb ReadingStore, item ReadingItem) (string, error) {
+	rules, err := mwdb.GetReadingRules()
+	if err != nil {
+		return "", err
+	}
+	for _, r := range rules {
+		radiologs := r.Radiologs()
+		if !r.Matches(item) || len(radiologs) == 0 {
+			continue
+		}
+		giliran, err := mwdb.NextRuleTurn(r.ID)
+		if err != nil {
+			return "", err
+		}
+		return radiologs[giliran%len(radiologs)], nil
+	}
+	return "", nil
+}
+
+// Masukkan study yang baru diterima ke worklist baca dan tugaskan sesuai aturan
+func enqueueReading(db *sql.DB, mwdb Store, accession, studyUID string) {
+	logger := NewLogger(mwdb, CompOrder)
+	fields := LogFields{Accession: accession}
+	sent, err := mwdb.GetSentWorklists([]string{accession})
+	if err != nil {
+		fields.Err = err
+		logger.Error("Gagal membuat item worklist baca", fields)
+		return
+	}
+	var wl WorklistRequest
+	json.Unmarshal([]byte(sent[accession].Worklist), &wl)
+	fields.Pasien = wl.PatientID
+
+	item := ReadingItem{
+		NomorOrder:       accession,
+		StudyInstanceUID: studyUID,
+		NamaPasien:       wl.PatientName,
+		Pemeriksaan:      wl.RequestedProcedureDescription,
+		Modality:         wl.Modality,
+		Prioritas:        PrioritasRutin,
+	}
+	if wl.PatientID != "" {
+		item.Unit, item.Prioritas = khanzaOrderContext(db, wl.PatientID)
+	}
+	created, err := mwdb.CreateReadingItem(item)
+	if err != nil {
+		fields.Err = err
+		logger.Error("Gagal membuat item worklist baca", fields)
+		return
+	}
+	if !created {
+		return
+	}
+	radiolog, err := assignByRules(mwdb, item)
+	if err != nil {
+		fields.Err = err
+		logger.Warn("Gagal menerapkan aturan penugasan radiolog", fields)
+		return
+	}
+	if radiolog == "" {
+		return
+	}
+	if err := mwdb.AssignReading(accession, radiolog); err != nil {
+		fields.Err = err
+		logger.Warn("Gagal menugaskan radiolog", fields)
+		return
+	}
+	logger.Info("Study ditugaskan ke radiolog "+radiolog, fields)
+}
+
+// Username portal dengan role radiolog untuk pilihan penugasan
+func radiologistUsers(mwdb PortalStore) []PortalUser {
+	users, err := mwdb.GetPortalUsers()
+	if err != nil {
+		return nil
+	}
+	var result []PortalUser
+	for _, u := range users {
+		if u.Aktif && (u.Role == RoleRadiologist || u.Role == RoleAdmin) {
+			result = append(result, u)
+		}
+	}
+	return result
+}
+
+type readingRow struct {
+	ReadingItem
+	OHIFLink string
+	Menunggu string
+}
+
+func waitDuration(d time.Duration) string {
+	d = d.Round(time.Minute)
+	if d >= 24*time.Hour {
+		return fmt.Sprintf("%d hari %d jam", int(d.Hours())/24, int(d.Hours())%24)
+	}
+	return fmt.Sprintf("%d jam %d menit", int(d.Hours()), int(d.Minutes())%60)
+}
+
+var readingTmpl = `
+<!DOCTYPE html>
+<html>
+<head>
+    <title>Worklist Baca Radiolog</title>
+    <style>
+        body { font-family: Arial; margin: 40px; }
+        table { border-collapse: collapse; width: 100%; margin-bottom: 30px; }
+        th, td { border: 1px solid #ccc; padding: 8px; text-align: left; }
+        th { background: #f0f0f0; }
+        .cito { color: red; font-weight: bold; }
+        .no { color: #aaa; }
+        form.inline { display: inline; margin: 0; }
+        form { margin-bottom: 20px; }
+    </style>
+</head>
+<body>
+    <h2>Worklist Baca Radiolog</h2>
+    <p><a href="/">Dashboard</a> | <a href="/critical">Temuan Kritis</a></p>
+    {{template "kritis" .Kritis}}
+    {{if .Pesan}}<p><b>{{.Pesan}}</b></p>{{end}}
+    <form method="get" action="/reading">
+        Status <select name="status">
+            <option value="unread" {{if eq .Status "unread"}}selected{{end}}>Belum dibaca</option>
+            <option value="read" {{if eq .Status "read"}}selected{{end}}>Sudah dibaca</option>
+            <option value="" {{if eq .Status ""}}selected{{end}}>Semua</option>
+        </select>
+        Modality <input type="text" name="modality" value="{{.Modality}}" size="4">
+        <label><input type="checkbox" name="saya" value="1" {{if .Saya}}checked{{end}}> Tugas saya</label>
+        <button type="submit">Filter</button>
+    </form>
+    <table>
+        <tr><th>Prioritas</th><th>Accession</th><th>Pasien</th><th>Pemeriksaan</th><th>Modality</th><th>Unit</th>
+            <th>Menunggu</th><th>Radiolog</th><th>OHIF</th><th>Laporan</th><th>Dibaca</th></tr>
+        {{range .Items}}
+        <tr>
+            <td>{{if eq .Prioritas "cito"}}<span class="cito">CITO</span>{{else}}{{.Prioritas}}{{end}}</td>
+            <td><a href="/order?accession={{.NomorOrder}}">{{.NomorOrder}}</a></td>
+            <td>{{.NamaPasien}}</td>
+            <td>{{.Pemeriksaan}}</td>
+            <td>{{.Modality}}</td>
+            <td>{{.Unit}}</td>
+            <td>{{.Menunggu}}</td>
+            <td>
+                {{if .Radiolog}}{{.Radiolog}}{{else}}<span class="no">-</span>{{end}}
+                {{if eq .Status "unread"}}
+                {{if $.CanAssign}}
+                <form class="inline" method="post" action="/reading/assign">
+                    <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+                    <input type="hidden" name="accession" value="{{.NomorOrder}}">
+                    <select name="radiolog">
+                        <option value="">-</option>
+                        {{$r := .Radiolog}}{{range $.Radiologs}}<option value="{{.Username}}" {{if eq .Username $r}}selected{{end}}>{{.Nama}}</option>{{end}}
+                    </select>
+                    <button type="submit">Tugaskan</button>
+                </form>
+                {{else if and $.CanClaim (not .Radiolog)}}
+                <form class="inline" method="post" action="/reading/assign">
+                    <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+                    <input type="hidden" name="accession" value="{{.NomorOrder}}">
+                    <input type="hidden" name="radiolog" value="{{$.Username}}">
+                    <button type="submit">Ambil</button>
+                </form>
+                {{end}}
+                {{end}}
+            </td>
+            <td>{{if .OHIFLink}}<a href="{{.OHIFLink}}" target="_blank">Buka</a>{{end}}</td>
+            <td><a href="/report?accession={{.NomorOrder}}">{{if eq .Status "unread"}}Tulis{{else}}Lihat{{end}}</a></td>
+            <td>{{if .Dibaca}}{{.Dibaca.Format "2006-01-02 15:04"}} {{.Pembaca}}{{else}}<span class="no">-</span>{{end}}</td>
+        </tr>
+        {{end}}
+    </table>
+
+    {{if .CanManageRules}}
+    <h3>Aturan Penugasan</h3>
+    <p>Aturan dicek berurutan, yang pertama cocok dipakai. Beberapa radiolog (dipisah koma) ditugaskan bergiliran.</p>
+    <table>
+        <tr><th>Urutan</th><th>Modality</th><th>Unit (kd_poli / kd_bangsal)</th><th>Radiolog</th><th></th></tr>
+        {{range .Rules}}
+        <tr><td>{{.Urutan}}</td><td>{{if .Modality}}{{.Modality}}{{else}}semua{{end}}</td><td>{{if .Unit}}{{.Unit}}{{else}}semua{{end}}</td><td>{{.Radiolog}}</td>
+            <td><form class="inline" method="post" action="/reading/rules/delete">
+                <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+                <input type="hidden" name="id" value="{{.ID}}">
+                <button type="submit">Hapus</button>
+            </form></td></tr>
+        {{end}}
+    </table>
+    <form method="post" action="/reading/rules">
+        <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+        Urutan <input type="number" name="urutan" value="0" size="3">
+        Modality <input type="text" name="modality" size="4">
+        Unit <input type="text" name="unit" size="8">
+        Radiolog <input type="text" name="radiolog" placeholder="username1,username2" required>
+        <button type="submit">Tambah Aturan</button>
+    </form>
+    {{end}}
+</body>
+</html>
+`
+
+func registerReadingHandlers(mux *http.ServeMux, cfg Config, mwdb Store) {
+	mux.HandleFunc("/reading", RequirePermission(mwdb, PermView, func(w http.ResponseWriter, r *http.Request) {
+		session := CurrentSession(r)
+		q := r.URL.Query()
+		status := ReadingUnread
+		if _, ok := q["status"]; ok {
+			status = q.Get("status")
+		}
+		f := ReadingFilter{Status: status, Modality: strings.ToUpper(q.Get("modality")), Limit: 500}
+		saya := q.Get("saya") == "1"
+		if saya {
+			f.Radiolog = session.User.Username
+		}
+		items, err := mwdb.GetReadingItems(f)
+		if err != nil {
+			http.Error(w, "Gagal ambil worklist baca: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		runtime := RuntimeConfig()
+		rows := make([]readingRow, 0, len(items))
+		for _, it := range items {
+			row := readingRow{ReadingItem: it, Menunggu: waitDuration(time.Since(it.Dibuat))}
+			if it.Dibaca != nil {
+				row.Menunggu = waitDuration(it.Dibaca.Sub(it.Dibuat))
+			}
+			if it.StudyInstanceUID != "" {
+				row.OHIFLink = GenerateOHIFLink(runtime, it.StudyInstanceUID)
+			}
+			rows = append(rows, row)
+		}
+		var rules []ReadingRule
+		canManageRules := HasPermission(session.User.Role, PermConfig)
+		if canManageRules {
+			rules, _ = mwdb.GetReadingRules()
+		}
+		radiologs := radiologistUsers(mwdb)
+		sort.Slice(radiologs, func(i, j int) bool { return radiologs[i].Nama < radiologs[j].Nama })
+
+		t, _ := template.New("reading").Parse(readingTmpl + criticalBannerTmpl)
+		t.Execute(w, struct {
+			Items          []readingRow
+			Status         string
+			Modality       string
+			Saya           bool
+			Pesan          string
+			Username       string
+			CanAssign      bool
+			CanClaim       bool
+			CanManageRules bool
+			Radiologs      []PortalUser
+			Rules          []ReadingRule
+			Kritis         []CriticalFinding
+			CSRFToken      string
+		}{
+			rows, status, f.Modality, saya, q.Get("pesan"), session.User.Username,
+			HasPermission(session.User.Role, PermOrderManage),
+			HasPermission(session.User.Role, PermReading),
+			canManageRules, radiologs, rules, openCriticalFindings(mwdb), session.CSRFToken,
+		})
+	}))
+
+	mux.HandleFunc("/reading/assign", RequirePermission(mwdb, PermView, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		session := CurrentSession(r)
+		accession, radiolog := r.FormValue("accession"), r.FormValue("radiolog")
+		pesan := "Order " + accession + " ditugaskan ke " + radiolog
+		if radiolog == "" {
+			pesan = "Penugasan order " + accession + " dilepas"
+		}
+		var err error
+		switch {
+		case HasPermission(session.User.Role, PermOrderManage):
+			// Admin boleh menugaskan ulang atau melepas penugasan
+			err = mwdb.AssignReading(accession, radiolog)
+		case HasPermission(session.User.Role, PermReading) && radiolog == session.User.Username:
+			// Radiolog hanya boleh mengambil item yang belum ditugaskan
+			err = mwdb.ClaimReading(accession, radiolog)
+			if errors.Is(err, ErrReadingClaimed) {
+				http.Error(w, "Gagal mengambil order "+accession+": "+err.Error(), http.StatusConflict)
+				return
+			}
+		default:
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			pesan = "Gagal menugaskan: " + err.Error()
+		} else {
+			NewLogger(mwdb, CompOrder).Info(pesan+" oleh "+session.User.Username, LogFields{Accession: accession})
+		}
+		http.Redirect(w, r, "/reading?pesan="+url.QueryEscape(pesan), http.StatusSeeOther)
+	}))
+
+	mux.HandleFunc("/reading/rules", RequirePermission(mwdb, PermConfig, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		urutan, _ := strconv.Atoi(r.FormValue("urutan"))
+		rule := ReadingRule{Urutan: urutan, Modality: strings.TrimSpace(r.FormValue("modality")),
+			Unit: strings.TrimSpace(r.FormValue("unit")), Radiolog: r.FormValue("radiolog")}
+		pesan := "Aturan penugasan ditambahkan"
+		if len(rule.Radiologs()) == 0 {
+			pesan = "Radiolog aturan wajib diisi"
+		} else if err := mwdb.SaveReadingRule(rule); err != nil {
+			pesan = "Gagal simpan aturan: " + err.Error()
+		}
+		http.Redirect(w, r, "/reading?pesan="+url.QueryEscape(pesan), http.StatusSeeOther)
+	}))
+
+	mux.HandleFunc("/reading/rules/delete", RequirePermission(mwdb, PermConfig, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id, _ := strconv.Atoi(r.FormValue("id"))
+		pesan := "Aturan penugasan dihapus"
+		if err := mwdb.DeleteReadingRule(id); err != nil {
+			pesan = "Gagal hapus aturan: " + err.Error()
+		}
+		http.Redirect(w, r, "/reading?pesan="+url.QueryEscape(pesan), http.StatusSeeOther)
+	}))
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestClaimReading(t *testing.T) {
+	var cfg Config
+	if err := applyDefaults(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	SetRuntimeConfig(cfg)
+	defer SetRuntimeConfig(Config{})
+
+	mwdb := openMemoryStore(t)
+	if err := mwdb.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mwdb.CreateReadingItem(ReadingItem{NomorOrder: "PR202601010001", Modality: "CR", Prioritas: PrioritasRutin}); err != nil {
+		t.Fatal(err)
+	}
+	sessions := map[string]PortalSession{}
+	for _, u := range []string{"radiolog1", "radiolog2", "admin"} {
+		role := RoleRadiologist
+		if u == "admin" {
+			role = RoleAdmin
+		}
+		if err := CreatePortalUser(mwdb, u, u, role, "rahasia-"+u); err != nil {
+			t.Fatal(err)
+		}
+		user, _, err := mwdb.GetPortalUserCredential(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sessions[u], err = CreateSession(mwdb, user.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mux := http.NewServeMux()
+	registerReadingHandlers(mux, cfg, mwdb)
+	assign := func(user, radiolog string) int {
+		s := sessions[user]
+		form := url.Values{"accession": {"PR202601010001"}, "radiolog": {radiolog}, "csrf_token": {s.CSRFToken}}
+		req := httptest.NewRequest(http.MethodPost, "/reading/assign", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: s.Token})
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	pemilik := func() string {
+		items, err := mwdb.GetReadingItems(ReadingFilter{})
+		if err != nil || len(items) != 1 {
+			t.Fatalf("item worklist baca: %v %v", items, err)
+		}
+		return items[0].Radiolog
+	}
+
+	if code := assign("radiolog1", "radiolog1"); code != http.StatusSeeOther || pemilik() != "radiolog1" {
+		t.Fatalf("ambil radiolog1: status %d, pemilik %q", code, pemilik())
+	}
+	if code := assign("radiolog1", "radiolog1"); code != http.StatusSeeOther {
+		t.Fatalf("ambil ulang oleh pemilik: status %d", code)
+	}
+	if code := assign("radiolog2", "radiolog2"); code != http.StatusConflict || pemilik() != "radiolog1" {
+		t.Fatalf("ambil radiolog2: status %d, pemilik %q", code, pemilik())
+	}
+	if err := mwdb.ClaimReading("PR202601010001", "radiolog2"); !errors.Is(err, ErrReadingClaimed) {
+		t.Fatalf("ClaimReading item milik orang lain: %v", err)
+	}
+	if code := assign("radiolog2", "radiolog1"); code != http.StatusForbidden {
+		t.Fatalf("radiolog menugaskan orang lain: status %d", code)
+	}
+	if code := assign("admin", "radiolog2"); code != http.StatusSeeOther || pemilik() != "radiolog2" {
+		t.Fatalf("admin menugaskan ulang: status %d, pemilik %q", code, pemilik())
+	}
+}
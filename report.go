@@ -0,0 +1,548 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Editor laporan radiolog di portal. Laporan disimpan sebagai draft atau
+// preliminary di DB middleware; saat ditandatangani (final) middleware membuat
+// DICOM Basic Text SR di Orthanc lewat /tools/create-dicom lalu menjalankan
+// alur simpan hasil yang sama dengan webhook SR.
+
+const (
+	ReportDraft       = "draft"
+	ReportPreliminary = "preliminary"
+	ReportFinal       = "final"
+
+	// SOP Class Basic Text SR
+	basicTextSRClass = "1.2.840.10008.5.1.4.1.1.88.11"
+)
+
+var ErrReportFinal = errors.New("laporan sudah final")
+
+type Report struct {
+	NomorOrder string
+	Temuan     string
+	Kesan      string
+	Status     string
+	Penulis    string
+	Diubah     time.Time
+	FinalPada  *time.Time
+	SRInstance string
+	// Ditandai radiolog sebagai temuan kritis, masuk ke SR sebagai item CODE
+	Kritis bool
+}
+
+// Pemeriksaan dalam satu order Khanza
+type OrderExam struct {
+	KdJenisPrw string
+	Nama       string
+}
+
+func (s *sqlStore) GetReport(nomorOrder string) (Report, error) {
+	var r Report
+	err := s.db.QueryRow(`SELECT nomor_order, IFNULL(temuan, ''), IFNULL(kesan, ''), status, IFNULL(penulis, ''), diubah, final_pada, IFNULL(sr_instance, ''), kritis
+		FROM report WHERE nomor_order=?`, nomorOrder).
+		Scan(&r.NomorOrder, &r.Temuan, &r.Kesan, &r.Status, &r.Penulis, &r.Diubah, &r.FinalPada, &r.SRInstance, &r.Kritis)
+	return r, err
+}
+
+// Simpan draft/preliminary. Laporan yang sudah final tidak bisa diubah.
+func (s *sqlStore) SaveReport(r Report) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var status string
+	err = tx.QueryRow("SELECT status FROM report WHERE nomor_order=?"+s.dialect.lockSuffix, r.NomorOrder).Scan(&status)
+	switch {
+	case err == sql.ErrNoRows:
+		_, err = tx.Exec("INSERT INTO report (nomor_order, temuan, kesan, status, penulis, diubah, kritis) VALUES (?, ?, ?, ?, ?, ?, ?)",
+			r.NomorOrder, r.Temuan, r.Kesan, r.Status, r.Penulis, time.Now(), r.Kritis)
+	case err != nil:
+		return err
+	case status == ReportFinal:
+		return ErrReportFinal
+	default:
+		_, err = tx.Exec("UPDATE report SET temuan=?, kesan=?, status=?, penulis=?, diubah=?, kritis=? WHERE nomor_order=?",
+			r.Temuan, r.Kesan, r.Status, r.Penulis, time.Now(), r.Kritis, r.NomorOrder)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Tandai final sebelum SR dibuat sehingga dua radiolog yang menandatangani
+// bersamaan tidak menghasilkan dua SR. Mengembalikan status sebelumnya.
+func (s *sqlStore) ClaimReportFinal(nomorOrder, penulis string) (string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+	var status string
+	if err := tx.QueryRow("SELECT status FROM report WHERE nomor_order=?"+s.dialect.lockSuffix, nomorOrder).Scan(&status); err != nil {
+		return "", err
+	}
+	if status == ReportFinal {
+		return "", ErrReportFinal
+	}
+	if _, err := tx.Exec("UPDATE report SET status=?, penulis=?, final_pada=? WHERE nomor_order=?", ReportFinal, penulis, time.Now(), nomorOrder); err != nil {
+		return "", err
+	}
+	return status, tx.Commit()
+}
+
+// Kembalikan status laporan bila pembuatan SR gagal
+func (s *sqlStore) ReleaseReportFinal(nomorOrder, status string) error {
+	_, err := s.db.Exec("UPDATE report SET status=?, final_pada=NULL WHERE nomor_order=? AND sr_instance IS NULL", status, nomorOrder)
+	return err
+}
+
+func (s *sqlStore) SetReportSRInstance(nomorOrder, instanceID string) error {
+	_, err := s.db.Exec("UPDATE report SET sr_instance=? WHERE nomor_order=?", instanceID, nomorOrder)
+	return err
+}
+
+// Daftar pemeriksaan order dari permintaan_pemeriksaan_radiologi
+func GetOrderExams(db *sql.DB, noorder string) ([]OrderExam, error) {
+	rows, err := db.Query(`SELECT pj.kd_jenis_prw, IFNULL(jpr.nm_perawatan, pj.kd_jenis_prw)
+		FROM permintaan_pemeriksaan_radiologi pj
+		LEFT JOIN jns_perawatan_radiologi jpr ON pj.kd_jenis_prw = jpr.kd_jenis_prw
+		WHERE pj.noorder = ? ORDER BY pj.kd_jenis_prw`, noorder)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []OrderExam
+	for rows.Next() {
+		var e OrderExam
+		if err := rows.Scan(&e.KdJenisPrw, &e.Nama); err != nil {
+			return nil, err
+		}
+		result = append(result, e)
+	}
+	return result, rows.Err()
+}
+
+// Tag Basic Text SR dalam format JSON /tools/create-dicom Orthanc. Temuan dan
+// kesan menjadi dua item TEXT di bawah container Diagnostic Imaging Report;
+// laporan kritis mendapat item CODE tambahan.
+func BuildBasicTextSR(r Report, radiolog string, waktu time.Time) map[string]interface{} {
+	code := func(value, scheme, meaning string) []interface{} {
+		return []interface{}{map[string]string{"CodeValue": value, "CodingSchemeDesignator": scheme, "CodeMeaning": meaning}}
+	}
+	text := func(value, meaning, isi string) map[string]interface{} {
+		return map[string]interface{}{
+			"RelationshipType":        "CONTAINS",
+			"ValueType":               "TEXT",
+			"ConceptNameCodeSequence": code(value, "DCM", meaning),
+			"TextValue":               isi,
+		}
+	}
+	var content []interface{}
+	if strings.TrimSpace(r.Temuan) != "" {
+		content = append(content, text("121071", "Findings", r.Temuan))
+	}
+	if strings.TrimSpace(r.Kesan) != "" {
+		content = append(content, text("121073", "Impression", r.Kesan))
+	}
+	if r.Kritis {
+		content = append(content, map[string]interface{}{
+			"RelationshipType":        "CONTAINS",
+			"ValueType":               "CODE",
+			"ConceptNameCodeSequence": code("KLASIFIKASI", criticalCodeScheme, "Klasifikasi hasil"),
+			"ConceptCodeSequence":     code(criticalCodeValue, criticalCodeScheme, "Temuan kritis"),
+		})
+	}
+	return map[string]interface{}{
+		"SOPClassUID":             basicTextSRClass,
+		"Modality":                "SR",
+		"SeriesDescription":       "Laporan Radiologi",
+		"SeriesNumber":            "999",
+		"InstanceNumber":          "1",
+		"ContentDate":             waktu.Format("20060102"),
+		"ContentTime":             waktu.Format("150405"),
+		"ValueType":               "CONTAINER",
+		"ContinuityOfContent":     "SEPARATE",
+		"ConceptNameCodeSequence": code("18748-4", "LN", "Diagnostic Imaging Report"),
+		"CompletionFlag":          "COMPLETE",
+		"VerificationFlag":        "VERIFIED",
+		"VerifyingObserverSequence": []interface{}{map[string]interface{}{
+			"VerifyingObserverName":                       radiolog,
+			"VerifyingOrganization":                       "Instalasi Radiologi",
+			"VerificationDateTime":                        waktu.Format("20060102150405"),
+			"VerifyingObserverIdentificationCodeSequence": []interface{}{},
+		}},
+		"ContentSequence": content,
+	}
+}
+
+// Buat instance SR di bawah study Orthanc, kembalikan ID instance baru
+func StoreSRToOrthanc(cfg Config, studyID string, tags map[string]interface{}) (string, error) {
+	body, _ := json.Marshal(map[string]interface{}{"Parent": studyID, "Tags": tags})
+	req, err := http.NewRequest("POST", cfg.OrthancURL+"/tools/create-dicom", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth(cfg.OrthancUser, cfg.OrthancPass)
+	req.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		pesan, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("Orthanc error: %s %s", resp.Status, strings.TrimSpace(string(pesan)))
+	}
+	var created struct {
+		ID string `json:"ID"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		return "", err
+	}
+	if created.ID == "" {
+		return "", fmt.Errorf("Orthanc tidak mengembalikan ID instance SR")
+	}
+	return created.ID, nil
+}
+
+// Tanda tangani laporan: buat SR di Orthanc lalu simpan hasil ke Khanza lewat
+// alur webhook. Payload disimpan sehingga bisa di-replay bila simpan gagal.
+// Bila Lua Orthanc juga mengirim webhook untuk SR ini, proses ulangnya aman
+// karena tagihan dan hasil tidak ditulis ganda.
+func SignReport(db *sql.DB, mwdb Store, accession string, user PortalUser) error {
+	cfg := RuntimeConfig()
+	logger := NewLogger(mwdb, CompSR)
+	report, err := mwdb.GetReport(accession)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(report.Temuan) == "" && strings.TrimSpace(report.Kesan) == "" {
+		return fmt.Errorf("laporan kosong")
+	}
+	noorder, err := noorderOf(mwdb, accession)
+	if err != nil {
+		return err
+	}
+	study, err := findOrthancStudy(cfg, accession)
+	if err != nil {
+		return fmt.Errorf("cari study di Orthanc: %v", err)
+	}
+	if study.ID == "" {
+		return fmt.Errorf("study untuk accession %s belum ada di Orthanc", accession)
+	}
+
+	sebelumnya, err := mwdb.ClaimReportFinal(accession, user.Username)
+	if err != nil {
+		return err
+	}
+	radiolog := user.Nama
+	if radiolog == "" {
+		radiolog = user.Username
+	}
+	instanceID, err := StoreSRToOrthanc(cfg, study.ID, BuildBasicTextSR(report, radiolog, time.Now()))
+	if err != nil {
+		mwdb.ReleaseReportFinal(accession, sebelumnya)
+		return fmt.Errorf("simpan SR ke Orthanc: %v", err)
+	}
+	mwdb.SetReportSRInstance(accession, instanceID)
+	logger.Info("Laporan ditandatangani "+user.Username+", SR "+instanceID, LogFields{Accession: accession, Pasien: noorder})
+	if report.Kritis {
+		// Dicatat sebelum webhook agar sumbernya tercatat sebagai tanda dari portal
+		FlagCriticalFinding(mwdb, accession, CriticalSourcePortal, "ditandai kritis oleh "+radiolog)
+	}
+
+	payload, _ := json.Marshal(map[string]interface{}{
+		"accession":    accession,
+		"patient_id":   noorder,
+		"orthanc_uuid": instanceID,
+		"study":        study.StudyInstanceUID,
+		"link":         GenerateOHIFLink(cfg, study.StudyInstanceUID),
+	})
+	if _, err := mwdb.SaveWebhookPayload(payload); err != nil {
+		logger.Warn("Gagal simpan payload laporan", LogFields{Accession: accession, Err: err})
+	}
+	processSRWebhook(cfg, db, mwdb, payload)
+
+	sent, err := mwdb.GetSentWorklists([]string{accession})
+	if err != nil {
+		return err
+	}
+	if status := sent[accession].Status; status != string(OrderFiled) {
+		return fmt.Errorf("SR tersimpan di Orthanc tetapi hasil belum tersimpan ke Khanza (status %s), lihat log order", status)
+	}
+	return nil
+}
+
+type reportOrder struct {
+	Accession   string
+	NoOrder     string
+	NamaPasien  string
+	Pemeriksaan string
+	Modality    string
+	Status      string
+	OHIFLink    string
+	worklist    WorklistRequest
+}
+
+// Makro dengan placeholder yang sudah diisi data order
+type reportMacroOption struct {
+	Kode, Nama, Temuan, Kesan string
+}
+
+func loadReportOrder(cfg Config, mwdb WorklistStore, accession string) (reportOrder, error) {
+	sent, err := mwdb.GetSentWorklists([]string{accession})
+	if err != nil {
+		return reportOrder{}, err
+	}
+	sw, ok := sent[accession]
+	if !ok {
+		return reportOrder{}, fmt.Errorf("order %s tidak ditemukan", accession)
+	}
+	var wl WorklistRequest
+	json.Unmarshal([]byte(sw.Worklist), &wl)
+	o := reportOrder{
+		Accession: accession, NoOrder: wl.PatientID, NamaPasien: wl.PatientName,
+		Pemeriksaan: wl.RequestedProcedureDescription, Modality: wl.Modality, Status: sw.Status,
+		worklist: wl,
+	}
+	if sw.StudyInstanceUID != "" {
+		o.OHIFLink = GenerateOHIFLink(cfg, sw.StudyInstanceUID)
+	}
+	return o, nil
+}
+
+var reportTmpl = `
+<!DOCTYPE html>
+<html>
+<head>
+    <title>Laporan {{.Order.Accession}}</title>
+    <style>
+        body { font-family: Arial; margin: 40px; }
+        textarea { width: 100%; font-family: Arial; font-size: 14px; }
+        .final { background: #eef7ee; padding: 10px; white-space: pre-wrap; }
+        .status { font-weight: bold; }
+        form.inline { display: inline; margin: 0; }
+    </style>
+</head>
+<body>
+    <h2>Laporan Radiologi {{.Order.Accession}}</h2>
+    <p><a href="/reading">Worklist Baca</a> | <a href="/order?accession={{.Order.Accession}}">Timeline Order</a>
+       {{if .Order.OHIFLink}}| <a href="{{.Order.OHIFLink}}" target="_blank">Buka di OHIF</a>{{end}}</p>
+    {{if .Pesan}}<p><b>{{.Pesan}}</b></p>{{end}}
+    {{if .Kritis.ID}}<p style="background:#fdecea;padding:8px;"><b>Temuan kritis</b> ({{.Kritis.Sumber}}): {{.Kritis.Keterangan}} -
+       {{if .Kritis.DiakuiPada}}diakui {{.Kritis.DiakuiOleh}} {{.Kritis.DiakuiPada.Format "2006-01-02 15:04"}}{{else}}belum diakui, level {{.Kritis.Level}}{{end}}
+       | <a href="/critical?id={{.Kritis.ID}}">Audit notifikasi</a></p>{{end}}
+    <p>Pasien: {{.Order.NamaPasien}} | No. order: {{.Order.NoOrder}} | Modality: {{.Order.Modality}} | Status order: {{.Order.Status}}</p>
+    <p>Pemeriksaan: {{range $i, $e := .Exams}}{{if $i}}, {{end}}{{$e.Nama}} ({{$e.KdJenisPrw}}){{else}}{{.Order.Pemeriksaan}}{{end}}</p>
+    <p>Status laporan: <span class="status">{{if .Report.Status}}{{.Report.Status}}{{else}}belum ada{{end}}</span>
+       {{if .Report.Penulis}}oleh {{.Report.Penulis}}{{end}}
+       {{if .Report.FinalPada}}, final {{.Report.FinalPada.Format "2006-01-02 15:04"}}{{end}}</p>
+
+    {{if eq .Report.Status "final"}}
+    <h3>Temuan</h3>
+    <div class="final">{{.Report.Temuan}}</div>
+    <h3>Kesan</h3>
+    <div class="final">{{.Report.Kesan}}</div>
+    {{else if .Versions}}
+    {{else if .CanEdit}}
+    {{if .Templates}}
+    <form method="get" action="/report">
+        <input type="hidden" name="accession" value="{{.Order.Accession}}">
+        Template <select name="template">
+            {{range .Templates}}<option value="{{.ID}}" {{if eq .ID $.Template}}selected{{end}}>{{.Nama}}{{if .KdJenisPrw}} ({{.KdJenisPrw}}){{else}} ({{.Modality}}){{end}}</option>{{end}}
+        </select>
+        <button type="submit">Pakai Template</button>
+    </form>
+    {{end}}
+    <form method="post" action="/report/save">
+        <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+        <input type="hidden" name="accession" value="{{.Order.Accession}}">
+        {{if .Macros}}
+        <p>Makro <select id="makro">
+            {{range .Macros}}<option data-temuan="{{.Temuan}}" data-kesan="{{.Kesan}}">{{.Kode}} - {{.Nama}}</option>{{end}}
+        </select>
+        <button type="button" onclick="sisipkanMakro()">Sisipkan</button></p>
+        {{end}}
+        <h3>Temuan</h3>
+        <textarea name="temuan" rows="12">{{.Report.Temuan}}</textarea>
+        <h3>Kesan</h3>
+        <textarea name="kesan" rows="5">{{.Report.Kesan}}</textarea>
+        <p><label><input type="checkbox" name="kritis" value="1" {{if .Report.Kritis}}checked{{end}}>
+            Temuan kritis (dokter perujuk dinotifikasi saat laporan ditandatangani)</label></p>
+        <p>
+            <button type="submit" name="status" value="draft">Simpan Draft</button>
+            <button type="submit" name="status" value="preliminary">Simpan Preliminary</button>
+            <button type="submit" name="status" value="final" onclick="return confirm('Tandatangani laporan? Laporan final dikirim ke Orthanc dan Khanza.')">Tandatangani (Final)</button>
+        </p>
+    </form>
+    {{else}}
+    <h3>Temuan</h3>
+    <div class="final">{{.Report.Temuan}}</div>
+    <h3>Kesan</h3>
+    <div class="final">{{.Report.Kesan}}</div>
+    {{end}}
+    {{if .Versions}}
+    <h3>Laporan di Khanza (versi {{len .Versions}})</h3>
+    <div class="final">{{.Gabungan}}</div>
+    <p><a href="/report/pdf?accession={{.Order.Accession}}" target="_blank">Cetak PDF</a> |
+       <a href="/report/history?accession={{.Order.Accession}}">Riwayat versi dan perbedaan</a></p>
+    {{if .CanEdit}}
+    <h3>Tambah Addendum</h3>
+    <p>Laporan final terkunci. Koreksi ditambahkan sebagai addendum dan dikirim ulang ke Khanza bersama laporan asli.</p>
+    <form method="post" action="/report/addendum">
+        <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+        <input type="hidden" name="accession" value="{{.Order.Accession}}">
+        <p>Alasan <input type="text" name="alasan" size="60" required></p>
+        <textarea name="teks" rows="5" required></textarea>
+        <p><button type="submit">Simpan Addendum</button></p>
+    </form>
+    {{end}}
+    {{end}}
+    {{if .CanEdit}}<p><a href="/report/templates">Kelola template dan makro laporan</a></p>{{end}}
+    <script>
+    // Temuan makro disisipkan di posisi kursor, kesan ditambahkan di akhir
+    function sisipkanMakro() {
+        var opt = document.getElementById('makro').selectedOptions[0];
+        if (!opt) return;
+        var temuan = document.getElementsByName('temuan')[0];
+        var kesan = document.getElementsByName('kesan')[0];
+        if (opt.dataset.temuan) {
+            var awal = temuan.selectionStart, akhir = temuan.selectionEnd;
+            temuan.value = temuan.value.slice(0, awal) + opt.dataset.temuan + temuan.value.slice(akhir);
+            temuan.selectionStart = temuan.selectionEnd = awal + opt.dataset.temuan.length;
+        }
+        if (opt.dataset.kesan) {
+            kesan.value = kesan.value ? kesan.value + "\n" + opt.dataset.kesan : opt.dataset.kesan;
+        }
+    }
+    </script>
+</body>
+</html>
+`
+
+func registerReportHandlers(mux *http.ServeMux, db *sql.DB, mwdb Store) {
+	mux.HandleFunc("/report", RequirePermission(mwdb, PermView, func(w http.ResponseWriter, r *http.Request) {
+		session := CurrentSession(r)
+		accession := r.URL.Query().Get("accession")
+		if accession == "" {
+			http.Error(w, "accession wajib diisi", http.StatusBadRequest)
+			return
+		}
+		order, err := loadReportOrder(RuntimeConfig(), mwdb, accession)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		report, err := mwdb.GetReport(accession)
+		if err != nil && err != sql.ErrNoRows {
+			http.Error(w, "Gagal ambil laporan: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var exams []OrderExam
+		if order.NoOrder != "" {
+			exams, _ = GetOrderExams(db, order.NoOrder)
+		}
+		canEdit := HasPermission(session.User.Role, PermReading)
+		var templates []ReportTemplate
+		var macros []reportMacroOption
+		pilihan, _ := strconv.Atoi(r.URL.Query().Get("template"))
+		if canEdit && report.Status != ReportFinal {
+			vars := ReportPlaceholders(order.worklist)
+			templates, _ = mwdb.GetReportTemplates()
+			// Laporan baru diisi template bawaan pemeriksaan
+			if pilihan == 0 && report.Status == "" {
+				kd := []string{order.worklist.RequestedProcedureID}
+				for _, e := range exams {
+					kd = append(kd, e.KdJenisPrw)
+				}
+				if t, ok := DefaultReportTemplate(templates, kd, order.Modality); ok {
+					pilihan = t.ID
+				}
+			}
+			for _, t := range templates {
+				if t.ID == pilihan {
+					report.Temuan, report.Kesan = FillPlaceholders(t.Temuan, vars), FillPlaceholders(t.Kesan, vars)
+				}
+			}
+			all, _ := mwdb.GetReportMacros()
+			for _, m := range macrosForModality(all, order.Modality) {
+				macros = append(macros, reportMacroOption{m.Kode, m.Nama, FillPlaceholders(m.Temuan, vars), FillPlaceholders(m.Kesan, vars)})
+			}
+		}
+		versions, err := mwdb.GetReportVersions(accession)
+		if err != nil {
+			http.Error(w, "Gagal ambil riwayat laporan: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// Belum ada temuan kritis berarti ID kosong
+		kritis, _ := mwdb.GetCriticalFindingByOrder(accession)
+		t, _ := template.New("report").Parse(reportTmpl)
+		t.Execute(w, struct {
+			Order     reportOrder
+			Exams     []OrderExam
+			Report    Report
+			Templates []ReportTemplate
+			Template  int
+			Macros    []reportMacroOption
+			Versions  []ReportVersion
+			Gabungan  string
+			Kritis    CriticalFinding
+			CanEdit   bool
+			Pesan     string
+			CSRFToken string
+		}{order, exams, report, templates, pilihan, macros, versions, CombinedReportText(versions), kritis, canEdit, r.URL.Query().Get("pesan"), session.CSRFToken})
+	}))
+
+	mux.HandleFunc("/report/save", RequirePermission(mwdb, PermReading, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		session := CurrentSession(r)
+		accession := r.FormValue("accession")
+		status := r.FormValue("status")
+		report := Report{
+			NomorOrder: accession,
+			Temuan:     strings.TrimSpace(r.FormValue("temuan")),
+			Kesan:      strings.TrimSpace(r.FormValue("kesan")),
+			Status:     status,
+			Penulis:    session.User.Username,
+			Kritis:     r.FormValue("kritis") == "1",
+		}
+		if status == ReportFinal {
+			// Isi terakhir disimpan dulu sebagai preliminary, lalu ditandatangani
+			report.Status = ReportPreliminary
+		} else if status != ReportDraft && status != ReportPreliminary {
+			http.Error(w, "status laporan tidak valid", http.StatusBadRequest)
+			return
+		}
+		pesan := "Laporan disimpan sebagai " + report.Status
+		err := mwdb.SaveReport(report)
+		if err == nil && status == ReportFinal {
+			pesan = "Laporan final, SR dikirim ke Orthanc dan hasil tersimpan di Khanza"
+			err = SignReport(db, mwdb, accession, session.User)
+		}
+		if err != nil {
+			pesan = "Gagal: " + err.Error()
+		}
+		http.Redirect(w, r, "/report?accession="+url.QueryEscape(accession)+"&pesan="+url.QueryEscape(pesan), http.StatusSeeOther)
+	}))
+}
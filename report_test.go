@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBuildBasicTextSR(t *testing.T) {
+	waktu := time.Date(2026, 1, 5, 10, 45, 30, 0, time.Local)
+	tests := []struct {
+		nama   string
+		report Report
+		isi    []string
+	}{
+		{"temuan dan kesan", Report{Temuan: "Cor normal.", Kesan: "Thorax normal."}, []string{"121071", "121073"}},
+		{"kesan saja", Report{Temuan: "  ", Kesan: "Thorax normal."}, []string{"121073"}},
+		{"temuan kritis", Report{Temuan: "Pneumothorax kanan.", Kritis: true}, []string{"121071", "KLASIFIKASI"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.nama, func(t *testing.T) {
+			tags := BuildBasicTextSR(tt.report, "Radiolog^Uji", waktu)
+			if tags["SOPClassUID"] != basicTextSRClass || tags["ContentDate"] != "20260105" || tags["ContentTime"] != "104530" {
+				t.Fatalf("tag SR %+v", tags)
+			}
+			content := tags["ContentSequence"].([]interface{})
+			if len(content) != len(tt.isi) {
+				t.Fatalf("%d item konten, seharusnya %d", len(content), len(tt.isi))
+			}
+			for i, item := range content {
+				code := item.(map[string]interface{})["ConceptNameCodeSequence"].([]interface{})[0].(map[string]string)
+				if code["CodeValue"] != tt.isi[i] {
+					t.Fatalf("item %d berkode %s, seharusnya %s", i, code["CodeValue"], tt.isi[i])
+				}
+			}
+			observer := tags["VerifyingObserverSequence"].([]interface{})[0].(map[string]interface{})
+			if observer["VerifyingObserverName"] != "Radiolog^Uji" {
+				t.Fatalf("radiolog penandatangan %v", observer["VerifyingObserverName"])
+			}
+		})
+	}
+}
+
+// Laporan final tidak bisa diubah atau difinalkan lagi; pembuatan SR yang
+// gagal mengembalikan status sebelumnya
+func TestReportFinalLock(t *testing.T) {
+	mwdb := openMemoryStore(t)
+	if err := mwdb.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+	const accession = "CR00010120260105"
+	if _, err := mwdb.ClaimReportFinal(accession, "radiolog1"); err == nil {
+		t.Fatal("laporan yang belum ada seharusnya tidak bisa difinalkan")
+	}
+	if err := mwdb.SaveReport(Report{NomorOrder: accession, Temuan: "Cor normal.", Status: ReportPreliminary, Penulis: "radiolog1"}); err != nil {
+		t.Fatal(err)
+	}
+	prev, err := mwdb.ClaimReportFinal(accession, "radiolog1")
+	if err != nil || prev != ReportPreliminary {
+		t.Fatalf("status sebelumnya %q: %v", prev, err)
+	}
+	if _, err := mwdb.ClaimReportFinal(accession, "radiolog2"); !errors.Is(err, ErrReportFinal) {
+		t.Fatalf("final kedua seharusnya ErrReportFinal, dapat %v", err)
+	}
+	if err := mwdb.SaveReport(Report{NomorOrder: accession, Status: ReportDraft}); !errors.Is(err, ErrReportFinal) {
+		t.Fatalf("simpan laporan final seharusnya ErrReportFinal, dapat %v", err)
+	}
+
+	if err := mwdb.ReleaseReportFinal(accession, prev); err != nil {
+		t.Fatal(err)
+	}
+	r, err := mwdb.GetReport(accession)
+	if err != nil || r.Status != ReportPreliminary || r.FinalPada != nil {
+		t.Fatalf("laporan setelah SR gagal %+v: %v", r, err)
+	}
+	// Setelah SR tersimpan, status final tidak bisa dilepas lagi
+	if _, err := mwdb.ClaimReportFinal(accession, "radiolog1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mwdb.SetReportSRInstance(accession, "sr-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := mwdb.ReleaseReportFinal(accession, prev); err != nil {
+		t.Fatal(err)
+	}
+	if r, err = mwdb.GetReport(accession); err != nil || r.Status != ReportFinal || r.SRInstance != "sr-1" {
+		t.Fatalf("laporan dengan SR %+v: %v", r, err)
+	}
+}
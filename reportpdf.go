@@ -0,0 +1,388 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/jpeg"
+	_ "image/png"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Laporan radiologi cetak (PDF) untuk diserahkan ke pasien: kop rumah sakit,
+// identitas pasien dari Khanza, pemeriksaan, laporan final beserta addendum,
+// radiolog penandatangan dan gambar kunci dari preview Orthanc. Bila
+// REPORT_PDF_FOLDER diisi, PDF disimpan setiap hasil dikirim ke Khanza dan
+// URL-nya (REPORT_PDF_URL) dicatat di gambar_radiologi.
+
+const (
+	pdfMargin       = 50.0
+	pdfMarginBottom = 60.0
+)
+
+type ReportPDFData struct {
+	Accession     string
+	NoOrder       string
+	NoRawat       string
+	NoRM          string
+	NamaPasien    string
+	JenisKelamin  string
+	TglLahir      string
+	Umur          string
+	DokterPerujuk string
+	TglPermintaan string
+	Pemeriksaan   []OrderExam
+	Teks          string
+	Radiolog      string
+	FinalPada     time.Time
+	Gambar        []pdfImage
+}
+
+// Modality tanpa piksel yang tidak punya preview bermakna
+var nonImageModality = map[string]bool{"SR": true, "KO": true, "PR": true, "DOC": true}
+
+// Kumpulkan isi laporan PDF. Laporan harus sudah punya versi final.
+// Kegagalan mengambil gambar kunci hanya dicatat.
+func LoadReportPDFData(cfg Config, db *sql.DB, mwdb Store, accession string) (ReportPDFData, error) {
+	data := ReportPDFData{Accession: accession}
+	versions, err := mwdb.GetReportVersions(accession)
+	if err != nil {
+		return data, err
+	}
+	if len(versions) == 0 {
+		return data, fmt.Errorf("laporan %s belum final", accession)
+	}
+	data.Teks = CombinedReportText(versions)
+	data.Radiolog = versions[0].Penulis
+	data.FinalPada = versions[0].Dibuat
+
+	if data.NoOrder, err = noorderOf(mwdb, accession); err != nil {
+		return data, err
+	}
+	var tglLahir, jk, tglPermintaan string
+	err = db.QueryRow(`SELECT pr.no_rawat, p.no_rkm_medis, IFNULL(p.nm_pasien, ''), IFNULL(p.tgl_lahir, ''), IFNULL(p.jk, ''),
+		IFNULL(d.nm_dokter, IFNULL(pr.dokter_perujuk, '')), IFNULL(pr.tgl_permintaan, '')
+		FROM permintaan_radiologi pr
+		JOIN reg_periksa r ON pr.no_rawat = r.no_rawat
+		JOIN pasien p ON r.no_rkm_medis = p.no_rkm_medis
+		LEFT JOIN dokter d ON pr.dokter_perujuk = d.kd_dokter
+		WHERE pr.noorder = ?`, data.NoOrder).
+		Scan(&data.NoRawat, &data.NoRM, &data.NamaPasien, &tglLahir, &jk, &data.DokterPerujuk, &tglPermintaan)
+	if err != nil {
+		return data, fmt.Errorf("data pasien order %s: %v", data.NoOrder, err)
+	}
+	data.JenisKelamin = map[string]string{"L": "Laki-laki", "P": "Perempuan"}[jk]
+	permintaan, errPermintaan := time.Parse("2006-01-02", firstN(tglPermintaan, 10))
+	if errPermintaan == nil {
+		data.TglPermintaan = permintaan.Format("02-01-2006")
+	}
+	if lahir, err := time.Parse("2006-01-02", firstN(tglLahir, 10)); err == nil {
+		data.TglLahir = lahir.Format("02-01-2006")
+		if errPermintaan != nil {
+			permintaan = time.Now()
+		}
+		data.Umur = patientAge(lahir, permintaan)
+	}
+	if data.Pemeriksaan, err = GetOrderExams(db, data.NoOrder); err != nil {
+		return data, err
+	}
+
+	if cfg.ReportKeyImages > 0 {
+		data.Gambar, err = FetchKeyImages(cfg, accession, cfg.ReportKeyImages)
+		if err != nil {
+			NewLogger(mwdb, CompSR).Warn("Gambar kunci untuk laporan PDF tidak bisa diambil", LogFields{Accession: accession, Pasien: data.NoOrder, Err: err})
+		}
+	}
+	return data, nil
+}
+
+func firstN(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
+
+// Ambil preview instance tengah dari tiap seri gambar sampai n gambar
+func FetchKeyImages(cfg Config, accession string, n int) ([]pdfImage, error) {
+	study, err := findOrthancStudy(cfg, accession)
+	if err != nil || study.ID == "" {
+		return nil, err
+	}
+	body, err := orthancGet(cfg, "/studies/"+study.ID+"/series", "")
+	if err != nil {
+		return nil, err
+	}
+	var series []struct {
+		MainDicomTags struct {
+			Modality string
+		}
+		Instances []string
+	}
+	if err := json.Unmarshal(body, &series); err != nil {
+		return nil, err
+	}
+	var result []pdfImage
+	for _, s := range series {
+		if len(result) >= n {
+			break
+		}
+		if len(s.Instances) == 0 || nonImageModality[s.MainDicomTags.Modality] {
+			continue
+		}
+		img, err := fetchInstancePreview(cfg, s.Instances[len(s.Instances)/2])
+		if err != nil {
+			return result, err
+		}
+		result = append(result, img)
+	}
+	return result, nil
+}
+
+// Preview Orthanc (PNG atau JPEG) dijadikan JPEG baseline untuk DCTDecode
+func fetchInstancePreview(cfg Config, instanceID string) (pdfImage, error) {
+	body, err := orthancGet(cfg, "/instances/"+instanceID+"/preview", "image/jpeg")
+	if err != nil {
+		return pdfImage{}, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(body))
+	if err != nil {
+		return pdfImage{}, fmt.Errorf("preview %s: %v", instanceID, err)
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85}); err != nil {
+		return pdfImage{}, err
+	}
+	_, gray := img.(*image.Gray)
+	b := img.Bounds()
+	return pdfImage{Data: buf.Bytes(), Width: b.Dx(), Height: b.Dy(), Gray: gray}, nil
+}
+
+// Penata letak sederhana dari atas ke bawah dengan pindah halaman otomatis
+type reportPDFLayout struct {
+	doc *pdfDoc
+	cfg Config
+	y   float64
+}
+
+func (l *reportPDFLayout) lebar() float64 {
+	return pdfPageWidth - 2*pdfMargin
+}
+
+func (l *reportPDFLayout) center(size float64, bold bool, s string) {
+	l.doc.Text((pdfPageWidth-pdfTextWidth(s, size, bold))/2, l.y, size, bold, s)
+}
+
+func (l *reportPDFLayout) newPage() {
+	l.doc.AddPage()
+	l.y = pdfPageHeight - pdfMargin
+	l.center(14, true, l.cfg.ReportHeaderTitle)
+	l.y -= 15
+	for _, baris := range strings.Split(l.cfg.ReportHeaderLines, "|") {
+		if baris = strings.TrimSpace(baris); baris != "" {
+			l.center(9, false, baris)
+			l.y -= 11
+		}
+	}
+	l.doc.Line(pdfMargin, l.y, pdfPageWidth-pdfMargin, l.y, 1.2)
+	l.y -= 20
+}
+
+// Pastikan masih ada ruang setinggi h, bila tidak pindah halaman
+func (l *reportPDFLayout) need(h float64) {
+	if l.y-h < pdfMarginBottom {
+		l.newPage()
+	}
+}
+
+func (l *reportPDFLayout) paragraph(teks string, size float64, bold bool) {
+	for _, baris := range pdfWrap(teks, size, l.lebar(), bold) {
+		l.need(size + 4)
+		l.doc.Text(pdfMargin, l.y, size, bold, baris)
+		l.y -= size + 4
+	}
+}
+
+// Susun PDF laporan dari data yang sudah dikumpulkan
+func RenderReportPDF(cfg Config, data ReportPDFData) []byte {
+	l := &reportPDFLayout{doc: newPDF(), cfg: cfg}
+	l.newPage()
+	l.center(12, true, "HASIL PEMERIKSAAN RADIOLOGI")
+	l.y -= 22
+
+	// Identitas dua kolom
+	kiri := [][2]string{
+		{"Nama", data.NamaPasien}, {"No. RM", data.NoRM},
+		{"Jenis Kelamin", data.JenisKelamin}, {"Tgl. Lahir / Umur", strings.Trim(data.TglLahir+" / "+data.Umur, " /")},
+	}
+	kanan := [][2]string{
+		{"No. Rawat", data.NoRawat}, {"No. Order", data.NoOrder},
+		{"Accession", data.Accession}, {"Dokter Perujuk", data.DokterPerujuk},
+	}
+	kolom := l.lebar() / 2
+	for i := range kiri {
+		for j, pasangan := range [][2]string{kiri[i], kanan[i]} {
+			x := pdfMargin + float64(j)*kolom
+			l.doc.Text(x, l.y, 9.5, false, pasangan[0])
+			l.doc.Text(x+85, l.y, 9.5, false, ": "+pasangan[1])
+		}
+		l.y -= 14
+	}
+	if data.TglPermintaan != "" {
+		l.doc.Text(pdfMargin, l.y, 9.5, false, "Tgl. Permintaan")
+		l.doc.Text(pdfMargin+85, l.y, 9.5, false, ": "+data.TglPermintaan)
+		l.y -= 14
+	}
+	l.y += 4
+	l.doc.Line(pdfMargin, l.y, pdfPageWidth-pdfMargin, l.y, 0.5)
+	l.y -= 18
+
+	var nama []string
+	for _, e := range data.Pemeriksaan {
+		nama = append(nama, e.Nama)
+	}
+	l.paragraph("Pemeriksaan: "+strings.Join(nama, ", "), 10.5, true)
+	l.y -= 8
+	l.paragraph(data.Teks, 10.5, false)
+
+	// Blok tanda tangan di kanan
+	l.y -= 20
+	l.need(80)
+	x := pdfMargin + kolom + 40
+	l.doc.Text(x, l.y, 10, false, "Tanggal: "+data.FinalPada.Format("02-01-2006 15:04"))
+	l.y -= 14
+	l.doc.Text(x, l.y, 10, false, "Dokter Radiolog,")
+	l.y -= 40
+	l.doc.Text(x, l.y, 10, true, data.Radiolog)
+	l.y -= 12
+	l.doc.Text(x, l.y, 7.5, false, "Ditandatangani secara elektronik")
+	l.y -= 24
+
+	if len(data.Gambar) > 0 {
+		l.need(40)
+		l.doc.Text(pdfMargin, l.y, 10.5, true, "Gambar Kunci")
+		l.y -= 16
+		// Dua gambar per baris, tinggi dibatasi agar tidak memenuhi halaman
+		sel := (l.lebar() - 10) / 2
+		for i := 0; i < len(data.Gambar); i += 2 {
+			tinggi := 0.0
+			var ukuran [][2]float64
+			for _, img := range data.Gambar[i:minInt(i+2, len(data.Gambar))] {
+				w, h := sel, sel*float64(img.Height)/float64(img.Width)
+				if h > 220 {
+					w, h = w*220/h, 220
+				}
+				ukuran = append(ukuran, [2]float64{w, h})
+				if h > tinggi {
+					tinggi = h
+				}
+			}
+			l.need(tinggi + 10)
+			for j, u := range ukuran {
+				idx := l.doc.AddJPEG(data.Gambar[i+j])
+				l.doc.Image(idx, pdfMargin+float64(j)*(sel+10), l.y-u[1], u[0], u[1])
+			}
+			l.y -= tinggi + 10
+		}
+	}
+
+	// Kaki halaman setelah jumlah halaman diketahui
+	for i, p := range l.doc.pages {
+		halaman := fmt.Sprintf("Halaman %d dari %d", i+1, len(l.doc.pages))
+		fmt.Fprintf(p, "BT /F1 8.0 Tf %.2f 30.00 Td (%s) Tj ET\n", pdfMargin, pdfEscape(cfg.ReportFooter))
+		fmt.Fprintf(p, "BT /F1 8.0 Tf %.2f 30.00 Td (%s) Tj ET\n",
+			pdfPageWidth-pdfMargin-pdfTextWidth(halaman, 8, false), pdfEscape(halaman))
+	}
+	return l.doc.Bytes()
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func GenerateReportPDF(cfg Config, db *sql.DB, mwdb Store, accession string) ([]byte, error) {
+	data, err := LoadReportPDFData(cfg, db, mwdb, accession)
+	if err != nil {
+		return nil, err
+	}
+	return RenderReportPDF(cfg, data), nil
+}
+
+// Nama file PDF di REPORT_PDF_FOLDER. Folder ini biasanya dibuka web server
+// tanpa login, jadi nama diberi token acak agar tidak bisa ditebak dari
+// accession. PDF yang sudah ada untuk accession yang sama ditimpa sehingga
+// link di gambar_radiologi tetap satu.
+func reportPDFName(folder, accession string) (string, error) {
+	prefix := filepath.Base(accession) + "-"
+	entries, err := os.ReadDir(folder)
+	if err != nil {
+		return "", err
+	}
+	for _, e := range entries {
+		nama := e.Name()
+		// randomToken menghasilkan 64 karakter hex
+		if strings.HasPrefix(nama, prefix) && strings.HasSuffix(nama, ".pdf") && len(nama) == len(prefix)+64+len(".pdf") {
+			return nama, nil
+		}
+	}
+	return prefix + randomToken() + ".pdf", nil
+}
+
+// Simpan PDF ke REPORT_PDF_FOLDER dan catat URL-nya di gambar_radiologi
+// dengan kunci waktu yang sama dengan hasil_radiologi. Mengembalikan path file.
+func PublishReportPDF(cfg Config, db *sql.DB, mwdb Store, accession, tglPeriksa, jam string) (string, error) {
+	data, err := LoadReportPDFData(cfg, db, mwdb, accession)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(cfg.ReportPDFFolder, 0750); err != nil {
+		return "", err
+	}
+	nama, err := reportPDFName(cfg.ReportPDFFolder, accession)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(cfg.ReportPDFFolder, nama)
+	if err := os.WriteFile(path, RenderReportPDF(cfg, data), 0640); err != nil {
+		return "", err
+	}
+	if cfg.ReportPDFURL == "" {
+		return path, nil
+	}
+	link := strings.TrimRight(cfg.ReportPDFURL, "/") + "/" + nama
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM gambar_radiologi WHERE no_rawat = ? AND tgl_periksa = ? AND jam = ? AND lokasi_gambar = ?",
+		data.NoRawat, tglPeriksa, jam, link).Scan(&n); err != nil {
+		return path, err
+	}
+	if n == 0 {
+		if _, err := db.Exec("INSERT INTO gambar_radiologi (no_rawat, tgl_periksa, jam, lokasi_gambar) VALUES (?, ?, ?, ?)",
+			data.NoRawat, tglPeriksa, jam, link); err != nil {
+			return path, fmt.Errorf("gambar_radiologi: %v", err)
+		}
+	}
+	return path, nil
+}
+
+func registerReportPDFHandlers(mux *http.ServeMux, db *sql.DB, mwdb Store) {
+	mux.HandleFunc("/report/pdf", RequirePermission(mwdb, PermView, func(w http.ResponseWriter, r *http.Request) {
+		accession := r.URL.Query().Get("accession")
+		pdf, err := GenerateReportPDF(RuntimeConfig(), db, mwdb, accession)
+		if err != nil {
+			http.Error(w, "Gagal membuat laporan PDF: "+err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", filepath.Base(accession)+".pdf"))
+		w.Write(pdf)
+	}))
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReportPDFName(t *testing.T) {
+	token := strings.Repeat("ab", 32)
+	tests := []struct {
+		nama      string
+		ada       []string
+		accession string
+		want      string
+	}{
+		{"folder kosong", nil, "CR00010120260105", ""},
+		{"pakai ulang nama lama", []string{"CR00010120260105-" + token + ".pdf"}, "CR00010120260105", "CR00010120260105-" + token + ".pdf"},
+		{"accession lain", []string{"CR00010220260105-" + token + ".pdf"}, "CR00010120260105", ""},
+		{"token bukan buatan middleware", []string{"CR00010120260105-salinan.pdf"}, "CR00010120260105", ""},
+		{"path di accession dibuang", nil, "../../etc/CR00010120260105", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.nama, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, f := range tt.ada {
+				if err := os.WriteFile(filepath.Join(dir, f), nil, 0640); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := reportPDFName(dir, tt.accession)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.want != "" {
+				if got != tt.want {
+					t.Fatalf("nama %q, seharusnya %q", got, tt.want)
+				}
+				return
+			}
+			prefix := filepath.Base(tt.accession) + "-"
+			if !strings.HasPrefix(got, prefix) || !strings.HasSuffix(got, ".pdf") || len(got) != len(prefix)+64+len(".pdf") || strings.Contains(got, "/") {
+				t.Fatalf("nama baru %q tidak berformat %s<token>.pdf", got, prefix)
+			}
+			if again, _ := reportPDFName(dir, tt.accession); again == got {
+				t.Fatal("token nama baru seharusnya acak")
+			}
+		})
+	}
+}
+
+// PDF ditulis 0640 di folder 0750, ditimpa di nama yang sama saat laporan
+// dikirim ulang, dan URL-nya dicatat sekali di gambar_radiologi
+func TestPublishReportPDF(t *testing.T) {
+	var cfg Config
+	if err := applyDefaults(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	cfg.ReportKeyImages = 0
+	cfg.ReportPDFFolder = filepath.Join(t.TempDir(), "laporan")
+	cfg.ReportPDFURL = "http://pacs.rs.local/laporan/"
+	SetRuntimeConfig(cfg)
+	defer SetRuntimeConfig(Config{})
+
+	db := openFakeKhanza(t)
+	mwdb := openMemoryStore(t)
+	if err := mwdb.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+	o := fakeOrder{
+		NoOrder: "PR202601050001", NoRawat: "2026/01/05/000001", NoRM: "000101", NamaPasien: "PASIEN UJI",
+		KdJenisPrw: "RAD001", Pemeriksaan: "THORAX PA", Waktu: time.Date(2026, 1, 5, 9, 30, 0, 0, time.Local),
+	}
+	if err := seedFakeOrder(db, o); err != nil {
+		t.Fatal(err)
+	}
+	const accession = "CR00010120260105"
+	wl, _ := json.Marshal(WorklistRequest{PatientID: o.NoOrder})
+	mwdb.InsertSentWorklist(accession, string(wl), nil)
+	if _, err := PublishReportPDF(cfg, db, mwdb, accession, "2026-01-05", "09:30:00"); err == nil {
+		t.Fatal("laporan yang belum final seharusnya tidak dibuat PDF-nya")
+	}
+
+	var paths []string
+	for _, teks := range []string{"Thorax normal.", "Addendum: kista simpleks."} {
+		if _, err := mwdb.AddReportVersion(ReportVersion{NomorOrder: accession, Teks: teks, Penulis: "Radiolog Uji"}); err != nil {
+			t.Fatal(err)
+		}
+		path, err := PublishReportPDF(cfg, db, mwdb, accession, "2026-01-05", "09:30:00")
+		if err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	if paths[0] != paths[1] {
+		t.Fatalf("PDF kiriman ulang seharusnya menimpa %s, dapat %s", paths[0], paths[1])
+	}
+	info, err := os.Stat(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Fatalf("mode file PDF %v, seharusnya 0640", info.Mode().Perm())
+	}
+	if info, err = os.Stat(cfg.ReportPDFFolder); err != nil || info.Mode().Perm()&^0750 != 0 {
+		t.Fatalf("folder PDF %v: %v", info.Mode(), err)
+	}
+	pdf, err := os.ReadFile(paths[0])
+	if err != nil || !strings.Contains(string(pdf), "kista simpleks") {
+		t.Fatalf("PDF tidak memuat addendum terbaru: %v", err)
+	}
+
+	var links []string
+	rows, err := db.Query("SELECT lokasi_gambar FROM gambar_radiologi WHERE no_rawat = ?", o.NoRawat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var link string
+		rows.Scan(&link)
+		links = append(links, link)
+	}
+	if want := cfg.ReportPDFURL + filepath.Base(paths[0]); len(links) != 1 || links[0] != want {
+		t.Fatalf("link gambar_radiologi %v, seharusnya [%s]", links, want)
+	}
+}
@@ -0,0 +1,543 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Pustaka template dan makro laporan. Template dipilih otomatis untuk laporan
+// baru berdasarkan kd_jenis_prw pemeriksaan, lalu modality; makro berisi
+// kalimat normal yang disisipkan radiolog ke laporan. Keduanya mendukung
+// placeholder {nama}, {umur} dan lainnya dari data worklist order, dan bisa
+// dipindah antar instalasi sebagai file JSON.
+
+type ReportTemplate struct {
+	ID         int       `json:"-"`
+	Nama       string    `json:"nama"`
+	KdJenisPrw string    `json:"kd_jenis_prw,omitempty"`
+	Modality   string    `json:"modality,omitempty"`
+	Temuan     string    `json:"temuan"`
+	Kesan      string    `json:"kesan"`
+	Diubah     time.Time `json:"-"`
+}
+
+type ReportMacro struct {
+	ID       int       `json:"-"`
+	Kode     string    `json:"kode"`
+	Nama     string    `json:"nama"`
+	Modality string    `json:"modality,omitempty"`
+	Temuan   string    `json:"temuan"`
+	Kesan    string    `json:"kesan"`
+	Diubah   time.Time `json:"-"`
+}
+
+// Format file import/export
+type ReportLibrary struct {
+	Versi    int              `json:"versi"`
+	Template []ReportTemplate `json:"template"`
+	Makro    []ReportMacro    `json:"makro"`
+}
+
+const reportLibraryVersion = 1
+
+var macroCode = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+func (s *sqlStore) GetReportTemplates() ([]ReportTemplate, error) {
+	rows, err := s.db.Query("SELECT id, nama, kd_jenis_prw, modality, IFNULL(temuan, ''), IFNULL(kesan, ''), diubah FROM report_template ORDER BY nama")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []ReportTemplate
+	for rows.Next() {
+		var t ReportTemplate
+		if err := rows.Scan(&t.ID, &t.Nama, &t.KdJenisPrw, &t.Modality, &t.Temuan, &t.Kesan, &t.Diubah); err != nil {
+			return nil, err
+		}
+		result = append(result, t)
+	}
+	return result, rows.Err()
+}
+
+func (s *sqlStore) GetReportTemplate(id int) (ReportTemplate, error) {
+	var t ReportTemplate
+	err := s.db.QueryRow("SELECT id, nama, kd_jenis_prw, modality, IFNULL(temuan, ''), IFNULL(kesan, ''), diubah FROM report_template WHERE id=?", id).
+		Scan(&t.ID, &t.Nama, &t.KdJenisPrw, &t.Modality, &t.Temuan, &t.Kesan, &t.Diubah)
+	return t, err
+}
+
+// Simpan template. Tanpa ID, template dengan nama yang sama ditimpa.
+func (s *sqlStore) SaveReportTemplate(t ReportTemplate) error {
+	t.Modality = strings.ToUpper(t.Modality)
+	query, key := "UPDATE report_template SET nama=?, kd_jenis_prw=?, modality=?, temuan=?, kesan=?, diubah=? WHERE nama=?", interface{}(t.Nama)
+	if t.ID > 0 {
+		query, key = "UPDATE report_template SET nama=?, kd_jenis_prw=?, modality=?, temuan=?, kesan=?, diubah=? WHERE id=?", t.ID
+	}
+	res, err := s.db.Exec(query, t.Nama, t.KdJenisPrw, t.Modality, t.Temuan, t.Kesan, time.Now(), key)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 || t.ID > 0 {
+		return nil
+	}
+	_, err = s.db.Exec("INSERT INTO report_template (nama, kd_jenis_prw, modality, temuan, kesan, diubah) VALUES (?, ?, ?, ?, ?, ?)",
+		t.Nama, t.KdJenisPrw, t.Modality, t.Temuan, t.Kesan, time.Now())
+	return err
+}
+
+func (s *sqlStore) DeleteReportTemplate(id int) error {
+	_, err := s.db.Exec("DELETE FROM report_template WHERE id=?", id)
+	return err
+}
+
+func (s *sqlStore) GetReportMacros() ([]ReportMacro, error) {
+	rows, err := s.db.Query("SELECT id, kode, nama, modality, IFNULL(temuan, ''), IFNULL(kesan, ''), diubah FROM report_macro ORDER BY kode")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []ReportMacro
+	for rows.Next() {
+		var m ReportMacro
+		if err := rows.Scan(&m.ID, &m.Kode, &m.Nama, &m.Modality, &m.Temuan, &m.Kesan, &m.Diubah); err != nil {
+			return nil, err
+		}
+		result = append(result, m)
+	}
+	return result, rows.Err()
+}
+
+// Simpan makro, kode yang sudah ada ditimpa
+func (s *sqlStore) SaveReportMacro(m ReportMacro) error {
+	m.Modality = strings.ToUpper(m.Modality)
+	res, err := s.db.Exec("UPDATE report_macro SET nama=?, modality=?, temuan=?, kesan=?, diubah=? WHERE kode=?",
+		m.Nama, m.Modality, m.Temuan, m.Kesan, time.Now(), m.Kode)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return nil
+	}
+	_, err = s.db.Exec("INSERT INTO report_macro (kode, nama, modality, temuan, kesan, diubah) VALUES (?, ?, ?, ?, ?, ?)",
+		m.Kode, m.Nama, m.Modality, m.Temuan, m.Kesan, time.Now())
+	return err
+}
+
+func (s *sqlStore) DeleteReportMacro(id int) error {
+	_, err := s.db.Exec("DELETE FROM report_macro WHERE id=?", id)
+	return err
+}
+
+// Template bawaan laporan baru: kd_jenis_prw pemeriksaan order lebih dulu,
+// lalu template umum untuk modality order
+func DefaultReportTemplate(templates []ReportTemplate, kdJenisPrw []string, modality string) (ReportTemplate, bool) {
+	for _, kd := range kdJenisPrw {
+		for _, t := range templates {
+			if kd != "" && t.KdJenisPrw == kd {
+				return t, true
+			}
+		}
+	}
+	for _, t := range templates {
+		if t.KdJenisPrw == "" && t.Modality != "" && strings.EqualFold(t.Modality, modality) {
+			return t, true
+		}
+	}
+	return ReportTemplate{}, false
+}
+
+// Makro yang berlaku untuk modality order (makro tanpa modality berlaku umum)
+func macrosForModality(macros []ReportMacro, modality string) []ReportMacro {
+	var result []ReportMacro
+	for _, m := range macros {
+		if m.Modality == "" || strings.EqualFold(m.Modality, modality) {
+			result = append(result, m)
+		}
+	}
+	return result
+}
+
+// Placeholder yang dikenali di template dan makro
+var reportPlaceholderHelp = []struct{ Nama, Isi string }{
+	{"nama", "nama pasien"},
+	{"umur", "umur pasien pada tanggal periksa"},
+	{"jk", "jenis kelamin"},
+	{"tgl_lahir", "tanggal lahir"},
+	{"tgl_periksa", "tanggal permintaan pemeriksaan"},
+	{"pemeriksaan", "nama pemeriksaan"},
+	{"modality", "modality"},
+	{"no_order", "nomor order Khanza"},
+}
+
+var placeholderPattern = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+func formatDicomDate(da string) string {
+	t, err := time.Parse("20060102", da)
+	if err != nil {
+		return da
+	}
+	return t.Format("02-01-2006")
+}
+
+// Umur dalam tahun, atau bulan/hari untuk bayi
+func patientAge(lahir, pada time.Time) string {
+	tahun := pada.Year() - lahir.Year()
+	bulan := int(pada.Month()) - int(lahir.Month())
+	if pada.Day() < lahir.Day() {
+		bulan--
+	}
+	if bulan < 0 {
+		tahun--
+		bulan += 12
+	}
+	switch {
+	case tahun > 0:
+		return fmt.Sprintf("%d tahun", tahun)
+	case bulan > 0:
+		return fmt.Sprintf("%d bulan", bulan)
+	}
+	return fmt.Sprintf("%d hari", int(pada.Sub(lahir).Hours()/24))
+}
+
+func ReportPlaceholders(wl WorklistRequest) map[string]string {
+	jk := map[string]string{"M": "Laki-laki", "F": "Perempuan"}[wl.PatientSex]
+	vars := map[string]string{
+		"nama":        dicomName(wl.PatientName),
+		"jk":          jk,
+		"tgl_lahir":   formatDicomDate(wl.PatientBirthDate),
+		"tgl_periksa": formatDicomDate(wl.ScheduledProcedureStepStartDate),
+		"pemeriksaan": wl.RequestedProcedureDescription,
+		"modality":    wl.Modality,
+		"no_order":    wl.PatientID,
+		"umur":        "",
+	}
+	lahir, err := time.Parse("20060102", wl.PatientBirthDate)
+	if err == nil {
+		pada, err := time.Parse("20060102", wl.ScheduledProcedureStepStartDate)
+		if err != nil {
+			pada = time.Now()
+		}
+		vars["umur"] = patientAge(lahir, pada)
+	}
+	return vars
+}
+
+// Ganti placeholder yang dikenal; yang tidak dikenal dibiarkan apa adanya
+func FillPlaceholders(teks string, vars map[string]string) string {
+	return placeholderPattern.ReplaceAllStringFunc(teks, func(p string) string {
+		if v, ok := vars[p[1:len(p)-1]]; ok {
+			return v
+		}
+		return p
+	})
+}
+
+func ExportReportLibrary(mwdb ReportTemplateStore) (ReportLibrary, error) {
+	lib := ReportLibrary{Versi: reportLibraryVersion}
+	var err error
+	if lib.Template, err = mwdb.GetReportTemplates(); err != nil {
+		return lib, err
+	}
+	lib.Makro, err = mwdb.GetReportMacros()
+	return lib, err
+}
+
+// Import JSON pustaka laporan: template ditimpa berdasarkan nama, makro
+// berdasarkan kode. Semua entri divalidasi dulu sebelum ada yang disimpan.
+func ImportReportLibrary(mwdb ReportTemplateStore, r io.Reader) (int, int, error) {
+	var lib ReportLibrary
+	if err := json.NewDecoder(r).Decode(&lib); err != nil {
+		return 0, 0, fmt.Errorf("JSON tidak valid: %v", err)
+	}
+	if lib.Versi != reportLibraryVersion {
+		return 0, 0, fmt.Errorf("versi file %d tidak didukung, seharusnya %d", lib.Versi, reportLibraryVersion)
+	}
+	for i, t := range lib.Template {
+		if strings.TrimSpace(t.Nama) == "" {
+			return 0, 0, fmt.Errorf("template ke-%d: nama wajib diisi", i+1)
+		}
+	}
+	for i, m := range lib.Makro {
+		if !macroCode.MatchString(m.Kode) || strings.TrimSpace(m.Nama) == "" {
+			return 0, 0, fmt.Errorf("makro ke-%d: kode %q tidak valid atau nama kosong", i+1, m.Kode)
+		}
+	}
+	for _, t := range lib.Template {
+		t.ID = 0
+		if err := mwdb.SaveReportTemplate(t); err != nil {
+			return 0, 0, fmt.Errorf("template %s: %v", t.Nama, err)
+		}
+	}
+	for _, m := range lib.Makro {
+		if err := mwdb.SaveReportMacro(m); err != nil {
+			return len(lib.Template), 0, fmt.Errorf("makro %s: %v", m.Kode, err)
+		}
+	}
+	return len(lib.Template), len(lib.Makro), nil
+}
+
+var reportTemplatesTmpl = `
+<!DOCTYPE html>
+<html>
+<head>
+    <title>Template dan Makro Laporan</title>
+    <style>
+        body { font-family: Arial; margin: 40px; }
+        table { border-collapse: collapse; width: 100%; margin-bottom: 30px; }
+        th, td { border: 1px solid #ccc; padding: 8px; text-align: left; vertical-align: top; }
+        th { background: #f0f0f0; }
+        td.isi { white-space: pre-wrap; font-size: 12px; }
+        textarea { width: 100%; }
+        form.inline { display: inline; margin: 0; }
+        code { background: #f4f4f4; padding: 1px 4px; }
+    </style>
+</head>
+<body>
+    <h2>Template dan Makro Laporan</h2>
+    <p><a href="/reading">Worklist Baca</a> | <a href="/report/templates/export">Export JSON</a></p>
+    {{if .Pesan}}<p><b>{{.Pesan}}</b></p>{{end}}
+    <p>Placeholder: {{range .Placeholder}}<code>{{"{"}}{{.Nama}}{{"}"}}</code> {{.Isi}}; {{end}}</p>
+
+    <h3>Template</h3>
+    <p>Laporan baru memakai template dengan kd_jenis_prw pemeriksaan order, bila tidak ada memakai template tanpa kd_jenis_prw untuk modality order.</p>
+    <table>
+        <tr><th>Nama</th><th>kd_jenis_prw</th><th>Modality</th><th>Temuan</th><th>Kesan</th><th>Diubah</th><th></th></tr>
+        {{range .Templates}}
+        <tr>
+            <td>{{.Nama}}</td><td>{{.KdJenisPrw}}</td><td>{{.Modality}}</td><td class="isi">{{.Temuan}}</td><td class="isi">{{.Kesan}}</td>
+            <td>{{.Diubah.Format "2006-01-02 15:04"}}</td>
+            <td>
+                <a href="/report/templates?id={{.ID}}">Ubah</a>
+                <form class="inline" method="post" action="/report/templates/delete">
+                    <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+                    <input type="hidden" name="id" value="{{.ID}}">
+                    <button type="submit">Hapus</button>
+                </form>
+            </td>
+        </tr>
+        {{end}}
+    </table>
+    <h4>{{if .Edit.ID}}Ubah{{else}}Tambah{{end}} Template</h4>
+    <form method="post" action="/report/templates">
+        <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+        <input type="hidden" name="id" value="{{.Edit.ID}}">
+        <p>Nama <input type="text" name="nama" value="{{.Edit.Nama}}" required>
+           kd_jenis_prw <input type="text" name="kd_jenis_prw" value="{{.Edit.KdJenisPrw}}" size="10">
+           Modality <input type="text" name="modality" value="{{.Edit.Modality}}" size="4"></p>
+        <p>Temuan<br><textarea name="temuan" rows="8">{{.Edit.Temuan}}</textarea></p>
+        <p>Kesan<br><textarea name="kesan" rows="4">{{.Edit.Kesan}}</textarea></p>
+        <button type="submit">Simpan Template</button>
+    </form>
+
+    <h3>Makro</h3>
+    <table>
+        <tr><th>Kode</th><th>Nama</th><th>Modality</th><th>Temuan</th><th>Kesan</th><th></th></tr>
+        {{range .Macros}}
+        <tr>
+            <td>{{.Kode}}</td><td>{{.Nama}}</td><td>{{.Modality}}</td><td class="isi">{{.Temuan}}</td><td class="isi">{{.Kesan}}</td>
+            <td><form class="inline" method="post" action="/report/macros/delete">
+                <input type="hidden" name="csrf_token" value="{{$.CSRFToken}}">
+                <input type="hidden" name="id" value="{{.ID}}">
+                <button type="submit">Hapus</button>
+            </form></td>
+        </tr>
+        {{end}}
+    </table>
+    <h4>Tambah / Ubah Makro</h4>
+    <form method="post" action="/report/macros">
+        <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+        <p>Kode <input type="text" name="kode" placeholder="normal-thorax" required>
+           Nama <input type="text" name="nama" required>
+           Modality <input type="text" name="modality" size="4"></p>
+        <p>Temuan<br><textarea name="temuan" rows="4"></textarea></p>
+        <p>Kesan<br><textarea name="kesan" rows="2"></textarea></p>
+        <button type="submit">Simpan Makro</button>
+    </form>
+
+    <h3>Import JSON</h3>
+    <form method="post" action="/report/templates/import" enctype="multipart/form-data">
+        <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+        <input type="file" name="file" accept="application/json" required>
+        <button type="submit">Import</button>
+    </form>
+</body>
+</html>
+`
+
+func registerReportTemplateHandlers(mux *http.ServeMux, mwdb Store) {
+	redirect := func(w http.ResponseWriter, r *http.Request, pesan string) {
+		http.Redirect(w, r, "/report/templates?pesan="+url.QueryEscape(pesan), http.StatusSeeOther)
+	}
+
+	mux.HandleFunc("/report/templates", RequirePermission(mwdb, PermReading, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			id, _ := strconv.Atoi(r.FormValue("id"))
+			t := ReportTemplate{
+				ID:         id,
+				Nama:       strings.TrimSpace(r.FormValue("nama")),
+				KdJenisPrw: strings.TrimSpace(r.FormValue("kd_jenis_prw")),
+				Modality:   strings.TrimSpace(r.FormValue("modality")),
+				Temuan:     r.FormValue("temuan"),
+				Kesan:      r.FormValue("kesan"),
+			}
+			pesan := "Template " + t.Nama + " disimpan"
+			if t.Nama == "" || (t.KdJenisPrw == "" && t.Modality == "") {
+				pesan = "Nama dan kd_jenis_prw atau modality wajib diisi"
+			} else if err := mwdb.SaveReportTemplate(t); err != nil {
+				pesan = "Gagal simpan template: " + err.Error()
+			}
+			redirect(w, r, pesan)
+			return
+		}
+		templates, err := mwdb.GetReportTemplates()
+		if err != nil {
+			http.Error(w, "Gagal ambil template: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		macros, err := mwdb.GetReportMacros()
+		if err != nil {
+			http.Error(w, "Gagal ambil makro: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var edit ReportTemplate
+		if id, _ := strconv.Atoi(r.URL.Query().Get("id")); id > 0 {
+			edit, _ = mwdb.GetReportTemplate(id)
+		}
+		t, _ := template.New("reportTemplates").Parse(reportTemplatesTmpl)
+		t.Execute(w, struct {
+			Templates   []ReportTemplate
+			Macros      []ReportMacro
+			Edit        ReportTemplate
+			Placeholder []struct{ Nama, Isi string }
+			Pesan       string
+			CSRFToken   string
+		}{templates, macros, edit, reportPlaceholderHelp, r.URL.Query().Get("pesan"), CurrentSession(r).CSRFToken})
+	}))
+
+	mux.HandleFunc("/report/templates/delete", RequirePermission(mwdb, PermReading, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id, _ := strconv.Atoi(r.FormValue("id"))
+		pesan := "Template dihapus"
+		if err := mwdb.DeleteReportTemplate(id); err != nil {
+			pesan = "Gagal hapus template: " + err.Error()
+		}
+		redirect(w, r, pesan)
+	}))
+
+	mux.HandleFunc("/report/macros", RequirePermission(mwdb, PermReading, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		m := ReportMacro{
+			Kode:     strings.ToLower(strings.TrimSpace(r.FormValue("kode"))),
+			Nama:     strings.TrimSpace(r.FormValue("nama")),
+			Modality: strings.TrimSpace(r.FormValue("modality")),
+			Temuan:   r.FormValue("temuan"),
+			Kesan:    r.FormValue("kesan"),
+		}
+		pesan := "Makro " + m.Kode + " disimpan"
+		if !macroCode.MatchString(m.Kode) || m.Nama == "" {
+			pesan = "Kode makro hanya huruf kecil, angka, - dan _; nama wajib diisi"
+		} else if err := mwdb.SaveReportMacro(m); err != nil {
+			pesan = "Gagal simpan makro: " + err.Error()
+		}
+		redirect(w, r, pesan)
+	}))
+
+	mux.HandleFunc("/report/macros/delete", RequirePermission(mwdb, PermReading, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		id, _ := strconv.Atoi(r.FormValue("id"))
+		pesan := "Makro dihapus"
+		if err := mwdb.DeleteReportMacro(id); err != nil {
+			pesan = "Gagal hapus makro: " + err.Error()
+		}
+		redirect(w, r, pesan)
+	}))
+
+	mux.HandleFunc("/report/templates/export", RequirePermission(mwdb, PermReading, func(w http.ResponseWriter, r *http.Request) {
+		lib, err := ExportReportLibrary(mwdb)
+		if err != nil {
+			http.Error(w, "Gagal export: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", "attachment; filename=template-laporan-"+time.Now().Format("20060102")+".json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(lib)
+	}))
+
+	mux.HandleFunc("/report/templates/import", RequirePermission(mwdb, PermReading, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			redirect(w, r, "File import wajib dipilih")
+			return
+		}
+		defer file.Close()
+		nTemplate, nMakro, err := ImportReportLibrary(mwdb, file)
+		pesan := fmt.Sprintf("Import selesai: %d template, %d makro", nTemplate, nMakro)
+		if err != nil {
+			pesan = "Gagal import: " + err.Error()
+		}
+		redirect(w, r, pesan)
+	}))
+}
+
+func runTemplateCommand(mwdb ReportTemplateStore, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("pemakaian: template export [FILE] | template import FILE")
+	}
+	switch args[0] {
+	case "export":
+		lib, err := ExportReportLibrary(mwdb)
+		if err != nil {
+			return err
+		}
+		data, _ := json.MarshalIndent(lib, "", "  ")
+		if len(args) < 2 {
+			fmt.Println(string(data))
+			return nil
+		}
+		if err := os.WriteFile(args[1], data, 0644); err != nil {
+			return err
+		}
+		fmt.Printf("%d template dan %d makro diexport ke %s\n", len(lib.Template), len(lib.Makro), args[1])
+		return nil
+	case "import":
+		if len(args) < 2 {
+			return fmt.Errorf("pemakaian: template import FILE")
+		}
+		f, err := os.Open(args[1])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		nTemplate, nMakro, err := ImportReportLibrary(mwdb, f)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d template dan %d makro diimport\n", nTemplate, nMakro)
+		return nil
+	}
+	return fmt.Errorf("pemakaian: template export [FILE] | template import FILE")
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPatientAge(t *testing.T) {
+	tgl := func(s string) time.Time {
+		d, err := time.Parse("2006-01-02", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	tests := []struct {
+		lahir, pada string
+		want        string
+	}{
+		{"1980-05-17", "2026-05-17", "46 tahun"},
+		{"1980-05-17", "2026-05-16", "45 tahun"},
+		{"1980-05-17", "2026-04-30", "45 tahun"},
+		{"2025-12-20", "2026-12-19", "11 bulan"},
+		{"2025-11-20", "2026-01-19", "1 bulan"},
+		{"2026-01-01", "2026-01-20", "19 hari"},
+		{"2026-01-05", "2026-01-05", "0 hari"},
+		{"2024-02-29", "2025-02-28", "11 bulan"},
+		{"2024-02-29", "2025-03-01", "1 tahun"},
+	}
+	for _, tt := range tests {
+		if got := patientAge(tgl(tt.lahir), tgl(tt.pada)); got != tt.want {
+			t.Errorf("umur lahir %s pada %s = %q, seharusnya %q", tt.lahir, tt.pada, got, tt.want)
+		}
+	}
+}
+
+func TestReportPlaceholders(t *testing.T) {
+	wl := WorklistRequest{
+		PatientName: "UJI^PASIEN", PatientSex: "F", PatientBirthDate: "19800517", PatientID: "PR202601050001",
+		ScheduledProcedureStepStartDate: "20260105", RequestedProcedureDescription: "THORAX PA", Modality: "CR",
+	}
+	tests := []struct {
+		nama string
+		ubah func(wl *WorklistRequest)
+		teks string
+		want string
+	}{
+		{"semua placeholder", nil,
+			"{nama} ({jk}, {umur}, lahir {tgl_lahir}) {pemeriksaan} {modality} {tgl_periksa} {no_order}",
+			"UJI PASIEN (Perempuan, 45 tahun, lahir 17-05-1980) THORAX PA CR 05-01-2026 PR202601050001"},
+		{"placeholder tidak dikenal dibiarkan", nil, "{nama} {dokter} {NAMA}", "UJI PASIEN {dokter} {NAMA}"},
+		{"tanggal lahir kosong", func(wl *WorklistRequest) { wl.PatientBirthDate = "" }, "umur: {umur}; lahir: {tgl_lahir}", "umur: ; lahir: "},
+		{"jenis kelamin lain", func(wl *WorklistRequest) { wl.PatientSex = "O" }, "[{jk}]", "[]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.nama, func(t *testing.T) {
+			w := wl
+			if tt.ubah != nil {
+				tt.ubah(&w)
+			}
+			if got := FillPlaceholders(tt.teks, ReportPlaceholders(w)); got != tt.want {
+				t.Fatalf("%q, seharusnya %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDefaultReportTemplate(t *testing.T) {
+	templates := []ReportTemplate{
+		{Nama: "CR umum", Modality: "CR"},
+		{Nama: "Thorax PA", KdJenisPrw: "RAD001", Modality: "CR"},
+		{Nama: "USG abdomen", KdJenisPrw: "RAD002"},
+	}
+	tests := []struct {
+		nama       string
+		kdJenisPrw []string
+		modality   string
+		want       string
+	}{
+		{"pemeriksaan cocok", []string{"RAD001"}, "CR", "Thorax PA"},
+		{"pemeriksaan kedua cocok", []string{"RAD009", "RAD002"}, "US", "USG abdomen"},
+		{"modality saja", []string{"RAD009"}, "cr", "CR umum"},
+		{"tidak ada yang cocok", []string{"RAD009"}, "MR", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.nama, func(t *testing.T) {
+			got, ok := DefaultReportTemplate(templates, tt.kdJenisPrw, tt.modality)
+			if got.Nama != tt.want || ok != (tt.want != "") {
+				t.Fatalf("template %q (%v), seharusnya %q", got.Nama, ok, tt.want)
+			}
+		})
+	}
+}
+
+// Entri yang tidak valid menolak seluruh file sebelum ada yang disimpan
+func TestImportReportLibraryValidation(t *testing.T) {
+	mwdb := openMemoryStore(t)
+	if err := mwdb.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		nama, json string
+	}{
+		{"versi lain", `{"versi": 2, "template": [{"nama": "Thorax"}]}`},
+		{"template tanpa nama", `{"versi": 1, "template": [{"nama": "Thorax"}, {"nama": " "}]}`},
+		{"kode makro tidak valid", `{"versi": 1, "template": [{"nama": "Thorax"}], "makro": [{"kode": "Normal Thorax", "nama": "Normal"}]}`},
+		{"JSON rusak", `{"versi": 1, "template": [`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.nama, func(t *testing.T) {
+			if _, _, err := ImportReportLibrary(mwdb, strings.NewReader(tt.json)); err == nil {
+				t.Fatal("import seharusnya ditolak")
+			}
+			if templates, err := mwdb.GetReportTemplates(); err != nil || len(templates) != 0 {
+				t.Fatalf("template tersimpan dari file yang ditolak: %v %v", templates, err)
+			}
+		})
+	}
+}
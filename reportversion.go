@@ -0,0 +1,375 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Riwayat versi laporan. Laporan final pertama (dari SR Orthanc atau editor
+// portal) terkunci; koreksi disimpan sebagai addendum lengkap dengan penulis
+// dan alasan. Khanza selalu menerima gabungan laporan final dan semua
+// addendum, bukan isi SR yang datang terakhir.
+
+const (
+	VersionFinal    = "final"
+	VersionAddendum = "addendum"
+)
+
+type ReportVersion struct {
+	ID         int
+	NomorOrder string
+	Versi      int
+	Jenis      string
+	Teks       string
+	Penulis    string
+	Alasan     string
+	SRInstance string
+	Dibuat     time.Time
+}
+
+func (s *sqlStore) GetReportVersions(nomorOrder string) ([]ReportVersion, error) {
+	rows, err := s.db.Query(`SELECT id, nomor_order, versi, jenis, teks, IFNULL(penulis, ''), IFNULL(alasan, ''), IFNULL(sr_instance, ''), dibuat
+		FROM report_version WHERE nomor_order=? ORDER BY versi`, nomorOrder)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []ReportVersion
+	for rows.Next() {
+		var v ReportVersion
+		if err := rows.Scan(&v.ID, &v.NomorOrder, &v.Versi, &v.Jenis, &v.Teks, &v.Penulis, &v.Alasan, &v.SRInstance, &v.Dibuat); err != nil {
+			return nil, err
+		}
+		result = append(result, v)
+	}
+	return result, rows.Err()
+}
+
+// Tambah versi baru. Versi pertama selalu final, berikutnya addendum. SR yang
+// sudah pernah dicatat (replay webhook) tidak menambah versi; hasilnya false.
+func (s *sqlStore) AddReportVersion(v ReportVersion) (bool, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	var last sql.NullInt64
+	if err := tx.QueryRow("SELECT MAX(versi) FROM report_version WHERE nomor_order=?"+s.dialect.lockSuffix, v.NomorOrder).Scan(&last); err != nil {
+		return false, err
+	}
+	if v.SRInstance != "" {
+		var n int
+		if err := tx.QueryRow("SELECT COUNT(*) FROM report_version WHERE nomor_order=? AND sr_instance=?", v.NomorOrder, v.SRInstance).Scan(&n); err != nil {
+			return false, err
+		}
+		if n > 0 {
+			return false, nil
+		}
+	}
+	v.Versi = int(last.Int64) + 1
+	v.Jenis = VersionAddendum
+	if v.Versi == 1 {
+		v.Jenis = VersionFinal
+	}
+	_, err = tx.Exec(`INSERT INTO report_version (nomor_order, versi, jenis, teks, penulis, alasan, sr_instance, dibuat)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		v.NomorOrder, v.Versi, v.Jenis, v.Teks, v.Penulis, v.Alasan, sql.NullString{String: v.SRInstance, Valid: v.SRInstance != ""}, time.Now())
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// Teks yang dikirim ke Khanza: laporan final diikuti setiap addendum
+func CombinedReportText(versions []ReportVersion) string {
+	var b strings.Builder
+	for _, v := range versions {
+		if v.Jenis != VersionAddendum {
+			b.WriteString(v.Teks)
+			continue
+		}
+		fmt.Fprintf(&b, "\n\nADDENDUM %d - %s", v.Versi-1, v.Dibuat.Format("02-01-2006 15:04"))
+		if v.Penulis != "" {
+			b.WriteString(" - " + v.Penulis)
+		}
+		b.WriteString("\n")
+		if v.Alasan != "" {
+			b.WriteString("Alasan: " + v.Alasan + "\n")
+		}
+		b.WriteString(v.Teks)
+	}
+	return b.String()
+}
+
+// Catat SR yang masuk lewat webhook. SR pertama menjadi versi final; SR lain
+// untuk order yang sama menjadi addendum kecuali isinya sama dengan versi
+// terakhir. Mengembalikan teks gabungan untuk Khanza.
+func recordSRVersion(mwdb ReportStore, accession, instanceID string, report SRReport) (string, error) {
+	versions, err := mwdb.GetReportVersions(accession)
+	if err != nil {
+		return "", err
+	}
+	baru := true
+	for _, v := range versions {
+		if v.SRInstance == instanceID {
+			baru = false
+		}
+	}
+	if baru && len(versions) > 0 && strings.TrimSpace(versions[len(versions)-1].Teks) == strings.TrimSpace(report.Teks) {
+		baru = false
+	}
+	if baru {
+		v := ReportVersion{NomorOrder: accession, Teks: report.Teks, Penulis: dicomName(report.Dokter), SRInstance: instanceID}
+		if len(versions) > 0 {
+			v.Alasan = "SR koreksi dari Orthanc"
+		}
+		if _, err := mwdb.AddReportVersion(v); err != nil {
+			return "", err
+		}
+		if versions, err = mwdb.GetReportVersions(accession); err != nil {
+			return "", err
+		}
+	}
+	return CombinedReportText(versions), nil
+}
+
+// Tulis tagihan dan hasil ke Khanza. Mengembalikan nama tabel yang gagal
+// untuk metrik dan log. Tagihan yang gagal (misalnya radiolog tidak dikenal)
+// tidak menahan hasil: hasil_radiologi tetap ditulis dan error dibungkus
+// ErrBillingFailed.
+func fileResultToKhanza(db *sql.DB, mwdb Store, accession, noorder, link string, p Pelaksana, teks string) (string, error) {
+	cfg := RuntimeConfig()
+	tglPeriksa, jam, billingErr := InsertPeriksaRadiologiFromPermintaan(db, cfg, noorder, link, p)
+	if billingErr != nil {
+		permintaan, err := loadPermintaanRadiologi(db, noorder)
+		if err != nil {
+			return "periksa_radiologi", billingErr
+		}
+		tglPeriksa, jam = permintaan.TglPeriksa, permintaan.Jam
+		if link != "" {
+			if err := insertGambarRadiologi(db, permintaan.NoRawat, tglPeriksa, jam, link); err != nil {
+				return "gambar_radiologi", err
+			}
+		}
+	}
+	if err := SaveRadiologyResult(db, noorder, tglPeriksa, jam, teks); err != nil {
+		return "hasil_radiologi", err
+	}
+	if cfg.ReportPDFFolder != "" {
+		// PDF hanya pelengkap, hasil teks di Khanza tetap dianggap tersimpan
+		if _, err := PublishReportPDF(cfg, db, mwdb, accession, tglPeriksa, jam); err != nil {
+			NewLogger(mwdb, CompSR).Warn("Gagal menyimpan laporan PDF", LogFields{Accession: accession, Pasien: noorder, Err: err})
+		}
+	}
+	mwdb.UpdateHasilOrthanc(accession, teks)
+	if billingErr != nil {
+		return "periksa_radiologi", fmt.Errorf("%w: %v", ErrBillingFailed, billingErr)
+	}
+	return "", nil
+}
+
+// Tambah addendum dari portal lalu kirim ulang teks gabungan ke Khanza.
+// State order tidak diubah agar waktu TAT laporan asli tetap. Konfigurasi
+// dibaca saat addendum dibuat agar ikut reload.
+func AddReportAddendum(db *sql.DB, mwdb Store, accession string, user PortalUser, teks, alasan string) error {
+	teks, alasan = strings.TrimSpace(teks), strings.TrimSpace(alasan)
+	if teks == "" || alasan == "" {
+		return fmt.Errorf("isi dan alasan addendum wajib diisi")
+	}
+	versions, err := mwdb.GetReportVersions(accession)
+	if err != nil {
+		return err
+	}
+	if len(versions) == 0 {
+		return fmt.Errorf("order %s belum punya laporan final", accession)
+	}
+	if !CurrentKhanzaSchema().Compatible() {
+		return ErrKhanzaSchemaIncompatible
+	}
+	sent, err := mwdb.GetSentWorklists([]string{accession})
+	if err != nil {
+		return err
+	}
+	var wl WorklistRequest
+	json.Unmarshal([]byte(sent[accession].Worklist), &wl)
+	if wl.PatientID == "" {
+		return fmt.Errorf("data worklist order %s tidak tersimpan", accession)
+	}
+	link := ""
+	if uid := sent[accession].StudyInstanceUID; uid != "" {
+		link = GenerateOHIFLink(RuntimeConfig(), uid)
+	}
+
+	penulis := user.Nama
+	if penulis == "" {
+		penulis = user.Username
+	}
+	if _, err := mwdb.AddReportVersion(ReportVersion{NomorOrder: accession, Teks: teks, Penulis: penulis, Alasan: alasan}); err != nil {
+		return err
+	}
+	if versions, err = mwdb.GetReportVersions(accession); err != nil {
+		return err
+	}
+	// Addendum yang memuat temuan kritis juga dinotifikasi
+	CheckCriticalFinding(mwdb, accession, SRReport{Teks: teks})
+	logger := NewLogger(mwdb, CompSR)
+	fields := LogFields{Accession: accession, Pasien: wl.PatientID}
+	// Radiolog penagihan tetap penulis laporan final
+	pelaksana := Pelaksana{Dokter: versions[0].Penulis}
+	tabel, err := fileResultToKhanza(db, mwdb, accession, wl.PatientID, link, pelaksana, CombinedReportText(versions))
+	if err != nil {
+		metricKhanzaWriteFailures.WithLabelValues(tabel).Inc()
+		fields.Err = err
+		if !errors.Is(err, ErrBillingFailed) {
+			logger.Error("Addendum tersimpan tetapi gagal dikirim ke "+tabel+" Khanza", fields)
+			return fmt.Errorf("addendum tersimpan di middleware tetapi gagal dikirim ke Khanza: %v", err)
+		}
+		logger.Error("Addendum tersimpan di hasil_radiologi tetapi tagihan gagal", fields)
+		fields.Err = nil
+	}
+	logger.Info(fmt.Sprintf("Addendum %d oleh %s disimpan ke Khanza: %s", len(versions)-1, user.Username, alasan), fields)
+	return nil
+}
+
+type diffLine struct {
+	Op   string // " ", "+" atau "-"
+	Teks string
+}
+
+// Diff per baris berbasis longest common subsequence, cukup untuk laporan
+// yang hanya puluhan baris
+func lineDiff(a, b string) []diffLine {
+	x, y := strings.Split(a, "\n"), strings.Split(b, "\n")
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var result []diffLine
+	i, j := 0, 0
+	for i < len(x) && j < len(y) {
+		switch {
+		case x[i] == y[j]:
+			result = append(result, diffLine{" ", x[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			result = append(result, diffLine{"-", x[i]})
+			i++
+		default:
+			result = append(result, diffLine{"+", y[j]})
+			j++
+		}
+	}
+	for ; i < len(x); i++ {
+		result = append(result, diffLine{"-", x[i]})
+	}
+	for ; j < len(y); j++ {
+		result = append(result, diffLine{"+", y[j]})
+	}
+	return result
+}
+
+var reportHistoryTmpl = `
+<!DOCTYPE html>
+<html>
+<head>
+    <title>Riwayat Laporan {{.Accession}}</title>
+    <style>
+        body { font-family: Arial; margin: 40px; }
+        table { border-collapse: collapse; width: 100%; margin-bottom: 30px; }
+        th, td { border: 1px solid #ccc; padding: 8px; text-align: left; vertical-align: top; }
+        th { background: #f0f0f0; }
+        pre { margin: 0; white-space: pre-wrap; font-family: monospace; }
+        .add { background: #e6ffed; }
+        .del { background: #ffeef0; text-decoration: line-through; }
+    </style>
+</head>
+<body>
+    <h2>Riwayat Laporan {{.Accession}}</h2>
+    <p><a href="/report?accession={{.Accession}}">Laporan</a> | <a href="/order?accession={{.Accession}}">Timeline Order</a></p>
+    <table>
+        <tr><th>Versi</th><th>Jenis</th><th>Waktu</th><th>Penulis</th><th>Alasan</th><th>SR</th><th></th></tr>
+        {{range .Versions}}
+        <tr><td>{{.Versi}}</td><td>{{.Jenis}}</td><td>{{.Dibuat.Format "2006-01-02 15:04"}}</td><td>{{.Penulis}}</td>
+            <td>{{.Alasan}}</td><td>{{.SRInstance}}</td>
+            <td>{{if gt .Versi 1}}<a href="/report/history?accession={{$.Accession}}&b={{.Versi}}">diff</a>{{end}}</td></tr>
+        {{end}}
+    </table>
+    {{if .Diff}}
+    <h3>Perbedaan teks Khanza versi {{.A}} dan versi {{.B}}</h3>
+    <form method="get" action="/report/history">
+        <input type="hidden" name="accession" value="{{.Accession}}">
+        Versi <input type="number" name="a" value="{{.A}}" min="1" max="{{len .Versions}}" size="3">
+        dengan <input type="number" name="b" value="{{.B}}" min="1" max="{{len .Versions}}" size="3">
+        <button type="submit">Bandingkan</button>
+    </form>
+    <pre>{{range .Diff}}<span class="{{if eq .Op "+"}}add{{else if eq .Op "-"}}del{{end}}">{{.Op}} {{.Teks}}</span>
+{{end}}</pre>
+    {{end}}
+</body>
+</html>
+`
+
+func registerReportVersionHandlers(mux *http.ServeMux, db *sql.DB, mwdb Store) {
+	mux.HandleFunc("/report/history", RequirePermission(mwdb, PermView, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		accession := q.Get("accession")
+		versions, err := mwdb.GetReportVersions(accession)
+		if err != nil {
+			http.Error(w, "Gagal ambil riwayat laporan: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// Default membandingkan versi terakhir dengan versi sebelumnya
+		b, _ := strconv.Atoi(q.Get("b"))
+		if b < 1 || b > len(versions) {
+			b = len(versions)
+		}
+		a, err := strconv.Atoi(q.Get("a"))
+		if err != nil || a < 1 || a > len(versions) {
+			a = b - 1
+		}
+		var diff []diffLine
+		if a >= 1 && b >= 1 {
+			diff = lineDiff(CombinedReportText(versions[:a]), CombinedReportText(versions[:b]))
+		}
+		t, _ := template.New("reportHistory").Parse(reportHistoryTmpl)
+		t.Execute(w, struct {
+			Accession string
+			Versions  []ReportVersion
+			A, B      int
+			Diff      []diffLine
+		}{accession, versions, a, b, diff})
+	}))
+
+	mux.HandleFunc("/report/addendum", RequirePermission(mwdb, PermReading, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		accession := r.FormValue("accession")
+		pesan := "Addendum disimpan dan dikirim ke Khanza"
+		if err := AddReportAddendum(db, mwdb, accession, CurrentSession(r).User, r.FormValue("teks"), r.FormValue("alasan")); err != nil {
+			pesan = "Gagal: " + err.Error()
+		}
+		http.Redirect(w, r, "/report?accession="+url.QueryEscape(accession)+"&pesan="+url.QueryEscape(pesan), http.StatusSeeOther)
+	}))
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+)
+
+// Batas waktu menulis respons portal. Cukup untuk backfill dan PDF; stream
+// SSE /events mematikan batas ini sendiri lewat ResponseController.
+const portalWriteTimeout = 2 * time.Minute
+
+// Server HTTP dengan timeout
+func newHTTPServer(addr string, handler http.Handler, writeTimeout time.Duration) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       120 * time.Second,
+	}
+}
+
+// Susun server portal dan webhook. Webhook memakai server portal kecuali
+// WEBHOOK_ADDR diisi dengan alamat yang berbeda.
+func buildHTTPServers(cfg Config, db *sql.DB, mwdb Store, queue *WebhookQueue) []*http.Server {
+	portalMux := http.NewServeMux()
+	RegisterPortalHandlers(portalMux, cfg, db, mwdb)
+	servers := []*http.Server{newHTTPServer(cfg.PortalAddr, portalMux, portalWriteTimeout)}
+
+	if cfg.WebhookAddr == "" || cfg.WebhookAddr == cfg.PortalAddr {
+		RegisterWebhookHandler(portalMux, queue, mwdb)
+		return servers
+	}
+	webhookMux := http.NewServeMux()
+	RegisterWebhookHandler(webhookMux, queue, mwdb)
+	webhookMux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	return append(servers, newHTTPServer(cfg.WebhookAddr, webhookMux, 30*time.Second))
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Stream /events tetap terbuka melewati WriteTimeout server portal
+func TestEventStreamOutlivesWriteTimeout(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(handleEventStream))
+	srv.Config.WriteTimeout = 200 * time.Millisecond
+	srv.Start()
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	time.Sleep(2 * srv.Config.WriteTimeout)
+	Bus.Publish(EventStatus, "uji-write-timeout")
+
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				t.Fatal("stream /events ditutup oleh WriteTimeout")
+			}
+			if strings.Contains(line, "uji-write-timeout") {
+				return
+			}
+		case <-timeout:
+			t.Fatal("event tidak diterima")
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// Driver database middleware yang didukung (MIDDLEWARE_DB_DRIVER)
+const (
+	StoreMySQL  = "mysql"
+	StoreSQLite = "sqlite"
+)
+
+// Repository data middleware: order yang dikirim ke modality (sent_worklist),
+// riwayat state order (order_event) dan log portal. Implementasi MySQL dipakai
+// bila middleware berbagi server dengan Khanza, SQLite untuk klinik kecil yang
+// cukup memakai file database di samping binary.
+//
+// Store menggabungkan interface kecil per subsistem di bawah. Fungsi yang
+// hanya menyentuh satu subsistem menerima interface kecilnya saja.
+type Store interface {
+	Ping() error
+	Close() error
+
+	MigrationStore
+	WorklistStore
+	OrderStore
+	WebhookStore
+	ReadingStore
+	ReportStore
+	ReportTemplateStore
+	CriticalStore
+	LogStore
+	PortalStore
+	HealthStore
+}
+
+// Versi skema dan migrasi
+type MigrationStore interface {
+	Driver() string
+	SchemaVersion() (int, error)
+	MigrateUp() error
+	MigrateDown(target int) error
+	ForceSchemaVersion(version int) error
+}
+
+// Order yang sudah dikirim ke modality (sent_worklist)
+type WorklistStore interface {
+	InsertSentWorklist(nomorOrder, worklist string, tglPermintaan *time.Time)
+	UpdateHasilOrthanc(nomorOrder, hasilOrthanc string)
+	UpdateStudyInstanceUID(nomorOrder, studyUID string)
+	GetSentWorklists(nomorOrders []string) (map[string]SentWorklist, error)
+	GetOrdersAwaitingImages(window time.Duration) ([]string, error)
+	GetOrderTimes(dari, sampai time.Time) ([]orderTimes, error)
+	GetUnreportedOrders() ([]orderTimes, error)
+}
+
+// State order dan riwayatnya (order_event)
+type OrderStore interface {
+	TransitionOrder(nomorOrder string, to OrderState, keterangan string) error
+	GetOrderEvents(nomorOrder string) ([]OrderEvent, error)
+	GetOrderTimeline(accession string) ([]TimelineEntry, error)
+}
+
+// Payload webhook SR yang disimpan sebelum diproses
+type WebhookStore interface {
+	SaveWebhookPayload(body []byte) (int64, error)
+	GetWebhookPayload(id int64) ([]byte, error)
+}
+
+// Worklist baca radiolog dan aturan pembagiannya
+type ReadingStore interface {
+	CreateReadingItem(item ReadingItem) (bool, error)
+	GetReadingItems(f ReadingFilter) ([]ReadingItem, error)
+	AssignReading(nomorOrder, radiolog string) error
+	ClaimReading(nomorOrder, radiolog string) error
+	MarkReadingDone(nomorOrder, pembaca string) error
+	GetReadingRules() ([]ReadingRule, error)
+	SaveReadingRule(r ReadingRule) error
+	DeleteReadingRule(id int) error
+	NextRuleTurn(id int) (int, error)
+}
+
+// Laporan radiolog beserta riwayat versinya
+type ReportStore interface {
+	GetReport(nomorOrder string) (Report, error)
+	SaveReport(r Report) error
+	ClaimReportFinal(nomorOrder, penulis string) (string, error)
+	ReleaseReportFinal(nomorOrder, status string) error
+	SetReportSRInstance(nomorOrder, instanceID string) error
+	GetReportVersions(nomorOrder string) ([]ReportVersion, error)
+	AddReportVersion(v ReportVersion) (bool, error)
+}
+
+// Template dan makro laporan
+type ReportTemplateStore interface {
+	GetReportTemplates() ([]ReportTemplate, error)
+	GetReportTemplate(id int) (ReportTemplate, error)
+	SaveReportTemplate(t ReportTemplate) error
+	DeleteReportTemplate(id int) error
+	GetReportMacros() ([]ReportMacro, error)
+	SaveReportMacro(m ReportMacro) error
+	DeleteReportMacro(id int) error
+}
+
+// Temuan kritis dan antrian notifikasinya
+type CriticalStore interface {
+	CreateCriticalFinding(f CriticalFinding) (CriticalFinding, bool, error)
+	GetCriticalFindings(terbuka bool, limit int) ([]CriticalFinding, error)
+	GetCriticalFinding(id int) (CriticalFinding, error)
+	GetCriticalFindingByOrder(nomorOrder string) (CriticalFinding, error)
+	AcknowledgeCriticalFinding(id int, oleh, catatan string) error
+	PendingCriticalNotices() ([]CriticalFinding, error)
+	ClaimCriticalNotice(id, level int) (bool, error)
+	DueCriticalEscalations(now time.Time) ([]CriticalFinding, error)
+	EscalateCriticalFinding(id, dari, ke int, berikut *time.Time) (bool, error)
+	AddCriticalNotification(n CriticalNotification) error
+	GetCriticalNotifications(findingID int) ([]CriticalNotification, error)
+}
+
+// Log portal
+type LogStore interface {
+	InsertLog(entry LogEntry) error
+	QueryLogs(f LogFilter) ([]LogEntry, int, error)
+	PurgeLogs(sebelum time.Time) (int64, error)
+}
+
+// Pengguna dan sesi portal
+type PortalStore interface {
+	CountPortalUsers() (int, error)
+	InsertPortalUser(u PortalUser, passwordHash string) error
+	UpdatePortalUser(id int, role string, aktif bool) error
+	SetPortalUserPassword(id int, passwordHash string) error
+	GetPortalUsers() ([]PortalUser, error)
+	GetPortalUserCredential(username string) (PortalUser, string, error)
+	InsertPortalSession(s PortalSession, dibuat, kadaluarsa time.Time) error
+	GetPortalSession(token string, now time.Time) (PortalSession, error)
+	DeletePortalSession(token string) error
+}
+
+// Riwayat gangguan komponen
+type HealthStore interface {
+	OpenHealthOutage(komponen string, mulai time.Time, pesan string) error
+	CloseHealthOutage(komponen string, selesai time.Time) error
+	GetHealthOutages(limit int) ([]HealthOutage, error)
+}
+
+// Implementasi bersama berbasis database/sql. Perbedaan sintaks antar
+// database dikumpulkan di sqlDialect.
+type sqlStore struct {
+	db      *sql.DB
+	dialect sqlDialect
+}
+
+type sqlDialect struct {
+	driver string
+	// Kunci baris saat membaca state order di dalam transaksi
+	lockSuffix string
+	// INSERT sent_worklist yang memperbarui worklist bila nomor_order sudah ada
+	upsertSentWorklist string
+	schema             []migration
+}
+
+var mysqlDialect = sqlDialect{
+	driver:     StoreMySQL,
+	lockSuffix: " FOR UPDATE",
+	upsertSentWorklist: `INSERT INTO sent_worklist (nomor_order, worklist, tgl_permintaan, tgl_masuk_worklist) VALUES (?, ?, ?, ?)
+		ON DUPLICATE KEY UPDATE worklist=VALUES(worklist), tgl_permintaan=VALUES(tgl_permintaan)`,
+	schema: mysqlMigrations,
+}
+
+var sqliteDialect = sqlDialect{
+	driver: StoreSQLite,
+	// SQLite mengunci seluruh database saat menulis, tidak perlu FOR UPDATE
+	lockSuffix: "",
+	upsertSentWorklist: `INSERT INTO sent_worklist (nomor_order, worklist, tgl_permintaan, tgl_masuk_worklist) VALUES (?, ?, ?, ?)
+		ON CONFLICT(nomor_order) DO UPDATE SET worklist=excluded.worklist, tgl_permintaan=excluded.tgl_permintaan`,
+	schema: sqliteMigrations,
+}
+
+func (s *sqlStore) Driver() string { return s.dialect.driver }
+func (s *sqlStore) Ping() error    { return s.db.Ping() }
+func (s *sqlStore) Close() error   { return s.db.Close() }
+
+func NewMySQLStore(db *sql.DB) Store {
+	return &sqlStore{db: db, dialect: mysqlDialect}
+}
+
+func NewSQLiteStore(db *sql.DB) Store {
+	return &sqlStore{db: db, dialect: sqliteDialect}
+}
+
+// Buka file SQLite. Satu koneksi saja agar penulisan tidak saling mengunci.
+func openSQLite(path string) (*sql.DB, error) {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	dsn := "file:" + path + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_time_format=sqlite"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// Koneksi ke database middleware sesuai MIDDLEWARE_DB_DRIVER
+func ConnectMiddlewareDB(cfg Config) (Store, error) {
+	switch cfg.DBDriver {
+	case StoreSQLite:
+		db, err := openSQLite(cfg.DBPath)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Successfully opened Middleware DB (SQLite %s)", cfg.DBPath)
+		return NewSQLiteStore(db), nil
+	case StoreMySQL, "":
+		db, err := connectMiddlewareMySQL(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return NewMySQLStore(db), nil
+	}
+	return nil, fmt.Errorf("driver DB middleware tidak dikenal: %s", cfg.DBDriver)
+}
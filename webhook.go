@@ -0,0 +1,129 @@
+package main
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var ErrWebhookQueueFull = errors.New("antrian webhook penuh")
+
+// Payload SR dari Lua Orthanc hanya berisi ID dan link, bukan isi DICOM
+const maxWebhookBody = 1 << 20
+
+// Antrian webhook SR. Worker memproses payload satu per satu sehingga saat
+// shutdown antrian bisa dikosongkan tanpa memotong proses simpan ke Khanza.
+type WebhookQueue struct {
+	jobs   chan []byte
+	mu     sync.RWMutex
+	closed bool
+	wg     sync.WaitGroup
+}
+
+func NewWebhookQueue(cfg Config, db *sql.DB, mwdb Store, workers, size int) *WebhookQueue {
+	q := &WebhookQueue{jobs: make(chan []byte, size)}
+	for i := 0; i < workers; i++ {
+		q.wg.Add(1)
+		go func() {
+			defer q.wg.Done()
+			for body := range q.jobs {
+				processSRWebhook(cfg, db, mwdb, body)
+			}
+		}()
+	}
+	return q
+}
+
+func (q *WebhookQueue) Enqueue(body []byte) error {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return errors.New("antrian webhook sudah ditutup")
+	}
+	select {
+	case q.jobs <- body:
+		return nil
+	default:
+		return ErrWebhookQueueFull
+	}
+}
+
+// Tutup antrian dan tunggu semua payload yang tersisa selesai diproses
+func (q *WebhookQueue) Drain() {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.jobs)
+	}
+	q.mu.Unlock()
+	q.wg.Wait()
+}
+
+// Simpan payload webhook apa adanya agar bisa diproses ulang dengan
+// `middleware webhook replay <id>`
+func (s *sqlStore) SaveWebhookPayload(body []byte) (int64, error) {
+	res, err := s.db.Exec("INSERT INTO webhook_payload (diterima, body) VALUES (?, ?)", time.Now(), string(body))
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (s *sqlStore) GetWebhookPayload(id int64) ([]byte, error) {
+	var body string
+	if err := s.db.QueryRow("SELECT body FROM webhook_payload WHERE id=?", id).Scan(&body); err != nil {
+		return nil, err
+	}
+	return []byte(body), nil
+}
+
+func RegisterWebhookHandler(mux *http.ServeMux, queue *WebhookQueue, mwdb WebhookStore) {
+	mux.HandleFunc("/webhook", func(w http.ResponseWriter, r *http.Request) {
+		log.Println("webhook SR diterima....")
+		if r.Method != http.MethodPost {
+			metricWebhookRequests.WithLabelValues("method_not_allowed").Inc()
+			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		// Webhook memicu tagihan dan hasil di Khanza, jadi pengirim wajib
+		// menyertakan WEBHOOK_TOKEN di header Authorization bila diisi
+		if token := RuntimeConfig().WebhookToken; token != "" &&
+			subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(token)) != 1 {
+			metricWebhookRequests.WithLabelValues("unauthorized").Inc()
+			log.Printf("Webhook ditolak dari %s: token tidak valid", r.RemoteAddr)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		bodyBytes, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBody))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				metricWebhookRequests.WithLabelValues("too_large").Inc()
+				http.Error(w, "Body terlalu besar", http.StatusRequestEntityTooLarge)
+				return
+			}
+			metricWebhookRequests.WithLabelValues("bad_request").Inc()
+			http.Error(w, "Gagal membaca body", http.StatusBadRequest)
+			return
+		}
+		log.Printf("Menerima webhook r.Body: %s", string(bodyBytes))
+		if id, err := mwdb.SaveWebhookPayload(bodyBytes); err != nil {
+			log.Printf("Gagal simpan payload webhook: %v", err)
+		} else {
+			log.Printf("Payload webhook disimpan dengan id %d", id)
+		}
+		if err := queue.Enqueue(bodyBytes); err != nil {
+			metricWebhookRequests.WithLabelValues("rejected").Inc()
+			log.Printf("Webhook ditolak: %v", err)
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		metricWebhookRequests.WithLabelValues("accepted").Inc()
+		w.Write([]byte("OK"))
+	})
+}
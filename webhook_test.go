@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWebhookRejected(t *testing.T) {
+	SetRuntimeConfig(Config{WebhookToken: "rahasia-webhook"})
+	defer SetRuntimeConfig(Config{})
+	mwdb := openMemoryStore(t)
+	if err := mwdb.MigrateUp(); err != nil {
+		t.Fatal(err)
+	}
+	// Request yang ditolak tidak boleh sampai ke antrian
+	mux := http.NewServeMux()
+	RegisterWebhookHandler(mux, nil, mwdb)
+
+	tests := []struct {
+		nama  string
+		token string
+		body  []byte
+		want  int
+	}{
+		{"tanpa token", "", []byte(`{"accession":"CR1"}`), http.StatusUnauthorized},
+		{"token salah", "rahasia-lain", []byte(`{"accession":"CR1"}`), http.StatusUnauthorized},
+		{"body terlalu besar", "rahasia-webhook", bytes.Repeat([]byte("a"), maxWebhookBody+1), http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewReader(tt.body))
+		if tt.token != "" {
+			req.Header.Set("Authorization", tt.token)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("%s: status %d, seharusnya %d", tt.nama, rec.Code, tt.want)
+		}
+	}
+	var n int
+	if err := mwdb.(*sqlStore).db.QueryRow("SELECT COUNT(*) FROM webhook_payload").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Fatalf("%d payload tersimpan dari request yang ditolak", n)
+	}
+}